@@ -5,6 +5,8 @@
 package cumulative
 
 import (
+	"encoding/json"
+	"sort"
 	"sync"
 	"time"
 
@@ -22,6 +24,23 @@ type lastValue struct {
 	value float64
 }
 
+// ResetBehavior determines how DeltaCalculator handles a cumulative value
+// which is less than the previous value, which happens when the counter is
+// reset, eg. because the process reporting it restarted.
+type ResetBehavior int
+
+const (
+	// ResetSkip does not create a Count for the value after a reset.  The
+	// value is stored so that the next delta is computed from it.  This
+	// is the default.
+	ResetSkip ResetBehavior = iota
+	// ResetFromZero treats the counter as having restarted from zero, so
+	// the Count for the value after a reset has the value itself as its
+	// delta.  This matches the reset handling of Prometheus and avoids
+	// losing the counts of the interval containing the reset.
+	ResetFromZero
+)
+
 // DeltaCalculator is used to create Count metrics from cumulative values.
 type DeltaCalculator struct {
 	lock                    sync.Mutex
@@ -29,6 +48,12 @@ type DeltaCalculator struct {
 	lastClean               time.Time
 	expirationCheckInterval time.Duration
 	expirationAge           time.Duration
+	resetBehavior           ResetBehavior
+	// monotonic is set by SetMonotonicClock.  anchor is the time, including
+	// its monotonic clock reading, from which elapsed time is measured.
+	monotonic bool
+	anchor    time.Time
+	since     func(time.Time) time.Duration
 }
 
 // NewDeltaCalculator creates a new DeltaCalculator.  A single DeltaCalculator
@@ -39,6 +64,7 @@ func NewDeltaCalculator() *DeltaCalculator {
 		// These defaults are described in the Set method doc comments.
 		expirationCheckInterval: 20 * time.Minute,
 		expirationAge:           20 * time.Minute,
+		since:                   time.Since,
 	}
 }
 
@@ -60,6 +86,34 @@ func (dc *DeltaCalculator) SetExpirationCheckInterval(interval time.Duration) *D
 	return dc
 }
 
+// SetResetBehavior configures how a cumulative value less than the previous
+// value of the metric is handled.  The default is ResetSkip.
+func (dc *DeltaCalculator) SetResetBehavior(behavior ResetBehavior) *DeltaCalculator {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	dc.resetBehavior = behavior
+	return dc
+}
+
+// SetMonotonicClock configures whether the order of values and the intervals
+// between them are measured with the monotonic clock rather than by comparing
+// the timestamps passed to CountMetric.  This prevents values from being
+// dropped when the wall clock steps backwards, eg. due to an NTP adjustment.
+// The Timestamp of each Count is then the current timestamp minus the
+// monotonic interval.  The default is false.
+func (dc *DeltaCalculator) SetMonotonicClock(enabled bool) *DeltaCalculator {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	if enabled && !dc.monotonic {
+		dc.anchor = time.Now()
+		// Values stored using the wall clock cannot be compared.
+		dc.datapoints = make(map[metricIdentity]lastValue)
+		dc.lastClean = time.Time{}
+	}
+	dc.monotonic = enabled
+	return dc
+}
+
 // CountMetric creates a count metric from the difference between the values and
 // timestamps of multiple calls.  If this is the first time the name/attributes
 // combination has been seen then the `valid` return value will be false.
@@ -71,14 +125,20 @@ func (dc *DeltaCalculator) CountMetric(name string, attributes map[string]interf
 	dc.lock.Lock()
 	defer dc.lock.Unlock()
 
-	if now.Sub(dc.lastClean) > dc.expirationCheckInterval {
-		cutoff := now.Add(-dc.expirationAge)
+	// clock is the time used to order values and measure intervals.
+	clock := now
+	if dc.monotonic {
+		clock = dc.anchor.Add(dc.since(dc.anchor))
+	}
+
+	if clock.Sub(dc.lastClean) > dc.expirationCheckInterval {
+		cutoff := clock.Add(-dc.expirationAge)
 		for k, v := range dc.datapoints {
 			if v.when.Before(cutoff) {
 				delete(dc.datapoints, k)
 			}
 		}
-		dc.lastClean = now
+		dc.lastClean = clock
 	}
 
 	id := metricIdentity{name: name, attributesJSON: string(attributesJSON)}
@@ -86,18 +146,63 @@ func (dc *DeltaCalculator) CountMetric(name string, attributes map[string]interf
 	last, ok := dc.datapoints[id]
 	if ok {
 		delta := val - last.value
-		timestampsOrdered = now.After(last.when)
+		timestampsOrdered = clock.After(last.when)
+		if delta < 0 && dc.resetBehavior == ResetFromZero {
+			delta = val
+		}
 		if timestampsOrdered && delta >= 0 {
 			count.Name = name
 			count.AttributesJSON = attributesJSON
 			count.Value = delta
 			count.Timestamp = last.when
-			count.Interval = now.Sub(last.when)
+			count.Interval = clock.Sub(last.when)
+			if dc.monotonic {
+				count.Timestamp = now.Add(-count.Interval)
+			}
 			valid = true
 		}
 	}
 	if !ok || timestampsOrdered {
-		dc.datapoints[id] = lastValue{value: val, when: now}
+		dc.datapoints[id] = lastValue{value: val, when: clock}
 	}
 	return
 }
+
+// Snapshot returns the cumulative values currently tracked without modifying
+// them, eg. to inspect them in tests or to record them during shutdown.  Each
+// Count has the Name and AttributesJSON of the metric, the last cumulative
+// value passed to CountMetric as its Value, and the time of that value as its
+// Timestamp.  The Counts are sorted by name and then by attributes.
+func (dc *DeltaCalculator) Snapshot() []telemetry.Count {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+
+	counts := make([]telemetry.Count, 0, len(dc.datapoints))
+	for id, last := range dc.datapoints {
+		count := telemetry.Count{
+			Name:      id.name,
+			Value:     last.value,
+			Timestamp: last.when,
+		}
+		if id.attributesJSON != "" {
+			count.AttributesJSON = json.RawMessage(id.attributesJSON)
+		}
+		counts = append(counts, count)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Name != counts[j].Name {
+			return counts[i].Name < counts[j].Name
+		}
+		return string(counts[i].AttributesJSON) < string(counts[j].AttributesJSON)
+	})
+	return counts
+}
+
+// Reset removes all tracked cumulative values, so the next value of each
+// metric passed to CountMetric is treated as the first.
+func (dc *DeltaCalculator) Reset() {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	dc.datapoints = make(map[metricIdentity]lastValue)
+	dc.lastClean = time.Time{}
+}
@@ -149,6 +149,48 @@ func TestTimestampOrder(t *testing.T) {
 	}
 }
 
+func TestMonotonicClockBackwardStep(t *testing.T) {
+	// Test that a value is not dropped when the wall clock steps backwards
+	// if the monotonic clock is used.
+	now := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	dc := NewDeltaCalculator().SetMonotonicClock(true)
+	var elapsed time.Duration
+	dc.since = func(time.Time) time.Duration { return elapsed }
+
+	if _, ok := dc.CountMetric("m1", nil, 5.0, now); ok {
+		t.Error(ok)
+	}
+	elapsed += time.Minute
+	m, ok := dc.CountMetric("m1", nil, 7.0, now.Add(-10*time.Minute))
+	if !ok || !reflect.DeepEqual(m, telemetry.Count{
+		Name:      "m1",
+		Value:     2.0,
+		Timestamp: now.Add(-11 * time.Minute),
+		Interval:  1 * time.Minute,
+	}) {
+		t.Error(ok, m)
+	}
+	elapsed += 30 * time.Second
+	m, ok = dc.CountMetric("m1", nil, 10.0, now.Add(-9*time.Minute))
+	if !ok || m.Value != 3.0 || m.Interval != 30*time.Second {
+		t.Error(ok, m)
+	}
+}
+
+func TestMonotonicClockSameInstant(t *testing.T) {
+	// Test that values are still ordered by the monotonic clock.
+	now := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	dc := NewDeltaCalculator().SetMonotonicClock(true)
+	dc.since = func(time.Time) time.Duration { return time.Minute }
+
+	if _, ok := dc.CountMetric("m1", nil, 5.0, now); ok {
+		t.Error(ok)
+	}
+	if _, ok := dc.CountMetric("m1", nil, 7.0, now.Add(time.Minute)); ok {
+		t.Error(ok)
+	}
+}
+
 func TestCountMetricNoAttributes(t *testing.T) {
 	// Test that CountMetric works when no attributes are provided.
 	now := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
@@ -233,3 +275,68 @@ func TestManyAttributes(t *testing.T) {
 		t.Error(ok)
 	}
 }
+
+func TestSnapshotAndReset(t *testing.T) {
+	now := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	dc := NewDeltaCalculator()
+	if s := dc.Snapshot(); len(s) != 0 {
+		t.Error(s)
+	}
+	dc.CountMetric("m2", nil, 300.0, now)
+	dc.CountMetric("m1", map[string]interface{}{"zip": "zap"}, 100.0, now)
+	dc.CountMetric("m1", map[string]interface{}{"zip": "zap"}, 105.0, now.Add(time.Minute))
+	expect := []telemetry.Count{
+		{
+			Name:           "m1",
+			AttributesJSON: json.RawMessage(`{"zip":"zap"}`),
+			Value:          105.0,
+			Timestamp:      now.Add(time.Minute),
+		},
+		{
+			Name:      "m2",
+			Value:     300.0,
+			Timestamp: now,
+		},
+	}
+	if s := dc.Snapshot(); !reflect.DeepEqual(s, expect) {
+		t.Error(s)
+	}
+	// Snapshot does not modify the tracked values.
+	m, ok := dc.CountMetric("m2", nil, 310.0, now.Add(time.Minute))
+	if !ok || m.Value != 10.0 {
+		t.Error(ok, m)
+	}
+
+	dc.Reset()
+	if s := dc.Snapshot(); len(s) != 0 {
+		t.Error(s)
+	}
+	if _, ok := dc.CountMetric("m2", nil, 320.0, now.Add(2*time.Minute)); ok {
+		t.Error("value after reset should be treated as the first")
+	}
+}
+
+func TestResetBehavior(t *testing.T) {
+	now := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		behavior ResetBehavior
+		expect   []float64
+	}{
+		{behavior: ResetSkip, expect: []float64{10, 10}},
+		{behavior: ResetFromZero, expect: []float64{10, 5, 10}},
+	} {
+		dc := NewDeltaCalculator().SetResetBehavior(tc.behavior)
+		var deltas []float64
+		for i, val := range []float64{10, 20, 5, 15} {
+			if m, ok := dc.CountMetric("m1", nil, val, now.Add(time.Duration(i)*time.Minute)); ok {
+				if m.Interval != time.Minute {
+					t.Error(m.Interval)
+				}
+				deltas = append(deltas, m.Value)
+			}
+		}
+		if !reflect.DeepEqual(deltas, tc.expect) {
+			t.Error(tc.behavior, deltas, tc.expect)
+		}
+	}
+}
@@ -45,11 +45,11 @@ func outboundCall(u *url.URL) {
 	statuses := []int{200, 200, 200, 200, 200, 404, 503}
 	status := statuses[rand.Int()%len(statuses)]
 
-	h.MetricAggregator().Summary("service.span.responseTime", map[string]interface{}{
+	h.RecordDuration("service.span.responseTime", map[string]interface{}{
 		"host":        u.Host,
 		"method":      "GET",
 		"http.status": status,
-	}).RecordDuration(time.Since(before))
+	}, time.Since(before))
 }
 
 func outbound(w http.ResponseWriter, r *http.Request) {
@@ -5,11 +5,45 @@ package internal
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"sort"
+	"sync"
+	"time"
 )
 
+// attributeConverters maps a reflect.Type to the function that converts
+// values of that type into a natively supported attribute value.
+var attributeConverters sync.Map
+
+// RegisterAttributeConverter registers a function that converts attribute
+// values of the given type into a string, bool, or number.
+func RegisterAttributeConverter(t reflect.Type, convert func(interface{}) interface{}) {
+	attributeConverters.Store(t, convert)
+}
+
+// ConvertAttribute converts the value using its registered converter.  Values
+// of types without a registered converter use the default conversion of
+// time.Time to milliseconds since the epoch and []byte to a base64 string.
+// The second return value is false if the value's type cannot be converted.
+func ConvertAttribute(val interface{}) (interface{}, bool) {
+	if nil == val {
+		return nil, false
+	}
+	if convert, ok := attributeConverters.Load(reflect.TypeOf(val)); ok {
+		return convert.(func(interface{}) interface{})(val), true
+	}
+	switch v := val.(type) {
+	case time.Time:
+		return v.UnixNano() / (1000 * 1000), true
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), true
+	}
+	return nil, false
+}
+
 // MarshalAttributes turns attributes into JSON.
 func MarshalAttributes(ats map[string]interface{}) []byte {
 	attrs := Attributes(ats)
@@ -36,6 +70,11 @@ func AddAttributes(w *JSONFieldsWriter, attrs map[string]interface{}) {
 	}
 }
 
+// AddAttribute writes a single attribute to the fields writer.
+func AddAttribute(w *JSONFieldsWriter, key string, val interface{}) {
+	writeAttribute(w, key, val)
+}
+
 // MarshalOrderedAttributes marshals the given attributes into JSON in
 // alphabetical order.
 func MarshalOrderedAttributes(attrs map[string]interface{}) []byte {
@@ -101,6 +140,12 @@ func writeAttribute(w *JSONFieldsWriter, key string, val interface{}) {
 	case nil:
 		// nil gets dropped.
 	default:
+		if converted, ok := ConvertAttribute(v); ok {
+			if _, custom := ConvertAttribute(converted); !custom {
+				writeAttribute(w, key, converted)
+				return
+			}
+		}
 		w.StringField(key, fmt.Sprintf("%T", v))
 	}
 }
@@ -6,8 +6,10 @@ package internal
 import (
 	"bytes"
 	"math"
+	"reflect"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestAttributesWriteJSON(t *testing.T) {
@@ -128,3 +130,51 @@ func BenchmarkOrderedAttributes(b *testing.B) {
 		}
 	}
 }
+
+type convertedID int
+
+type nestedID int
+
+func TestAttributeConverter(t *testing.T) {
+	RegisterAttributeConverter(reflect.TypeOf(convertedID(0)), func(v interface{}) interface{} {
+		return int(v.(convertedID)) * 2
+	})
+	// A converter returning another custom type is not followed.
+	RegisterAttributeConverter(reflect.TypeOf(nestedID(0)), func(v interface{}) interface{} {
+		return convertedID(v.(nestedID))
+	})
+
+	if js := string(MarshalAttributes(map[string]interface{}{"id": convertedID(21)})); js != `{"id":42}` {
+		t.Error(js)
+	}
+	if js := string(MarshalAttributes(map[string]interface{}{"id": nestedID(21)})); js != `{"id":"internal.nestedID"}` {
+		t.Error(js)
+	}
+	if _, ok := ConvertAttribute(nil); ok {
+		t.Error("nil should not be converted")
+	}
+}
+
+func TestDefaultAttributeConversions(t *testing.T) {
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	attributes := map[string]interface{}{
+		"time":  tm,
+		"bytes": []byte{0xde, 0xad, 0xbe, 0xef},
+	}
+	if js := string(MarshalOrderedAttributes(attributes)); js != `{"bytes":"3q2+7w==","time":1417136460000}` {
+		t.Error(js)
+	}
+}
+
+func TestDefaultAttributeConversionOverride(t *testing.T) {
+	typ := reflect.TypeOf(time.Time{})
+	RegisterAttributeConverter(typ, func(v interface{}) interface{} {
+		return v.(time.Time).Format(time.RFC3339)
+	})
+	defer attributeConverters.Delete(typ)
+
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	if js := string(MarshalAttributes(map[string]interface{}{"time": tm})); js != `{"time":"2014-11-28T01:01:00Z"}` {
+		t.Error(js)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package uuid
+
+import (
+	"regexp"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNew(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		u, err := New()
+		if nil != err {
+			t.Fatal(err)
+		}
+		if !uuidPattern.MatchString(u) {
+			t.Error("invalid uuid", u)
+		}
+		if seen[u] {
+			t.Error("duplicate uuid", u)
+		}
+		seen[u] = true
+	}
+}
@@ -36,7 +36,13 @@ func isFloatValid(f float64) error {
 //  * the number of errors thrown
 //  * the number of support tickets answered
 //
-type AggregatedCount struct{ metricHandle }
+type AggregatedCount struct {
+	metricHandle
+	interval time.Duration
+	// nonMonotonic is set once Add is used, since the count may then
+	// decrease.
+	nonMonotonic bool
+}
 
 // Increment increases the Count value by one.
 func (c *AggregatedCount) Increment() {
@@ -44,12 +50,26 @@ func (c *AggregatedCount) Increment() {
 }
 
 // Increase increases the Count value by the number given.  The value must be
-// non-negative.
+// non-negative: Increase is for monotonic counters and negative values are
+// ignored.  Use Add for counters which can decrease.
 func (c *AggregatedCount) Increase(val float64) {
-	if nil == c {
+	if val < 0 {
 		return
 	}
-	if val < 0 {
+	c.add(val, false)
+}
+
+// Add changes the Count value by the delta given, which may be negative.  This
+// is for up/down counters, such as the number of open connections, where the
+// value reported is the net change over the reporting time window and may
+// itself be negative.  Counts updated with Add are reported as non-monotonic
+// sums in the OTLP/JSON format.
+func (c *AggregatedCount) Add(delta float64) {
+	c.add(delta, true)
+}
+
+func (c *AggregatedCount) add(delta float64, nonMonotonic bool) {
+	if nil == c {
 		return
 	}
 
@@ -58,25 +78,75 @@ func (c *AggregatedCount) Increase(val float64) {
 		return
 	}
 
-	h.lock.Lock()
-	defer h.lock.Unlock()
-
-	if err := isFloatValid(val); err != nil {
+	if err := isFloatValid(delta); err != nil {
 		h.config.logError(map[string]interface{}{
 			"message": "invalid aggregated count value",
 			"err":     err.Error(),
 		})
+		h.config.drop(SignalMetrics, 1, DropReasonValidation)
 		return
 	}
 
-	m := h.findOrCreateMetric(c.metricIdentity)
+	var first bool
+	defer func() { h.notifyFirstRecord(SignalMetrics, first) }()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	first = h.metricsEmpty()
+	if nonMonotonic {
+		c.nonMonotonic = true
+	}
+	m := c.findOrCreateCount()
+	m.c.Value += delta
+}
+
+// SetInterval sets the interval reported with the count rather than using the
+// harvest period from the common block.  The interval takes precedence over
+// the common block interval.  It applies to the count reported for the
+// current harvest period and to later increases made using this
+// AggregatedCount.  The interval must be positive.
+func (c *AggregatedCount) SetInterval(d time.Duration) {
+	if nil == c {
+		return
+	}
+	if d <= 0 {
+		return
+	}
+
+	h := c.harvester
+	if nil == h {
+		return
+	}
+
+	var first bool
+	defer func() { h.notifyFirstRecord(SignalMetrics, first) }()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	first = h.metricsEmpty()
+	c.interval = d
+	c.findOrCreateCount()
+}
+
+// findOrCreateCount finds or creates the count metric for the current harvest
+// period and applies the custom interval if one is set.  This function assumes
+// the Harvester is locked.
+func (c *AggregatedCount) findOrCreateCount() *metric {
+	m := c.harvester.findOrCreateMetric(c.metricIdentity)
 	if nil == m.c {
 		m.c = &Count{
 			Name:           c.Name,
 			AttributesJSON: json.RawMessage(c.attributesJSON),
 		}
 	}
-	m.c.Value += val
+	if c.interval > 0 {
+		m.c.Interval = c.interval
+		m.c.ForceIntervalValid = true
+	}
+	if c.nonMonotonic {
+		m.c.nonMonotonic = true
+	}
+	return m
 }
 
 // AggregatedGauge is the metric type that records a value that can increase or decrease.
@@ -84,7 +154,8 @@ func (c *AggregatedCount) Increase(val float64) {
 // time.  One typically records a AggregatedGauge value on a set interval.
 //
 // Only the most recent AggregatedGauge metric value is reported over a given harvest
-// period, all others are dropped.
+// period, all others are dropped.  The reported metric's timestamp is the time
+// at which that value was recorded, not the time of the harvest.
 //
 // Example possible uses:
 //
@@ -93,7 +164,19 @@ func (c *AggregatedCount) Increase(val float64) {
 //  * the bytes per second flowing into Kafka at this exact moment in time
 //  * the current speed of your car
 //
-type AggregatedGauge struct{ metricHandle }
+// Use SetSummarize to also report the distribution of the values recorded
+// over a harvest period.
+type AggregatedGauge struct {
+	metricHandle
+	// summarize is true if values are also added to the summary identified
+	// by summary.  See SetSummarize.
+	summarize bool
+	summary   metricIdentity
+}
+
+// gaugeSummarySuffix is appended to the name of an AggregatedGauge to name
+// the summary of its values.
+const gaugeSummarySuffix = ".summary"
 
 // valueNow facilitates testing.
 func (g *AggregatedGauge) valueNow(val float64, now time.Time) {
@@ -105,34 +188,85 @@ func (g *AggregatedGauge) valueNow(val float64, now time.Time) {
 		return
 	}
 
-	h.lock.Lock()
-	defer h.lock.Unlock()
-
 	if err := isFloatValid(val); err != nil {
 		h.config.logError(map[string]interface{}{
 			"message": "invalid aggregated gauge value",
 			"err":     err.Error(),
 		})
+		h.config.drop(SignalMetrics, 1, DropReasonValidation)
 		return
 	}
 
+	var first bool
+	defer func() { h.notifyFirstRecord(SignalMetrics, first) }()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	first = h.metricsEmpty()
+	if g.summarize {
+		h.summarize(g.summary, val)
+	}
 	m := h.findOrCreateMetric(g.metricIdentity)
 	if nil == m.g {
 		m.g = &Gauge{
 			Name:           g.Name,
 			AttributesJSON: json.RawMessage(g.attributesJSON),
 			Value:          val,
+			Timestamp:      now,
 		}
 	}
+	// Values sampled before the current value are ignored so that the
+	// latest sample wins regardless of the order in which they arrive.
+	if now.Before(m.g.Timestamp) {
+		return
+	}
 	m.g.Value = val
 	m.g.Timestamp = now
 }
 
-// Value records the value given.
+// Value records the value given at the current time.
 func (g *AggregatedGauge) Value(val float64) {
 	g.valueNow(val, time.Now())
 }
 
+// ValueAt records the value given as sampled at time t, which is used as the
+// timestamp of the reported metric.  If a value with a later timestamp has
+// already been recorded in this harvest period, this value is ignored.  If t
+// is zero the current time is used.
+func (g *AggregatedGauge) ValueAt(val float64, t time.Time) {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	g.valueNow(val, t)
+}
+
+// SetSummarize enables or disables summarizing the values recorded using this
+// AggregatedGauge.  When enabled, each value is also added to a summary named
+// after the gauge with the suffix ".summary" and the same attributes.  The
+// summary reports the count, sum, min, and max of the values recorded in the
+// harvest period, while the gauge still reports the last value.  This is
+// useful for gauges recorded many times per harvest period, such as the depth
+// of a queue sampled on every operation, where the last value alone hides
+// the distribution.
+func (g *AggregatedGauge) SetSummarize(enabled bool) {
+	if nil == g {
+		return
+	}
+	h := g.harvester
+	if nil == h {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	g.summarize = enabled
+	g.summary = metricIdentity{
+		Name:           g.Name + gaugeSummarySuffix,
+		attributesJSON: g.attributesJSON,
+	}
+}
+
 // AggregatedSummary is the metric type used for reporting aggregated information about
 // discrete events.   It provides the count, average, sum, min and max values
 // over time.  All fields are reset to 0 every reporting interval.
@@ -159,22 +293,32 @@ func (s *AggregatedSummary) Record(val float64) {
 		return
 	}
 
-	h.lock.Lock()
-	defer h.lock.Unlock()
-
 	if err := isFloatValid(val); err != nil {
 		h.config.logError(map[string]interface{}{
 			"message": "invalid aggregated summary value",
 			"err":     err.Error(),
 		})
+		h.config.drop(SignalMetrics, 1, DropReasonValidation)
 		return
 	}
 
-	m := h.findOrCreateMetric(s.metricIdentity)
+	var first bool
+	defer func() { h.notifyFirstRecord(SignalMetrics, first) }()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	first = h.metricsEmpty()
+	h.summarize(s.metricIdentity, val)
+}
+
+// summarize adds an observation to the summary with the given identity.  This
+// function assumes the Harvester is locked.
+func (h *Harvester) summarize(identity metricIdentity, val float64) {
+	m := h.findOrCreateMetric(identity)
 	if nil == m.s {
 		m.s = &Summary{
-			Name:           s.Name,
-			AttributesJSON: json.RawMessage(s.attributesJSON),
+			Name:           identity.Name,
+			AttributesJSON: json.RawMessage(identity.attributesJSON),
 			Count:          1,
 			Sum:            val,
 			Min:            val,
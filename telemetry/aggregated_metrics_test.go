@@ -60,6 +60,22 @@ func TestManyAttributes(t *testing.T) {
 	}
 }
 
+func TestHarvesterRecordDuration(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	attributes := map[string]interface{}{"zip": "zap"}
+	h.RecordDuration("myDuration", attributes, 2*time.Second)
+	h.RecordDuration("myDuration", attributes, 500*time.Millisecond)
+	expect := `[
+		{"name":"myDuration","type":"summary","value":{"sum":2500,"count":2,"min":500,"max":2000},"attributes":{"zip":"zap"}}
+	]`
+	testHarvesterMetrics(t, h, expect)
+}
+
+func TestNilHarvesterRecordDuration(t *testing.T) {
+	var h *Harvester
+	h.RecordDuration("myDuration", nil, time.Second)
+}
+
 func BenchmarkAggregatedMetric(b *testing.B) {
 	// This benchmark tests creating and aggregating a summary.
 	h, _ := NewHarvester(configTesting)
@@ -104,6 +120,33 @@ func TestGauge(t *testing.T) {
 	testHarvesterMetrics(t, h, expect)
 }
 
+func TestGaugeLastValueTimestamp(t *testing.T) {
+	sampled := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting)
+	gauge := h.MetricAggregator().Gauge("myGauge", map[string]interface{}{"zip": "zap"})
+	gauge.ValueAt(1, sampled)
+	gauge.ValueAt(2, sampled.Add(2*time.Second))
+	// An older sample arriving late must not replace the latest value.
+	gauge.ValueAt(3, sampled.Add(time.Second))
+
+	// The emitted timestamp is the sample time rather than the harvest time.
+	expect := `[{"name":"myGauge","type":"gauge","value":2,"timestamp":1417136462000,"attributes":{"zip":"zap"}}]`
+	testHarvesterMetrics(t, h, expect)
+}
+
+func TestGaugeValueAtZeroTime(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	before := time.Now()
+	h.MetricAggregator().Gauge("myGauge", nil).ValueAt(1, time.Time{})
+	metrics, _ := h.takeMetrics(time.Now())
+	if len(metrics) != 1 {
+		t.Fatal(metrics)
+	}
+	if ts := metrics[0].timestamp(); ts.Before(before) {
+		t.Error("zero time should be replaced with the current time", ts)
+	}
+}
+
 func TestNilAggregatorGauges(t *testing.T) {
 	now := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
 	var h *Harvester
@@ -122,6 +165,61 @@ func TestGaugeNilAggregator(t *testing.T) {
 	g.Value(10)
 }
 
+func TestGaugeSetSummarize(t *testing.T) {
+	sampled := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting)
+	gauge := h.MetricAggregator().Gauge("queue.depth", map[string]interface{}{"zip": "zap"})
+	gauge.SetSummarize(true)
+	for i := 0; i < 1000; i++ {
+		gauge.ValueAt(float64(i%100), sampled.Add(time.Duration(i)*time.Millisecond))
+	}
+	// An older sample arriving late is summarized but is not the last value.
+	gauge.ValueAt(500, sampled)
+
+	expect := `[
+		{"name":"queue.depth","type":"gauge","value":99,"timestamp":1417136460999,"attributes":{"zip":"zap"}},
+		{"name":"queue.depth.summary","type":"summary","value":{"sum":50000,"count":1001,"min":0,"max":500},"attributes":{"zip":"zap"}}
+	]`
+	testHarvesterMetrics(t, h, expect)
+
+	gauge.SetSummarize(false)
+	gauge.ValueAt(1, sampled)
+	expect = `[{"name":"queue.depth","type":"gauge","value":1,"timestamp":1417136460000,"attributes":{"zip":"zap"}}]`
+	testHarvesterMetrics(t, h, expect)
+}
+
+func TestGaugeSetSummarizeOtherHandles(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	summarized := h.MetricAggregator().Gauge("myGauge", nil)
+	summarized.SetSummarize(true)
+	summarized.Value(1)
+	// Values recorded using a different handle are not summarized.
+	h.MetricAggregator().Gauge("myGauge", nil).Value(2)
+	summarized.Value(3)
+
+	metrics, _ := h.takeMetrics(time.Now())
+	var found bool
+	for _, m := range metrics {
+		if s, ok := m.(*Summary); ok {
+			found = true
+			if s.Name != "myGauge.summary" || s.Count != 2 || s.Sum != 4 || s.Min != 1 || s.Max != 3 {
+				t.Error(s)
+			}
+		}
+	}
+	if !found || len(metrics) != 2 {
+		t.Error(metrics)
+	}
+}
+
+func TestNilGaugeSetSummarize(t *testing.T) {
+	var gauge *AggregatedGauge
+	gauge.SetSummarize(true)
+	g := AggregatedGauge{}
+	g.SetSummarize(true)
+	g.Value(10)
+}
+
 func TestCount(t *testing.T) {
 	h, _ := NewHarvester(configTesting)
 	count := h.MetricAggregator().Count("myCount", map[string]interface{}{"zip": "zap"})
@@ -132,6 +230,35 @@ func TestCount(t *testing.T) {
 	testHarvesterMetrics(t, h, expect)
 }
 
+func TestCountSetInterval(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	count := h.MetricAggregator().Count("myCount", map[string]interface{}{"zip": "zap"})
+	count.Increment()
+	count.SetInterval(time.Minute)
+	count.Increment()
+	// A different count is unaffected.
+	h.MetricAggregator().Count("other", nil).Increment()
+
+	expect := `[
+		{"name":"myCount","type":"count","value":2,"interval.ms":60000,"attributes":{"zip":"zap"}},
+		{"name":"other","type":"count","value":1,"attributes":{}}
+	]`
+	testHarvesterMetrics(t, h, expect)
+
+	// The interval is kept for later increases made using the same count.
+	count.Increment()
+	expect = `[{"name":"myCount","type":"count","value":1,"interval.ms":60000,"attributes":{"zip":"zap"}}]`
+	testHarvesterMetrics(t, h, expect)
+}
+
+func TestCountSetIntervalInvalid(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	count := h.MetricAggregator().Count("myCount", nil)
+	count.SetInterval(-time.Minute)
+	count.Increment()
+	testHarvesterMetrics(t, h, `[{"name":"myCount","type":"count","value":1,"attributes":{}}]`)
+}
+
 func TestCountNegative(t *testing.T) {
 	h, _ := NewHarvester(configTesting)
 	count := h.MetricAggregator().Count("myCount", map[string]interface{}{"zip": "zap"})
@@ -141,6 +268,29 @@ func TestCountNegative(t *testing.T) {
 	}
 }
 
+func TestCountAddNegativeDeltas(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	count := h.MetricAggregator().Count("myCount", nil)
+	count.Add(5)
+	count.Add(-2)
+	count.Add(-4.5)
+	testHarvesterMetrics(t, h, `[{"name":"myCount","type":"count","value":-1.5,"attributes":{}}]`)
+
+	count.Add(-1)
+	count.Add(3)
+	count.Increase(-10)
+	testHarvesterMetrics(t, h, `[{"name":"myCount","type":"count","value":2,"attributes":{}}]`)
+}
+
+func TestCountAddInvalid(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	count := h.MetricAggregator().Count("myCount", nil)
+	count.Add(math.Inf(-1))
+	if ms := h.swapOutMetrics(time.Now()); len(ms) != 0 {
+		t.Fatal(ms)
+	}
+}
+
 func TestNilAggregatorCounts(t *testing.T) {
 	var h *Harvester
 	count := h.MetricAggregator().Count("count", map[string]interface{}{})
@@ -152,12 +302,15 @@ func TestNilCountMethods(t *testing.T) {
 	var count *AggregatedCount
 	count.Increment()
 	count.Increase(5)
+	count.Add(-5)
+	count.SetInterval(time.Second)
 }
 
 func TestCountNilAggregator(t *testing.T) {
 	c := AggregatedCount{}
 	c.Increment()
 	c.Increase(1)
+	c.SetInterval(time.Second)
 }
 
 func TestSummary(t *testing.T) {
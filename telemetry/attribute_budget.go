@@ -0,0 +1,179 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+// attributeSizer is implemented by the groups whose attributes are limited by
+// Config.MaxAttributeBytesPerRequest.
+type attributeSizer interface {
+	// attributeBytes returns the serialized size in bytes of the
+	// attributes of the group's items.
+	attributeBytes() int
+}
+
+// attributesBytes returns the serialized size in bytes of the attributes,
+// which are either a map or already serialized.
+func attributesBytes(attributes map[string]interface{}, attributesJSON json.RawMessage) int {
+	if nil != attributes {
+		return len(internal.MarshalAttributes(attributes))
+	}
+	return len(attributesJSON)
+}
+
+func (group *spanGroup) attributeBytes() int {
+	n := 0
+	for _, s := range group.Spans {
+		n += attributesBytes(s.Attributes, nil)
+		for _, e := range s.Events {
+			n += attributesBytes(e.Attributes, e.AttributesJSON)
+		}
+		for _, l := range s.Links {
+			n += attributesBytes(l.Attributes, nil)
+		}
+	}
+	return n
+}
+
+func (group *metricGroup) attributeBytes() int {
+	n := 0
+	for _, m := range group.Metrics {
+		switch v := m.(type) {
+		case Count:
+			n += attributesBytes(v.Attributes, v.AttributesJSON)
+		case Summary:
+			n += attributesBytes(v.Attributes, v.AttributesJSON)
+		case Gauge:
+			n += attributesBytes(v.Attributes, v.AttributesJSON)
+		case Histogram:
+			n += attributesBytes(v.Attributes, v.AttributesJSON)
+		}
+	}
+	return n
+}
+
+func (group *eventGroup) attributeBytes() int {
+	n := 0
+	common := attributesBytes(group.commonAttributes, nil)
+	for _, e := range group.Events {
+		n += common + attributesBytes(e.Attributes, e.AttributesJSON)
+	}
+	return n
+}
+
+func (group *logGroup) attributeBytes() int {
+	n := 0
+	for _, l := range group.Logs {
+		n += attributesBytes(l.Attributes, nil)
+	}
+	return n
+}
+
+// batchesAttributeBytes returns the serialized size in bytes of the
+// attributes of the items in the batches.  Common block attributes are not
+// included.
+func batchesAttributeBytes(batches []Batch) int {
+	n := 0
+	for _, batch := range batches {
+		for _, e := range batch {
+			if sizer, ok := e.(attributeSizer); ok {
+				n += sizer.attributeBytes()
+			}
+		}
+	}
+	return n
+}
+
+// splitAttributeBytes splits the batches, in the same way that oversized
+// requests are split, until the attributes of each part total at most max
+// bytes.  Parts with a single item exceeding max are not split further.
+func splitAttributeBytes(batches []Batch, max int, strategy SplitStrategy) [][]Batch {
+	if max <= 0 || batchesAttributeBytes(batches) <= max {
+		return [][]Batch{batches}
+	}
+
+	var split1, split2 []Batch
+	if len(batches) > 1 {
+		middle := len(batches) / 2
+		split1 = batches[0:middle]
+		split2 = batches[middle:]
+	} else {
+		var entries1, entries2 []MapEntry
+		wasSplit := false
+		for _, e := range batches[0] {
+			if splittable, ok := e.(splittablePayloadEntry); ok {
+				if parts := splittable.split(strategy); parts != nil {
+					entries1 = append(entries1, parts[0])
+					entries2 = append(entries2, parts[1])
+					wasSplit = true
+					continue
+				}
+			}
+			entries1 = append(entries1, e)
+			entries2 = append(entries2, e)
+		}
+		if !wasSplit {
+			return [][]Batch{batches}
+		}
+		split1 = []Batch{entries1}
+		split2 = []Batch{entries2}
+	}
+	return append(splitAttributeBytes(split1, max, strategy), splitAttributeBytes(split2, max, strategy)...)
+}
+
+// buildRequests builds the requests for the batches of the signal using the
+// factory.  Items too large to send even on their own are dropped, and the
+// rest are sent.
+func (h *Harvester) buildRequests(signal Signal, batches []Batch, factory RequestFactory) ([]*http.Request, error) {
+	reqs, oversized, err := h.splitBatches(batches, factory)
+	for _, r := range oversized {
+		h.dropOversized(signal, r)
+	}
+	return reqs, err
+}
+
+// splitBatches builds the requests for the batches using the factory.  The
+// batches are first split so that the attributes of each request total at
+// most Config.MaxAttributeBytesPerRequest, and each part is then split further
+// if its request is too large.  The requests which are too large but cannot
+// be split further are returned in oversized.
+func (h *Harvester) splitBatches(batches []Batch, factory RequestFactory) (reqs, oversized []*http.Request, err error) {
+	strategy := h.config.splitStrategy()
+	parts := splitAttributeBytes(batches, h.config.MaxAttributeBytesPerRequest, strategy)
+	if len(parts) > 1 {
+		h.config.logDebug(map[string]interface{}{
+			"event":     "data split due to attribute size",
+			"parts":     len(parts),
+			"max-bytes": h.config.MaxAttributeBytesPerRequest,
+		})
+	}
+	for _, part := range parts {
+		rs, os, err := buildSplitRequestsSkippingOversized(part, factory, strategy)
+		if nil != err {
+			return nil, nil, err
+		}
+		reqs = append(reqs, rs...)
+		oversized = append(oversized, os...)
+	}
+	return reqs, oversized, nil
+}
+
+// dropOversized drops the data of a request which is too large to send but
+// cannot be split further.
+func (h *Harvester) dropOversized(signal Signal, r *http.Request) {
+	count := countRequestItems(r, h.config.CompressionDictionary)
+	h.config.logError(map[string]interface{}{
+		"message":          "dropping data too large to send",
+		"data-type":        signal.String(),
+		"count":            count,
+		"compressed-bytes": r.ContentLength,
+		"err":              errUnableToSplit.Error(),
+	})
+	h.config.drop(signal, count, DropReasonTooLarge)
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+func TestMaxAttributeBytesPerRequest(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.MaxAttributeBytesPerRequest = 10 * 1024
+	})
+	value := strings.Repeat("a", 1000)
+	for i := 0; i < 50; i++ {
+		h.RecordLog(Log{Message: "message", Attributes: map[string]interface{}{"big": value}})
+	}
+	reqs := h.swapOutLogs()
+	// Each log has just over 1000 bytes of attributes, so at most 9 fit in
+	// each request.
+	if len(reqs) < 6 {
+		t.Fatal(len(reqs))
+	}
+	logs := 0
+	for _, req := range reqs {
+		body, _ := ioutil.ReadAll(req.Body)
+		js, _ := internal.Uncompress(body)
+		var payload []struct {
+			Logs []struct {
+				Attributes map[string]interface{} `json:"attributes"`
+			} `json:"logs"`
+		}
+		if err := json.Unmarshal(js, &payload); err != nil {
+			t.Fatal(err)
+		}
+		attributeBytes := 0
+		for _, l := range payload[0].Logs {
+			attributeBytes += attributesBytes(l.Attributes, nil)
+		}
+		if attributeBytes > 10*1024 {
+			t.Error(attributeBytes)
+		}
+		logs += len(payload[0].Logs)
+	}
+	if logs != 50 {
+		t.Error(logs)
+	}
+}
+
+func TestMaxAttributeBytesPerRequestUnset(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	value := strings.Repeat("a", 1000)
+	for i := 0; i < 50; i++ {
+		h.RecordLog(Log{Message: "message", Attributes: map[string]interface{}{"big": value}})
+	}
+	if reqs := h.swapOutLogs(); len(reqs) != 1 {
+		t.Error(len(reqs))
+	}
+}
+
+func TestMaxAttributeBytesPerRequestSingleItem(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.MaxAttributeBytesPerRequest = 100
+	})
+	value := strings.Repeat("a", 1000)
+	h.RecordEvent(Event{EventType: "MyEvent", Attributes: map[string]interface{}{"big": value}})
+	h.RecordEvent(Event{EventType: "MyEvent", AttributesJSON: json.RawMessage(`{"small":1}`)})
+	if reqs := h.swapOutEvents(); len(reqs) != 2 {
+		t.Error(len(reqs))
+	}
+}
+
+func TestBatchesAttributeBytes(t *testing.T) {
+	batches := []Batch{
+		{
+			&metricCommonBlock{attributes: &cachedMapEntry{}},
+			&metricGroup{Metrics: []Metric{
+				Gauge{Name: "gauge", Attributes: map[string]interface{}{"zip": "zap"}},
+				Count{Name: "count", AttributesJSON: json.RawMessage(`{"a":1}`)},
+			}},
+		},
+		{&spanGroup{Spans: []Span{{
+			Attributes: map[string]interface{}{"zip": "zap"},
+			Events:     []Event{{Attributes: map[string]interface{}{"a": 1}}},
+			Links:      []SpanLink{{Attributes: map[string]interface{}{"a": 1}}},
+		}}}},
+	}
+	// {"zip":"zap"} is 13 bytes and {"a":1} is 7 bytes.
+	if n := batchesAttributeBytes(batches); n != 13+7+13+7+7 {
+		t.Error(n)
+	}
+}
@@ -6,6 +6,8 @@ package telemetry
 import (
 	"bytes"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
@@ -17,12 +19,34 @@ func attributeValueValid(val interface{}) bool {
 		int32, int64, float32, float64, uint, int, uintptr:
 		return true
 	default:
-		return false
+		_, ok := internal.ConvertAttribute(val)
+		return ok
 	}
 }
 
+// RegisterAttributeType registers a conversion for attribute values with the
+// same type as example.  Attribute values of that type are passed to convert,
+// which must return a string, bool, or number, before being serialized.  Use
+// this to send custom ID or numeric types as their natural value instead of
+// as the name of their type.  Registrations apply to all Harvesters and
+// RequestFactories and should be made during program initialization.
+//
+// By default, time.Time attribute values are sent as milliseconds since the
+// epoch and []byte values are sent as base64 strings.  Register a conversion
+// for those types to change their encoding, eg. to send times as RFC3339
+// strings:
+//
+//	telemetry.RegisterAttributeType(time.Time{}, func(v interface{}) interface{} {
+//		return v.(time.Time).Format(time.RFC3339)
+//	})
+func RegisterAttributeType(example interface{}, convert func(interface{}) interface{}) {
+	internal.RegisterAttributeConverter(reflect.TypeOf(example), convert)
+}
+
 type errInvalidAttributes struct {
 	msg string
+	// keys are the names of the invalid attributes in sorted order.
+	keys []string
 }
 
 func (e errInvalidAttributes) Error() string {
@@ -46,14 +70,17 @@ func vetAttributes(attributes map[string]interface{}) (map[string]interface{}, e
 	// improve performance.
 	validAttributes := make(map[string]interface{}, len(attributes))
 	var errStrs []string
+	var keys []string
 	for key, val := range attributes {
 		if attributeValueValid(val) {
 			validAttributes[key] = val
 		} else {
 			errStrs = append(errStrs, fmt.Sprintf(`attribute "%s" has invalid type %T`, key, val))
+			keys = append(keys, key)
 		}
 	}
-	return validAttributes, errInvalidAttributes{strings.Join(errStrs, ",")}
+	sort.Strings(keys)
+	return validAttributes, errInvalidAttributes{msg: strings.Join(errStrs, ","), keys: keys}
 }
 
 type commonAttributes struct {
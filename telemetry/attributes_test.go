@@ -1,8 +1,11 @@
 package telemetry
 
 import (
+	"bytes"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func Test_newCommonAttributes(t *testing.T) {
@@ -45,3 +48,70 @@ func Test_newCommonAttributes(t *testing.T) {
 		})
 	}
 }
+
+type testAccountID uint32
+
+type testHexID uint64
+
+func TestRegisterAttributeType(t *testing.T) {
+	RegisterAttributeType(testAccountID(0), func(v interface{}) interface{} {
+		return uint32(v.(testAccountID))
+	})
+	RegisterAttributeType(testHexID(0), func(v interface{}) interface{} {
+		return strconv.FormatUint(uint64(v.(testHexID)), 16)
+	})
+
+	attributes := map[string]interface{}{
+		"account": testAccountID(12345),
+		"hex":     testHexID(255),
+	}
+	if _, err := vetAttributes(attributes); err != nil {
+		t.Error("registered types should be valid attributes", err)
+	}
+
+	buf := &bytes.Buffer{}
+	NewEventGroup([]Event{{EventType: "myEvent", Attributes: map[string]interface{}{
+		"account": testAccountID(12345),
+	}}}).WriteDataEntry(buf)
+	if js := buf.String(); js != `{"eventType":"myEvent","account":12345}` {
+		t.Error(js)
+	}
+
+	common, _ := newCommonAttributes(map[string]interface{}{"hex": testHexID(255)})
+	if js := common.WriteDataEntry(&bytes.Buffer{}).String(); js != `{"hex":"ff"}` {
+		t.Error(js)
+	}
+}
+
+type testUnregisteredID uint64
+
+func TestUnregisteredAttributeTypeInvalid(t *testing.T) {
+	if attributeValueValid(testUnregisteredID(1)) {
+		t.Error("unregistered types should not be valid attributes")
+	}
+}
+
+func TestTimeAndBytesAttributes(t *testing.T) {
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	attributes := map[string]interface{}{
+		"time":  tm,
+		"bytes": []byte("id"),
+	}
+	if _, err := vetAttributes(attributes); err != nil {
+		t.Error("time and bytes should be valid attributes", err)
+	}
+
+	buf := &bytes.Buffer{}
+	NewEventGroup([]Event{{EventType: "myEvent", Timestamp: tm, Attributes: map[string]interface{}{
+		"time": tm,
+	}}}).WriteDataEntry(buf)
+	if js := buf.String(); js != `{"eventType":"myEvent","timestamp":1417136460000,"time":1417136460000}` {
+		t.Error(js)
+	}
+
+	// Other unsupported types are still rejected.
+	_, err := vetAttributes(map[string]interface{}{"pointer": &tm})
+	if e, ok := err.(errInvalidAttributes); !ok || !reflect.DeepEqual(e.keys, []string{"pointer"}) {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+const (
+	dnsDurationAttribute      = "http.dns.duration.ms"
+	connectDurationAttribute  = "http.connect.duration.ms"
+	tlsDurationAttribute      = "http.tls.duration.ms"
+	connectionReusedAttribute = "http.connection.reused"
+)
+
+// clientTrace accumulates the phase timings of a single outbound request.
+// The httptrace hooks may be called from different goroutines.
+type clientTrace struct {
+	harvester *Harvester
+	span      Span
+
+	lock                   sync.Mutex
+	dnsStart, dnsDone      time.Time
+	connectStart           time.Time
+	connectDone            time.Time
+	tlsStart, tlsDone      time.Time
+	reused, gotConn, ended bool
+}
+
+// ClientTrace returns an httptrace.ClientTrace which records s as a client
+// span when the first byte of the response is received.  If s.Timestamp is
+// unset it is set to when the request began fetching a connection.  The
+// span's Duration is set to the time from Timestamp until the first response
+// byte, and the durations of the DNS, connect, and TLS phases are added to
+// its attributes in milliseconds.  Phases which did not happen, eg. because
+// a connection was reused, are omitted.  ID and TraceID must be set on s.  No
+// span is recorded if the request fails before a response is received.
+//
+// Use httptrace.WithClientTrace to add the trace to a request's context:
+//
+//	span := telemetry.Span{ID: spanID, TraceID: traceID, Name: "GET /users"}
+//	trace := h.ClientTrace(span)
+//	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+//	resp, err := http.DefaultClient.Do(req)
+func (h *Harvester) ClientTrace(s Span) *httptrace.ClientTrace {
+	t := &clientTrace{
+		harvester: h,
+		span:      s,
+	}
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			if t.span.Timestamp.IsZero() {
+				t.span.Timestamp = time.Now()
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			t.gotConn = true
+			t.reused = info.Reused
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			t.dnsDone = time.Now()
+		},
+		// Multiple addresses may be dialed concurrently, so the connect
+		// phase spans from the first dial to the last one to finish.
+		ConnectStart: func(string, string) {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(string, string, error) {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			t.connectDone = time.Now()
+		},
+		TLSHandshakeStart: func() {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			t.tlsDone = time.Now()
+		},
+		GotFirstResponseByte: t.end,
+	}
+}
+
+// phaseDuration returns the duration of a phase in milliseconds and whether
+// the phase completed.
+func phaseDuration(start, done time.Time) (float64, bool) {
+	if start.IsZero() || done.IsZero() || done.Before(start) {
+		return 0, false
+	}
+	return done.Sub(start).Seconds() * 1000.0, true
+}
+
+func (t *clientTrace) end() {
+	now := time.Now()
+
+	t.lock.Lock()
+	if t.ended {
+		t.lock.Unlock()
+		return
+	}
+	t.ended = true
+	s := t.span
+	if s.Timestamp.IsZero() {
+		s.Timestamp = now
+	}
+	s.Duration = now.Sub(s.Timestamp)
+	// Copy the attributes since the map is owned by the caller.
+	attributes := make(map[string]interface{}, len(s.Attributes)+5)
+	for k, v := range s.Attributes {
+		attributes[k] = v
+	}
+	if _, ok := attributes[spanKindAttribute]; !ok && s.Kind == "" {
+		s.Kind = SpanKindClient
+	}
+	if d, ok := phaseDuration(t.dnsStart, t.dnsDone); ok {
+		attributes[dnsDurationAttribute] = d
+	}
+	if d, ok := phaseDuration(t.connectStart, t.connectDone); ok {
+		attributes[connectDurationAttribute] = d
+	}
+	if d, ok := phaseDuration(t.tlsStart, t.tlsDone); ok {
+		attributes[tlsDurationAttribute] = d
+	}
+	if t.gotConn {
+		attributes[connectionReusedAttribute] = t.reused
+	}
+	s.Attributes = attributes
+	t.lock.Unlock()
+
+	if nil == t.harvester {
+		return
+	}
+	if err := t.harvester.RecordSpan(s); nil != err {
+		t.harvester.config.logError(map[string]interface{}{
+			"err":     err.Error(),
+			"message": "unable to record client span",
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+)
+
+func doTracedRequest(t *testing.T, h *Harvester, client *http.Client, url string, s Span) {
+	req, _ := http.NewRequest("GET", url, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), h.ClientTrace(s)))
+	resp, err := client.Do(req)
+	if nil != err {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func takeSingleSpan(t *testing.T, h *Harvester) Span {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.spans) != 1 {
+		t.Fatal("incorrect number of spans recorded", len(h.spans))
+	}
+	s := h.spans[0]
+	h.spans = nil
+	return s
+}
+
+func TestClientTrace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer srv.Close()
+	// Use localhost rather than the server's IP address so that a DNS
+	// lookup is made.
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	h, _ := NewHarvester(configTesting)
+	attrs := map[string]interface{}{"http.method": "GET"}
+	doTracedRequest(t, h, client, url, Span{ID: "1", TraceID: "tid", Name: "outbound", Attributes: attrs})
+	s := takeSingleSpan(t, h)
+	if s.ID != "1" || s.TraceID != "tid" || s.Name != "outbound" {
+		t.Error(s)
+	}
+	if s.Timestamp.IsZero() || s.Duration <= 0 {
+		t.Error(s.Timestamp, s.Duration)
+	}
+	if len(attrs) != 1 {
+		t.Error("caller's attributes modified", attrs)
+	}
+	for _, key := range []string{dnsDurationAttribute, connectDurationAttribute} {
+		if d, ok := s.Attributes[key].(float64); !ok || d < 0 {
+			t.Error(key, s.Attributes[key])
+		}
+	}
+	if _, ok := s.Attributes[tlsDurationAttribute]; ok {
+		t.Error("tls duration recorded without tls", s.Attributes)
+	}
+	if s.Kind != SpanKindClient ||
+		s.Attributes[connectionReusedAttribute] != false ||
+		s.Attributes["http.method"] != "GET" {
+		t.Error(s.Attributes)
+	}
+
+	// A second request reuses the connection, so there are no dns or
+	// connect phases.
+	doTracedRequest(t, h, client, url, Span{ID: "2", TraceID: "tid"})
+	s = takeSingleSpan(t, h)
+	if s.Attributes[connectionReusedAttribute] != true {
+		t.Error(s.Attributes)
+	}
+	for _, key := range []string{dnsDurationAttribute, connectDurationAttribute, tlsDurationAttribute} {
+		if _, ok := s.Attributes[key]; ok {
+			t.Error(key, s.Attributes)
+		}
+	}
+}
+
+func TestClientTraceTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer srv.Close()
+
+	h, _ := NewHarvester(configTesting)
+	doTracedRequest(t, h, srv.Client(), srv.URL, Span{ID: "1", TraceID: "tid"})
+	s := takeSingleSpan(t, h)
+	for _, key := range []string{connectDurationAttribute, tlsDurationAttribute} {
+		if d, ok := s.Attributes[key].(float64); !ok || d < 0 {
+			t.Error(key, s.Attributes[key])
+		}
+	}
+	if _, ok := s.Attributes[dnsDurationAttribute]; ok {
+		t.Error("dns duration recorded for an ip address", s.Attributes)
+	}
+}
+
+func TestClientTraceInvalidSpan(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var logged []map[string]interface{}
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.ErrorLogger = func(fields map[string]interface{}) { logged = append(logged, fields) }
+	})
+	doTracedRequest(t, h, srv.Client(), srv.URL, Span{ID: "1"})
+	if len(logged) != 1 || logged[0]["err"] != errTraceIDUnset.Error() {
+		t.Error(logged)
+	}
+}
+
+func TestClientTraceNilHarvester(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var h *Harvester
+	doTracedRequest(t, h, srv.Client(), srv.URL, Span{ID: "1", TraceID: "tid"})
+}
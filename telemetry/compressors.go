@@ -0,0 +1,97 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+// dictionaryCompressor is a Compressor that uses zlib with a preset
+// dictionary.  Writers are pooled since they are expensive to create.
+type dictionaryCompressor struct {
+	dict    []byte
+	writers sync.Pool
+}
+
+// pooledZlibWriter returns its zlib.Writer to the pool when closed.
+type pooledZlibWriter struct {
+	*zlib.Writer
+	pool *sync.Pool
+}
+
+func (w *pooledZlibWriter) Close() error {
+	err := w.Writer.Close()
+	w.pool.Put(w.Writer)
+	return err
+}
+
+func (c *dictionaryCompressor) Writer(w io.Writer) io.WriteCloser {
+	if zw, ok := c.writers.Get().(*zlib.Writer); ok {
+		zw.Reset(w)
+		return &pooledZlibWriter{Writer: zw, pool: &c.writers}
+	}
+	// An error is only possible with an invalid compression level.
+	zw, _ := zlib.NewWriterLevelDict(w, zlib.DefaultCompression, c.dict)
+	return &pooledZlibWriter{Writer: zw, pool: &c.writers}
+}
+
+func (c *dictionaryCompressor) Encoding() string {
+	return "deflate"
+}
+
+// WithCompressionDictionary creates a ClientOption that compresses request
+// bodies with zlib ("deflate" Content-Encoding) using the given preset
+// dictionary.  A dictionary containing the attribute keys and values that
+// appear in most payloads can greatly improve the compression ratio of
+// repetitive log and span data.
+//
+// The gzip format does not support preset dictionaries, so this replaces the
+// default gzip compression.  Any decoder of these requests must be configured
+// with the same dictionary.  Only use this option with endpoints that accept
+// deflate bodies compressed with this dictionary.
+func WithCompressionDictionary(dict []byte) ClientOption {
+	return WithCompressor(&dictionaryCompressor{dict: dict})
+}
+
+// uncompressBody uncompresses a request body with the given Content-Encoding.
+// dict is the preset dictionary of deflate encoded bodies, see
+// WithCompressionDictionary.  All other bodies are expected to be gzip
+// encoded.
+func uncompressBody(compressed []byte, encoding string, dict []byte) ([]byte, error) {
+	if encoding != "deflate" {
+		return internal.Uncompress(compressed)
+	}
+	r, err := zlib.NewReaderDict(bytes.NewReader(compressed), dict)
+	if nil != err {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// readRequestBody returns the uncompressed body of a request without
+// consuming it.  An error is returned if the request has no GetBody.
+func readRequestBody(req *http.Request, dict []byte) ([]byte, error) {
+	if nil == req.GetBody {
+		return nil, errors.New("request body cannot be read")
+	}
+	bodyReader, err := req.GetBody()
+	if nil != err {
+		return nil, err
+	}
+	defer bodyReader.Close()
+	compressed, err := ioutil.ReadAll(bodyReader)
+	if nil != err {
+		return nil, err
+	}
+	return uncompressBody(compressed, req.Header.Get("Content-Encoding"), dict)
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logDictionary contains the keys and values common to testLogBatch.
+var logDictionary = []byte(`{"message":"","timestamp":,"attributes":{"service.name":"checkout","host.name":"prod-web-","log.level":"INFO","http.method":"GET","http.url":"/api/v1/cart"}}`)
+
+func testLogBatch(n int) []Batch {
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	var logs []Log
+	for i := 0; i < n; i++ {
+		logs = append(logs, Log{
+			Message:   fmt.Sprintf("request %d served", i),
+			Timestamp: tm.Add(time.Duration(i) * time.Millisecond),
+			Attributes: map[string]interface{}{
+				"service.name": "checkout",
+				"host.name":    fmt.Sprintf("prod-web-%d", i%3),
+				"log.level":    "INFO",
+				"http.method":  "GET",
+				"http.url":     "/api/v1/cart",
+			},
+		})
+	}
+	return []Batch{{NewLogGroup(logs)}}
+}
+
+func TestCompressionDictionaryRoundTrip(t *testing.T) {
+	f, _ := NewLogRequestFactory(WithInsertKey("key!"), WithCompressionDictionary(logDictionary))
+	batches := testLogBatch(5)
+	expect := &bytes.Buffer{}
+	bufferRequestBytes(expect, batches)
+
+	// Build twice to exercise writer reuse from the pool.
+	for i := 0; i < 2; i++ {
+		req, err := f.BuildRequest(context.Background(), batches)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc := req.Header.Get("Content-Encoding"); enc != "deflate" {
+			t.Error("incorrect Content-Encoding header", enc)
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		r, err := zlib.NewReaderDict(bytes.NewReader(body), logDictionary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, _ := ioutil.ReadAll(r)
+		var expectJSON, actualJSON interface{}
+		json.Unmarshal(expect.Bytes(), &expectJSON)
+		if err := json.Unmarshal(actual, &actualJSON); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expectJSON, actualJSON) {
+			t.Errorf("\nexpect=%s\nactual=%s\n", expect.String(), actual)
+		}
+	}
+}
+
+func TestConfigCompressionDictionary(t *testing.T) {
+	var lock sync.Mutex
+	var bodies []string
+	var results []HarvestResult
+	buf := &bytes.Buffer{}
+	h, _ := NewHarvester(configTesting, ConfigFallbackWriter(buf), func(cfg *Config) {
+		cfg.CompressionDictionary = logDictionary
+		cfg.HarvestCallback = func(r HarvestResult) {
+			lock.Lock()
+			defer lock.Unlock()
+			results = append(results, r)
+		}
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			if enc := req.Header.Get("Content-Encoding"); enc != "deflate" {
+				t.Error("incorrect Content-Encoding header", enc)
+			}
+			compressed, _ := ioutil.ReadAll(req.Body)
+			r, err := zlib.NewReaderDict(bytes.NewReader(compressed), logDictionary)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(r)
+			bodies = append(bodies, string(body))
+			return emptyResponse(413), nil
+		})
+	})
+	h.RecordLog(Log{Message: "message", Timestamp: time.Now()})
+	h.RecordEvent(Event{EventType: "event", Timestamp: time.Now()})
+	h.HarvestNow(context.Background())
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(bodies) != 2 {
+		t.Fatal(bodies)
+	}
+	// The bodies are read back with the dictionary for the harvest results
+	// and the fallback writer.
+	if len(results) != 2 {
+		t.Error(results)
+	}
+	for _, r := range results {
+		if r.Items != 1 {
+			t.Error(r)
+		}
+	}
+	lines := parseFallbackLines(t, buf)
+	if len(lines) != 2 {
+		t.Error(lines)
+	}
+}
+
+func benchmarkLogCompression(b *testing.B, options ...ClientOption) {
+	f, _ := NewLogRequestFactory(append([]ClientOption{WithInsertKey("key!")}, options...)...)
+	batches := testLogBatch(20)
+	uncompressed := &bytes.Buffer{}
+	bufferRequestBytes(uncompressed, batches)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var compressedSize int64
+	for i := 0; i < b.N; i++ {
+		r, _ := f.BuildRequest(context.Background(), batches)
+		compressedSize = r.ContentLength
+	}
+	b.ReportMetric(float64(uncompressed.Len())/float64(compressedSize), "ratio")
+}
+
+// These benchmarks report the compression ratio of a small batch of
+// repetitive logs with and without a preset dictionary.
+func BenchmarkLogCompressionGzip(b *testing.B) { benchmarkLogCompression(b) }
+func BenchmarkLogCompressionDictionary(b *testing.B) {
+	benchmarkLogCompression(b, WithCompressionDictionary(logDictionary))
+}
@@ -4,32 +4,94 @@
 package telemetry
 
 import (
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal/uuid"
 )
 
+// Logger is a structured logger that can be set as Config.Logger.  msg is
+// the "message" field, or the "event" field if there is no message, of the
+// fields that would be sent to the func based loggers.  fields contains the
+// remaining fields and may be retained by the Logger.
+type Logger interface {
+	Error(msg string, fields map[string]interface{})
+	Debug(msg string, fields map[string]interface{})
+}
+
 // Config customizes the behavior of a Harvester.
 type Config struct {
 	// APIKey is required and refers to your New Relic Insert API key.
 	APIKey string
-	// Client is the http.Client used for making requests.
+	// UseLicenseKey indicates that APIKey is a New Relic license key rather
+	// than an Insert API key.
+	UseLicenseKey bool
+	// SpansAPIKey overrides APIKey for span requests if not empty.
+	SpansAPIKey string
+	// MetricsAPIKey overrides APIKey for metric requests if not empty.
+	MetricsAPIKey string
+	// EventsAPIKey overrides APIKey for event requests if not empty.
+	EventsAPIKey string
+	// LogsAPIKey overrides APIKey for log requests if not empty, eg. to use
+	// a key dedicated to log forwarding.  UseLicenseKey applies to all of
+	// the keys.
+	LogsAPIKey string
+	// Client is the http.Client used for making requests.  When its
+	// Transport is nil or an http.Transport with compression enabled, the
+	// Harvester sets Accept-Encoding: gzip on its requests and decompresses
+	// the responses itself.  Requests sent through any other RoundTripper
+	// are left without an Accept-Encoding header.
 	Client *http.Client
 	// HarvestTimeout is the total amount of time including retries that the
 	// Harvester may use trying to harvest data.  By default, HarvestTimeout
 	// is set to 15 seconds.
 	HarvestTimeout time.Duration
-	// CommonAttributes are the attributes to be applied to all metrics that
-	// use this Config. They are not applied to spans.
+	// CommonAttributes are the attributes to be applied to all metrics,
+	// spans, and logs that use this Config.  They are not applied to
+	// events, since the Event API has no common block.
 	CommonAttributes map[string]interface{}
+	// SpanCommonAttributes are the attributes to be applied to all spans
+	// in addition to CommonAttributes.  They take precedence over the
+	// CommonAttributes with the same keys.
+	SpanCommonAttributes map[string]interface{}
+	// LogCommonAttributes are the attributes to be applied to all logs in
+	// addition to CommonAttributes.  They take precedence over the
+	// CommonAttributes with the same keys.
+	LogCommonAttributes map[string]interface{}
+	// EventCommonAttributes are the attributes to be applied to all
+	// events.  They are added to each event, except where the event has
+	// an attribute with the same key.
+	EventCommonAttributes map[string]interface{}
 	// HarvestPeriod controls how frequently data will be sent to New Relic.
 	// If HarvestPeriod is zero then NewHarvester will not spawn a goroutine
 	// to send data and it is incumbent on the consumer to call
-	// Harvester.HarvestNow when data should be sent. By default, HarvestPeriod
-	// is set to 5 seconds.
+	// Harvester.HarvestNow when data should be sent, unless one of the type
+	// harvest periods below is set. By default, HarvestPeriod is set to 5
+	// seconds.
 	HarvestPeriod time.Duration
+	// SpansHarvestPeriod, MetricsHarvestPeriod, EventsHarvestPeriod, and
+	// LogsHarvestPeriod, if non-zero, override HarvestPeriod for a single
+	// type of data, which is then harvested on its own schedule.  For
+	// example, logs may be sent every second while metrics are aggregated
+	// over a minute.  Types with the same period are harvested together.
+	// The heartbeat event is recorded when events are harvested, logs are
+	// only correlated with spans that are harvested at the same time, and
+	// CoalesceRequests only applies to the types harvested every
+	// HarvestPeriod.
+	SpansHarvestPeriod   time.Duration
+	MetricsHarvestPeriod time.Duration
+	EventsHarvestPeriod  time.Duration
+	LogsHarvestPeriod    time.Duration
 	// ErrorLogger receives errors that occur in this sdk.
 	ErrorLogger func(map[string]interface{})
 	// DebugLogger receives structured debug log messages.
@@ -37,6 +99,21 @@ type Config struct {
 	// AuditLogger receives structured log messages that include the
 	// uncompressed data sent to New Relic.  Use this to log all data sent.
 	AuditLogger func(map[string]interface{})
+	// AuditBodyCompressed, if true, puts the compressed request body, base64
+	// encoded, in audit messages under "data-compressed" instead of the
+	// uncompressed body under "data".  This keeps audit logs of large
+	// payloads small.
+	AuditBodyCompressed bool
+	// AuditBodySink, if set, receives the full uncompressed body of each
+	// request sent, keyed by a unique id.  Audit messages then contain the
+	// id under "body-id" instead of the body, keeping the audit log
+	// readable.  AuditBodySink may be called concurrently.
+	AuditBodySink func(id string, body []byte)
+	// Logger, if set, receives error and debug messages instead of
+	// ErrorLogger and DebugLogger.  Use this to integrate with a
+	// structured logging library.  Audit messages are only sent to
+	// AuditLogger.
+	Logger Logger
 	// MetricsURLOverride overrides the metrics endpoint if not empty.
 	MetricsURLOverride string
 	// SpansURLOverride overrides the spans endpoint if not empty.
@@ -45,6 +122,15 @@ type Config struct {
 	// Trace Observer URL.  See
 	// https://docs.newrelic.com/docs/understand-dependencies/distributed-tracing/enable-configure/enable-distributed-tracing
 	SpansURLOverride string
+	// SpansMirrorURL, if not empty, is a second spans endpoint to which all
+	// spans are also sent.  This is useful when migrating to Infinite
+	// Tracing: set it to your Trace Observer URL to compare it with the
+	// standard trace endpoint.  Requests to each endpoint are retried
+	// independently.  The mirror requests are best effort: they are not
+	// counted by HarvestCallback, PayloadSizeObserver,
+	// ReportInternalMetrics, or MaxRequestsPerHarvest, and their data is
+	// not written to the FallbackWriter or passed to OnDrop.
+	SpansMirrorURL string
 	// EventsURLOverride overrides the events endpoint if not empty
 	EventsURLOverride string
 	// LogsURLOverride overrides the logs endpoint if not empty.
@@ -53,8 +139,330 @@ type Config struct {
 	Product string
 	// ProductVersion is added to the User-Agent header. eg. "0.1.0".
 	ProductVersion string
+	// SplitStrategy decides where data is divided when a request payload is
+	// too large to send.  By default, CountSplitStrategy is used.  Use
+	// ByteSizeSplitStrategy when item sizes vary widely.
+	SplitStrategy SplitStrategy
+	// HeartbeatEventType enables heartbeat events if not empty.  When set,
+	// the Harvester records an event of this type on every harvest with the
+	// Harvester's uptime and the SDK version.  The absence of heartbeat
+	// events in New Relic can be used to detect a process that has died.
+	HeartbeatEventType string
+	// SpanDurationUnit controls the unit, and attribute name, used to
+	// report Span.Duration.  By default, durations are reported in
+	// milliseconds using the "duration.ms" attribute.
+	SpanDurationUnit SpanDurationUnit
+	// MaxDataAge is the maximum age of buffered data.  If MaxDataAge is
+	// non-zero, metrics, spans, events, and logs with a timestamp older
+	// than MaxDataAge at harvest time are dropped rather than sent, since
+	// New Relic may reject data with timestamps outside of its accepted
+	// window.  Metrics without a timestamp are never dropped.
+	MaxDataAge time.Duration
+	// MetricsRejectedCallback is called with the metrics that the metrics
+	// endpoint reports as permanently rejected in a successful response.
+	// These metrics are dropped.  Metrics that the endpoint reports as
+	// retryable are re-queued and sent in the next harvest.
+	MetricsRejectedCallback func([]RejectedMetric)
+	// ClampFutureTimestamps enables clamping of timestamps that are more
+	// than FutureTimestampSkew ahead of the current time at harvest.  These
+	// timestamps, which may be produced by a host with a skewed clock, are
+	// replaced with the harvest time and a warning is logged.
+	ClampFutureTimestamps bool
+	// FutureTimestampSkew is the amount of time that timestamps may be
+	// ahead of the current time before they are clamped.  It is only used
+	// if ClampFutureTimestamps is true.
+	FutureTimestampSkew time.Duration
+	// PayloadWarnBytes is the compressed request body size above which a
+	// warning is logged.  The warning is logged at most once per harvest
+	// and is intended to help tune batching before payloads are large
+	// enough to be split.  If zero, no warning is logged.
+	PayloadWarnBytes int64
+	// DisableJitter disables the random delay of up to three seconds
+	// before the first scheduled harvest, and the randomization of the
+	// default retry backoff.  These prevent many harvesters that start or
+	// fail at once from sending data at the same time.  Disabling them is
+	// mostly useful in tests that use a non-zero HarvestPeriod or that
+	// depend on retry timing.
+	DisableJitter bool
+	// MergeDuplicateMetrics enables merging of metrics that have the same
+	// type, name, attributes, timestamp, and interval within a harvest,
+	// such as a metric recorded with both RecordMetric and the
+	// MetricAggregator.  Counts are summed, summaries are combined, and
+	// the most recently recorded gauge is kept.  Merges are logged to the
+	// DebugLogger.
+	MergeDuplicateMetrics bool
+	// CorrelateLogsAndSpans enables backfilling the span.id and entity.guid
+	// attributes of logs that have a trace.id attribute from a span of the
+	// same trace that is sent in the same harvest.  This improves the
+	// correlation of logs and traces.
+	CorrelateLogsAndSpans bool
+	// OnDrop, if set, is called whenever the Harvester drops data.  signal
+	// is the type of data dropped: "spans", "metrics", "events", or "logs".
+	// reason is one of the DropReason constants.  This allows dropped data
+	// to be counted separately from the errors sent to the ErrorLogger.
+	// OnDrop may be called from multiple goroutines.
+	OnDrop func(signal string, count int, reason string)
+	// LogMessagePrefix, if set, is prepended to the message of every log
+	// recorded with RecordLog, eg. "[staging] ".  No separator is added.
+	LogMessagePrefix string
+	// InternAttributes enables sharing the storage of identical attribute
+	// keys and string values of recorded spans, metrics, events, and logs.
+	// This reduces the memory used to buffer many items with repeated
+	// attributes at the cost of copying each attribute map when it is
+	// recorded.  The table of shared strings is bounded in size.
+	InternAttributes bool
+	// EventIdempotencyKeys enables adding a unique "idempotency.key"
+	// attribute to each event recorded with RecordEvent that does not
+	// already have one.  The key is sent unchanged when a request is
+	// retried.  It only prevents duplicate events if the endpoint
+	// deduplicates events using the key.
+	EventIdempotencyKeys bool
+	// RetryBudgetRatio enables a retry budget which prevents retries from
+	// dominating throughput during partial outages.  Each failed attempt
+	// spends a token and each successful request earns RetryBudgetRatio
+	// tokens, up to a maximum of 10.  Retries are skipped and their data
+	// dropped while half or fewer of the tokens remain.  With a ratio of
+	// 0.1, one retry is allowed for every ten successful requests once
+	// the initial tokens are spent.  If zero, retries are not limited.
+	RetryBudgetRatio float64
+	// RetryPolicy, if set, decides whether failed requests are retried and
+	// how long to wait before each retry, eg. to add jitter or to limit the
+	// number of retries.  If unset, JitteredRetryPolicy is used, or
+	// DefaultRetryPolicy if DisableJitter is set.  The retry budget and
+	// the harvest context still apply.
+	RetryPolicy RetryPolicy
+	// RequestInterceptor, if set, is called with all of the requests of a
+	// harvest after they have been built and split, just before they are
+	// sent.  The requests it returns are sent instead, allowing requests to
+	// be filtered, reordered, annotated, or dropped.  This is the lowest
+	// level extension point and no validation is done on the result:
+	// modified bodies or headers may cause requests to be rejected and
+	// their data lost.  If the body is replaced, GetBody must be replaced
+	// too so that retries send the new body: requests without GetBody are
+	// neither retried nor audited.  Nil requests are ignored.  Requests not
+	// passed to the interceptor are sent without metric rejection handling
+	// and their drops are reported with the signal "unknown".
+	RequestInterceptor func([]*http.Request) []*http.Request
+	// OnFirstRecord, if set, is called when data is added to the empty
+	// buffer of a signal, ie. with the first item recorded after creation
+	// or after a harvest of that signal.  Use this with a zero
+	// HarvestPeriod to start harvesting only once there is data.  It is
+	// called synchronously, outside of the Harvester's lock, and so should
+	// return quickly.
+	OnFirstRecord func(signal Signal)
+	// MaxResponseBytes is the maximum number of bytes of a successful
+	// response body that are read.  The remainder of a larger body is
+	// ignored, protecting the Harvester from a misbehaving endpoint.  If
+	// zero, MaxResponseBytes is 1 MiB.
+	MaxResponseBytes int64
+	// TLSServerName, if not empty, overrides the server name sent in the
+	// TLS handshake (SNI) and used to verify the server's certificate.
+	// This is useful when the endpoint is reached through a proxy that
+	// terminates TLS with a different hostname.  It does not change the
+	// host that requests are sent to.  TLSServerName can only be used if
+	// the Client's Transport is nil, ie. the default transport is used.
+	TLSServerName string
+	// CoalesceRequests enables skipping the scheduled harvests which occur
+	// while the previous scheduled harvest is still sending data, so that
+	// the data of consecutive harvest periods is sent in fewer requests.
+	// Data is held for at most four consecutive harvest periods, or until
+	// 1000 items are buffered.  This trades a little latency for far fewer
+	// requests when HarvestPeriod is short.  Calls to HarvestNow are never
+	// coalesced.
+	CoalesceRequests bool
+	// MaxBufferedPayloads, if positive, limits the number of spans, events,
+	// and logs, and of metrics recorded with RecordMetric, RecordGaugeSeries,
+	// and RecordMetricSnapshot, that are buffered between harvests.  Each
+	// type is limited separately.  When a buffer is full, newly recorded
+	// data is dropped, an error is returned where possible, and the number
+	// dropped is logged at the next harvest.  Harvester.BufferDrops returns
+	// the total number of items dropped.  This bounds the memory used
+	// during bursts.  Aggregated metrics are not limited.
+	MaxBufferedPayloads int
+	// ProxyURL, if not empty, is the URL of the HTTP proxy that requests
+	// are sent through, eg. "http://proxy.example.com:3128".  It is applied
+	// to a copy of the Client's Transport, which must be nil or an
+	// *http.Transport.  NewHarvester returns an error if ProxyURL is not a
+	// valid absolute URL.
+	ProxyURL string
+	// Transport, if not nil, replaces the Transport of the Client.  This
+	// allows the transport to be configured while keeping the Client's
+	// other settings.
+	Transport http.RoundTripper
+	// HarvestLatencyMetric, if not empty, is the name of an aggregated
+	// summary metric to which the duration of each harvest is recorded in
+	// milliseconds.  The duration of a harvest is sent with the next
+	// harvest of metrics.  See also Harvester.Stats.
+	HarvestLatencyMetric string
+	// HarvestCallback, if set, is called with the outcome of each request
+	// once it has succeeded, failed without being retried, or been
+	// abandoned.  This allows the health of the Harvester to be reported,
+	// eg. as metrics counting the data sent and dropped.  HarvestCallback
+	// may be called from multiple goroutines.
+	HarvestCallback func(HarvestResult)
+	// FallbackWriter, if set, receives the data of requests which could
+	// not be delivered instead of it being dropped: requests which failed
+	// without being retried, exhausted the retry budget, or were abandoned
+	// when the harvest's context was done.  Each request is written as a
+	// single line of JSON containing the "data-type" and the uncompressed
+	// "payload" that would have been sent.  This allows a sidecar to pick up
+	// the data while the endpoint is unreachable.  Writes from a Harvester
+	// are serialized.  Only gzip compressed requests, the default, can be
+	// written.
+	FallbackWriter io.Writer
+	// TrackQueueLatency enables adding the "nr.queue.wait.ms" attribute
+	// to spans, events, and logs.  Its value is the number of milliseconds
+	// from when the item was recorded until it was harvested, which helps
+	// diagnose latency caused by buffering.  It is not added to events
+	// which use AttributesJSON.
+	TrackQueueLatency bool
+	// MaxAttributeValueLength is the maximum length in bytes of string
+	// attribute values.  Longer values of metric, span, event, and log
+	// attributes are truncated when recorded and end with "...", rather
+	// than causing the payload to be rejected.  The number of values
+	// truncated is logged once per harvest.  NewHarvester defaults it to
+	// 4095, the limit of New Relic's ingest APIs.  Set it to zero to
+	// disable truncation.
+	MaxAttributeValueLength int
+	// PayloadSizeObserver, if set, is called with the data type, eg.
+	// "spans", and the uncompressed and compressed body sizes in bytes of
+	// each request before it is sent.  This allows the volume of
+	// telemetry to be graphed, and helps diagnose why requests near the
+	// 1MB compressed size limit are split.  The uncompressed size is -1 if
+	// it is unknown, such as for requests replaced by RequestInterceptor
+	// with a body that is not gzip encoded.
+	PayloadSizeObserver func(dataType string, uncompressed, compressed int)
+	// GzipCompressionLevel is the compress/gzip level of request bodies,
+	// eg. gzip.BestSpeed to reduce CPU usage or gzip.BestCompression to
+	// reduce bandwidth.  If zero, gzip.DefaultCompression is used, so
+	// gzip.NoCompression cannot be selected.  NewHarvester returns an
+	// error if the level is invalid.
+	GzipCompressionLevel int
+	// CompressionDictionary, if set, compresses request bodies with zlib
+	// using it as the preset dictionary, as WithCompressionDictionary
+	// does, instead of with gzip.  GzipCompressionLevel is then ignored.
+	// Only use this with endpoints that accept deflate bodies compressed
+	// with this dictionary.
+	CompressionDictionary []byte
+	// MaxRequestsPerHarvest, if positive, is the maximum number of requests
+	// sent by each harvest.  When data is split into more requests than
+	// this, the remaining requests are deferred to the next harvest of
+	// their signal and are sent before its own requests.  This spreads
+	// bursts of data over several harvests rather than dropping it.  The
+	// harvest in Shutdown sends all deferred requests.  At most
+	// MaxBufferedPayloads requests, or 10 times MaxRequestsPerHarvest if
+	// MaxBufferedPayloads is unset, are deferred.  The data of the newest
+	// requests beyond that is dropped and reported to OnDrop with
+	// DropReasonBufferFull.
+	MaxRequestsPerHarvest int
+	// RequestIDFunc, if set, is called for each request to set its
+	// X-Request-Id header, eg. to a correlation ID from an existing
+	// tracing system.  Retries of a request use the same ID.  The header
+	// is omitted if it returns an empty string.  It must be safe for
+	// concurrent use.
+	RequestIDFunc func() string
+	// MaxAttributeBytesPerRequest, if positive, is the maximum total size
+	// in bytes of the serialized attributes of the spans, metrics, events,
+	// and logs in each request.  Data with larger attributes is split into
+	// several requests before it is sent, rather than after the server
+	// rejects an oversized request.  Common attributes are not counted,
+	// and a single item whose attributes exceed the limit is sent alone.
+	MaxAttributeBytesPerRequest int
+	// DisableAutoTimestamp prevents RecordSpan, RecordEvent, and RecordLog
+	// from setting a zero Timestamp to the current time, eg. when
+	// replaying historical data where a missing timestamp is an error.
+	// The timestamp field of data with a zero Timestamp is then omitted,
+	// and New Relic assigns the time the data is received.
+	DisableAutoTimestamp bool
+	// Region selects the New Relic data center whose endpoints data is
+	// sent to: RegionUS or RegionEU.  It is case insensitive, and RegionUS
+	// is used if it is empty.  The URL override fields take precedence.
+	// NewHarvester returns an error if the region is unknown.
+	Region string
+	// FlushBytesThreshold, if positive, starts an early harvest when the
+	// approximate size in bytes of the buffered spans, metrics, events,
+	// and logs exceeds it.  This bounds the memory used between harvests
+	// by data with few but large items.  The size is a cheap estimate of
+	// the uncompressed JSON and does not include aggregated metrics.  It
+	// has no effect unless data is harvested in the background, ie. a
+	// harvest period is set.
+	FlushBytesThreshold int64
+	// ReportInternalMetrics records metrics about the Harvester itself to
+	// its MetricAggregator, so they are sent with the next harvest of
+	// metrics:
+	//
+	//	newrelic.telemetry.sdk.items.sent: count of the items delivered
+	//	newrelic.telemetry.sdk.items.dropped: count of the items dropped
+	//	newrelic.telemetry.sdk.request.retries: count of request retries
+	//	newrelic.telemetry.sdk.request.duration: summary of request durations
+	//	newrelic.telemetry.sdk.harvest.duration: summary of harvest durations
+	//
+	// Each has a "signal" attribute, except the harvest duration, and the
+	// dropped items have a "reason" attribute as passed to OnDrop.  The
+	// internal metrics are aggregated, so reporting them does not cause
+	// ever more of them to be recorded, but they are included in the
+	// counts of metrics sent and dropped.
+	ReportInternalMetrics bool
+	// SpanFilter, if set, is called with each span when it is harvested,
+	// after it has been recorded and before it is sent.  Spans for which
+	// it returns false are dropped with DropReasonFiltered, and the others
+	// are sent as modified by the filter, eg. for sampling or to redact
+	// attributes.  The Attributes map may be the one passed to
+	// RecordSpan, so redact attributes by replacing it with a modified
+	// copy.  The filter is not called while the Harvester's lock is held,
+	// but it may be called from multiple goroutines.
+	SpanFilter func(*Span) bool
+	// EventFilter is called with each event when it is harvested, as
+	// SpanFilter is with spans.
+	EventFilter func(*Event) bool
+	// LogFilter is called with each log when it is harvested, as
+	// SpanFilter is with spans.
+	LogFilter func(*Log) bool
+
+	// dropHook, if set, is called by drop in addition to OnDrop.  It is
+	// set by NewHarvester for ReportInternalMetrics.
+	dropHook func(signal Signal, count int, reason string)
+	// randInt63n, if set, replaces the random source of the default
+	// JitteredRetryPolicy for deterministic tests.  It returns a number in [0, n).
+	randInt63n func(n int64) int64
 }
 
+// Regions accepted by Config.Region.
+const (
+	RegionUS = "US"
+	RegionEU = "EU"
+)
+
+// Reasons passed to Config.OnDrop.
+const (
+	// DropReasonValidation is used for data that is invalid when recorded.
+	DropReasonValidation = "validation"
+	// DropReasonTooOld is used for data older than Config.MaxDataAge.
+	DropReasonTooOld = "too_old"
+	// DropReasonRejected is used for data permanently rejected by the
+	// endpoint, or whose failed request is not retried.
+	DropReasonRejected = "rejected"
+	// DropReasonRequestError is used for data that could not be built into
+	// a request.
+	DropReasonRequestError = "request_error"
+	// DropReasonTooLarge is used for single items too large to be sent
+	// even in a request of their own.
+	DropReasonTooLarge = "too_large"
+	// DropReasonRetryBudget is used for data that was not retried because
+	// the retry budget was exhausted.  See Config.RetryBudgetRatio.
+	DropReasonRetryBudget = "retry_budget"
+	// DropReasonTimeout is used for data not sent before the harvest
+	// context was done.  See Config.HarvestTimeout.
+	DropReasonTimeout = "timeout"
+	// DropReasonBufferFull is used for data recorded while its buffer was
+	// full, and for requests beyond the limit of deferred requests.  See
+	// Config.MaxBufferedPayloads and Config.MaxRequestsPerHarvest.
+	DropReasonBufferFull = "buffer_full"
+	// DropReasonFiltered is used for data rejected by Config.SpanFilter,
+	// Config.EventFilter, or Config.LogFilter.
+	DropReasonFiltered = "filtered"
+)
+
 // ConfigAPIKey sets the Config's APIKey which is required and refers to your
 // New Relic Insert API key.
 func ConfigAPIKey(key string) func(*Config) {
@@ -63,6 +471,23 @@ func ConfigAPIKey(key string) func(*Config) {
 	}
 }
 
+// ConfigUseLicenseKey sets the Config's UseLicenseKey field so that APIKey is
+// sent as a New Relic license key, using the X-License-Key header, rather
+// than as an Insert API key.
+func ConfigUseLicenseKey() func(*Config) {
+	return func(cfg *Config) {
+		cfg.UseLicenseKey = true
+	}
+}
+
+// ConfigDisableAutoTimestamp sets the Config's DisableAutoTimestamp field so
+// that zero timestamps are not replaced with the current time.
+func ConfigDisableAutoTimestamp() func(*Config) {
+	return func(cfg *Config) {
+		cfg.DisableAutoTimestamp = true
+	}
+}
+
 // ConfigCommonAttributes adds the given attributes to the Config's
 // CommonAttributes.
 func ConfigCommonAttributes(attributes map[string]interface{}) func(*Config) {
@@ -71,6 +496,30 @@ func ConfigCommonAttributes(attributes map[string]interface{}) func(*Config) {
 	}
 }
 
+// ConfigSpanFilter sets the Config's SpanFilter field which can drop or
+// modify spans before they are sent.
+func ConfigSpanFilter(filter func(*Span) bool) func(*Config) {
+	return func(cfg *Config) {
+		cfg.SpanFilter = filter
+	}
+}
+
+// ConfigEventFilter sets the Config's EventFilter field which can drop or
+// modify events before they are sent.
+func ConfigEventFilter(filter func(*Event) bool) func(*Config) {
+	return func(cfg *Config) {
+		cfg.EventFilter = filter
+	}
+}
+
+// ConfigLogFilter sets the Config's LogFilter field which can drop or modify
+// logs before they are sent.
+func ConfigLogFilter(filter func(*Log) bool) func(*Config) {
+	return func(cfg *Config) {
+		cfg.LogFilter = filter
+	}
+}
+
 // ConfigHarvestPeriod sets the Config's HarvestPeriod field which controls the
 // rate data is reported to New Relic.  If it is set to zero then the Harvester
 // will never report data unless HarvestNow is called.
@@ -80,6 +529,78 @@ func ConfigHarvestPeriod(period time.Duration) func(*Config) {
 	}
 }
 
+// ConfigSpansHarvestPeriod sets the Config's SpansHarvestPeriod field which
+// overrides HarvestPeriod for spans.
+func ConfigSpansHarvestPeriod(period time.Duration) func(*Config) {
+	return func(cfg *Config) {
+		cfg.SpansHarvestPeriod = period
+	}
+}
+
+// ConfigMetricsHarvestPeriod sets the Config's MetricsHarvestPeriod field
+// which overrides HarvestPeriod for metrics.
+func ConfigMetricsHarvestPeriod(period time.Duration) func(*Config) {
+	return func(cfg *Config) {
+		cfg.MetricsHarvestPeriod = period
+	}
+}
+
+// ConfigEventsHarvestPeriod sets the Config's EventsHarvestPeriod field which
+// overrides HarvestPeriod for events.
+func ConfigEventsHarvestPeriod(period time.Duration) func(*Config) {
+	return func(cfg *Config) {
+		cfg.EventsHarvestPeriod = period
+	}
+}
+
+// ConfigLogsHarvestPeriod sets the Config's LogsHarvestPeriod field which
+// overrides HarvestPeriod for logs.
+func ConfigLogsHarvestPeriod(period time.Duration) func(*Config) {
+	return func(cfg *Config) {
+		cfg.LogsHarvestPeriod = period
+	}
+}
+
+// ConfigHarvestCallback sets the Config's HarvestCallback field which is
+// called with the outcome of each request.
+func ConfigHarvestCallback(fn func(HarvestResult)) func(*Config) {
+	return func(cfg *Config) {
+		cfg.HarvestCallback = fn
+	}
+}
+
+// ConfigFallbackWriter sets the Config's FallbackWriter field which receives
+// the data of requests which could not be delivered.
+func ConfigFallbackWriter(w io.Writer) func(*Config) {
+	return func(cfg *Config) {
+		cfg.FallbackWriter = w
+	}
+}
+
+// ConfigMaxBufferedPayloads sets the Config's MaxBufferedPayloads field which
+// limits the number of items of each type buffered between harvests.
+func ConfigMaxBufferedPayloads(n int) func(*Config) {
+	return func(cfg *Config) {
+		cfg.MaxBufferedPayloads = n
+	}
+}
+
+// ConfigProxyURL sets the Config's ProxyURL, the URL of the HTTP proxy that
+// requests are sent through.
+func ConfigProxyURL(url string) func(*Config) {
+	return func(cfg *Config) {
+		cfg.ProxyURL = url
+	}
+}
+
+// ConfigHTTPTransport sets the Config's Transport, which replaces the
+// Transport of the Client used for making requests.
+func ConfigHTTPTransport(rt http.RoundTripper) func(*Config) {
+	return func(cfg *Config) {
+		cfg.Transport = rt
+	}
+}
+
 func newBasicLogger(w io.Writer) func(map[string]interface{}) {
 	flags := log.Ldate | log.Ltime | log.Lmicroseconds
 	lg := log.New(w, "", flags)
@@ -148,6 +669,13 @@ func ConfigLogsURLOverride(url string) func(*Config) {
 	}
 }
 
+// ConfigRegion sets the Config's Region field, eg. to RegionEU.
+func ConfigRegion(region string) func(*Config) {
+	return func(cfg *Config) {
+		cfg.Region = region
+	}
+}
+
 // configTesting is the config function to be used when testing. It sets the
 // APIKey but disables the harvest goroutine.
 func configTesting(cfg *Config) {
@@ -155,7 +683,31 @@ func configTesting(cfg *Config) {
 	cfg.HarvestPeriod = 0
 }
 
+// loggerMessage splits the fields into a message and the remaining fields
+// for use with a Logger.
+func loggerMessage(fields map[string]interface{}) (string, map[string]interface{}) {
+	key := "message"
+	if _, ok := fields[key].(string); !ok {
+		key = "event"
+	}
+	msg, ok := fields[key].(string)
+	if !ok {
+		return "", fields
+	}
+	rest := make(map[string]interface{}, len(fields)-1)
+	for k, v := range fields {
+		if k != key {
+			rest[k] = v
+		}
+	}
+	return msg, rest
+}
+
 func (cfg *Config) logError(fields map[string]interface{}) {
+	if nil != cfg.Logger {
+		cfg.Logger.Error(loggerMessage(fields))
+		return
+	}
 	if nil == cfg.ErrorLogger {
 		return
 	}
@@ -163,14 +715,236 @@ func (cfg *Config) logError(fields map[string]interface{}) {
 }
 
 func (cfg *Config) logDebug(fields map[string]interface{}) {
+	if nil != cfg.Logger {
+		cfg.Logger.Debug(loggerMessage(fields))
+		return
+	}
 	if nil == cfg.DebugLogger {
 		return
 	}
 	cfg.DebugLogger(fields)
 }
 
+// drop reports dropped data to the OnDrop callback.
+func (cfg *Config) drop(signal Signal, count int, reason string) {
+	if count <= 0 {
+		return
+	}
+	if nil != cfg.OnDrop {
+		cfg.OnDrop(signal.String(), count, reason)
+	}
+	if nil != cfg.dropHook {
+		cfg.dropHook(signal, count, reason)
+	}
+}
+
+func (cfg *Config) splitStrategy() SplitStrategy {
+	if nil == cfg.SplitStrategy {
+		return CountSplitStrategy{}
+	}
+	return cfg.SplitStrategy
+}
+
+// staleCutoff returns the time before which buffered data is considered too
+// old to send.  The zero time is returned if MaxDataAge is unset.
+func (cfg *Config) staleCutoff(now time.Time) time.Time {
+	if cfg.MaxDataAge <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-cfg.MaxDataAge)
+}
+
+// httpClient returns the Client with Transport, ProxyURL, and TLSServerName
+// applied.  The Client is copied rather than modified if any are set.
+func (cfg *Config) httpClient() (*http.Client, error) {
+	if nil == cfg.Transport && cfg.ProxyURL == "" && cfg.TLSServerName == "" {
+		return cfg.Client, nil
+	}
+	var proxy *url.URL
+	if cfg.ProxyURL != "" {
+		var err error
+		if proxy, err = parseProxyURL(cfg.ProxyURL); nil != err {
+			return nil, err
+		}
+	}
+	if cfg.TLSServerName != "" && (nil != cfg.Client.Transport || nil != cfg.Transport) {
+		return nil, errTLSServerNameTransport
+	}
+
+	client := *cfg.Client
+	if nil != cfg.Transport {
+		client.Transport = cfg.Transport
+	}
+	if nil == proxy && cfg.TLSServerName == "" {
+		return &client, nil
+	}
+	var transport *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return nil, errProxyTransport
+	}
+	if nil != proxy {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if cfg.TLSServerName != "" {
+		if nil == transport.TLSClientConfig {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.ServerName = cfg.TLSServerName
+	}
+	client.Transport = transport
+	return &client, nil
+}
+
+// parseProxyURL parses a ProxyURL, which must have a scheme and host.
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if nil != err {
+		return nil, fmt.Errorf("invalid ProxyURL %q: %v", rawURL, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid ProxyURL %q: scheme and host are required", rawURL)
+	}
+	return u, nil
+}
+
+// maxResponseBytes returns the limit on the bytes read from a response body.
+func (cfg *Config) maxResponseBytes() int64 {
+	if cfg.MaxResponseBytes <= 0 {
+		return defaultMaxResponseBytes
+	}
+	return cfg.MaxResponseBytes
+}
+
+// harvestJitter returns the delay before the harvest ticker is started.
+func (cfg *Config) harvestJitter() time.Duration {
+	if cfg.DisableJitter {
+		return 0
+	}
+	// Introduce a small jitter to ensure the backend isn't hammered if many
+	// harvesters start at once.
+	d := 3 * time.Second
+	for _, schedule := range cfg.harvestSchedules() {
+		d = minDuration(d, schedule.period)
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return time.Nanosecond * time.Duration(rnd.Int63n(d.Nanoseconds()))
+}
+
+// retryPolicy returns the RetryPolicy of the Harvester: Config.RetryPolicy if
+// set, otherwise JitteredRetryPolicy unless DisableJitter is set.
+func (cfg *Config) retryPolicy() RetryPolicy {
+	if nil != cfg.RetryPolicy {
+		return cfg.RetryPolicy
+	}
+	if cfg.DisableJitter {
+		return DefaultRetryPolicy{}
+	}
+	return JitteredRetryPolicy{Int63n: cfg.randInt63n}
+}
+
+// harvestPeriod returns the period at which the data of the signal is
+// harvested by the harvest goroutine, or zero if it is not.
+func (cfg *Config) harvestPeriod(signal Signal) time.Duration {
+	var period time.Duration
+	switch signal {
+	case SignalSpans:
+		period = cfg.SpansHarvestPeriod
+	case SignalMetrics:
+		period = cfg.MetricsHarvestPeriod
+	case SignalEvents:
+		period = cfg.EventsHarvestPeriod
+	case SignalLogs:
+		period = cfg.LogsHarvestPeriod
+	}
+	if period > 0 {
+		return period
+	}
+	return cfg.HarvestPeriod
+}
+
+// harvestSchedule is a set of signals harvested together every period by the
+// harvest goroutine.
+type harvestSchedule struct {
+	period  time.Duration
+	signals []Signal
+	// coalesce is true if the signals are harvested every HarvestPeriod,
+	// and so Config.CoalesceRequests applies.
+	coalesce bool
+}
+
+// harvestSchedules groups the signals by their harvest period.  No schedules
+// are returned if the harvest goroutine is not needed.
+func (cfg *Config) harvestSchedules() []harvestSchedule {
+	var schedules []harvestSchedule
+	for _, signal := range allSignals {
+		period := cfg.harvestPeriod(signal)
+		if period <= 0 {
+			continue
+		}
+		found := false
+		for i := range schedules {
+			if schedules[i].period == period {
+				schedules[i].signals = append(schedules[i].signals, signal)
+				found = true
+				break
+			}
+		}
+		if !found {
+			schedules = append(schedules, harvestSchedule{
+				period:   period,
+				signals:  []Signal{signal},
+				coalesce: period == cfg.HarvestPeriod,
+			})
+		}
+	}
+	return schedules
+}
+
 func (cfg *Config) auditLogEnabled() bool {
-	return cfg.AuditLogger != nil
+	return cfg.AuditLogger != nil || cfg.AuditBodySink != nil
+}
+
+// auditRequest logs the body of the request to the AuditLogger, or sends it
+// to the AuditBodySink and logs its id.  Requests without GetBody, such as
+// those returned by RequestInterceptor, are not audited since their body
+// cannot be read without consuming it.
+func (cfg *Config) auditRequest(req *http.Request) {
+	if nil == req.GetBody {
+		return
+	}
+	bodyReader, _ := req.GetBody()
+	compressedBody, _ := ioutil.ReadAll(bodyReader)
+	fields := map[string]interface{}{
+		"event": "uncompressed request body",
+		"url":   req.URL.String(),
+	}
+	switch {
+	case nil != cfg.AuditBodySink:
+		uncompressedBody, _ := uncompressBody(compressedBody, req.Header.Get("Content-Encoding"), cfg.CompressionDictionary)
+		id, err := uuid.New()
+		if nil != err {
+			cfg.logError(map[string]interface{}{
+				"err":     err.Error(),
+				"message": "unable to create audit body id",
+			})
+			return
+		}
+		cfg.AuditBodySink(id, uncompressedBody)
+		fields["body-id"] = id
+	case cfg.AuditBodyCompressed:
+		fields["event"] = "compressed request body"
+		fields["content-encoding"] = req.Header.Get("Content-Encoding")
+		fields["data-compressed"] = base64.StdEncoding.EncodeToString(compressedBody)
+	default:
+		uncompressedBody, _ := uncompressBody(compressedBody, req.Header.Get("Content-Encoding"), cfg.CompressionDictionary)
+		fields["data"] = jsonString(uncompressedBody)
+	}
+	cfg.logAudit(fields)
 }
 
 func (cfg *Config) logAudit(fields map[string]interface{}) {
@@ -185,12 +959,30 @@ const (
 	defaultMetricURL = "https://metric-api.newrelic.com/metric/v1"
 	defaultEventURL  = "https://insights-collector.newrelic.com/v1/accounts/events"
 	defaultLogURL    = "https://log-api.newrelic.com/log/v1"
+
+	euSpanURL   = "https://trace-api.eu.newrelic.com/trace/v1"
+	euMetricURL = "https://metric-api.eu.newrelic.com/metric/v1"
+	euEventURL  = "https://insights-collector.eu01.nr-data.net/v1/accounts/events"
+	euLogURL    = "https://log-api.eu.newrelic.com/log/v1"
 )
 
+// validRegion returns true if Region is empty or a known region.
+func (cfg *Config) validRegion() bool {
+	return cfg.Region == "" || strings.EqualFold(cfg.Region, RegionUS) || cfg.euRegion()
+}
+
+// euRegion returns true if Region selects the EU endpoints.
+func (cfg *Config) euRegion() bool {
+	return strings.EqualFold(cfg.Region, RegionEU)
+}
+
 func (cfg *Config) spanURL() string {
 	if cfg.SpansURLOverride != "" {
 		return cfg.SpansURLOverride
 	}
+	if cfg.euRegion() {
+		return euSpanURL
+	}
 	return defaultSpanURL
 }
 
@@ -198,6 +990,9 @@ func (cfg *Config) metricURL() string {
 	if cfg.MetricsURLOverride != "" {
 		return cfg.MetricsURLOverride
 	}
+	if cfg.euRegion() {
+		return euMetricURL
+	}
 	return defaultMetricURL
 }
 
@@ -205,6 +1000,9 @@ func (cfg *Config) eventURL() string {
 	if cfg.EventsURLOverride != "" {
 		return cfg.EventsURLOverride
 	}
+	if cfg.euRegion() {
+		return euEventURL
+	}
 	return defaultEventURL
 }
 
@@ -212,9 +1010,62 @@ func (cfg *Config) logURL() string {
 	if cfg.LogsURLOverride != "" {
 		return cfg.LogsURLOverride
 	}
+	if cfg.euRegion() {
+		return euLogURL
+	}
 	return defaultLogURL
 }
 
+// apiKey returns the key used for requests of the signal.
+func (cfg *Config) apiKey(signal Signal) string {
+	var key string
+	switch signal {
+	case SignalSpans:
+		key = cfg.SpansAPIKey
+	case SignalMetrics:
+		key = cfg.MetricsAPIKey
+	case SignalEvents:
+		key = cfg.EventsAPIKey
+	case SignalLogs:
+		key = cfg.LogsAPIKey
+	}
+	if key == "" {
+		return cfg.APIKey
+	}
+	return key
+}
+
+// apiKeyOption returns the ClientOption which sets the key of the signal on
+// requests.
+func (cfg *Config) apiKeyOption(signal Signal) ClientOption {
+	if cfg.UseLicenseKey {
+		return WithLicenseKey(cfg.apiKey(signal))
+	}
+	return WithInsertKey(cfg.apiKey(signal))
+}
+
+// maxDeferredRequests returns the maximum number of requests deferred due to
+// MaxRequestsPerHarvest.
+func (cfg *Config) maxDeferredRequests() int {
+	if cfg.MaxBufferedPayloads > 0 {
+		return cfg.MaxBufferedPayloads
+	}
+	return 10 * cfg.MaxRequestsPerHarvest
+}
+
+// compressionOption returns the ClientOption which applies
+// CompressionDictionary or GzipCompressionLevel.  It leaves the factory's
+// default compression unchanged if neither is set.
+func (cfg *Config) compressionOption() ClientOption {
+	if nil != cfg.CompressionDictionary {
+		return WithCompressionDictionary(cfg.CompressionDictionary)
+	}
+	if cfg.GzipCompressionLevel == 0 {
+		return func(*requestFactory) {}
+	}
+	return WithGzipCompressionLevel(cfg.GzipCompressionLevel)
+}
+
 // userAgent creates the extended portion of the User-Agent header version according to the spec here:
 // https://github.com/newrelic/newrelic-telemetry-sdk-specs/blob/master/communication.md#user-agent
 func (cfg *Config) userAgent() string {
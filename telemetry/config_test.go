@@ -5,8 +5,18 @@ package telemetry
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestConfigAPIKey(t *testing.T) {
@@ -203,3 +213,548 @@ func TestConfigUserAgent(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigHarvestJitter(t *testing.T) {
+	cfg := Config{HarvestPeriod: 10 * time.Second}
+	for i := 0; i < 10; i++ {
+		if j := cfg.harvestJitter(); j < 0 || j >= 3*time.Second {
+			t.Error("jitter out of range", j)
+		}
+	}
+	cfg.DisableJitter = true
+	if j := cfg.harvestJitter(); j != 0 {
+		t.Error("jitter should be disabled", j)
+	}
+
+	// The jitter is less than the shortest harvest period.
+	cfg = Config{LogsHarvestPeriod: 100 * time.Millisecond}
+	for i := 0; i < 10; i++ {
+		if j := cfg.harvestJitter(); j < 0 || j >= 100*time.Millisecond {
+			t.Error("jitter out of range", j)
+		}
+	}
+}
+
+func TestConfigHarvestSchedules(t *testing.T) {
+	for idx, tc := range []struct {
+		cfg    Config
+		expect []harvestSchedule
+	}{
+		{
+			cfg: Config{},
+		},
+		{
+			cfg: Config{HarvestPeriod: 5 * time.Second},
+			expect: []harvestSchedule{
+				{period: 5 * time.Second, signals: allSignals, coalesce: true},
+			},
+		},
+		{
+			cfg: Config{
+				HarvestPeriod:        5 * time.Second,
+				MetricsHarvestPeriod: time.Minute,
+				EventsHarvestPeriod:  5 * time.Second,
+				LogsHarvestPeriod:    time.Second,
+			},
+			expect: []harvestSchedule{
+				{period: 5 * time.Second, signals: []Signal{SignalSpans, SignalEvents}, coalesce: true},
+				{period: time.Minute, signals: []Signal{SignalMetrics}},
+				{period: time.Second, signals: []Signal{SignalLogs}},
+			},
+		},
+		{
+			cfg: Config{SpansHarvestPeriod: time.Second, LogsHarvestPeriod: time.Second},
+			expect: []harvestSchedule{
+				{period: time.Second, signals: []Signal{SignalSpans, SignalLogs}},
+			},
+		},
+	} {
+		if schedules := tc.cfg.harvestSchedules(); !reflect.DeepEqual(schedules, tc.expect) {
+			t.Error(idx, schedules)
+		}
+	}
+}
+
+type recordingLogger struct {
+	errors []map[string]interface{}
+	debugs []map[string]interface{}
+}
+
+// record merges the message back into the fields so that the result can be
+// compared with the fields received by the func based loggers.
+func record(logs *[]map[string]interface{}, msg string, fields map[string]interface{}, key string) {
+	merged := map[string]interface{}{key: msg}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	*logs = append(*logs, merged)
+}
+
+func (l *recordingLogger) Error(msg string, fields map[string]interface{}) {
+	record(&l.errors, msg, fields, "message")
+}
+
+func (l *recordingLogger) Debug(msg string, fields map[string]interface{}) {
+	record(&l.debugs, msg, fields, "event")
+}
+
+func TestConfigLogger(t *testing.T) {
+	var funcErrors, funcDebugs []map[string]interface{}
+	funcHarvester, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.ErrorLogger = func(fields map[string]interface{}) { funcErrors = append(funcErrors, fields) }
+		cfg.DebugLogger = func(fields map[string]interface{}) { funcDebugs = append(funcDebugs, fields) }
+	})
+	logger := &recordingLogger{}
+	loggerHarvester, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Logger = logger
+		// The Logger takes precedence over the func based loggers.
+		cfg.ErrorLogger = func(fields map[string]interface{}) { t.Error("ErrorLogger called", fields) }
+		cfg.DebugLogger = func(fields map[string]interface{}) { t.Error("DebugLogger called", fields) }
+	})
+
+	for _, h := range []*Harvester{funcHarvester, loggerHarvester} {
+		h.RecordMetric(Gauge{Name: "gauge", Value: math.NaN()})
+		h.swapOutSpans()
+		h.config.logDebug(map[string]interface{}{"event": "test event", "zip": "zap"})
+	}
+
+	if len(funcErrors) != 1 || !reflect.DeepEqual(funcErrors, logger.errors) {
+		t.Errorf("\nfunc=%v\nlogger=%v", funcErrors, logger.errors)
+	}
+	if len(funcDebugs) == 0 || !reflect.DeepEqual(funcDebugs, logger.debugs) {
+		t.Errorf("\nfunc=%v\nlogger=%v", funcDebugs, logger.debugs)
+	}
+}
+
+func TestLoggerMessage(t *testing.T) {
+	msg, fields := loggerMessage(map[string]interface{}{"message": "hello", "event": "e", "zip": "zap"})
+	if msg != "hello" || !reflect.DeepEqual(fields, map[string]interface{}{"event": "e", "zip": "zap"}) {
+		t.Error(msg, fields)
+	}
+	msg, fields = loggerMessage(map[string]interface{}{"err": "oops"})
+	if msg != "" || !reflect.DeepEqual(fields, map[string]interface{}{"err": "oops"}) {
+		t.Error(msg, fields)
+	}
+}
+
+func TestConfigTLSServerName(t *testing.T) {
+	client := &http.Client{Timeout: time.Second}
+	h, err := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Client = client
+		cfg.TLSServerName = "collector.example.com"
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if h.config.Client == client || nil != client.Transport {
+		t.Error("the given Client should not be modified")
+	}
+	if h.config.Client.Timeout != time.Second {
+		t.Error(h.config.Client.Timeout)
+	}
+	transport, ok := h.config.Client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatal(h.config.Client.Transport)
+	}
+	if name := transport.TLSClientConfig.ServerName; name != "collector.example.com" {
+		t.Error(name)
+	}
+
+	clone, err := h.Clone()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if clone.config.Client != h.config.Client {
+		t.Error("the clone should share the Client")
+	}
+}
+
+func TestConfigTLSServerNameCustomTransport(t *testing.T) {
+	_, err := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Client = &http.Client{Transport: &http.Transport{}}
+		cfg.TLSServerName = "collector.example.com"
+	})
+	if err != errTLSServerNameTransport {
+		t.Error(err)
+	}
+}
+
+func TestConfigProxyURL(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests sent through a proxy have an absolute URL.
+		proxied = append(proxied, r.URL.String())
+		w.WriteHeader(202)
+	}))
+	defer proxy.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	h, err := NewHarvester(configTesting, ConfigProxyURL(proxy.URL), func(cfg *Config) {
+		cfg.Client = client
+		cfg.SpansURLOverride = "http://collector.example.com/trace/v1"
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if h.config.Client == client || nil != client.Transport {
+		t.Error("the given Client should not be modified")
+	}
+	if h.config.Client.Timeout != 5*time.Second {
+		t.Error(h.config.Client.Timeout)
+	}
+	h.RecordSpan(Span{ID: "1", TraceID: "tid"})
+	h.HarvestNow(context.Background())
+	if len(proxied) != 1 || proxied[0] != "http://collector.example.com/trace/v1" {
+		t.Error(proxied)
+	}
+
+	clone, err := h.Clone()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if clone.config.Client != h.config.Client {
+		t.Error("the clone should share the Client")
+	}
+}
+
+func TestConfigProxyURLClientTransport(t *testing.T) {
+	transport := &http.Transport{MaxIdleConns: 7}
+	h, err := NewHarvester(configTesting, ConfigProxyURL("http://proxy.example.com:3128"), func(cfg *Config) {
+		cfg.Client = &http.Client{Transport: transport}
+		cfg.TLSServerName = "collector.example.com"
+	})
+	if err != errTLSServerNameTransport {
+		t.Error(err)
+	}
+
+	h, err = NewHarvester(configTesting, ConfigProxyURL("http://proxy.example.com:3128"), func(cfg *Config) {
+		cfg.Client = &http.Client{Transport: transport}
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nil != transport.Proxy {
+		t.Error("the given Transport should not be modified")
+	}
+	proxied, ok := h.config.Client.Transport.(*http.Transport)
+	if !ok || proxied.MaxIdleConns != 7 {
+		t.Fatal(h.config.Client.Transport)
+	}
+	req, _ := http.NewRequest("POST", "https://trace-api.newrelic.com/trace/v1", nil)
+	if u, err := proxied.Proxy(req); nil != err || u.String() != "http://proxy.example.com:3128" {
+		t.Error(u, err)
+	}
+}
+
+func TestConfigProxyURLTLSServerName(t *testing.T) {
+	h, err := NewHarvester(configTesting, ConfigProxyURL("http://proxy.example.com:3128"), func(cfg *Config) {
+		cfg.TLSServerName = "collector.example.com"
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	transport := h.config.Client.Transport.(*http.Transport)
+	if nil == transport.Proxy || transport.TLSClientConfig.ServerName != "collector.example.com" {
+		t.Error(transport)
+	}
+}
+
+func TestConfigProxyURLCustomTransport(t *testing.T) {
+	_, err := NewHarvester(configTesting, ConfigProxyURL("http://proxy.example.com:3128"), func(cfg *Config) {
+		cfg.Client = &http.Client{Transport: roundTripperFunc(nil)}
+	})
+	if err != errProxyTransport {
+		t.Error(err)
+	}
+	_, err = NewHarvester(configTesting, ConfigProxyURL("http://proxy.example.com:3128"), ConfigHTTPTransport(roundTripperFunc(nil)))
+	if err != errProxyTransport {
+		t.Error(err)
+	}
+}
+
+func TestConfigProxyURLInvalid(t *testing.T) {
+	for _, proxyURL := range []string{
+		"proxy.example.com:3128",
+		"/proxy",
+		"http://[::1",
+	} {
+		h, err := NewHarvester(configTesting, ConfigProxyURL(proxyURL))
+		if nil == err || !strings.Contains(err.Error(), "invalid ProxyURL") {
+			t.Error(proxyURL, err)
+		}
+		if nil != h {
+			t.Error(proxyURL, h)
+		}
+	}
+}
+
+func TestConfigHTTPTransport(t *testing.T) {
+	var posted int
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		posted++
+		return emptyResponse(202), nil
+	})
+	client := &http.Client{Timeout: 5 * time.Second}
+	h, err := NewHarvester(configTesting, ConfigHTTPTransport(rt), func(cfg *Config) {
+		cfg.Client = client
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nil != client.Transport || h.config.Client.Timeout != 5*time.Second {
+		t.Error(client, h.config.Client)
+	}
+	h.RecordSpan(Span{ID: "1", TraceID: "tid"})
+	h.HarvestNow(context.Background())
+	if posted != 1 {
+		t.Error(posted)
+	}
+}
+
+func TestConfigSignalAPIKeys(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.APIKey = "shared-key"
+		cfg.MetricsAPIKey = "metrics-key"
+		cfg.LogsAPIKey = "logs-key"
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "id"})
+	h.RecordMetric(Gauge{Name: "gauge", Value: 1, Timestamp: time.Now()})
+	h.RecordEvent(Event{EventType: "event"})
+	h.RecordLog(Log{Message: "log"})
+
+	for _, tc := range []struct {
+		reqs []*http.Request
+		key  string
+	}{
+		{reqs: h.swapOutSpans(), key: "shared-key"},
+		{reqs: h.swapOutMetrics(time.Now()), key: "metrics-key"},
+		{reqs: h.swapOutEvents(), key: "shared-key"},
+		{reqs: h.swapOutLogs(), key: "logs-key"},
+	} {
+		if len(tc.reqs) != 1 {
+			t.Fatal(tc.reqs)
+		}
+		if key := tc.reqs[0].Header.Get("Api-Key"); key != tc.key {
+			t.Errorf("%s: expected key %q, got %q", tc.reqs[0].URL, tc.key, key)
+		}
+	}
+}
+
+func TestConfigUseLicenseKey(t *testing.T) {
+	h, _ := NewHarvester(configTesting, ConfigAPIKey("license-key"), ConfigUseLicenseKey())
+	h.RecordSpan(Span{ID: "id", TraceID: "id"})
+	h.RecordMetric(Gauge{Name: "gauge", Value: 1, Timestamp: time.Now()})
+	h.RecordEvent(Event{EventType: "event"})
+	h.RecordLog(Log{Message: "log"})
+
+	for _, reqs := range [][]*http.Request{
+		h.swapOutSpans(),
+		h.swapOutMetrics(time.Now()),
+		h.swapOutEvents(),
+		h.swapOutLogs(),
+	} {
+		if len(reqs) != 1 {
+			t.Fatal(reqs)
+		}
+		if key := reqs[0].Header.Get("X-License-Key"); key != "license-key" {
+			t.Errorf("%s: expected license key, got %q", reqs[0].URL, key)
+		}
+		if _, ok := reqs[0].Header["Api-Key"]; ok {
+			t.Errorf("%s: unexpected Api-Key header", reqs[0].URL)
+		}
+	}
+}
+
+func TestConfigSignalAPIKeysLicenseKey(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.UseLicenseKey = true
+		cfg.SpansAPIKey = "spans-key"
+		cfg.EventsAPIKey = "events-key"
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "id"})
+	h.RecordEvent(Event{EventType: "event"})
+
+	if key := h.swapOutSpans()[0].Header.Get("X-License-Key"); key != "spans-key" {
+		t.Error(key)
+	}
+	if key := h.swapOutEvents()[0].Header.Get("X-License-Key"); key != "events-key" {
+		t.Error(key)
+	}
+}
+
+func TestConfigGzipCompressionLevel(t *testing.T) {
+	message := strings.Repeat("the quick brown fox jumps over the lazy dog ", 1000)
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	contentLength := func(level int) int64 {
+		h, err := NewHarvester(configTesting, func(cfg *Config) {
+			cfg.GzipCompressionLevel = level
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+		h.RecordLog(Log{Message: message, Timestamp: tm})
+		reqs := h.swapOutLogs()
+		if len(reqs) != 1 {
+			t.Fatal(reqs)
+		}
+		return reqs[0].ContentLength
+	}
+	if speed, best := contentLength(gzip.HuffmanOnly), contentLength(gzip.BestCompression); speed <= best {
+		t.Error("compression level not applied", speed, best)
+	}
+	if unset, def := contentLength(0), contentLength(gzip.DefaultCompression); unset != def {
+		t.Error(unset, def)
+	}
+}
+
+func TestConfigGzipCompressionLevelInvalid(t *testing.T) {
+	h, err := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.GzipCompressionLevel = 42
+	})
+	if nil != h || err != errGzipCompressionLevel {
+		t.Error(h, err)
+	}
+}
+
+func TestHarvesterEndpoints(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	expect := map[Signal]string{
+		SignalSpans:   defaultSpanURL,
+		SignalMetrics: defaultMetricURL,
+		SignalEvents:  defaultEventURL,
+		SignalLogs:    defaultLogURL,
+	}
+	if endpoints := h.Endpoints(); !reflect.DeepEqual(endpoints, expect) {
+		t.Error(endpoints)
+	}
+
+	h, _ = NewHarvester(configTesting, func(cfg *Config) {
+		cfg.SpansURLOverride = "https://trace-observer.example.com:443/trace/v1"
+		// The path of an override is not used.
+		cfg.LogsURLOverride = "http://localhost:8080/custom/path"
+	})
+	expect = map[Signal]string{
+		SignalSpans:   "https://trace-observer.example.com:443/trace/v1",
+		SignalMetrics: defaultMetricURL,
+		SignalEvents:  defaultEventURL,
+		SignalLogs:    "http://localhost:8080/log/v1",
+	}
+	if endpoints := h.Endpoints(); !reflect.DeepEqual(endpoints, expect) {
+		t.Error(endpoints)
+	}
+
+	var nilHarvester *Harvester
+	if endpoints := nilHarvester.Endpoints(); nil != endpoints {
+		t.Error(endpoints)
+	}
+}
+
+func TestConfigRequestIDFunc(t *testing.T) {
+	var lock sync.Mutex
+	var ids []string
+	var next int
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.RequestIDFunc = func() string {
+			lock.Lock()
+			defer lock.Unlock()
+			next++
+			return "id-" + strconv.Itoa(next)
+		}
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			ids = append(ids, req.Header.Get("X-Request-Id"))
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+	h.RecordLog(Log{Message: "message"})
+	h.HarvestNow(context.Background())
+	sort.Strings(ids)
+	if expect := []string{"id-1", "id-2"}; !reflect.DeepEqual(ids, expect) {
+		t.Error(ids, expect)
+	}
+}
+
+func TestConfigDisableAutoTimestamp(t *testing.T) {
+	h, _ := NewHarvester(configTesting, ConfigDisableAutoTimestamp())
+	if !h.config.DisableAutoTimestamp {
+		t.Fatal("DisableAutoTimestamp not set")
+	}
+	h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+	h.RecordEvent(Event{EventType: "MyEvent"})
+	h.RecordLog(Log{Message: "message"})
+	spans := h.takeSpans()
+	if len(spans) != 1 || !spans[0].Timestamp.IsZero() {
+		t.Fatal(spans)
+	}
+	events := h.takeEvents()
+	if len(events) != 1 || !events[0].Timestamp.IsZero() {
+		t.Fatal(events)
+	}
+	logs := h.takeLogs()
+	if len(logs) != 1 || !logs[0].Timestamp.IsZero() {
+		t.Fatal(logs)
+	}
+
+	// The zero timestamps are omitted so that New Relic assigns them.
+	buf := &bytes.Buffer{}
+	spans[0].writeJSON(buf, SpanDurationMilliseconds)
+	events[0].writeJSON(buf, nil)
+	logs[0].writeJSON(buf)
+	if js := buf.String(); strings.Contains(js, "timestamp") {
+		t.Error(js)
+	}
+}
+
+func TestConfigAutoTimestamp(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+	h.RecordEvent(Event{EventType: "MyEvent"})
+	h.RecordLog(Log{Message: "message"})
+	if spans := h.takeSpans(); len(spans) != 1 || spans[0].Timestamp.IsZero() {
+		t.Error(spans)
+	}
+	if events := h.takeEvents(); len(events) != 1 || events[0].Timestamp.IsZero() {
+		t.Error(events)
+	}
+	if logs := h.takeLogs(); len(logs) != 1 || logs[0].Timestamp.IsZero() {
+		t.Error(logs)
+	}
+}
+
+func TestConfigRegion(t *testing.T) {
+	for _, region := range []string{RegionEU, "eu"} {
+		h, err := NewHarvester(configTesting, ConfigRegion(region))
+		if nil != err {
+			t.Fatal(region, err)
+		}
+		expect := map[Signal]string{
+			SignalSpans:   euSpanURL,
+			SignalMetrics: euMetricURL,
+			SignalEvents:  euEventURL,
+			SignalLogs:    euLogURL,
+		}
+		if endpoints := h.Endpoints(); !reflect.DeepEqual(endpoints, expect) {
+			t.Error(region, endpoints)
+		}
+	}
+
+	h, _ := NewHarvester(configTesting, ConfigRegion(RegionUS))
+	if endpoints := h.Endpoints(); endpoints[SignalMetrics] != defaultMetricURL {
+		t.Error(endpoints)
+	}
+
+	// The URL overrides take precedence over the region.
+	h, _ = NewHarvester(configTesting, ConfigRegion(RegionEU), ConfigMetricsURLOverride("https://localhost:8080/metric/v1"))
+	if endpoints := h.Endpoints(); endpoints[SignalMetrics] != "https://localhost:8080/metric/v1" || endpoints[SignalSpans] != euSpanURL {
+		t.Error(endpoints)
+	}
+}
+
+func TestConfigRegionInvalid(t *testing.T) {
+	h, err := NewHarvester(configTesting, ConfigRegion("APAC"))
+	if nil != h || err != errRegion {
+		t.Error(h, err)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+const (
+	traceIDAttribute    = "trace.id"
+	spanIDAttribute     = "span.id"
+	entityGUIDAttribute = "entity.guid"
+)
+
+// correlateLogsAndSpans backfills the span.id and entity.guid attributes of
+// logs that have a trace.id attribute using a span of the same trace.  The
+// root span of the trace is used if it is present, otherwise the first span
+// recorded.  Attributes already set on a log are not modified.  The number
+// of logs changed is returned.
+func correlateLogsAndSpans(logs []Log, spans []Span) int {
+	if len(logs) == 0 || len(spans) == 0 {
+		return 0
+	}
+	traces := make(map[string]*Span)
+	for i := range spans {
+		s := &spans[i]
+		if existing, ok := traces[s.TraceID]; !ok || (existing.ParentID != "" && s.ParentID == "") {
+			traces[s.TraceID] = s
+		}
+	}
+
+	var correlated int
+	for i := range logs {
+		traceID, _ := logs[i].Attributes[traceIDAttribute].(string)
+		s, ok := traces[traceID]
+		if !ok || traceID == "" {
+			continue
+		}
+		backfill := make(map[string]interface{}, 2)
+		if _, ok := logs[i].Attributes[spanIDAttribute]; !ok {
+			backfill[spanIDAttribute] = s.ID
+		}
+		if guid, ok := s.Attributes[entityGUIDAttribute]; ok {
+			if _, ok := logs[i].Attributes[entityGUIDAttribute]; !ok {
+				backfill[entityGUIDAttribute] = guid
+			}
+		}
+		if len(backfill) == 0 {
+			continue
+		}
+		// Copy the attributes since the map is owned by the caller of
+		// RecordLog.
+		attributes := make(map[string]interface{}, len(logs[i].Attributes)+len(backfill))
+		for k, v := range logs[i].Attributes {
+			attributes[k] = v
+		}
+		for k, v := range backfill {
+			attributes[k] = v
+		}
+		logs[i].Attributes = attributes
+		correlated++
+	}
+	return correlated
+}
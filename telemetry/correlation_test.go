@@ -0,0 +1,88 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+func TestCorrelateLogsAndSpans(t *testing.T) {
+	var lock sync.Mutex
+	var logsBody []byte
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.CorrelateLogsAndSpans = true
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if strings.HasPrefix(req.URL.Path, "/log") {
+				compressed, _ := ioutil.ReadAll(req.Body)
+				lock.Lock()
+				logsBody, _ = internal.Uncompress(compressed)
+				lock.Unlock()
+			}
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "child", TraceID: "trace-1", ParentID: "root"})
+	h.RecordSpan(Span{ID: "root", TraceID: "trace-1", Attributes: map[string]interface{}{
+		"entity.guid": "guid-1",
+	}})
+	logAttributes := map[string]interface{}{"trace.id": "trace-1"}
+	h.RecordLog(Log{Message: "correlated", Attributes: logAttributes})
+	h.RecordLog(Log{Message: "other trace", Attributes: map[string]interface{}{"trace.id": "trace-2"}})
+	h.HarvestNow(context.Background())
+
+	var batches []struct {
+		Logs []struct {
+			Message    string                 `json:"message"`
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"logs"`
+	}
+	if err := json.Unmarshal(logsBody, &batches); nil != err {
+		t.Fatal(err, string(logsBody))
+	}
+	logs := batches[0].Logs
+	if len(logs) != 2 {
+		t.Fatal(logs)
+	}
+	expect := map[string]interface{}{"trace.id": "trace-1", "span.id": "root", "entity.guid": "guid-1"}
+	if !reflect.DeepEqual(logs[0].Attributes, expect) {
+		t.Error("incorrect correlated attributes", logs[0].Attributes)
+	}
+	if !reflect.DeepEqual(logs[1].Attributes, map[string]interface{}{"trace.id": "trace-2"}) {
+		t.Error("log without a matching span should be unchanged", logs[1].Attributes)
+	}
+	if len(logAttributes) != 1 {
+		t.Error("the recorded attributes map should not be modified", logAttributes)
+	}
+}
+
+func TestCorrelateLogsAndSpansKeepsExistingAttributes(t *testing.T) {
+	logs := []Log{{Message: "log", Attributes: map[string]interface{}{
+		"trace.id": "trace-1",
+		"span.id":  "explicit",
+	}}}
+	spans := []Span{{ID: "first", TraceID: "trace-1", ParentID: "missing"}}
+	if n := correlateLogsAndSpans(logs, spans); n != 0 {
+		t.Error("no logs should be changed", n)
+	}
+	if id := logs[0].Attributes["span.id"]; id != "explicit" {
+		t.Error(id)
+	}
+
+	logs[0].Attributes = map[string]interface{}{"trace.id": "trace-1"}
+	if n := correlateLogsAndSpans(logs, spans); n != 1 {
+		t.Error(n)
+	}
+	if id := logs[0].Attributes["span.id"]; id != "first" {
+		t.Error("first span should be used without a root span", id)
+	}
+}
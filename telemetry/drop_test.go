@@ -0,0 +1,298 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type dropRecord struct {
+	signal string
+	count  int
+	reason string
+}
+
+func configureDropsToSlice(lock *sync.Mutex, drops *[]dropRecord) func(*Config) {
+	return func(cfg *Config) {
+		cfg.OnDrop = func(signal string, count int, reason string) {
+			lock.Lock()
+			defer lock.Unlock()
+			*drops = append(*drops, dropRecord{signal: signal, count: count, reason: reason})
+		}
+	}
+}
+
+func TestOnDropValidation(t *testing.T) {
+	var lock sync.Mutex
+	var drops []dropRecord
+	h, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops))
+
+	h.RecordSpan(Span{ID: "id"})
+	h.RecordSpan(Span{TraceID: "id"})
+	h.RecordEvent(Event{})
+	h.RecordLog(Log{})
+	h.RecordMetric(Gauge{Name: "gauge", Value: math.NaN()})
+	h.RecordGaugeSeries("series", nil, []GaugePoint{
+		{Value: math.Inf(1)}, {Value: 1}, {Value: math.NaN()},
+	})
+	h.MetricAggregator().Count("count", nil).Increase(math.NaN())
+	h.MetricAggregator().Gauge("gauge", nil).Value(math.NaN())
+	h.MetricAggregator().Summary("summary", nil).Record(math.NaN())
+
+	expect := []dropRecord{
+		{"spans", 1, DropReasonValidation},
+		{"spans", 1, DropReasonValidation},
+		{"events", 1, DropReasonValidation},
+		{"logs", 1, DropReasonValidation},
+		{"metrics", 1, DropReasonValidation},
+		{"metrics", 2, DropReasonValidation},
+		{"metrics", 1, DropReasonValidation},
+		{"metrics", 1, DropReasonValidation},
+		{"metrics", 1, DropReasonValidation},
+	}
+	if !reflect.DeepEqual(drops, expect) {
+		t.Errorf("\nexpect=%v\nactual=%v", expect, drops)
+	}
+}
+
+func TestOnDropTooOld(t *testing.T) {
+	var lock sync.Mutex
+	var drops []dropRecord
+	h, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops), func(cfg *Config) {
+		cfg.MaxDataAge = time.Minute
+	})
+	old := time.Now().Add(-time.Hour)
+	h.RecordSpan(Span{ID: "id", TraceID: "id", Timestamp: old})
+	h.RecordEvent(Event{EventType: "event", Timestamp: old})
+	h.RecordEvent(Event{EventType: "event", Timestamp: old})
+	h.RecordLog(Log{Message: "log", Timestamp: old})
+	h.RecordMetric(Gauge{Name: "gauge", Timestamp: old})
+	h.swapOutSpans()
+	h.swapOutEvents()
+	h.swapOutLogs()
+	h.swapOutMetrics(time.Now())
+
+	expect := []dropRecord{
+		{"spans", 1, DropReasonTooOld},
+		{"events", 2, DropReasonTooOld},
+		{"logs", 1, DropReasonTooOld},
+		{"metrics", 1, DropReasonTooOld},
+	}
+	if !reflect.DeepEqual(drops, expect) {
+		t.Errorf("\nexpect=%v\nactual=%v", expect, drops)
+	}
+}
+
+func TestOnDropRejected(t *testing.T) {
+	var lock sync.Mutex
+	var drops []dropRecord
+	h, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops), func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 202,
+				Body: ioutil.NopCloser(bytes.NewReader([]byte(`{"rejected":[
+					{"index":0,"retryable":false},
+					{"index":1,"retryable":true}
+				]}`))),
+			}, nil
+		})
+	})
+	h.RecordMetric(Gauge{Name: "dropMe", Timestamp: time.Now()})
+	h.RecordMetric(Gauge{Name: "retryMe", Timestamp: time.Now()})
+	h.HarvestNow(context.Background())
+
+	expect := []dropRecord{{"metrics", 1, DropReasonRejected}}
+	if !reflect.DeepEqual(drops, expect) {
+		t.Errorf("\nexpect=%v\nactual=%v", expect, drops)
+	}
+}
+
+func TestOnDropRejectedStatus(t *testing.T) {
+	var lock sync.Mutex
+	var drops []dropRecord
+	h, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops), func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(413), nil
+		})
+	})
+	h.RecordEvent(Event{EventType: "event", Timestamp: time.Now()})
+	h.RecordEvent(Event{EventType: "event", Timestamp: time.Now()})
+	h.HarvestNow(context.Background())
+
+	expect := []dropRecord{{"events", 2, DropReasonRejected}}
+	if !reflect.DeepEqual(drops, expect) {
+		t.Errorf("\nexpect=%v\nactual=%v", expect, drops)
+	}
+}
+
+func TestOnDropTimeout(t *testing.T) {
+	var lock sync.Mutex
+	var drops []dropRecord
+	dropped := make(chan struct{})
+	h, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops), func(cfg *Config) {
+		cfg.DisableJitter = true
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(500), nil
+		})
+		onDrop := cfg.OnDrop
+		cfg.OnDrop = func(signal string, count int, reason string) {
+			onDrop(signal, count, reason)
+			close(dropped)
+		}
+	})
+	h.RecordLog(Log{Message: "log", Timestamp: time.Now()})
+	// The second retry waits a second, so the context is done first.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	h.HarvestNow(ctx)
+
+	select {
+	case <-dropped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("data was not dropped")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	expect := []dropRecord{{"logs", 1, DropReasonTimeout}}
+	if !reflect.DeepEqual(drops, expect) {
+		t.Errorf("\nexpect=%v\nactual=%v", expect, drops)
+	}
+}
+
+func TestOnDropTooLarge(t *testing.T) {
+	var lock sync.Mutex
+	var drops []dropRecord
+	h, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops))
+	// A single log too large to be split into acceptable payloads.
+	h.RecordLog(Log{Message: string(randomJSON(4 * maxCompressedSizeBytes))})
+	if reqs := h.swapOutLogs(); nil != reqs {
+		t.Error(reqs)
+	}
+
+	expect := []dropRecord{{"logs", 1, DropReasonTooLarge}}
+	if !reflect.DeepEqual(drops, expect) {
+		t.Errorf("\nexpect=%v\nactual=%v", expect, drops)
+	}
+}
+
+func TestMaxBufferedPayloads(t *testing.T) {
+	var lock sync.Mutex
+	var drops []dropRecord
+	var savedErrors []map[string]interface{}
+	h, _ := NewHarvester(configTesting,
+		ConfigMaxBufferedPayloads(2),
+		configureDropsToSlice(&lock, &drops),
+		configureLoggingErrorsToMap(&savedErrors))
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		err := h.RecordSpan(Span{ID: "id", TraceID: "id"})
+		if expectFull := i == 2; (err == errBufferFull) != expectFull {
+			t.Error(i, err)
+		}
+	}
+	h.RecordEvent(Event{EventType: "event"})
+	h.RecordEvent(Event{EventType: "event"})
+	if err := h.RecordEvent(Event{EventType: "event"}); err != errBufferFull {
+		t.Error(err)
+	}
+	h.RecordLog(Log{Message: "log"})
+	h.RecordLog(Log{Message: "log"})
+	if err := h.RecordLog(Log{Message: "log"}); err != errBufferFull {
+		t.Error(err)
+	}
+	h.RecordMetric(Gauge{Name: "gauge", Value: 1, Timestamp: now})
+	h.RecordGaugeSeries("series", nil, []GaugePoint{
+		{Value: 1, Timestamp: now}, {Value: 2, Timestamp: now},
+	})
+	if err := h.RecordMetricSnapshot([]Metric{Gauge{Name: "snapshot", Value: 1}}, now, 0); err != errBufferFull {
+		t.Error(err)
+	}
+	if err := h.RecordMetric(Gauge{Name: "gauge", Value: 1, Timestamp: now}); err != errBufferFull {
+		t.Error(err)
+	}
+	// Aggregated metrics are not limited.
+	h.MetricAggregator().Count("count", nil).Increment()
+
+	expect := []dropRecord{
+		{"spans", 1, DropReasonBufferFull},
+		{"events", 1, DropReasonBufferFull},
+		{"logs", 1, DropReasonBufferFull},
+		{"metrics", 1, DropReasonBufferFull},
+		{"metrics", 1, DropReasonBufferFull},
+		{"metrics", 1, DropReasonBufferFull},
+	}
+	if !reflect.DeepEqual(drops, expect) {
+		t.Errorf("\nexpect=%v\nactual=%v", expect, drops)
+	}
+	if n := h.BufferDrops(); n != 6 {
+		t.Error(n)
+	}
+	if len(savedErrors) != 0 {
+		t.Error("drops should be logged at harvest", savedErrors)
+	}
+
+	if metrics, _ := h.takeMetrics(now); len(metrics) != 3 {
+		t.Error(len(metrics))
+	}
+	if spans := h.takeSpans(); len(spans) != 2 {
+		t.Error(len(spans))
+	}
+	h.takeEvents()
+	h.takeLogs()
+	if len(savedErrors) != 4 {
+		t.Fatal(savedErrors)
+	}
+	if e := savedErrors[0]; e["data-type"] != "metrics" || e["count"] != 3 {
+		t.Error(e)
+	}
+
+	// The buffers have space again after the harvest.
+	if err := h.RecordSpan(Span{ID: "id", TraceID: "id"}); nil != err {
+		t.Error(err)
+	}
+	if n := h.BufferDrops(); n != 6 {
+		t.Error(n)
+	}
+}
+
+func TestBufferDropsNilHarvester(t *testing.T) {
+	var h *Harvester
+	if n := h.BufferDrops(); n != 0 {
+		t.Error(n)
+	}
+}
+
+func TestOnDropTooLargeAmongOthers(t *testing.T) {
+	var lock sync.Mutex
+	var drops []dropRecord
+	h, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops))
+	for i := 0; i < 10; i++ {
+		h.RecordLog(Log{Message: "small"})
+	}
+	h.RecordLog(Log{Message: string(randomJSON(4 * maxCompressedSizeBytes))})
+	for i := 0; i < 10; i++ {
+		h.RecordLog(Log{Message: "small"})
+	}
+
+	logs := 0
+	for _, req := range h.swapOutLogs() {
+		logs += countRequestItems(req, nil)
+	}
+	if logs != 20 {
+		t.Error(logs)
+	}
+	expect := []dropRecord{{"logs", 1, DropReasonTooLarge}}
+	if !reflect.DeepEqual(drops, expect) {
+		t.Errorf("\nexpect=%v\nactual=%v", expect, drops)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+// duplicateKey identifies metrics which describe the same time series over
+// the same time window and can therefore be merged.
+type duplicateKey struct {
+	metricType string
+	metricIdentity
+	timestamp int64
+	interval  time.Duration
+	server    bool
+}
+
+func identityAttributesJSON(attributes map[string]interface{}, attributesJSON json.RawMessage) string {
+	if nil == attributes && len(attributesJSON) > 0 {
+		// Decode the attributes so that the identity does not depend on
+		// the order of the fields in the raw JSON.
+		if err := json.Unmarshal(attributesJSON, &attributes); nil != err {
+			return string(attributesJSON)
+		}
+	}
+	return string(internal.MarshalOrderedAttributes(attributes))
+}
+
+func timestampKey(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// dereferenceMetric returns the value of metrics recorded as pointers so
+// that merging does not modify the caller's metric.
+func dereferenceMetric(m Metric) Metric {
+	switch v := m.(type) {
+	case *Count:
+		return *v
+	case *Summary:
+		return *v
+	case *Gauge:
+		return *v
+	case *Histogram:
+		return *v
+	}
+	return m
+}
+
+// newDuplicateKey returns the key of the metric and false if the metric
+// cannot be merged.
+func newDuplicateKey(m Metric) (duplicateKey, bool) {
+	switch v := m.(type) {
+	case Count:
+		return duplicateKey{
+			metricType:     "count",
+			server:         v.ServerTimestamp,
+			metricIdentity: metricIdentity{Name: v.Name, attributesJSON: identityAttributesJSON(v.Attributes, v.AttributesJSON)},
+			timestamp:      timestampKey(v.Timestamp),
+			interval:       v.Interval,
+		}, true
+	case Summary:
+		return duplicateKey{
+			metricType:     "summary",
+			server:         v.ServerTimestamp,
+			metricIdentity: metricIdentity{Name: v.Name, attributesJSON: identityAttributesJSON(v.Attributes, v.AttributesJSON)},
+			timestamp:      timestampKey(v.Timestamp),
+			interval:       v.Interval,
+		}, true
+	case Gauge:
+		return duplicateKey{
+			metricType:     "gauge",
+			metricIdentity: metricIdentity{Name: v.Name, attributesJSON: identityAttributesJSON(v.Attributes, v.AttributesJSON)},
+			timestamp:      timestampKey(v.Timestamp),
+		}, true
+	}
+	return duplicateKey{}, false
+}
+
+// mergeMetrics merges m into existing.  Both metrics must have the same
+// duplicateKey.  Counts are summed, summaries are combined, and the later
+// gauge replaces the earlier one.
+func mergeMetrics(existing, m Metric) Metric {
+	switch e := existing.(type) {
+	case Count:
+		c := m.(Count)
+		e.Value += c.Value
+		e.ForceIntervalValid = e.ForceIntervalValid || c.ForceIntervalValid
+		return e
+	case Summary:
+		s := m.(Summary)
+		e.Count += s.Count
+		e.Sum += s.Sum
+		e.Min = math.Min(e.Min, s.Min)
+		e.Max = math.Max(e.Max, s.Max)
+		e.ForceIntervalValid = e.ForceIntervalValid || s.ForceIntervalValid
+		return e
+	}
+	return m
+}
+
+// mergeDuplicateMetrics merges metrics with the same type, name, attributes,
+// timestamp, and interval.  The order of the first occurrence of each metric
+// is preserved.  The number of metrics merged away is returned.
+func mergeDuplicateMetrics(metrics []Metric) ([]Metric, int) {
+	index := make(map[duplicateKey]int, len(metrics))
+	merged := make([]Metric, 0, len(metrics))
+	var count int
+	for _, m := range metrics {
+		m = dereferenceMetric(m)
+		key, ok := newDuplicateKey(m)
+		if !ok {
+			merged = append(merged, m)
+			continue
+		}
+		if i, exists := index[key]; exists {
+			merged[i] = mergeMetrics(merged[i], m)
+			count++
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, m)
+	}
+	return merged, count
+}
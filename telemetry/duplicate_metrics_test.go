@@ -0,0 +1,76 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMergeDuplicateMetrics(t *testing.T) {
+	var debugLogs []map[string]interface{}
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.MergeDuplicateMetrics = true
+		cfg.DebugLogger = func(fields map[string]interface{}) {
+			if fields["event"] == "duplicate metrics merged" {
+				debugLogs = append(debugLogs, fields)
+			}
+		}
+	})
+	attrs := map[string]interface{}{"zip": "zap", "zop": 1}
+
+	h.RecordMetric(Count{Name: "myCount", Attributes: attrs, Value: 2})
+	h.MetricAggregator().Count("myCount", attrs).Increase(3)
+	// Raw JSON attributes in a different order share the identity.
+	h.RecordMetric(Summary{Name: "mySummary", AttributesJSON: json.RawMessage(`{"zop":1,"zip":"zap"}`), Count: 1, Sum: 10, Min: 10, Max: 10})
+	h.MetricAggregator().Summary("mySummary", attrs).Record(2)
+	// Metrics of a different type or window are not merged.
+	h.RecordMetric(Gauge{Name: "myCount", Attributes: attrs, Value: 7, Timestamp: time.Unix(10, 0)})
+	h.RecordMetric(Count{Name: "myCount", Attributes: attrs, Value: 1, Timestamp: time.Unix(10, 0)})
+
+	metrics, _ := h.takeMetrics(time.Now())
+	if len(metrics) != 4 {
+		t.Fatal(metrics)
+	}
+	if c, ok := metrics[0].(Count); !ok || c.Value != 5 {
+		t.Error("counts should be summed", metrics[0])
+	}
+	if s, ok := metrics[1].(Summary); !ok || s.Count != 2 || s.Sum != 12 || s.Min != 2 || s.Max != 10 {
+		t.Error("summaries should be combined", metrics[1])
+	}
+	if g, ok := metrics[2].(Gauge); !ok || g.Value != 7 {
+		t.Error(metrics[2])
+	}
+	if c, ok := metrics[3].(Count); !ok || c.Value != 1 {
+		t.Error(metrics[3])
+	}
+	if len(debugLogs) != 1 || debugLogs[0]["count"] != 2 {
+		t.Error("merges should be logged", debugLogs)
+	}
+}
+
+func TestMergeDuplicateGaugesKeepsLatest(t *testing.T) {
+	ts := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting, func(cfg *Config) { cfg.MergeDuplicateMetrics = true })
+	h.RecordMetric(Gauge{Name: "myGauge", Value: 1, Timestamp: ts})
+	h.MetricAggregator().Gauge("myGauge", nil).ValueAt(2, ts)
+	metrics, _ := h.takeMetrics(time.Now())
+	if len(metrics) != 1 {
+		t.Fatal(metrics)
+	}
+	if g := metrics[0].(Gauge); g.Value != 2 {
+		t.Error("the most recently recorded gauge should be kept", g)
+	}
+}
+
+func TestDuplicateMetricsNotMergedByDefault(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	h.RecordMetric(Count{Name: "myCount", Value: 2})
+	h.MetricAggregator().Count("myCount", nil).Increase(3)
+	metrics, _ := h.takeMetrics(time.Now())
+	if len(metrics) != 2 {
+		t.Error(metrics)
+	}
+}
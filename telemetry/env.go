@@ -0,0 +1,92 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Environment variables read by NewHarvesterFromEnv.
+const (
+	envLicenseKey         = "NEW_RELIC_LICENSE_KEY"
+	envInsertKey          = "NEW_RELIC_INSIGHTS_INSERT_API_KEY"
+	envInsertKeyAlternate = "NEW_RELIC_INSERT_API_KEY"
+	envRegion             = "NEW_RELIC_REGION"
+	envMetricsURL         = "NEW_RELIC_METRICS_URL"
+	envSpansURL           = "NEW_RELIC_SPANS_URL"
+	envEventsURL          = "NEW_RELIC_EVENTS_URL"
+	envLogsURL            = "NEW_RELIC_LOGS_URL"
+)
+
+var (
+	errEnvAPIKeyUnset = errors.New("no New Relic key found in the environment: set one of " +
+		envLicenseKey + ", " + envInsertKey + ", or " + envInsertKeyAlternate)
+)
+
+// NewHarvesterFromEnv creates a new harvester configured from the
+// environment.  The key is read from NEW_RELIC_LICENSE_KEY, in which case it
+// is sent as a license key, or else from NEW_RELIC_INSIGHTS_INSERT_API_KEY or
+// NEW_RELIC_INSERT_API_KEY, in which case it is sent as an Insert API key.
+// NEW_RELIC_REGION selects the "US" or "EU" endpoints, defaulting to "EU" for
+// EU keys and "US" otherwise.  NEW_RELIC_METRICS_URL, NEW_RELIC_SPANS_URL,
+// NEW_RELIC_EVENTS_URL, and NEW_RELIC_LOGS_URL override individual endpoints.
+// The options are applied after the environment and so take precedence.
+func NewHarvesterFromEnv(options ...func(*Config)) (*Harvester, error) {
+	return newHarvesterFromLookup(os.LookupEnv, options...)
+}
+
+func newHarvesterFromLookup(lookup func(string) (string, bool), options ...func(*Config)) (*Harvester, error) {
+	env := func(key string) string {
+		val, _ := lookup(key)
+		return strings.TrimSpace(val)
+	}
+
+	var cfg Config
+	if key := env(envLicenseKey); key != "" {
+		cfg.APIKey = key
+		cfg.UseLicenseKey = true
+	} else if key := env(envInsertKey); key != "" {
+		cfg.APIKey = key
+	} else if key := env(envInsertKeyAlternate); key != "" {
+		cfg.APIKey = key
+	} else {
+		return nil, errEnvAPIKeyUnset
+	}
+
+	region := strings.ToUpper(env(envRegion))
+	if region == "" && strings.HasPrefix(cfg.APIKey, euKeyPrefix) {
+		region = RegionEU
+	}
+	cfg.Region = region
+	if !cfg.validRegion() {
+		return nil, fmt.Errorf("invalid %s %q: must be \"US\" or \"EU\"", envRegion, region)
+	}
+
+	if u := env(envSpansURL); u != "" {
+		cfg.SpansURLOverride = u
+	}
+	if u := env(envMetricsURL); u != "" {
+		cfg.MetricsURLOverride = u
+	}
+	if u := env(envEventsURL); u != "" {
+		cfg.EventsURLOverride = u
+	}
+	if u := env(envLogsURL); u != "" {
+		cfg.LogsURLOverride = u
+	}
+
+	envOption := func(c *Config) {
+		c.APIKey = cfg.APIKey
+		c.UseLicenseKey = cfg.UseLicenseKey
+		c.Region = cfg.Region
+		c.SpansURLOverride = cfg.SpansURLOverride
+		c.MetricsURLOverride = cfg.MetricsURLOverride
+		c.EventsURLOverride = cfg.EventsURLOverride
+		c.LogsURLOverride = cfg.LogsURLOverride
+	}
+	return NewHarvester(append([]func(*Config){envOption}, options...)...)
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func mapLookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		val, ok := env[key]
+		return val, ok
+	}
+}
+
+func disableHarvestRoutine(cfg *Config) {
+	cfg.HarvestPeriod = 0
+}
+
+func TestNewHarvesterFromEnv(t *testing.T) {
+	testcases := []struct {
+		name       string
+		env        map[string]string
+		key        string
+		keyHeader  string
+		metricHost string
+		eventHost  string
+	}{
+		{
+			name:       "insert key",
+			env:        map[string]string{"NEW_RELIC_INSIGHTS_INSERT_API_KEY": "insert"},
+			key:        "insert",
+			keyHeader:  "Api-Key",
+			metricHost: "metric-api.newrelic.com",
+			eventHost:  "insights-collector.newrelic.com",
+		},
+		{
+			name:       "alternate insert key",
+			env:        map[string]string{"NEW_RELIC_INSERT_API_KEY": "insert"},
+			key:        "insert",
+			keyHeader:  "Api-Key",
+			metricHost: "metric-api.newrelic.com",
+			eventHost:  "insights-collector.newrelic.com",
+		},
+		{
+			name: "eu region",
+			env: map[string]string{
+				"NEW_RELIC_INSIGHTS_INSERT_API_KEY": "insert",
+				"NEW_RELIC_REGION":                  "eu",
+			},
+			key:        "insert",
+			keyHeader:  "Api-Key",
+			metricHost: "metric-api.eu.newrelic.com",
+			eventHost:  "insights-collector.eu01.nr-data.net",
+		},
+		{
+			name:       "eu key",
+			env:        map[string]string{"NEW_RELIC_INSIGHTS_INSERT_API_KEY": "eu01xx0123456789abcdef0123456789abcdef"},
+			key:        "eu01xx0123456789abcdef0123456789abcdef",
+			keyHeader:  "Api-Key",
+			metricHost: "metric-api.eu.newrelic.com",
+			eventHost:  "insights-collector.eu01.nr-data.net",
+		},
+		{
+			name: "us region overrides eu key",
+			env: map[string]string{
+				"NEW_RELIC_INSIGHTS_INSERT_API_KEY": "eu01xx0123456789abcdef0123456789abcdef",
+				"NEW_RELIC_REGION":                  "US",
+			},
+			key:        "eu01xx0123456789abcdef0123456789abcdef",
+			keyHeader:  "Api-Key",
+			metricHost: "metric-api.newrelic.com",
+			eventHost:  "insights-collector.newrelic.com",
+		},
+		{
+			name: "url override",
+			env: map[string]string{
+				"NEW_RELIC_INSIGHTS_INSERT_API_KEY": "insert",
+				"NEW_RELIC_REGION":                  "EU",
+				"NEW_RELIC_METRICS_URL":             "https://localhost:8080/metric/v1",
+			},
+			key:        "insert",
+			keyHeader:  "Api-Key",
+			metricHost: "localhost:8080",
+			eventHost:  "insights-collector.eu01.nr-data.net",
+		},
+		{
+			name:       "license key",
+			env:        map[string]string{"NEW_RELIC_LICENSE_KEY": "license"},
+			key:        "license",
+			keyHeader:  "X-License-Key",
+			metricHost: "metric-api.newrelic.com",
+			eventHost:  "insights-collector.newrelic.com",
+		},
+		{
+			name: "license key preferred",
+			env: map[string]string{
+				"NEW_RELIC_LICENSE_KEY":             "license",
+				"NEW_RELIC_INSIGHTS_INSERT_API_KEY": "insert",
+			},
+			key:        "license",
+			keyHeader:  "X-License-Key",
+			metricHost: "metric-api.newrelic.com",
+			eventHost:  "insights-collector.newrelic.com",
+		},
+		{
+			name: "license key eu region",
+			env: map[string]string{
+				"NEW_RELIC_LICENSE_KEY": "license",
+				"NEW_RELIC_REGION":      "eu",
+			},
+			key:        "license",
+			keyHeader:  "X-License-Key",
+			metricHost: "metric-api.eu.newrelic.com",
+			eventHost:  "insights-collector.eu01.nr-data.net",
+		},
+		{
+			name:       "eu license key",
+			env:        map[string]string{"NEW_RELIC_LICENSE_KEY": "eu01xx0123456789abcdef0123456789abcdNRAL"},
+			key:        "eu01xx0123456789abcdef0123456789abcdNRAL",
+			keyHeader:  "X-License-Key",
+			metricHost: "metric-api.eu.newrelic.com",
+			eventHost:  "insights-collector.eu01.nr-data.net",
+		},
+	}
+
+	for _, tc := range testcases {
+		h, err := newHarvesterFromLookup(mapLookup(tc.env), disableHarvestRoutine)
+		if nil != err {
+			t.Fatal(tc.name, err)
+		}
+		h.RecordMetric(Gauge{Name: "gauge"})
+		h.RecordEvent(Event{EventType: "testEvent"})
+		metricReq := h.swapOutMetrics(h.lastHarvest)[0]
+		eventReq := h.swapOutEvents()[0]
+		if host := metricReq.URL.Host; host != tc.metricHost {
+			t.Error(tc.name, host)
+		}
+		if host := eventReq.URL.Host; host != tc.eventHost {
+			t.Error(tc.name, host)
+		}
+		for _, req := range []*http.Request{metricReq, eventReq} {
+			if key := req.Header.Get(tc.keyHeader); key != tc.key {
+				t.Error(tc.name, req.Header)
+			}
+		}
+	}
+}
+
+func TestNewHarvesterFromEnvOptionsTakePrecedence(t *testing.T) {
+	env := map[string]string{"NEW_RELIC_INSIGHTS_INSERT_API_KEY": "insert"}
+	h, err := newHarvesterFromLookup(mapLookup(env), disableHarvestRoutine, func(cfg *Config) {
+		cfg.EventsURLOverride = "https://localhost/v1/accounts/events"
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if u := h.config.eventURL(); u != "https://localhost/v1/accounts/events" {
+		t.Error(u)
+	}
+}
+
+func TestNewHarvesterFromEnvErrors(t *testing.T) {
+	h, err := newHarvesterFromLookup(mapLookup(nil), disableHarvestRoutine)
+	if nil != h || err != errEnvAPIKeyUnset {
+		t.Fatal(h, err)
+	}
+	for _, v := range []string{"NEW_RELIC_LICENSE_KEY", "NEW_RELIC_INSIGHTS_INSERT_API_KEY", "NEW_RELIC_INSERT_API_KEY"} {
+		if !strings.Contains(err.Error(), v) {
+			t.Error("error does not list", v, err)
+		}
+	}
+
+	env := map[string]string{
+		"NEW_RELIC_INSIGHTS_INSERT_API_KEY": "insert",
+		"NEW_RELIC_REGION":                  "APAC",
+	}
+	h, err = newHarvesterFromLookup(mapLookup(env), disableHarvestRoutine)
+	if nil != h || nil == err {
+		t.Fatal(h, err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	// errorEventType is the event type ingested by the Errors Inbox.
+	errorEventType = "TransactionError"
+	// errorStackMaxFrames limits the size of the error.stack attribute.
+	errorStackMaxFrames = 20
+)
+
+var (
+	errErrorUnset = errors.New("error must not be nil")
+)
+
+// RecordError records the error as an event shaped for New Relic's Errors
+// Inbox.  The event has the following attributes, which may be overridden
+// using attributes:
+//
+//  * error.message: the result of err.Error()
+//  * error.class: the concrete type of err, eg. "*os.PathError"
+//  * error.group: used to group similar errors, defaults to error.class
+//  * error.chain: the type and message of each error in the chain of
+//    wrapped errors found using errors.Unwrap, one per line
+//  * error.stack: the stack trace of the caller of RecordError
+//
+// The event is sent using the events pipeline.  An error is returned if err
+// is nil.
+func (h *Harvester) RecordError(err error, attributes map[string]interface{}) error {
+	if nil == h {
+		return nil
+	}
+	if nil == err {
+		h.config.drop(SignalEvents, 1, DropReasonValidation)
+		return errErrorUnset
+	}
+
+	class := errorClass(err)
+	attrs := map[string]interface{}{
+		"error.message": err.Error(),
+		"error.class":   class,
+		"error.group":   class,
+		"error.chain":   errorChain(err),
+		"error.stack":   callerStack(2),
+	}
+	for k, v := range attributes {
+		attrs[k] = v
+	}
+	return h.RecordEvent(Event{
+		EventType:  errorEventType,
+		Attributes: attrs,
+	})
+}
+
+func errorClass(err error) string {
+	return fmt.Sprintf("%T", err)
+}
+
+// errorChain describes err and each of the errors it wraps, one per line.
+func errorChain(err error) string {
+	var lines []string
+	for ; nil != err; err = errors.Unwrap(err) {
+		lines = append(lines, errorClass(err)+": "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// callerStack returns the stack trace starting at the caller skip frames
+// above callerStack.
+func callerStack(skip int) string {
+	pcs := make([]uintptr, errorStackMaxFrames)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		b.WriteString(frame.Function)
+		b.WriteString("\n\t")
+		b.WriteString(frame.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(frame.Line))
+		b.WriteByte('\n')
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRecordError(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	root := errors.New("file not found")
+	err := fmt.Errorf("unable to load config: %w", root)
+	if e := h.RecordError(err, map[string]interface{}{"zip": "zap"}); nil != e {
+		t.Fatal(e)
+	}
+
+	events := h.takeEvents()
+	if len(events) != 1 {
+		t.Fatal(events)
+	}
+	e := events[0]
+	if e.EventType != "TransactionError" {
+		t.Error(e.EventType)
+	}
+	if e.Timestamp.IsZero() {
+		t.Error("timestamp not set")
+	}
+	attrs := e.Attributes
+	expect := map[string]interface{}{
+		"error.message": err.Error(),
+		"error.class":   "*fmt.wrapError",
+		"error.group":   "*fmt.wrapError",
+		"error.chain":   "*fmt.wrapError: " + err.Error() + "\n*errors.errorString: file not found",
+		"zip":           "zap",
+	}
+	for k, v := range expect {
+		if attrs[k] != v {
+			t.Errorf("%s: expect=%q actual=%q", k, v, attrs[k])
+		}
+	}
+	stack, _ := attrs["error.stack"].(string)
+	if !strings.HasPrefix(stack, "github.com/newrelic/newrelic-telemetry-sdk-go/telemetry.TestRecordError\n") {
+		t.Error(stack)
+	}
+	if len(attrs) != len(expect)+1 {
+		t.Error(attrs)
+	}
+}
+
+func TestRecordErrorOverrideAttributes(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	h.RecordError(errors.New("timeout"), map[string]interface{}{"error.group": "network"})
+	events := h.takeEvents()
+	if len(events) != 1 {
+		t.Fatal(events)
+	}
+	if g := events[0].Attributes["error.group"]; g != "network" {
+		t.Error(g)
+	}
+	if c := events[0].Attributes["error.class"]; c != "*errors.errorString" {
+		t.Error(c)
+	}
+}
+
+func TestRecordErrorNil(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	if err := h.RecordError(nil, nil); err != errErrorUnset {
+		t.Error(err)
+	}
+	if events := h.takeEvents(); len(events) != 0 {
+		t.Error(events)
+	}
+
+	var nilHarvester *Harvester
+	if err := nilHarvester.RecordError(errors.New("oops"), nil); nil != err {
+		t.Error(err)
+	}
+}
@@ -10,6 +10,10 @@ import (
 
 const eventTypeName string = "events"
 
+// eventIdempotencyKeyAttribute is the attribute set on events when
+// Config.EventIdempotencyKeys is enabled.
+const eventIdempotencyKeyAttribute = "idempotency.key"
+
 // Event is a unique set of data that happened at a specific point in time
 type Event struct {
 	// Required Fields:
@@ -17,7 +21,9 @@ type Event struct {
 	// EventType is the name of the event
 	EventType string
 	// Timestamp is when this event happened.  If Timestamp is not set, it
-	// will be assigned to time.Now() in Harvester.RecordEvent.
+	// will be assigned to time.Now() in Harvester.RecordEvent unless
+	// Config.DisableAutoTimestamp is set, in which case it is omitted
+	// and New Relic assigns the time the event is received.
 	Timestamp time.Time
 
 	// Recommended Fields:
@@ -28,15 +34,28 @@ type Event struct {
 	// AttributesJSON is a json.RawMessage of attributes for this metric. It
 	// will only be sent if Attributes is nil.
 	AttributesJSON json.RawMessage
+
+	// bufferedAt is when the event was recorded if
+	// Config.TrackQueueLatency is enabled.
+	bufferedAt time.Time
 }
 
-func (e *Event) writeJSON(buf *bytes.Buffer) {
+// writeJSON writes the event with the common attributes that it does not
+// override, since the Event API has no common block.
+func (e *Event) writeJSON(buf *bytes.Buffer, common map[string]interface{}) {
 	w := internal.JSONFieldsWriter{Buf: buf}
 	buf.WriteByte('{')
 
 	w.StringField("eventType", e.EventType)
-	w.IntField("timestamp", e.Timestamp.UnixNano()/(1000*1000))
+	if !e.Timestamp.IsZero() {
+		w.IntField("timestamp", e.Timestamp.UnixNano()/(1000*1000))
+	}
 
+	for k, v := range common {
+		if _, ok := e.Attributes[k]; !ok {
+			internal.AddAttribute(&w, k, v)
+		}
+	}
 	internal.AddAttributes(&w, e.Attributes)
 
 	buf.WriteByte('}')
@@ -45,16 +64,24 @@ func (e *Event) writeJSON(buf *bytes.Buffer) {
 // eventGroup represents a single batch of events to report to New Relic.
 type eventGroup struct {
 	Events []Event
+	// commonAttributes are added to each event.  See
+	// Config.EventCommonAttributes.
+	commonAttributes map[string]interface{}
 }
 
-// split will split the eventGroup into 2 equally sized batches.
-// If the number of events in the original is 0 or 1 then nil is returned.
-func (group *eventGroup) split() []splittablePayloadEntry {
+// split will split the eventGroup into 2 batches at the point chosen by the
+// strategy.  If the number of events in the original is 0 or 1 then nil is
+// returned.
+func (group *eventGroup) split(strategy SplitStrategy) []splittablePayloadEntry {
 	if len(group.Events) < 2 {
 		return nil
 	}
 
-	half := len(group.Events) / 2
+	half := splitIndex(strategy, len(group.Events), func(i int) int {
+		buf := &bytes.Buffer{}
+		group.Events[i].writeJSON(buf, group.commonAttributes)
+		return buf.Len()
+	})
 	b1 := *group
 	b1.Events = group.Events[:half]
 	b2 := *group
@@ -68,7 +95,7 @@ func (group *eventGroup) writeJSON(buf *bytes.Buffer) {
 		if idx > 0 {
 			buf.WriteByte(',')
 		}
-		s.writeJSON(buf)
+		s.writeJSON(buf, group.commonAttributes)
 	}
 }
 
@@ -49,36 +49,36 @@ func TestEventsPayloadSplit(t *testing.T) {
 
 	// test len 0
 	ev := NewEventGroup([]Event{})
-	split := ev.(splittablePayloadEntry).split()
+	split := ev.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if split != nil {
 		t.Error(split)
 	}
 
 	// test len 1
 	ev = NewEventGroup([]Event{{EventType: "a"}})
-	split = ev.(splittablePayloadEntry).split()
+	split = ev.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if split != nil {
 		t.Error(split)
 	}
 
 	// test len 2
 	ev = NewEventGroup([]Event{{EventType: "a"}, {EventType: "b"}})
-	split = ev.(splittablePayloadEntry).split()
+	split = ev.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if len(split) != 2 {
 		t.Error("split into incorrect number of slices", len(split))
 	}
 
-	testEventGroupJSON(t, []Batch{{split[0]}}, `[{"eventType":"a","timestamp":-6795364578871}]`)
-	testEventGroupJSON(t, []Batch{{split[1]}}, `[{"eventType":"b","timestamp":-6795364578871}]`)
+	testEventGroupJSON(t, []Batch{{split[0]}}, `[{"eventType":"a"}]`)
+	testEventGroupJSON(t, []Batch{{split[1]}}, `[{"eventType":"b"}]`)
 
 	// test len 3
 	ev = NewEventGroup([]Event{{EventType: "a"}, {EventType: "b"}, {EventType: "c"}})
-	split = ev.(splittablePayloadEntry).split()
+	split = ev.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if len(split) != 2 {
 		t.Error("split into incorrect number of slices", len(split))
 	}
-	testEventGroupJSON(t, []Batch{{split[0]}}, `[{"eventType":"a","timestamp":-6795364578871}]`)
-	testEventGroupJSON(t, []Batch{{split[1]}}, `[{"eventType":"b","timestamp":-6795364578871},{"eventType":"c","timestamp":-6795364578871}]`)
+	testEventGroupJSON(t, []Batch{{split[0]}}, `[{"eventType":"a"}]`)
+	testEventGroupJSON(t, []Batch{{split[1]}}, `[{"eventType":"b"},{"eventType":"c"}]`)
 }
 
 func TestEventsJSON(t *testing.T) {
@@ -97,8 +97,7 @@ func TestEventsJSON(t *testing.T) {
 
 	testEventGroupJSON(t, []Batch{{group1, group2}, {group3}}, `[
 		{
-		  "eventType":"",
-		  "timestamp":-6795364578871
+		  "eventType":""
 		},
 		{
 			"eventType":"testEvent",
@@ -106,12 +105,10 @@ func TestEventsJSON(t *testing.T) {
 			"zip":"zap"
 		},
 		{
-		  "eventType":"a",
-		  "timestamp":-6795364578871
+		  "eventType":"a"
 		},
 		{
-		  "eventType":"b",
-		  "timestamp":-6795364578871
+		  "eventType":"b"
 		}
 	]`)
 }
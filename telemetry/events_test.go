@@ -4,7 +4,11 @@
 package telemetry
 
 import (
+	"context"
+	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"reflect"
 	"testing"
 	"time"
 
@@ -159,3 +163,59 @@ func TestRecordEventNilHarvester(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestEventIdempotencyKeys(t *testing.T) {
+	var bodies [][]byte
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.EventIdempotencyKeys = true
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			compressed, _ := ioutil.ReadAll(req.Body)
+			body, _ := internal.Uncompress(compressed)
+			bodies = append(bodies, body)
+			// Fail the first attempt so that the request is retried.
+			if len(bodies) == 1 {
+				return emptyResponse(503), nil
+			}
+			return emptyResponse(202), nil
+		})
+	})
+	attributes := map[string]interface{}{"zip": "zap"}
+	h.RecordEvent(Event{EventType: "a", Attributes: attributes})
+	h.RecordEvent(Event{EventType: "b"})
+	h.RecordEvent(Event{EventType: "c", Attributes: map[string]interface{}{"idempotency.key": "mine"}})
+	h.HarvestNow(context.Background())
+
+	if len(attributes) != 1 {
+		t.Error("recorded attributes should not be modified", attributes)
+	}
+	if len(bodies) != 2 {
+		t.Fatal("request should be retried once", len(bodies))
+	}
+	var keys [2][]interface{}
+	for i, body := range bodies {
+		var events []map[string]interface{}
+		if err := json.Unmarshal(body, &events); nil != err {
+			t.Fatal(err, string(body))
+		}
+		for _, e := range events {
+			keys[i] = append(keys[i], e["idempotency.key"])
+		}
+	}
+	if len(keys[0]) != 3 || keys[0][0] == keys[0][1] || keys[0][0] == "" || keys[0][1] == "" {
+		t.Error("each event should have a unique key", keys[0])
+	}
+	if keys[0][2] != "mine" {
+		t.Error("existing key should be kept", keys[0][2])
+	}
+	if !reflect.DeepEqual(keys[0], keys[1]) {
+		t.Error("keys should be unchanged on retry", keys)
+	}
+}
+
+func TestEventIdempotencyKeysDisabled(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	h.RecordEvent(Event{EventType: "a"})
+	if _, ok := h.events[0].Attributes["idempotency.key"]; ok {
+		t.Error("idempotency key should not be added by default")
+	}
+}
@@ -81,13 +81,16 @@ func ExampleHarvester_RecordMetric() {
 		ConfigAPIKey(os.Getenv("NEW_RELIC_INSERT_API_KEY")),
 	)
 	start := time.Now()
-	h.RecordMetric(Count{
+	err := h.RecordMetric(Count{
 		Name:           "myCount",
 		AttributesJSON: json.RawMessage(`{"zip":"zap"}`),
 		Value:          123,
 		Timestamp:      start,
 		Interval:       5 * time.Second,
 	})
+	if err != nil {
+		fmt.Println(err)
+	}
 }
 
 func ExampleConfigSpansURLOverride() {
@@ -0,0 +1,62 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// fallbackLine is a line written to Config.FallbackWriter.
+type fallbackLine struct {
+	DataType string          `json:"data-type"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// writeFallback writes the uncompressed body of a request which could not be
+// delivered to Config.FallbackWriter as a single line of JSON.  It returns
+// true if the body was written.
+func (h *Harvester) writeFallback(req *http.Request, signal Signal) bool {
+	if nil == h.config.FallbackWriter || nil == req.GetBody {
+		return false
+	}
+	line, err := fallbackJSON(req, signal, h.config.CompressionDictionary)
+	if nil != err {
+		h.config.logError(map[string]interface{}{
+			"err":     err.Error(),
+			"message": "unable to read request body for fallback writer",
+		})
+		return false
+	}
+
+	h.fallbackLock.Lock()
+	defer h.fallbackLock.Unlock()
+
+	if _, err := h.config.FallbackWriter.Write(line); nil != err {
+		h.config.logError(map[string]interface{}{
+			"err":     err.Error(),
+			"message": "unable to write to fallback writer",
+		})
+		return false
+	}
+	return true
+}
+
+// fallbackJSON returns the newline terminated JSON line for a request.  dict
+// is the dictionary of deflate encoded bodies.
+func fallbackJSON(req *http.Request, signal Signal, dict []byte) ([]byte, error) {
+	payload, err := readRequestBody(req, dict)
+	if nil != err {
+		return nil, err
+	}
+	// Marshal compacts the payload so that it cannot contain newlines.
+	line, err := json.Marshal(fallbackLine{
+		DataType: signal.String(),
+		Payload:  payload,
+	})
+	if nil != err {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseFallbackLines returns the lines written to a FallbackWriter sorted
+// by data type.
+func parseFallbackLines(t *testing.T, buf *bytes.Buffer) []fallbackLine {
+	var lines []fallbackLine
+	for _, l := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if l == "" {
+			continue
+		}
+		var line fallbackLine
+		if err := json.Unmarshal([]byte(l), &line); nil != err {
+			t.Fatal(err, l)
+		}
+		lines = append(lines, line)
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].DataType < lines[j].DataType })
+	return lines
+}
+
+func TestFallbackWriterNonRetryableFailure(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h, _ := NewHarvester(configTesting, ConfigFallbackWriter(buf), func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(403), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "1", TraceID: "id", Name: "span"})
+	h.RecordEvent(Event{EventType: "MyEvent", Attributes: map[string]interface{}{"zip": "zap"}})
+	h.HarvestNow(context.Background())
+
+	lines := parseFallbackLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatal(buf.String())
+	}
+	if lines[0].DataType != "events" || !strings.Contains(string(lines[0].Payload), `"eventType":"MyEvent"`) {
+		t.Error(lines[0].DataType, string(lines[0].Payload))
+	}
+	if lines[1].DataType != "spans" || !strings.Contains(string(lines[1].Payload), `"id":"1"`) {
+		t.Error(lines[1].DataType, string(lines[1].Payload))
+	}
+}
+
+func TestFallbackWriterRetryBudgetExhausted(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var dropped int
+	h, _ := NewHarvester(configTesting, ConfigFallbackWriter(buf), func(cfg *Config) {
+		cfg.RetryBudgetRatio = 0.1
+		cfg.OnDrop = func(signal string, count int, reason string) {
+			dropped += count
+		}
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(503), nil
+		})
+	})
+	for h.retryBudget.allowRetry() {
+	}
+	h.RecordLog(Log{Message: "hello"})
+	h.HarvestNow(context.Background())
+
+	lines := parseFallbackLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatal(buf.String())
+	}
+	if lines[0].DataType != "logs" || !strings.Contains(string(lines[0].Payload), `"message":"hello"`) {
+		t.Error(lines[0].DataType, string(lines[0].Payload))
+	}
+	// Data written to the fallback is not dropped.
+	if dropped != 0 {
+		t.Error(dropped)
+	}
+}
+
+// channelWriter sends each write to a channel.
+type channelWriter chan []byte
+
+func (w channelWriter) Write(b []byte) (int, error) {
+	w <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func TestFallbackWriterContextCancelled(t *testing.T) {
+	writes := make(channelWriter, 1)
+	h, _ := NewHarvester(configTesting, ConfigFallbackWriter(writes), func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// Set a Retry-After so that the harvest is cancelled
+			// while waiting to retry.
+			return &http.Response{
+				Header:     http.Header{"Retry-After": []string{"10"}},
+				StatusCode: 429,
+				Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+			}, nil
+		})
+	})
+	h.RecordSpan(Span{ID: "1", TraceID: "id"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	h.HarvestNow(ctx)
+
+	select {
+	case b := <-writes:
+		if !strings.HasPrefix(string(b), `{"data-type":"spans","payload":[`) || !strings.HasSuffix(string(b), "\n") {
+			t.Error(string(b))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no data written to the fallback writer")
+	}
+}
+
+func TestFallbackWriterSuccess(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h, _ := NewHarvester(configTesting, ConfigFallbackWriter(buf), func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "1", TraceID: "id"})
+	h.HarvestNow(context.Background())
+	if buf.Len() != 0 {
+		t.Error(buf.String())
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	// approximateItemOverhead is the approximate size in bytes of the
+	// JSON fields of an item other than its strings and attributes.
+	approximateItemOverhead = 64
+	// approximateValueSize is the approximate serialized size in bytes of
+	// an attribute value which is not a string.
+	approximateValueSize = 8
+)
+
+// approximateAttributesSize returns a cheap estimate of the serialized size
+// in bytes of the attributes.
+func approximateAttributesSize(attributes map[string]interface{}, attributesJSON json.RawMessage) int64 {
+	if nil == attributes {
+		return int64(len(attributesJSON))
+	}
+	var n int64
+	for k, v := range attributes {
+		// Quotes, colon, and comma.
+		n += int64(len(k)) + 4
+		if s, ok := v.(string); ok {
+			n += int64(len(s)) + 2
+		} else {
+			n += approximateValueSize
+		}
+	}
+	return n
+}
+
+func (s *Span) approximateSize() int64 {
+	n := int64(approximateItemOverhead + len(s.ID) + len(s.TraceID) + len(s.Name) +
+		len(s.ParentID) + len(s.ServiceName))
+	n += approximateAttributesSize(s.Attributes, nil)
+	for _, e := range s.Events {
+		n += e.approximateSize()
+	}
+	for _, l := range s.Links {
+		n += approximateItemOverhead + approximateAttributesSize(l.Attributes, nil)
+	}
+	return n
+}
+
+func (e *Event) approximateSize() int64 {
+	return int64(approximateItemOverhead+len(e.EventType)) +
+		approximateAttributesSize(e.Attributes, e.AttributesJSON)
+}
+
+func (l *Log) approximateSize() int64 {
+	return int64(approximateItemOverhead+len(l.Message)) +
+		approximateAttributesSize(l.Attributes, nil)
+}
+
+func approximateMetricSize(m Metric) int64 {
+	switch v := m.(type) {
+	case Count:
+		return int64(approximateItemOverhead+len(v.Name)) + approximateAttributesSize(v.Attributes, v.AttributesJSON)
+	case Summary:
+		return int64(approximateItemOverhead+len(v.Name)) + approximateAttributesSize(v.Attributes, v.AttributesJSON)
+	case Gauge:
+		return int64(approximateItemOverhead+len(v.Name)) + approximateAttributesSize(v.Attributes, v.AttributesJSON)
+	case Histogram:
+		return int64(approximateItemOverhead+len(v.Name)) + approximateAttributesSize(v.Attributes, v.AttributesJSON)
+	case requeuedMetric:
+		return int64(len(v.js))
+	}
+	return approximateItemOverhead
+}
+
+func approximateMetricsSize(ms []Metric) int64 {
+	var n int64
+	for _, m := range ms {
+		n += approximateMetricSize(m)
+	}
+	return n
+}
+
+// addBufferedBytes adds the approximate size of a recorded item to the
+// buffered bytes of the signal, and requests an early harvest if the total
+// exceeds Config.FlushBytesThreshold.  h.lock must be held.
+func (h *Harvester) addBufferedBytes(signal Signal, n int64) {
+	if h.config.FlushBytesThreshold <= 0 {
+		return
+	}
+	h.bufferedBytes[signal] += n
+	var total int64
+	for _, b := range h.bufferedBytes {
+		total += b
+	}
+	if total > h.config.FlushBytesThreshold {
+		select {
+		case h.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// earlyHarvestRoutine starts the harvests requested outside of the regular
+// ticks until Shutdown: harvests of the signals harvested by the schedules
+// when Config.FlushBytesThreshold is exceeded, and harvests of events when an
+// urgent event is recorded.
+func (h *Harvester) earlyHarvestRoutine(schedules []harvestSchedule) {
+	var signals []Signal
+	for _, schedule := range schedules {
+		signals = append(signals, schedule.signals...)
+	}
+	for {
+		select {
+		case <-h.flush:
+			h.config.logDebug(map[string]interface{}{
+				"event":     "early harvest for buffered bytes",
+				"threshold": h.config.FlushBytesThreshold,
+			})
+			h.harvests.Add(1)
+			go h.earlyHarvest(signals)
+		case <-h.urgent:
+			h.harvests.Add(1)
+			go h.earlyHarvest([]Signal{SignalEvents})
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// earlyHarvest harvests the signals and then marks the harvest as done.
+func (h *Harvester) earlyHarvest(signals []Signal) {
+	defer h.harvests.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.HarvestTimeout)
+	defer cancel()
+	h.harvestType(ctx, signals...)
+}
+
+// RecordUrgentEvent records the given event as RecordEvent does, and then
+// starts a harvest of the buffered events rather than waiting for the next
+// harvest.  Use it for the few events, such as alerts, which must be
+// delivered with low latency.  It does not block while the events are sent.
+// An error is returned, and no harvest is started, if the event is not
+// recorded.
+func (h *Harvester) RecordUrgentEvent(e Event) error {
+	if err := h.RecordEvent(e); nil != err || nil == h {
+		return err
+	}
+	if nil == h.routineDone {
+		// Without the harvest goroutine there is no harvest to wait
+		// for in Shutdown.
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), h.config.HarvestTimeout)
+			defer cancel()
+			h.harvestType(ctx, SignalEvents)
+		}()
+		return nil
+	}
+	select {
+	case h.urgent <- struct{}{}:
+	default:
+	}
+	return nil
+}
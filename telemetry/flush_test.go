@@ -0,0 +1,206 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlushBytesThreshold(t *testing.T) {
+	sent := make(chan string, 10)
+	h, _ := NewHarvester(func(cfg *Config) {
+		cfg.APIKey = "APIKey"
+		cfg.HarvestPeriod = time.Hour
+		cfg.FlushBytesThreshold = 10 * 1024
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent <- req.URL.String()
+			return emptyResponse(202), nil
+		})
+	})
+	defer h.Shutdown(context.Background())
+
+	message := strings.Repeat("a", 4*1024)
+	h.RecordLog(Log{Message: message})
+	h.RecordLog(Log{Message: message})
+	select {
+	case u := <-sent:
+		t.Fatal("harvest before threshold exceeded", u)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	h.RecordLog(Log{Message: message})
+	select {
+	case u := <-sent:
+		if u != defaultLogURL {
+			t.Error(u)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no early harvest")
+	}
+}
+
+func TestFlushBytesThresholdGaugeSeries(t *testing.T) {
+	sent := make(chan string, 10)
+	h, _ := NewHarvester(func(cfg *Config) {
+		cfg.APIKey = "APIKey"
+		cfg.HarvestPeriod = time.Hour
+		cfg.FlushBytesThreshold = 1024
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent <- req.URL.String()
+			return emptyResponse(202), nil
+		})
+	})
+	defer h.Shutdown(context.Background())
+
+	points := make([]GaugePoint, 100)
+	for i := range points {
+		points[i] = GaugePoint{Value: float64(i), Timestamp: time.Now()}
+	}
+	h.RecordGaugeSeries("gauge", map[string]interface{}{"zip": "zap"}, points)
+	select {
+	case u := <-sent:
+		if u != defaultMetricURL {
+			t.Error(u)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no early harvest")
+	}
+}
+
+func TestFlushBytesThresholdMetricSnapshots(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.FlushBytesThreshold = 1024 * 1024
+	})
+	h.RecordMetricSnapshot([]Metric{Gauge{Name: "gauge", Value: 1}}, time.Time{}, 0)
+	n := h.bufferedBytes[SignalMetrics]
+	if n <= 0 {
+		t.Fatal(n)
+	}
+
+	h.RecordLog(Log{Message: "message"})
+	snap, err := h.Snapshot()
+	if nil != err {
+		t.Fatal(err)
+	}
+	h.takeMetrics(time.Now())
+	h.takeLogs()
+	if err := h.LoadSnapshot(snap); nil != err {
+		t.Fatal(err)
+	}
+	if h.bufferedBytes[SignalMetrics] <= 0 || h.bufferedBytes[SignalLogs] <= 0 {
+		t.Error(h.bufferedBytes)
+	}
+}
+
+func TestFlushBytesThresholdReset(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.FlushBytesThreshold = 100
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "tid", Attributes: map[string]interface{}{"zip": "zap"}})
+	h.RecordMetric(Gauge{Name: "gauge", Value: 1})
+	h.RecordEvent(Event{EventType: "MyEvent"})
+	h.RecordLog(Log{Message: "message"})
+	for signal, n := range h.bufferedBytes {
+		if n <= 0 {
+			t.Error(Signal(signal), n)
+		}
+	}
+	// Without a harvest goroutine nothing consumes the flush request.
+	if len(h.flush) != 1 {
+		t.Error(len(h.flush))
+	}
+
+	h.takeSpans()
+	h.takeMetrics(time.Now())
+	h.takeEvents()
+	h.takeLogs()
+	for signal, n := range h.bufferedBytes {
+		if n != 0 {
+			t.Error(Signal(signal), n)
+		}
+	}
+}
+
+func TestFlushBytesThresholdUnset(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	h.RecordLog(Log{Message: strings.Repeat("a", 4*1024)})
+	if n := h.bufferedBytes[SignalLogs]; n != 0 {
+		t.Error(n)
+	}
+}
+
+func TestApproximateAttributesSize(t *testing.T) {
+	if n := approximateAttributesSize(map[string]interface{}{"zip": "zap", "a": 1}, nil); n != 3+4+3+2+1+4+approximateValueSize {
+		t.Error(n)
+	}
+	if n := approximateAttributesSize(nil, []byte(`{"a":1}`)); n != 7 {
+		t.Error(n)
+	}
+}
+
+func TestRecordUrgentEvent(t *testing.T) {
+	sent := make(chan string, 10)
+	h, _ := NewHarvester(func(cfg *Config) {
+		cfg.APIKey = "APIKey"
+		cfg.HarvestPeriod = time.Hour
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent <- req.URL.String()
+			return emptyResponse(202), nil
+		})
+	})
+	defer h.Shutdown(context.Background())
+
+	h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+	if err := h.RecordUrgentEvent(Event{EventType: "Alert"}); nil != err {
+		t.Fatal(err)
+	}
+	select {
+	case u := <-sent:
+		if u != defaultEventURL {
+			t.Error(u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("urgent event not sent")
+	}
+	// Only the events are harvested.
+	if n := len(h.takeSpans()); n != 1 {
+		t.Error(n)
+	}
+}
+
+func TestRecordUrgentEventWithoutHarvestGoroutine(t *testing.T) {
+	sent := make(chan string, 10)
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent <- req.URL.String()
+			return emptyResponse(202), nil
+		})
+	})
+	if err := h.RecordUrgentEvent(Event{EventType: "Alert"}); nil != err {
+		t.Fatal(err)
+	}
+	select {
+	case u := <-sent:
+		if u != defaultEventURL {
+			t.Error(u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("urgent event not sent")
+	}
+}
+
+func TestRecordUrgentEventInvalid(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	if err := h.RecordUrgentEvent(Event{}); err != errEventTypeUnset {
+		t.Error(err)
+	}
+	var nilHarvester *Harvester
+	if err := nilHarvester.RecordUrgentEvent(Event{EventType: "Alert"}); nil != err {
+		t.Error(err)
+	}
+}
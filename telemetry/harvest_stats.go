@@ -0,0 +1,92 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// harvestLatencySamples is the number of most recent harvest durations used
+// to calculate the latency percentiles of HarvestStats.
+const harvestLatencySamples = 100
+
+// HarvestStats contains statistics about the harvests of a Harvester.
+type HarvestStats struct {
+	// Harvests is the number of harvests completed.
+	Harvests int64
+	// LatencyP50 and LatencyP99 are the 50th and 99th percentiles of the
+	// durations of the 100 most recent harvests.  A harvest's duration is
+	// the time from when it starts until all of its requests have
+	// completed, or its context is done.
+	LatencyP50 time.Duration
+	LatencyP99 time.Duration
+}
+
+// latencyTracker records the durations of the most recent harvests.
+type latencyTracker struct {
+	lock     sync.Mutex
+	samples  [harvestLatencySamples]time.Duration
+	harvests int64
+}
+
+// record adds the duration of a harvest.
+func (t *latencyTracker) record(d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.samples[t.harvests%harvestLatencySamples] = d
+	t.harvests++
+}
+
+// stats returns the number of harvests and the latency percentiles.
+func (t *latencyTracker) stats() HarvestStats {
+	t.lock.Lock()
+	n := t.harvests
+	if n > harvestLatencySamples {
+		n = harvestLatencySamples
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, t.samples[:n])
+	stats := HarvestStats{Harvests: t.harvests}
+	t.lock.Unlock()
+
+	if n == 0 {
+		return stats
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	stats.LatencyP50 = percentile(sorted, 50)
+	stats.LatencyP99 = percentile(sorted, 99)
+	return stats
+}
+
+// percentile returns the nearest-rank percentile p of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Stats returns statistics about the Harvester's harvests, including the
+// latency percentiles of recent harvests.
+func (h *Harvester) Stats() HarvestStats {
+	if nil == h {
+		return HarvestStats{}
+	}
+	return h.latency.stats()
+}
+
+// recordHarvestLatency records the duration of a harvest which started at
+// start, and records it to Config.HarvestLatencyMetric if set.
+func (h *Harvester) recordHarvestLatency(start time.Time) {
+	d := time.Since(start)
+	h.latency.record(d)
+	h.recordInternalHarvest(d)
+	if h.config.HarvestLatencyMetric != "" {
+		h.MetricAggregator().Summary(h.config.HarvestLatencyMetric, nil).RecordDuration(d)
+	}
+}
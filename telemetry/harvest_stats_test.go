@@ -0,0 +1,101 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLatencyTrackerPercentiles(t *testing.T) {
+	var tracker latencyTracker
+	if stats := tracker.stats(); stats != (HarvestStats{}) {
+		t.Error(stats)
+	}
+
+	for i := 100; i >= 1; i-- {
+		tracker.record(time.Duration(i) * time.Millisecond)
+	}
+	expect := HarvestStats{
+		Harvests:   100,
+		LatencyP50: 50 * time.Millisecond,
+		LatencyP99: 99 * time.Millisecond,
+	}
+	if stats := tracker.stats(); stats != expect {
+		t.Error(stats)
+	}
+
+	// Only the most recent harvests are used for the percentiles.
+	for i := 0; i < 100; i++ {
+		tracker.record(time.Second)
+	}
+	expect = HarvestStats{
+		Harvests:   200,
+		LatencyP50: time.Second,
+		LatencyP99: time.Second,
+	}
+	if stats := tracker.stats(); stats != expect {
+		t.Error(stats)
+	}
+}
+
+func TestLatencyTrackerSingleHarvest(t *testing.T) {
+	var tracker latencyTracker
+	tracker.record(time.Second)
+	expect := HarvestStats{
+		Harvests:   1,
+		LatencyP50: time.Second,
+		LatencyP99: time.Second,
+	}
+	if stats := tracker.stats(); stats != expect {
+		t.Error(stats)
+	}
+}
+
+func TestHarvesterStats(t *testing.T) {
+	delays := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond}
+	var harvest int
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.HarvestLatencyMetric = "harvest.duration"
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			time.Sleep(delays[harvest])
+			return emptyResponse(202), nil
+		})
+	})
+	for harvest = range delays {
+		h.RecordEvent(Event{EventType: "event"})
+		h.HarvestNow(context.Background())
+	}
+
+	stats := h.Stats()
+	if stats.Harvests != 3 {
+		t.Error(stats.Harvests)
+	}
+	if stats.LatencyP50 < 20*time.Millisecond || stats.LatencyP50 >= 40*time.Millisecond {
+		t.Error(stats.LatencyP50)
+	}
+	if stats.LatencyP99 < 40*time.Millisecond {
+		t.Error(stats.LatencyP99)
+	}
+
+	// The latency of the last harvest is recorded as a summary to be sent
+	// with the next harvest.
+	metrics, _ := h.takeMetrics(time.Now())
+	if len(metrics) != 1 {
+		t.Fatal(metrics)
+	}
+	s, ok := metrics[0].(*Summary)
+	if !ok || s.Name != "harvest.duration" || s.Count != 1 || s.Sum < 40 {
+		t.Error(metrics[0])
+	}
+}
+
+func TestHarvesterStatsNilHarvester(t *testing.T) {
+	var h *Harvester
+	if stats := h.Stats(); stats != (HarvestStats{}) {
+		t.Error(stats)
+	}
+}
@@ -5,61 +5,147 @@ package telemetry
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"math/rand"
+	"math"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal/uuid"
 )
 
 // Harvester aggregates and reports metrics and spans.
 type Harvester struct {
+	// bufferDrops is the total number of items dropped because their
+	// buffer was full.  It is accessed atomically and is first so that it
+	// is 64-bit aligned.
+	bufferDrops int64
+	// truncatedValues is the number of attribute values truncated since
+	// the previous harvest.  It is accessed atomically.
+	truncatedValues int64
+
 	// These fields are not modified after Harvester creation.  They may be
 	// safely accessed without locking.
 	config           Config
 	commonAttributes *cachedMapEntry
+	// spanCommonAttributes and logCommonAttributes are the common
+	// attributes combined with the SpanCommonAttributes and
+	// LogCommonAttributes.
+	spanCommonAttributes  *cachedMapEntry
+	logCommonAttributes   *cachedMapEntry
+	eventCommonAttributes map[string]interface{}
+	// commonAttributeValues is a copy of the valid common attributes
+	// used by Clone.
+	commonAttributeValues map[string]interface{}
+	start                 time.Time
+	interner              *stringInterner
+	retryBudget           *retryBudget
+	latency               *latencyTracker
+	// unknownLogTypes contains the unknown Log.LogType values which have
+	// been logged.
+	unknownLogTypes sync.Map
+	// unknownSpanValues contains the unknown Span.Kind and Span.StatusCode
+	// values which have been logged.
+	unknownSpanValues sync.Map
+	// levelConflictOnce logs the first log recorded with both a Severity
+	// and a "level" attribute.
+	levelConflictOnce sync.Once
+	// fallbackLock serializes writes to Config.FallbackWriter.
+	fallbackLock sync.Mutex
+	// flush requests an early harvest when Config.FlushBytesThreshold is
+	// exceeded.
+	flush chan struct{}
+	// urgent requests an early harvest of events when an urgent event is
+	// recorded.
+	urgent chan struct{}
+	// done is closed by Shutdown to stop the harvest goroutine, which
+	// closes routineDone when it exits.  routineDone is nil if there is no
+	// harvest goroutine.
+	done         chan struct{}
+	routineDone  chan struct{}
+	shutdownOnce sync.Once
+	// harvests tracks the harvests started by the harvest goroutine, of
+	// which pendingHarvests are in progress.
+	harvests        sync.WaitGroup
+	pendingHarvests int32
+	// coalescedTicks is the number of consecutive harvest goroutine ticks
+	// skipped due to Config.CoalesceRequests.  It is only used by the
+	// harvest goroutine.
+	coalescedTicks int
 
 	// lock protects the mutable fields below.
-	lock                 sync.Mutex
-	lastHarvest          time.Time
-	rawMetrics           []Metric
-	aggregatedMetrics    map[metricIdentity]*metric
-	spans                []Span
-	events               []Event
-	logs                 []Log
-	spanRequestFactory   RequestFactory
-	metricRequestFactory RequestFactory
-	eventRequestFactory  RequestFactory
-	logRequestFactory    RequestFactory
+	lock              sync.Mutex
+	lastHarvest       time.Time
+	rawMetrics        []Metric
+	metricSnapshots   []metricSnapshot
+	aggregatedMetrics map[metricIdentity]*metric
+	spans             []Span
+	events            []Event
+	logs              []Log
+	// deferredRequests are the requests deferred to a later harvest due
+	// to Config.MaxRequestsPerHarvest.
+	deferredRequests []deferredRequest
+	// metricCollectors are called before each harvest of metrics to
+	// record state held outside of the Harvester, see MetricRegistry.
+	metricCollectors   []func()
+	spanRequestFactory RequestFactory
+	// spanMirrorRequestFactory is nil unless Config.SpansMirrorURL is set.
+	spanMirrorRequestFactory RequestFactory
+	metricRequestFactory     RequestFactory
+	eventRequestFactory      RequestFactory
+	logRequestFactory        RequestFactory
+	// bufferDropped is the number of items of each signal dropped because
+	// the buffer was full since the signal was last harvested.
+	bufferDropped [SignalLogs + 1]int
+	// bufferedBytes is the approximate size in bytes of the data of each
+	// signal buffered since the signal was last harvested.  It is only
+	// tracked if Config.FlushBytesThreshold is set.
+	bufferedBytes [SignalLogs + 1]int64
 }
 
 const (
 	// NOTE:  These constant values are used in Config field doc comments.
-	defaultHarvestPeriod  = 5 * time.Second
-	defaultHarvestTimeout = 15 * time.Second
+	defaultHarvestPeriod    = 5 * time.Second
+	defaultHarvestTimeout   = 15 * time.Second
+	defaultMaxResponseBytes = 1 << 20
+
+	// maxCoalescedTicks and maxCoalescedItems limit how long and how much
+	// data is held when Config.CoalesceRequests is set.
+	maxCoalescedTicks = 4
+	maxCoalescedItems = 1000
 
 	// euKeyPrefix is used to sanitize the api-key for logging.
 	euKeyPrefix = "eu01xx"
 )
 
 var (
-	errAPIKeyUnset = errors.New("APIKey is required")
+	errAPIKeyUnset            = errors.New("APIKey is required")
+	errNilHarvester           = errors.New("cannot clone a nil Harvester")
+	errTLSServerNameTransport = errors.New("TLSServerName requires a Client with a nil Transport")
+	errProxyTransport         = errors.New("ProxyURL requires a Transport that is nil or an *http.Transport")
+	errBufferFull             = errors.New("buffer full, data dropped")
+	errGzipCompressionLevel   = errors.New("GzipCompressionLevel must be a valid compress/gzip level")
+	errRegion                 = errors.New("Region must be \"US\" or \"EU\"")
 )
 
 // NewHarvester creates a new harvester.
 func NewHarvester(options ...func(*Config)) (*Harvester, error) {
 	cfg := Config{
-		Client:         &http.Client{},
-		HarvestPeriod:  defaultHarvestPeriod,
-		HarvestTimeout: defaultHarvestTimeout,
+		Client:                  &http.Client{},
+		HarvestPeriod:           defaultHarvestPeriod,
+		HarvestTimeout:          defaultHarvestTimeout,
+		MaxAttributeValueLength: defaultMaxAttributeValueLength,
 	}
 	for _, opt := range options {
 		opt(&cfg)
@@ -68,11 +154,39 @@ func NewHarvester(options ...func(*Config)) (*Harvester, error) {
 	if cfg.APIKey == "" {
 		return nil, errAPIKeyUnset
 	}
+	if _, err := gzip.NewWriterLevel(nil, cfg.GzipCompressionLevel); nil != err {
+		return nil, errGzipCompressionLevel
+	}
+	if !cfg.validRegion() {
+		return nil, errRegion
+	}
+	client, err := cfg.httpClient()
+	if nil != err {
+		return nil, err
+	}
+	cfg.Client = client
+	// A Config copied by Clone has the hook of the original Harvester.
+	cfg.dropHook = nil
 
+	now := time.Now()
 	h := &Harvester{
 		config:            cfg,
-		lastHarvest:       time.Now(),
+		start:             now,
+		lastHarvest:       now,
 		aggregatedMetrics: make(map[metricIdentity]*metric),
+		done:              make(chan struct{}),
+		flush:             make(chan struct{}, 1),
+		urgent:            make(chan struct{}, 1),
+		latency:           &latencyTracker{},
+	}
+	if h.config.InternAttributes {
+		h.interner = newStringInterner()
+	}
+	if h.config.ReportInternalMetrics {
+		h.config.dropHook = h.recordInternalDrop
+	}
+	if h.config.RetryBudgetRatio > 0 {
+		h.retryBudget = newRetryBudget(h.config.RetryBudgetRatio)
 	}
 
 	// Marshal the common attributes to JSON here to avoid doing it on every
@@ -80,14 +194,31 @@ func NewHarvester(options ...func(*Config)) (*Harvester, error) {
 	// the consumer modifies the CommonAttributes map after calling
 	// NewHarvester.
 	if len(h.config.CommonAttributes) > 0 {
-		commonAttributes, err := newCommonAttributes(h.config.CommonAttributes)
+		// Invalid attributes are dropped and the valid ones are kept.
+		commonAttributes, err := newCommonAttributes(h.truncateAttributes(h.config.CommonAttributes))
 		if err != nil {
-			h.config.logError(map[string]interface{}{"err": err.Error()})
+			fields := map[string]interface{}{
+				"err":     err.Error(),
+				"message": "dropping invalid common attributes",
+			}
+			if e, ok := err.(errInvalidAttributes); ok {
+				fields["dropped-keys"] = e.keys
+			}
+			h.config.logError(fields)
 		}
 
-		h.commonAttributes = newCachedMapEntry(commonAttributes)
+		if nil != commonAttributes && len(commonAttributes.Attributes) > 0 {
+			h.commonAttributes = newCachedMapEntry(commonAttributes)
+			h.commonAttributeValues = make(map[string]interface{}, len(commonAttributes.Attributes))
+			for k, v := range commonAttributes.Attributes {
+				h.commonAttributeValues[k] = v
+			}
+		}
 		h.config.CommonAttributes = nil
 	}
+	h.spanCommonAttributes = cachedCommonAttributes(h.typeCommonAttributes(SignalSpans, &h.config.SpanCommonAttributes, h.commonAttributeValues))
+	h.logCommonAttributes = cachedCommonAttributes(h.typeCommonAttributes(SignalLogs, &h.config.LogCommonAttributes, h.commonAttributeValues))
+	h.eventCommonAttributes = h.typeCommonAttributes(SignalEvents, &h.config.EventCommonAttributes, nil)
 
 	spanURL, err := url.Parse(h.config.spanURL())
 	if nil != err {
@@ -97,25 +228,47 @@ func NewHarvester(options ...func(*Config)) (*Harvester, error) {
 	userAgent := "harvester " + h.config.userAgent()
 
 	h.spanRequestFactory, err = NewSpanRequestFactory(
-		WithInsertKey(h.config.APIKey),
+		h.config.apiKeyOption(SignalSpans),
 		withScheme(spanURL.Scheme),
 		WithEndpoint(spanURL.Host),
 		WithUserAgent(userAgent),
+		h.config.compressionOption(),
+		WithRequestIDFunc(h.config.RequestIDFunc),
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	if h.config.SpansMirrorURL != "" {
+		mirrorURL, err := url.Parse(h.config.SpansMirrorURL)
+		if nil != err {
+			return nil, err
+		}
+		h.spanMirrorRequestFactory, err = NewSpanRequestFactory(
+			h.config.apiKeyOption(SignalSpans),
+			withScheme(mirrorURL.Scheme),
+			WithEndpoint(mirrorURL.Host),
+			WithUserAgent(userAgent),
+			h.config.compressionOption(),
+			WithRequestIDFunc(h.config.RequestIDFunc),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	metricURL, err := url.Parse(h.config.metricURL())
 	if nil != err {
 		return nil, err
 	}
 
 	h.metricRequestFactory, err = NewMetricRequestFactory(
-		WithInsertKey(h.config.APIKey),
+		h.config.apiKeyOption(SignalMetrics),
 		withScheme(metricURL.Scheme),
 		WithEndpoint(metricURL.Host),
 		WithUserAgent(userAgent),
+		h.config.compressionOption(),
+		WithRequestIDFunc(h.config.RequestIDFunc),
 	)
 	if err != nil {
 		return nil, err
@@ -127,10 +280,12 @@ func NewHarvester(options ...func(*Config)) (*Harvester, error) {
 	}
 
 	h.eventRequestFactory, err = NewEventRequestFactory(
-		WithInsertKey(h.config.APIKey),
+		h.config.apiKeyOption(SignalEvents),
 		withScheme(eventURL.Scheme),
 		WithEndpoint(eventURL.Host),
 		WithUserAgent(userAgent),
+		h.config.compressionOption(),
+		WithRequestIDFunc(h.config.RequestIDFunc),
 	)
 	if err != nil {
 		return nil, err
@@ -142,10 +297,12 @@ func NewHarvester(options ...func(*Config)) (*Harvester, error) {
 	}
 
 	h.logRequestFactory, err = NewLogRequestFactory(
-		WithInsertKey(h.config.APIKey),
+		h.config.apiKeyOption(SignalLogs),
 		withScheme(logURL.Scheme),
 		WithEndpoint(logURL.Host),
 		WithUserAgent(userAgent),
+		h.config.compressionOption(),
+		WithRequestIDFunc(h.config.RequestIDFunc),
 	)
 	if err != nil {
 		return nil, err
@@ -155,20 +312,99 @@ func NewHarvester(options ...func(*Config)) (*Harvester, error) {
 		"event":                  "harvester created",
 		"api-key":                sanitizeAPIKeyForLogging(h.config.APIKey),
 		"harvest-period-seconds": h.config.HarvestPeriod.Seconds(),
+		"region":                 h.config.Region,
 		"metrics-url-override":   h.config.MetricsURLOverride,
 		"spans-url-override":     h.config.SpansURLOverride,
+		"spans-mirror-url":       h.config.SpansMirrorURL,
 		"events-url-override":    h.config.EventsURLOverride,
 		"logs-url-override":      h.config.LogsURLOverride,
 		"version":                version,
 	})
 
-	if h.config.HarvestPeriod != 0 {
-		go harvestRoutine(h)
+	if schedules := h.config.harvestSchedules(); len(schedules) > 0 {
+		h.routineDone = make(chan struct{})
+		go harvestRoutine(h, schedules)
 	}
 
 	return h, nil
 }
 
+// Clone creates a new Harvester with the configuration of h, including its
+// common attributes, modified by the options.  The options may, for example,
+// set different CommonAttributes for a subsystem.  The clone shares h's
+// Client, and therefore its transport and connections, but none of its
+// buffered data.  It harvests independently of h.
+func (h *Harvester) Clone(options ...func(*Config)) (*Harvester, error) {
+	if nil == h {
+		return nil, errNilHarvester
+	}
+	base := func(cfg *Config) {
+		*cfg = h.config
+		// The Client already uses the Transport, ProxyURL, and
+		// TLSServerName.
+		cfg.TLSServerName = ""
+		cfg.ProxyURL = ""
+		cfg.Transport = nil
+		if nil != h.commonAttributeValues {
+			cfg.CommonAttributes = make(map[string]interface{}, len(h.commonAttributeValues))
+			for k, v := range h.commonAttributeValues {
+				cfg.CommonAttributes[k] = v
+			}
+		}
+	}
+	return NewHarvester(append([]func(*Config){base}, options...)...)
+}
+
+// typeCommonAttributes returns the common attributes of a type of data: the
+// global common attributes overridden by the valid attributes of the type's
+// Config field.  The field is replaced with a copy of its valid attributes,
+// which protects against later changes to the map and is used by Clone.
+func (h *Harvester) typeCommonAttributes(signal Signal, attributes *map[string]interface{}, global map[string]interface{}) map[string]interface{} {
+	var valid map[string]interface{}
+	if len(*attributes) > 0 {
+		common, err := newCommonAttributes(h.truncateAttributes(*attributes))
+		if err != nil {
+			fields := map[string]interface{}{
+				"err":       err.Error(),
+				"message":   "dropping invalid common attributes",
+				"data-type": signal.String(),
+			}
+			if e, ok := err.(errInvalidAttributes); ok {
+				fields["dropped-keys"] = e.keys
+			}
+			h.config.logError(fields)
+		}
+		valid = common.Attributes
+	}
+	*attributes = nil
+	if len(valid) > 0 {
+		*attributes = make(map[string]interface{}, len(valid))
+		for k, v := range valid {
+			(*attributes)[k] = v
+		}
+	}
+	if len(global)+len(valid) == 0 {
+		return nil
+	}
+	merged := make(map[string]interface{}, len(global)+len(valid))
+	for k, v := range global {
+		merged[k] = v
+	}
+	for k, v := range valid {
+		merged[k] = v
+	}
+	return merged
+}
+
+// cachedCommonAttributes returns the JSON of the common attributes, or nil if
+// there are none.
+func cachedCommonAttributes(attributes map[string]interface{}) *cachedMapEntry {
+	if len(attributes) == 0 {
+		return nil
+	}
+	return newCachedMapEntry(&commonAttributes{Attributes: attributes})
+}
+
 func sanitizeAPIKeyForLogging(apiKey string) string {
 	if len(apiKey) <= 8 {
 		return apiKey
@@ -193,40 +429,216 @@ func (h *Harvester) RecordSpan(s Span) error {
 		return nil
 	}
 	if s.TraceID == "" {
+		h.config.drop(SignalSpans, 1, DropReasonValidation)
 		return errTraceIDUnset
 	}
 	if s.ID == "" {
+		h.config.drop(SignalSpans, 1, DropReasonValidation)
 		return errSpanIDUnset
 	}
-	if s.Timestamp.IsZero() {
+	if s.Timestamp.IsZero() && !h.config.DisableAutoTimestamp {
 		s.Timestamp = time.Now()
 	}
+	if s.Kind != "" {
+		kind, ok := normalizeSpanKind(s.Kind)
+		if !ok {
+			h.logUnknownSpanValue("unknown span kind will not be sent", "kind", s.Kind)
+		}
+		s.Kind = kind
+	}
+	if s.StatusCode != "" {
+		code, ok := normalizeSpanStatusCode(s.StatusCode)
+		if !ok {
+			h.logUnknownSpanValue("unknown span status code will not be sent", "status-code", s.StatusCode)
+		}
+		s.StatusCode = code
+	}
+	h.truncateSpan(&s)
+	s.Attributes = h.interner.attributes(s.Attributes)
+	if h.config.TrackQueueLatency {
+		s.bufferedAt = time.Now()
+	}
 
+	var first bool
+	var full int
+	defer func() { h.afterRecord(SignalSpans, first, full) }()
 	h.lock.Lock()
 	defer h.lock.Unlock()
 
+	if h.bufferSpace(len(h.spans)) < 1 {
+		full = h.bufferDrop(SignalSpans, 1)
+		return errBufferFull
+	}
+	first = len(h.spans) == 0
 	h.spans = append(h.spans, s)
+	h.addBufferedBytes(SignalSpans, s.approximateSize())
 	return nil
 }
 
+// RecordSpanContext records the given span unless ctx is done, in which case
+// ctx.Err() is returned and the span is not recorded.  This allows callers
+// which are shutting down or rate limited to stop recording.
+func (h *Harvester) RecordSpanContext(ctx context.Context, s Span) error {
+	if err := ctx.Err(); nil != err {
+		return err
+	}
+	return h.RecordSpan(s)
+}
+
+// logUnknownSpanValue logs the first time each unknown Span.Kind or
+// Span.StatusCode value is recorded.
+func (h *Harvester) logUnknownSpanValue(message, field, value string) {
+	if _, logged := h.unknownSpanValues.LoadOrStore(field+"="+value, true); !logged {
+		h.config.logError(map[string]interface{}{
+			"message": message,
+			field:     value,
+		})
+	}
+}
+
 // RecordMetric adds a fully formed metric.  This metric is not aggregated with
-// any other metrics and is never dropped.  The timestamp field must be
-// specified on Gauge metrics.  The timestamp/interval fields on Count and
-// Summary are optional and will be assumed to be the harvester batch times if
-// unset.  Use MetricAggregator() instead to aggregate metrics.
-func (h *Harvester) RecordMetric(m Metric) {
+// any other metrics.  The timestamp field must be specified on Gauge metrics.
+// The timestamp/interval fields on Count and Summary are optional and will be
+// assumed to be the harvester batch times if unset.  Use MetricAggregator()
+// instead to aggregate metrics.  An error is returned if the metric is invalid
+// or the buffer is full, see Config.MaxBufferedPayloads, and it has been
+// dropped.
+func (h *Harvester) RecordMetric(m Metric) error {
 	if nil == h {
-		return
+		return nil
+	}
+	if fields := m.validate(); nil != fields {
+		h.config.logError(fields)
+		h.config.drop(SignalMetrics, 1, DropReasonValidation)
+		return fmt.Errorf("%v: %v", fields["message"], fields["err"])
 	}
+	m = h.interner.metric(h.truncateMetric(m))
+
+	var first bool
+	var full int
+	defer func() { h.afterRecord(SignalMetrics, first, full) }()
 	h.lock.Lock()
 	defer h.lock.Unlock()
 
-	if fields := m.validate(); nil != fields {
-		h.config.logError(fields)
+	if h.bufferSpace(h.bufferedMetrics()) < 1 {
+		full = h.bufferDrop(SignalMetrics, 1)
+		return errBufferFull
+	}
+	first = h.metricsEmpty()
+	h.rawMetrics = append(h.rawMetrics, m)
+	h.addBufferedBytes(SignalMetrics, approximateMetricSize(m))
+	return nil
+}
+
+// RecordMetricContext records the given metric as RecordMetric does unless
+// ctx is done, in which case ctx.Err() is returned and the metric is not
+// recorded.
+func (h *Harvester) RecordMetricContext(ctx context.Context, m Metric) error {
+	if err := ctx.Err(); nil != err {
+		return err
+	}
+	return h.RecordMetric(m)
+}
+
+// RecordGaugeSeries adds a Gauge metric with the given name and attributes for
+// each of the points.  This is useful when importing or backfilling a time
+// series.  Points with invalid values are logged and dropped, as are the
+// points which do not fit in the buffer, see Config.MaxBufferedPayloads.
+func (h *Harvester) RecordGaugeSeries(name string, attributes map[string]interface{}, points []GaugePoint) {
+	if nil == h {
 		return
 	}
+	attributes = h.interner.attributes(h.truncateAttributes(attributes))
+	gauges := make([]Metric, 0, len(points))
+	for _, p := range points {
+		m := Gauge{
+			Name:       name,
+			Attributes: attributes,
+			Value:      p.Value,
+			Timestamp:  p.Timestamp,
+		}
+		if fields := m.validate(); nil != fields {
+			h.config.logError(fields)
+			continue
+		}
+		gauges = append(gauges, m)
+	}
+	h.config.drop(SignalMetrics, len(points)-len(gauges), DropReasonValidation)
 
-	h.rawMetrics = append(h.rawMetrics, m)
+	if len(gauges) == 0 {
+		return
+	}
+
+	var first bool
+	var full int
+	defer func() { h.afterRecord(SignalMetrics, first, full) }()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if space := h.bufferSpace(h.bufferedMetrics()); space < len(gauges) {
+		full = h.bufferDrop(SignalMetrics, len(gauges)-space)
+		gauges = gauges[:space]
+		if len(gauges) == 0 {
+			return
+		}
+	}
+	first = h.metricsEmpty()
+	h.rawMetrics = append(h.rawMetrics, gauges...)
+	h.addBufferedBytes(SignalMetrics, approximateMetricsSize(gauges))
+}
+
+// RecordMetricSnapshot adds metrics which must be sent together with the
+// given timestamp and interval, such as related metrics computed at the same
+// time by a subsystem.  The metrics are sent in the same harvest in a batch
+// whose common block has the timestamp and interval, which apply to the
+// metrics that do not set their own.  If t is zero the current time is used.
+// Invalid metrics are logged and dropped, and an error is returned for the
+// first of them.  If the metrics do not all fit in the buffer, see
+// Config.MaxBufferedPayloads, they are all dropped and an error is returned.
+func (h *Harvester) RecordMetricSnapshot(metrics []Metric, t time.Time, interval time.Duration) error {
+	if nil == h {
+		return nil
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	var err error
+	valid := make([]Metric, 0, len(metrics))
+	for _, m := range metrics {
+		if fields := m.validate(); nil != fields {
+			h.config.logError(fields)
+			if nil == err {
+				err = fmt.Errorf("%v: %v", fields["message"], fields["err"])
+			}
+			continue
+		}
+		valid = append(valid, h.interner.metric(h.truncateMetric(m)))
+	}
+	h.config.drop(SignalMetrics, len(metrics)-len(valid), DropReasonValidation)
+
+	if len(valid) == 0 {
+		return err
+	}
+
+	var first bool
+	var full int
+	defer func() { h.afterRecord(SignalMetrics, first, full) }()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	// The snapshot is kept or dropped as a whole.
+	if h.bufferSpace(h.bufferedMetrics()) < len(valid) {
+		full = h.bufferDrop(SignalMetrics, len(valid))
+		return errBufferFull
+	}
+	first = h.metricsEmpty()
+	h.metricSnapshots = append(h.metricSnapshots, metricSnapshot{
+		metrics:   valid,
+		timestamp: t,
+		interval:  interval,
+	})
+	h.addBufferedBytes(SignalMetrics, approximateMetricsSize(valid))
+	return err
 }
 
 // RecordEvent records the given event.
@@ -235,38 +647,191 @@ func (h *Harvester) RecordEvent(e Event) error {
 		return nil
 	}
 	if e.EventType == "" {
+		h.config.drop(SignalEvents, 1, DropReasonValidation)
 		return errEventTypeUnset
 	}
-	if e.Timestamp.IsZero() {
+	if e.Timestamp.IsZero() && !h.config.DisableAutoTimestamp {
 		e.Timestamp = time.Now()
 	}
+	e.Attributes = h.interner.attributes(h.truncateAttributes(e.Attributes))
+	if h.config.TrackQueueLatency {
+		e.bufferedAt = time.Now()
+	}
+	if h.config.EventIdempotencyKeys {
+		e.Attributes = h.addIdempotencyKey(e.Attributes)
+	}
 
+	var first bool
+	var full int
+	defer func() { h.afterRecord(SignalEvents, first, full) }()
 	h.lock.Lock()
 	defer h.lock.Unlock()
 
+	if h.bufferSpace(len(h.events)) < 1 {
+		full = h.bufferDrop(SignalEvents, 1)
+		return errBufferFull
+	}
+	first = len(h.events) == 0
 	h.events = append(h.events, e)
+	h.addBufferedBytes(SignalEvents, e.approximateSize())
 	return nil
 }
 
+// RecordEventContext records the given event unless ctx is done, in which
+// case ctx.Err() is returned and the event is not recorded.
+func (h *Harvester) RecordEventContext(ctx context.Context, e Event) error {
+	if err := ctx.Err(); nil != err {
+		return err
+	}
+	return h.RecordEvent(e)
+}
+
+// addIdempotencyKey returns a copy of the attributes with a unique
+// idempotency key added if one is not already present.
+func (h *Harvester) addIdempotencyKey(attributes map[string]interface{}) map[string]interface{} {
+	if _, ok := attributes[eventIdempotencyKeyAttribute]; ok {
+		return attributes
+	}
+	key, err := uuid.New()
+	if nil != err {
+		h.config.logError(map[string]interface{}{
+			"err":     err.Error(),
+			"message": "unable to create event idempotency key",
+		})
+		return attributes
+	}
+	withKey := make(map[string]interface{}, len(attributes)+1)
+	for k, v := range attributes {
+		withKey[k] = v
+	}
+	withKey[eventIdempotencyKeyAttribute] = key
+	return withKey
+}
+
 // RecordLog records the given log message.
 func (h *Harvester) RecordLog(l Log) error {
 	if nil == h {
 		return nil
 	}
 	if l.Message == "" {
+		h.config.drop(SignalLogs, 1, DropReasonValidation)
 		return errLogMessageUnset
 	}
-	if l.Timestamp.IsZero() {
+	l.Message = h.config.LogMessagePrefix + l.Message
+	if l.Timestamp.IsZero() && !h.config.DisableAutoTimestamp {
 		l.Timestamp = time.Now()
 	}
+	l.Attributes = h.interner.attributes(h.truncateAttributes(l.Attributes))
+	if h.config.TrackQueueLatency {
+		l.bufferedAt = time.Now()
+	}
+	if l.LogType != "" && !knownLogTypes[l.LogType] {
+		if _, logged := h.unknownLogTypes.LoadOrStore(l.LogType, true); !logged {
+			h.config.logError(map[string]interface{}{
+				"message": "unknown log type will not match a parsing rule",
+				"logtype": l.LogType,
+			})
+		}
+	}
+	if _, ok := l.Attributes[logLevelField]; ok && l.Severity != "" {
+		h.levelConflictOnce.Do(func() {
+			h.config.logError(map[string]interface{}{
+				"message":  "log severity overrides level attribute",
+				"severity": l.Severity,
+			})
+		})
+	}
 
+	var first bool
+	var full int
+	defer func() { h.afterRecord(SignalLogs, first, full) }()
 	h.lock.Lock()
 	defer h.lock.Unlock()
 
+	if h.bufferSpace(len(h.logs)) < 1 {
+		full = h.bufferDrop(SignalLogs, 1)
+		return errBufferFull
+	}
+	first = len(h.logs) == 0
 	h.logs = append(h.logs, l)
+	h.addBufferedBytes(SignalLogs, l.approximateSize())
 	return nil
 }
 
+// RecordLogContext records the given log message unless ctx is done, in which
+// case ctx.Err() is returned and the log is not recorded.
+func (h *Harvester) RecordLogContext(ctx context.Context, l Log) error {
+	if err := ctx.Err(); nil != err {
+		return err
+	}
+	return h.RecordLog(l)
+}
+
+// RecordLogf records a log message formatted with fmt.Sprintf at the current
+// time.  severity, eg. "INFO", is sent as the log's level if it is not empty.
+// Use RecordLog to set other fields.
+func (h *Harvester) RecordLogf(severity, format string, args ...interface{}) error {
+	if nil == h {
+		return nil
+	}
+	return h.RecordLog(Log{
+		Message:   fmt.Sprintf(format, args...),
+		Timestamp: time.Now(),
+		Severity:  severity,
+	})
+}
+
+// recordHeartbeat records a heartbeat event if Config.HeartbeatEventType is
+// set.
+func (h *Harvester) recordHeartbeat(now time.Time) {
+	if h.config.HeartbeatEventType == "" {
+		return
+	}
+	h.RecordEvent(Event{
+		EventType: h.config.HeartbeatEventType,
+		Timestamp: now,
+		Attributes: map[string]interface{}{
+			"uptime.seconds": now.Sub(h.start).Seconds(),
+			"version":        version,
+		},
+	})
+}
+
+// HarvestResult describes the outcome of sending a request to New Relic.  It
+// is passed to Config.HarvestCallback.
+type HarvestResult struct {
+	// Signal is the type of data in the request.
+	Signal Signal
+	// Items is the number of spans, metrics, events, or logs in the
+	// request.
+	Items int
+	// StatusCode is the HTTP status code of the final attempt, or zero if
+	// no response was received.
+	StatusCode int
+	// Err is the error of the final attempt if it was not successful.
+	Err error
+	// Retries is the number of times the request was retried.
+	Retries int
+	// Bytes is the size of the compressed request body.
+	Bytes int64
+}
+
+// harvestCallback passes the outcome of the request to
+// Config.HarvestCallback if it is set.
+func (h *Harvester) harvestCallback(req *http.Request, signal Signal, resp response, retries int) {
+	if nil == h.config.HarvestCallback {
+		return
+	}
+	h.config.HarvestCallback(HarvestResult{
+		Signal:     signal,
+		Items:      countRequestItems(req, h.config.CompressionDictionary),
+		StatusCode: resp.statusCode,
+		Err:        resp.err,
+		Retries:    retries,
+		Bytes:      req.ContentLength,
+	})
+}
+
 type response struct {
 	statusCode int
 	body       []byte
@@ -278,12 +843,34 @@ var (
 	backoffSequenceSeconds = []int{0, 1, 2, 4, 8, 16}
 )
 
+// needsRetry determines whether the response should be retried after the
+// given number of attempts, and how long to wait before doing so, using the
+// Config's retry policy.  Successful responses are never retried.
 func (r response) needsRetry(cfg *Config, attempts int) (bool, time.Duration) {
+	if nil == r.err && r.statusCode >= 200 && r.statusCode < 300 {
+		return false, 0
+	}
+	return cfg.retryPolicy().NextBackoff(r.statusCode, attempts, r.retryAfter)
+}
+
+// defaultBackoff returns the wait time before retrying after the given number
+// of attempts.
+func defaultBackoff(attempts int) time.Duration {
 	if attempts >= len(backoffSequenceSeconds) {
 		attempts = len(backoffSequenceSeconds) - 1
 	}
-	backoff := time.Duration(backoffSequenceSeconds[attempts]) * time.Second
+	return time.Duration(backoffSequenceSeconds[attempts]) * time.Second
+}
 
+// defaultRetry implements DefaultRetryPolicy.
+func (r response) defaultRetry(attempts int) (bool, time.Duration) {
+	return r.retryBackoff(defaultBackoff(attempts))
+}
+
+// retryBackoff determines whether the response should be retried and how long
+// to wait before doing so.  backoff is the default wait time, which may be
+// extended by a Retry-After header.
+func (r response) retryBackoff(backoff time.Duration) (bool, time.Duration) {
 	switch r.statusCode {
 	case 202, 200:
 		// success
@@ -293,13 +880,8 @@ func (r response) needsRetry(cfg *Config, attempts int) (bool, time.Duration) {
 		return false, 0
 	case 429:
 		// special retry backoff time
-		if r.retryAfter != "" {
-			// Honor Retry-After header value in seconds
-			if d, err := time.ParseDuration(r.retryAfter + "s"); nil == err {
-				if d > backoff {
-					return true, d
-				}
-			}
+		if d, ok := parseRetryAfter(r.retryAfter, time.Now()); ok && d > backoff {
+			return true, d
 		}
 		return true, backoff
 	default:
@@ -308,12 +890,78 @@ func (r response) needsRetry(cfg *Config, attempts int) (bool, time.Duration) {
 	}
 }
 
-func postData(req *http.Request, client *http.Client) response {
+// parseRetryAfter parses a Retry-After header value, which is either a number
+// of seconds or an HTTP-date, into the time to wait from now.
+func parseRetryAfter(retryAfter string, now time.Time) (time.Duration, bool) {
+	if retryAfter == "" {
+		return 0, false
+	}
+	if d, err := time.ParseDuration(retryAfter + "s"); nil == err {
+		return d, true
+	}
+	if t, err := http.ParseTime(retryAfter); nil == err {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// acceptEncoding is the Accept-Encoding header the Harvester sets on its
+// requests when it decodes the responses itself.
+const acceptEncoding = "gzip"
+
+// decodesResponses returns whether the Harvester requests compressed responses
+// and decodes them itself.  It only does so when the client uses an
+// http.Transport which would otherwise request gzip and decompress the
+// response transparently, so that user-supplied round trippers and transports
+// with DisableCompression set see the requests unchanged.
+func decodesResponses(client *http.Client) bool {
+	switch t := client.Transport.(type) {
+	case nil:
+		return true
+	case *http.Transport:
+		return !t.DisableCompression
+	default:
+		return false
+	}
+}
+
+// gzipResponseBody closes both the gzip.Reader and the response body.
+type gzipResponseBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (b gzipResponseBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// responseBody returns the response's body, decompressing it if the response
+// is gzip encoded.  http.Transport does not decompress responses itself when
+// the request sets Accept-Encoding.  A body which is not valid gzip is read as
+// empty.  The returned body must be closed.
+func responseBody(resp *http.Response) io.ReadCloser {
+	if resp.Uncompressed || !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return resp.Body
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if nil != err {
+		resp.Body.Close()
+		return http.NoBody
+	}
+	return gzipResponseBody{Reader: zr, body: resp.Body}
+}
+
+func postData(req *http.Request, client *http.Client, maxBytes int64) response {
+	if req.Header.Get("Accept-Encoding") == "" && decodesResponses(client) {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
 	resp, err := client.Do(req)
 	if nil != err {
 		return response{err: fmt.Errorf("error posting data: %v", err)}
 	}
-	defer resp.Body.Close()
+	body := responseBody(resp)
+	defer body.Close()
 
 	r := response{
 		statusCode: resp.StatusCode,
@@ -322,7 +970,7 @@ func postData(req *http.Request, client *http.Client) response {
 
 	// On success, metrics ingest returns 202, span ingest returns 200.
 	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
-		r.body, _ = ioutil.ReadAll(resp.Body)
+		r.body, _ = ioutil.ReadAll(io.LimitReader(body, maxBytes))
 	} else {
 		r.err = fmt.Errorf("unexpected post response code: %d: %s",
 			resp.StatusCode, http.StatusText(resp.StatusCode))
@@ -331,15 +979,40 @@ func postData(req *http.Request, client *http.Client) response {
 	return r
 }
 
-func (h *Harvester) swapOutMetrics(now time.Time) []*http.Request {
+// addMetricCollector adds a function called before each harvest of metrics.
+func (h *Harvester) addMetricCollector(collect func()) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.metricCollectors = append(h.metricCollectors, collect)
+}
+
+// collectMetrics calls the metric collectors.  They are called without the
+// lock held so that they can record metrics.
+func (h *Harvester) collectMetrics() {
+	h.lock.Lock()
+	collectors := h.metricCollectors
+	h.lock.Unlock()
+	for _, collect := range collectors {
+		collect()
+	}
+}
+
+// takeMetrics removes and returns all raw and aggregated metrics along with
+// the start time of the harvest period they were collected in.  The metric
+// collectors are called first.
+func (h *Harvester) takeMetrics(now time.Time) ([]Metric, time.Time) {
+	h.collectMetrics()
 	h.lock.Lock()
 	lastHarvest := h.lastHarvest
 	h.lastHarvest = now
 	rawMetrics := h.rawMetrics
 	h.rawMetrics = nil
+	h.bufferedBytes[SignalMetrics] = 0
 	aggregatedMetrics := h.aggregatedMetrics
 	h.aggregatedMetrics = make(map[metricIdentity]*metric, len(aggregatedMetrics))
+	dropped := h.takeBufferDropped(SignalMetrics)
 	h.lock.Unlock()
+	h.logBufferDropped(SignalMetrics, dropped)
 
 	for _, m := range aggregatedMetrics {
 		if nil != m.c {
@@ -353,108 +1026,375 @@ func (h *Harvester) swapOutMetrics(now time.Time) []*http.Request {
 		}
 	}
 
-	if len(rawMetrics) == 0 {
+	if h.config.MergeDuplicateMetrics {
+		var merged int
+		rawMetrics, merged = mergeDuplicateMetrics(rawMetrics)
+		if merged > 0 {
+			h.config.logDebug(map[string]interface{}{
+				"event": "duplicate metrics merged",
+				"count": merged,
+			})
+		}
+	}
+
+	if f := h.config.newTimestampFilter(now); f.enabled() {
+		kept := rawMetrics[:0]
+		for _, m := range rawMetrics {
+			ts, keep := f.apply(m.timestamp())
+			if !keep {
+				continue
+			}
+			if !ts.Equal(m.timestamp()) {
+				m = m.withTimestamp(ts)
+			}
+			kept = append(kept, m)
+		}
+		f.log(&h.config, SignalMetrics)
+		rawMetrics = kept
+	}
+	return rawMetrics, lastHarvest
+}
+
+// takeMetricSnapshots removes and returns all metrics recorded with
+// RecordMetricSnapshot.
+func (h *Harvester) takeMetricSnapshots(now time.Time) []metricSnapshot {
+	h.lock.Lock()
+	snapshots := h.metricSnapshots
+	h.metricSnapshots = nil
+	h.lock.Unlock()
+
+	if f := h.config.newTimestampFilter(now); f.enabled() {
+		kept := snapshots[:0]
+		for _, ms := range snapshots {
+			ts, keep := f.apply(ms.timestamp)
+			if !keep {
+				// The filter counts the snapshot as a single item.
+				f.dropped += len(ms.metrics) - 1
+				continue
+			}
+			ms.timestamp = ts
+			kept = append(kept, ms)
+		}
+		f.log(&h.config, SignalMetrics)
+		snapshots = kept
+	}
+	return snapshots
+}
+
+func (h *Harvester) metricRequests(rawMetrics []Metric, snapshots []metricSnapshot, lastHarvest, now time.Time) []*http.Request {
+	if len(rawMetrics) == 0 && len(snapshots) == 0 {
 		return nil
 	}
 
-	commonBlock := &metricCommonBlock{
-		timestamp: lastHarvest,
-		interval:  now.Sub(lastHarvest),
+	// Metrics with server assigned timestamps are sent in a separate batch
+	// whose common block has no timestamp or interval to inherit.
+	var harvestMetrics, serverMetrics []Metric
+	for _, m := range rawMetrics {
+		if usesServerTimestamp(m) {
+			serverMetrics = append(serverMetrics, m)
+		} else {
+			harvestMetrics = append(harvestMetrics, m)
+		}
+	}
+
+	var batches []Batch
+	if len(harvestMetrics) > 0 {
+		commonBlock := &metricCommonBlock{
+			timestamp: lastHarvest,
+			interval:  now.Sub(lastHarvest),
+		}
+		if h.commonAttributes != nil {
+			commonBlock.attributes = h.commonAttributes
+		}
+		batches = append(batches, Batch{commonBlock, &metricGroup{Metrics: harvestMetrics}})
 	}
-	if h.commonAttributes != nil {
-		commonBlock.attributes = h.commonAttributes
+	if len(serverMetrics) > 0 {
+		commonBlock := &metricCommonBlock{}
+		if h.commonAttributes != nil {
+			commonBlock.attributes = h.commonAttributes
+		}
+		batches = append(batches, Batch{commonBlock, &metricGroup{Metrics: serverMetrics}})
+	}
+	for _, ms := range snapshots {
+		commonBlock := &metricCommonBlock{
+			timestamp: ms.timestamp,
+			interval:  ms.interval,
+		}
+		if h.commonAttributes != nil {
+			commonBlock.attributes = h.commonAttributes
+		}
+		batches = append(batches, Batch{commonBlock, &metricGroup{Metrics: ms.metrics}})
 	}
-	group := &metricGroup{Metrics: rawMetrics}
-	entries := []MapEntry{commonBlock, group}
-	reqs, err := buildSplitRequests([]Batch{entries}, h.metricRequestFactory)
+	reqs, err := h.buildRequests(SignalMetrics, batches, h.metricRequestFactory)
 	if nil != err {
 		h.config.logError(map[string]interface{}{
 			"err":     err.Error(),
 			"message": "error creating requests for metrics",
 		})
+		h.config.drop(SignalMetrics, len(rawMetrics)+countSnapshotMetrics(snapshots), DropReasonRequestError)
 		return nil
 	}
 	return reqs
 }
 
-func (h *Harvester) swapOutSpans() []*http.Request {
+func (h *Harvester) swapOutMetrics(now time.Time) []*http.Request {
+	rawMetrics, lastHarvest := h.takeMetrics(now)
+	return h.metricRequests(rawMetrics, h.takeMetricSnapshots(now), lastHarvest, now)
+}
+
+// queueWaitAttribute is the attribute added to spans, events, and logs when
+// Config.TrackQueueLatency is enabled.
+const queueWaitAttribute = "nr.queue.wait.ms"
+
+// withQueueWait returns a copy of the attributes of an item recorded at
+// bufferedAt and harvested at now with the queue wait time added.  The
+// attributes are returned unchanged if bufferedAt is not set.
+func withQueueWait(attributes map[string]interface{}, bufferedAt, now time.Time) map[string]interface{} {
+	if bufferedAt.IsZero() {
+		return attributes
+	}
+	// Copy the attributes since the map is owned by the caller.
+	withWait := make(map[string]interface{}, len(attributes)+1)
+	for k, v := range attributes {
+		withWait[k] = v
+	}
+	withWait[queueWaitAttribute] = now.Sub(bufferedAt).Seconds() * 1000.0
+	return withWait
+}
+
+// takeSpans removes and returns all recorded spans.
+func (h *Harvester) takeSpans() []Span {
 	h.lock.Lock()
 	sps := h.spans
 	h.spans = nil
+	h.bufferedBytes[SignalSpans] = 0
+	dropped := h.takeBufferDropped(SignalSpans)
 	h.lock.Unlock()
+	h.logBufferDropped(SignalSpans, dropped)
+
+	now := time.Now()
+	if f := h.config.newTimestampFilter(now); f.enabled() {
+		kept := sps[:0]
+		for _, s := range sps {
+			var keep bool
+			if s.Timestamp, keep = f.apply(s.Timestamp); keep {
+				kept = append(kept, s)
+			}
+		}
+		f.log(&h.config, SignalSpans)
+		sps = kept
+	}
+	if h.config.TrackQueueLatency {
+		for i := range sps {
+			sps[i].Attributes = withQueueWait(sps[i].Attributes, sps[i].bufferedAt, now)
+		}
+	}
+	if nil != h.config.SpanFilter {
+		kept := sps[:0]
+		for i := range sps {
+			if h.config.SpanFilter(&sps[i]) {
+				kept = append(kept, sps[i])
+			}
+		}
+		h.config.drop(SignalSpans, len(sps)-len(kept), DropReasonFiltered)
+		sps = kept
+	}
+	return sps
+}
 
-	if nil == sps {
-		return nil
+// spanRequests returns the requests of the spans, and the copies of them for
+// Config.SpansMirrorURL.
+func (h *Harvester) spanRequests(sps []Span) (reqs, mirrorReqs []*http.Request) {
+	if len(sps) == 0 {
+		return nil, nil
 	}
 
 	var entries []MapEntry
-	if nil != h.commonAttributes {
-		entries = append(entries, &spanCommonBlock{attributes: h.commonAttributes})
+	if nil != h.spanCommonAttributes {
+		entries = append(entries, &spanCommonBlock{attributes: h.spanCommonAttributes})
 	}
-	entries = append(entries, &spanGroup{Spans: sps})
-	reqs, err := buildSplitRequests([]Batch{entries}, h.spanRequestFactory)
+	entries = append(entries, &spanGroup{Spans: sps, durationUnit: h.config.SpanDurationUnit})
+	reqs, err := h.buildRequests(SignalSpans, []Batch{entries}, h.spanRequestFactory)
 	if nil != err {
 		h.config.logError(map[string]interface{}{
 			"err":     err.Error(),
 			"message": "error creating requests for spans",
 		})
-		return nil
+		h.config.drop(SignalSpans, len(sps), DropReasonRequestError)
+		return nil, nil
 	}
+	if nil != h.spanMirrorRequestFactory {
+		// Oversized spans were already dropped for the primary
+		// requests.
+		mirrorReqs, _, err = h.splitBatches([]Batch{entries}, h.spanMirrorRequestFactory)
+		if nil != err {
+			h.config.logError(map[string]interface{}{
+				"err":     err.Error(),
+				"message": "error creating mirror requests for spans",
+			})
+		}
+	}
+	return reqs, mirrorReqs
+}
+
+func (h *Harvester) swapOutSpans() []*http.Request {
+	reqs, _ := h.spanRequests(h.takeSpans())
 	return reqs
 }
 
-func (h *Harvester) swapOutEvents() []*http.Request {
+// takeEvents removes and returns all recorded events.
+func (h *Harvester) takeEvents() []Event {
 	h.lock.Lock()
 	events := h.events
 	h.events = nil
+	h.bufferedBytes[SignalEvents] = 0
+	dropped := h.takeBufferDropped(SignalEvents)
 	h.lock.Unlock()
+	h.logBufferDropped(SignalEvents, dropped)
+
+	now := time.Now()
+	if f := h.config.newTimestampFilter(now); f.enabled() {
+		kept := events[:0]
+		for _, e := range events {
+			var keep bool
+			if e.Timestamp, keep = f.apply(e.Timestamp); keep {
+				kept = append(kept, e)
+			}
+		}
+		f.log(&h.config, SignalEvents)
+		events = kept
+	}
+	if h.config.TrackQueueLatency {
+		for i := range events {
+			// Adding Attributes would prevent AttributesJSON from
+			// being sent.
+			if nil == events[i].Attributes && nil != events[i].AttributesJSON {
+				continue
+			}
+			events[i].Attributes = withQueueWait(events[i].Attributes, events[i].bufferedAt, now)
+		}
+	}
+	if nil != h.config.EventFilter {
+		kept := events[:0]
+		for i := range events {
+			if h.config.EventFilter(&events[i]) {
+				kept = append(kept, events[i])
+			}
+		}
+		h.config.drop(SignalEvents, len(events)-len(kept), DropReasonFiltered)
+		events = kept
+	}
+	return events
+}
 
-	if nil == events {
+func (h *Harvester) eventRequests(events []Event) []*http.Request {
+	if len(events) == 0 {
 		return nil
 	}
 	group := &eventGroup{
-		Events: events,
+		Events:           events,
+		commonAttributes: h.eventCommonAttributes,
 	}
-	reqs, err := buildSplitRequests([]Batch{{group}}, h.eventRequestFactory)
+	reqs, err := h.buildRequests(SignalEvents, []Batch{{group}}, h.eventRequestFactory)
 	if nil != err {
 		h.config.logError(map[string]interface{}{
 			"err":     err.Error(),
 			"message": "error creating requests for events",
 		})
+		h.config.drop(SignalEvents, len(events), DropReasonRequestError)
 		return nil
 	}
 	return reqs
 }
 
-func (h *Harvester) swapOutLogs() []*http.Request {
+func (h *Harvester) swapOutEvents() []*http.Request {
+	return h.eventRequests(h.takeEvents())
+}
+
+// takeLogs removes and returns all recorded logs.
+func (h *Harvester) takeLogs() []Log {
 	h.lock.Lock()
 	logs := h.logs
 	h.logs = nil
+	h.bufferedBytes[SignalLogs] = 0
+	dropped := h.takeBufferDropped(SignalLogs)
 	h.lock.Unlock()
+	h.logBufferDropped(SignalLogs, dropped)
+
+	now := time.Now()
+	if f := h.config.newTimestampFilter(now); f.enabled() {
+		kept := logs[:0]
+		for _, l := range logs {
+			var keep bool
+			if l.Timestamp, keep = f.apply(l.Timestamp); keep {
+				kept = append(kept, l)
+			}
+		}
+		f.log(&h.config, SignalLogs)
+		logs = kept
+	}
+	if h.config.TrackQueueLatency {
+		for i := range logs {
+			logs[i].Attributes = withQueueWait(logs[i].Attributes, logs[i].bufferedAt, now)
+		}
+	}
+	if nil != h.config.LogFilter {
+		kept := logs[:0]
+		for i := range logs {
+			if h.config.LogFilter(&logs[i]) {
+				kept = append(kept, logs[i])
+			}
+		}
+		h.config.drop(SignalLogs, len(logs)-len(kept), DropReasonFiltered)
+		logs = kept
+	}
+	return logs
+}
 
-	if nil == logs {
+func (h *Harvester) logRequests(logs []Log) []*http.Request {
+	if len(logs) == 0 {
 		return nil
 	}
 
 	var entries []MapEntry
-	if nil != h.commonAttributes {
-		entries = append(entries, &logCommonBlock{attributes: h.commonAttributes})
+	if nil != h.logCommonAttributes {
+		entries = append(entries, &logCommonBlock{attributes: h.logCommonAttributes})
 	}
 	entries = append(entries, &logGroup{Logs: logs})
-	reqs, err := buildSplitRequests([]Batch{entries}, h.logRequestFactory)
+	reqs, err := h.buildRequests(SignalLogs, []Batch{entries}, h.logRequestFactory)
 	if nil != err {
 		h.config.logError(map[string]interface{}{
 			"err":     err.Error(),
 			"message": "error creating requests for logs",
 		})
+		h.config.drop(SignalLogs, len(logs), DropReasonRequestError)
 		return nil
 	}
 	return reqs
 }
 
-func harvestRequest(req *http.Request, cfg *Config, wg *sync.WaitGroup) {
+func (h *Harvester) swapOutLogs() []*http.Request {
+	return h.logRequests(h.takeLogs())
+}
+
+// harvestRequest sends the request, retrying as necessary.  If onSuccess is
+// not nil, it is called with the response body once the request succeeds.
+func (h *Harvester) harvestRequest(req *http.Request, signal Signal, wg *sync.WaitGroup, onSuccess func(*http.Request, []byte)) {
 	var attempts int
+	var resp response
+	cfg := &h.config
+	start := time.Now()
+	// Requests returned by Config.RequestInterceptor may not be able to
+	// reproduce their body, in which case they are not retried.
+	canRetry := nil == req.Body || nil != req.GetBody
 	defer wg.Done()
+	defer func() {
+		if signal == signalSpansMirror {
+			return
+		}
+		h.harvestCallback(req, signal, resp, attempts)
+		h.recordInternalRequest(req, signal, resp, attempts, time.Since(start))
+	}()
 	for {
 		cfg.logDebug(map[string]interface{}{
 			"event":       "data post",
@@ -464,17 +1404,10 @@ func harvestRequest(req *http.Request, cfg *Config, wg *sync.WaitGroup) {
 		// Check if the audit log is enabled to prevent unnecessarily
 		// copying UncompressedBody.
 		if cfg.auditLogEnabled() {
-			bodyReader, _ := req.GetBody()
-			compressedBody, _ := ioutil.ReadAll(bodyReader)
-			uncompressedBody, _ := internal.Uncompress(compressedBody)
-			cfg.logAudit(map[string]interface{}{
-				"event": "uncompressed request body",
-				"url":   req.URL.String(),
-				"data":  jsonString(uncompressedBody),
-			})
+			cfg.auditRequest(req)
 		}
 
-		resp := postData(req, cfg.Client)
+		resp = postData(req, cfg.Client, cfg.maxResponseBytes())
 
 		if nil != resp.err {
 			cfg.logError(map[string]interface{}{
@@ -488,7 +1421,23 @@ func harvestRequest(req *http.Request, cfg *Config, wg *sync.WaitGroup) {
 			})
 		}
 		retry, backoff := resp.needsRetry(cfg, attempts)
-		if !retry {
+		if !retry || !canRetry {
+			if nil == resp.err && resp.statusCode >= 200 && resp.statusCode < 300 {
+				h.retryBudget.success()
+				if nil != onSuccess {
+					onSuccess(req, resp.body)
+				}
+			} else {
+				h.dropRequest(req, signal, DropReasonRejected)
+			}
+			return
+		}
+		if !h.retryBudget.allowRetry() {
+			cfg.logError(map[string]interface{}{
+				"message": "retry budget exhausted, dropping data",
+				"url":     req.URL.String(),
+			})
+			h.dropRequest(req, signal, DropReasonRetryBudget)
 			return
 		}
 
@@ -509,21 +1458,97 @@ func harvestRequest(req *http.Request, cfg *Config, wg *sync.WaitGroup) {
 					"context-error": err.Error(),
 				})
 			}
+			h.dropRequest(req, signal, DropReasonTimeout)
 			return
 		}
 		attempts++
 
 		// Reattach request body because the original one has already been read
 		// and closed.
-		originalBody, _ := req.GetBody()
-		req.Body = originalBody
+		if nil != req.GetBody {
+			req.Body, _ = req.GetBody()
+		}
+	}
+}
+
+// dropRequest writes the data of a request that was not delivered to the
+// FallbackWriter, or else reports it to OnDrop.  Mirror requests are copies
+// of span requests, so their failures are only logged.
+func (h *Harvester) dropRequest(req *http.Request, signal Signal, reason string) {
+	if signal == signalSpansMirror {
+		return
+	}
+	if !h.writeFallback(req, signal) {
+		h.config.drop(signal, countRequestItems(req, h.config.CompressionDictionary), reason)
+	}
+}
+
+// warnLargePayloads logs a single warning if the compressed body of any of
+// the requests exceeds Config.PayloadWarnBytes.
+func (h *Harvester) warnLargePayloads(reqs []*http.Request) {
+	if h.config.PayloadWarnBytes <= 0 {
+		return
+	}
+	var count int
+	var largest int64
+	for _, req := range reqs {
+		if req.ContentLength > h.config.PayloadWarnBytes {
+			count++
+			if req.ContentLength > largest {
+				largest = req.ContentLength
+			}
+		}
+	}
+	if count > 0 {
+		h.config.logError(map[string]interface{}{
+			"message":       "request payload exceeds warning threshold",
+			"threshold":     h.config.PayloadWarnBytes,
+			"largest-bytes": largest,
+			"requests":      count,
+		})
+	}
+}
+
+// observePayloadSizes calls Config.PayloadSizeObserver with the uncompressed
+// and compressed body sizes of each request.
+func (h *Harvester) observePayloadSizes(reqs []*http.Request, signals map[*http.Request]Signal) {
+	if nil == h.config.PayloadSizeObserver {
+		return
+	}
+	for _, req := range reqs {
+		if signals[req] == signalSpansMirror {
+			continue
+		}
+		h.config.PayloadSizeObserver(signals[req].String(), uncompressedSize(req), int(req.ContentLength))
 	}
 }
 
+// uncompressedSize returns the uncompressed size of a gzip encoded request
+// body, or -1 if it is unknown.  The size is read from the gzip trailer, which
+// records it modulo 2^32, to avoid decompressing the body.
+func uncompressedSize(req *http.Request) int {
+	if req.Header.Get("Content-Encoding") != "gzip" || nil == req.GetBody {
+		return -1
+	}
+	body, err := req.GetBody()
+	if nil != err {
+		return -1
+	}
+	defer body.Close()
+	compressed, err := ioutil.ReadAll(body)
+	// A gzip member has a 10 byte header and an 8 byte trailer.
+	if nil != err || len(compressed) < 18 {
+		return -1
+	}
+	return int(binary.LittleEndian.Uint32(compressed[len(compressed)-4:]))
+}
+
 // HarvestNow sends metric and span data to New Relic.  This method blocks until
-// all data has been sent successfully or the Config.HarvestTimeout timeout has
-// elapsed. This method can be used with a zero Config.HarvestPeriod value to
-// control exactly when data is sent to New Relic servers.
+// all data has been sent successfully, the Config.HarvestTimeout timeout has
+// elapsed, or ct is done.  Requests still in progress when ct is done are
+// cancelled in the background. This method can be used with a zero
+// Config.HarvestPeriod value to control exactly when data is sent to New Relic
+// servers.
 func (h *Harvester) HarvestNow(ct context.Context) {
 	if nil == h {
 		return
@@ -532,33 +1557,392 @@ func (h *Harvester) HarvestNow(ct context.Context) {
 	ctx, cancel := context.WithTimeout(ct, h.config.HarvestTimeout)
 	defer cancel()
 
-	var reqs []*http.Request
-	reqs = append(reqs, h.swapOutMetrics(time.Now())...)
-	reqs = append(reqs, h.swapOutSpans()...)
-	reqs = append(reqs, h.swapOutEvents()...)
-	reqs = append(reqs, h.swapOutLogs()...)
-	wg := sync.WaitGroup{}
+	h.recordHeartbeat(time.Now())
+	h.harvestType(ctx, allSignals...)
+}
 
+// sendRequests passes the requests through Config.RequestInterceptor, if
+// set, and then sends each in its own goroutine, blocking until all have
+// completed or ctx is done.  signals maps each request to the signal of the data it
+// contains.
+func (h *Harvester) sendRequests(ctx context.Context, reqs []*http.Request, signals map[*http.Request]Signal) {
+	if nil != h.config.RequestInterceptor {
+		intercepted := h.config.RequestInterceptor(reqs)
+		reqs = make([]*http.Request, 0, len(intercepted))
+		for _, req := range intercepted {
+			if nil != req {
+				reqs = append(reqs, req)
+			}
+		}
+	}
+	h.warnLargePayloads(reqs)
+	h.observePayloadSizes(reqs, signals)
+
+	wg := sync.WaitGroup{}
 	for _, req := range reqs {
+		signal, ok := signals[req]
+		if !ok {
+			signal = signalUnknown
+		}
+		var onSuccess func(*http.Request, []byte)
+		if signal == SignalMetrics {
+			onSuccess = h.handleMetricRejections
+		}
 		wg.Add(1)
 		httpRequest := req.WithContext(ctx)
-		go harvestRequest(httpRequest, &h.config, &wg)
+		go h.harvestRequest(httpRequest, signal, &wg, onSuccess)
+	}
+
+	// Return as soon as the context is done rather than waiting for a slow
+	// transport to notice.  The request goroutines finish on their own.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// Signal is a type of telemetry data buffered by the Harvester.
+type Signal int
+
+// Signals that can be harvested with HarvestSignal.
+const (
+	SignalSpans Signal = iota
+	SignalMetrics
+	SignalEvents
+	SignalLogs
+
+	// signalUnknown is used for requests returned by
+	// Config.RequestInterceptor that were not built by the Harvester.
+	signalUnknown Signal = -1
+	// signalSpansMirror is used for the copies of span requests sent to
+	// Config.SpansMirrorURL.  They are not counted as spans sent or
+	// dropped.
+	signalSpansMirror Signal = -2
+)
+
+// allSignals contains every Signal buffered by the Harvester.
+var allSignals = []Signal{SignalSpans, SignalMetrics, SignalEvents, SignalLogs}
+
+// String returns the name of the signal as used in log messages and passed
+// to Config.OnDrop.
+func (s Signal) String() string {
+	switch s {
+	case SignalSpans:
+		return "spans"
+	case SignalMetrics:
+		return "metrics"
+	case SignalEvents:
+		return "events"
+	case SignalLogs:
+		return "logs"
+	}
+	return "unknown"
+}
+
+// HarvestSignal sends only the data of the given signal to New Relic, leaving
+// all other data buffered.  This is useful to flush logs before a known
+// crash point without also harvesting metrics.  Like HarvestNow, this method
+// blocks until all requests have completed, failed, or the context is
+// cancelled, and it respects Config.HarvestTimeout.
+func (h *Harvester) HarvestSignal(ct context.Context, signal Signal) {
+	if nil == h {
+		return
+	}
+
+	switch signal {
+	case SignalSpans, SignalMetrics, SignalEvents, SignalLogs:
+	default:
+		h.config.logError(map[string]interface{}{
+			"message": "unable to harvest unknown signal",
+			"signal":  int(signal),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ct, h.config.HarvestTimeout)
+	defer cancel()
+
+	h.harvestType(ctx, signal)
+}
+
+// harvestType swaps out and sends only the data of the given signals,
+// leaving all other data buffered.  Logs are correlated with spans if both
+// are harvested.
+func (h *Harvester) harvestType(ctx context.Context, signals ...Signal) {
+	start := time.Now()
+	defer h.recordHarvestLatency(start)
+	h.logTruncatedValues()
+
+	var harvest [SignalLogs + 1]bool
+	for _, signal := range signals {
+		harvest[signal] = true
+	}
+
+	now := time.Now()
+	var metrics []Metric
+	var lastHarvest time.Time
+	var snapshots []metricSnapshot
+	var spans []Span
+	var events []Event
+	var logs []Log
+	if harvest[SignalMetrics] {
+		metrics, lastHarvest = h.takeMetrics(now)
+		snapshots = h.takeMetricSnapshots(now)
+	}
+	if harvest[SignalSpans] {
+		spans = h.takeSpans()
+	}
+	if harvest[SignalEvents] {
+		events = h.takeEvents()
+	}
+	if harvest[SignalLogs] {
+		logs = h.takeLogs()
+	}
+
+	if h.config.CorrelateLogsAndSpans {
+		if n := correlateLogsAndSpans(logs, spans); n > 0 {
+			h.config.logDebug(map[string]interface{}{
+				"event": "logs correlated with spans",
+				"count": n,
+			})
+		}
+	}
+
+	h.config.logDebug(map[string]interface{}{
+		"event":   "harvest data swapped out",
+		"metrics": len(metrics) + countSnapshotMetrics(snapshots),
+		"spans":   len(spans),
+		"events":  len(events),
+		"logs":    len(logs),
+	})
+
+	requestSignals := make(map[*http.Request]Signal)
+	var reqs []*http.Request
+	spanReqs, mirrorReqs := h.spanRequests(spans)
+	for _, sr := range []struct {
+		signal Signal
+		reqs   []*http.Request
+	}{
+		{signal: SignalMetrics, reqs: h.metricRequests(metrics, snapshots, lastHarvest, now)},
+		{signal: SignalSpans, reqs: spanReqs},
+		{signal: SignalEvents, reqs: h.eventRequests(events)},
+		{signal: SignalLogs, reqs: h.logRequests(logs)},
+	} {
+		for _, req := range sr.reqs {
+			requestSignals[req] = sr.signal
+		}
+		reqs = append(reqs, sr.reqs...)
+	}
+	reqs = h.limitRequests(reqs, requestSignals, harvest)
+	// Mirror requests are not limited since they are not deferred.
+	for _, req := range mirrorReqs {
+		requestSignals[req] = signalSpansMirror
+	}
+	reqs = append(reqs, mirrorReqs...)
+	h.sendRequests(ctx, reqs, requestSignals)
+}
+
+// deferredRequest is a request deferred to a later harvest.
+type deferredRequest struct {
+	req    *http.Request
+	signal Signal
+}
+
+// limitRequests returns the requests to send in this harvest.  The requests
+// deferred by earlier harvests of the harvested signals are sent first.  If
+// there are more than Config.MaxRequestsPerHarvest requests then the rest are
+// deferred to the next harvest, unless the Harvester has been shut down in
+// which case there may not be one.  signals is updated with the signals of
+// the deferred requests returned.
+func (h *Harvester) limitRequests(reqs []*http.Request, signals map[*http.Request]Signal, harvest [SignalLogs + 1]bool) []*http.Request {
+	max := h.config.MaxRequestsPerHarvest
+	if max <= 0 {
+		return reqs
+	}
+
+	h.lock.Lock()
+	var pending, deferred []deferredRequest
+	for _, d := range h.deferredRequests {
+		if harvest[d.signal] {
+			pending = append(pending, d)
+		} else {
+			deferred = append(deferred, d)
+		}
+	}
+	for _, req := range reqs {
+		pending = append(pending, deferredRequest{req: req, signal: signals[req]})
+	}
+	select {
+	case <-h.done:
+		max = len(pending)
+	default:
+	}
+	if len(pending) > max {
+		deferred = append(deferred, pending[max:]...)
+		pending = pending[:max]
+	}
+	var dropped []deferredRequest
+	if limit := h.config.maxDeferredRequests(); len(deferred) > limit {
+		dropped = deferred[limit:]
+		deferred = deferred[:limit:limit]
+	}
+	h.deferredRequests = deferred
+	h.lock.Unlock()
+
+	for _, d := range dropped {
+		h.dropRequest(d.req, d.signal, DropReasonBufferFull)
+	}
+	if len(dropped) > 0 {
+		h.config.logError(map[string]interface{}{
+			"message": "deferred requests limit reached, data dropped",
+			"dropped": len(dropped),
+		})
+	}
+
+	if len(deferred) > 0 {
+		h.config.logDebug(map[string]interface{}{
+			"event":    "requests deferred to next harvest",
+			"deferred": len(deferred),
+			"sending":  len(pending),
+		})
+	}
+	reqs = make([]*http.Request, len(pending))
+	for i, d := range pending {
+		reqs[i] = d.req
+		signals[d.req] = d.signal
+	}
+	return reqs
+}
+
+// Shutdown stops the goroutine which harvests every Config.HarvestPeriod,
+// waits for the harvests it started, and then harvests all buffered data.  It
+// blocks until all requests have completed or ctx is done.  Use Shutdown
+// before a short-lived process exits to avoid losing data.  An error is
+// returned if ctx expired before all requests completed.  Data recorded after
+// Shutdown is only sent by calls to HarvestNow.  Calls after the first return
+// nil immediately.
+func (h *Harvester) Shutdown(ctx context.Context) error {
+	if nil == h {
+		return nil
+	}
+	var err error
+	h.shutdownOnce.Do(func() {
+		close(h.done)
+		if nil != h.routineDone {
+			<-h.routineDone
+		}
+
+		harvested := make(chan struct{})
+		go func() {
+			h.harvests.Wait()
+			h.HarvestNow(ctx)
+			close(harvested)
+		}()
+		select {
+		case <-harvested:
+		case <-ctx.Done():
+		}
+		err = ctx.Err()
+	})
+	return err
+}
+
+func harvestRoutine(h *Harvester, schedules []harvestSchedule) {
+	defer close(h.routineDone)
+
+	// Early harvests are not delayed by the jitter.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.earlyHarvestRoutine(schedules)
+	}()
+
+	jitter := time.NewTimer(h.config.harvestJitter())
+	select {
+	case <-jitter.C:
+	case <-h.done:
+		jitter.Stop()
+		return
+	}
+
+	// Each schedule has its own ticker and goroutine.
+	for _, schedule := range schedules {
+		wg.Add(1)
+		go func(schedule harvestSchedule) {
+			defer wg.Done()
+			ticker := time.NewTicker(schedule.period)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					h.harvestTick(schedule)
+				case <-h.done:
+					return
+				}
+			}
+		}(schedule)
 	}
-	wg.Wait()
 }
 
-func harvestRoutine(h *Harvester) {
-	// Introduce a small jitter to ensure the backend isn't hammered if many
-	// harvesters start at once.
-	d := minDuration(h.config.HarvestPeriod, 3*time.Second)
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	jitter := time.Nanosecond * time.Duration(rnd.Int63n(d.Nanoseconds()))
-	time.Sleep(jitter)
+// harvestTick starts a harvest of the schedule's signals for a tick of the
+// harvest goroutine unless it is coalesced with the next one.
+func (h *Harvester) harvestTick(schedule harvestSchedule) {
+	if schedule.coalesce && h.coalesceTick() {
+		return
+	}
+	h.harvests.Add(1)
+	if schedule.coalesce {
+		atomic.AddInt32(&h.pendingHarvests, 1)
+	}
+	go func() {
+		defer h.harvests.Done()
+		if schedule.coalesce {
+			defer atomic.AddInt32(&h.pendingHarvests, -1)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), h.config.HarvestTimeout)
+		defer cancel()
+		for _, signal := range schedule.signals {
+			if signal == SignalEvents {
+				h.recordHeartbeat(time.Now())
+			}
+		}
+		h.harvestType(ctx, schedule.signals...)
+	}()
+}
 
-	ticker := time.NewTicker(h.config.HarvestPeriod)
-	for range ticker.C {
-		go h.HarvestNow(context.Background())
+// coalesceTick returns true if the harvest of this tick should be skipped
+// because Config.CoalesceRequests is set and the previous harvest is still
+// in progress.
+func (h *Harvester) coalesceTick() bool {
+	if !h.config.CoalesceRequests ||
+		atomic.LoadInt32(&h.pendingHarvests) == 0 ||
+		h.coalescedTicks >= maxCoalescedTicks ||
+		h.bufferedItems() >= maxCoalescedItems {
+		h.coalescedTicks = 0
+		return false
 	}
+	h.coalescedTicks++
+	h.config.logDebug(map[string]interface{}{
+		"event": "harvest coalesced",
+		"ticks": h.coalescedTicks,
+	})
+	return true
+}
+
+// bufferedItems returns the number of spans, metrics, events, and logs
+// buffered.
+func (h *Harvester) bufferedItems() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.rawMetrics) + countSnapshotMetrics(h.metricSnapshots) +
+		len(h.aggregatedMetrics) + len(h.spans) + len(h.events) + len(h.logs)
 }
 
 type metricIdentity struct {
@@ -580,6 +1964,7 @@ type metricHandle struct {
 }
 
 func newMetricHandle(h *Harvester, name string, attributes map[string]interface{}) metricHandle {
+	attributes = h.truncateAttributes(attributes)
 	return metricHandle{
 		harvester: h,
 		metricIdentity: metricIdentity{
@@ -589,6 +1974,115 @@ func newMetricHandle(h *Harvester, name string, attributes map[string]interface{
 	}
 }
 
+// metricsEmpty returns true if no raw, snapshot, or aggregated metrics are
+// buffered.
+// This function assumes the Harvester is locked.
+func (h *Harvester) metricsEmpty() bool {
+	return len(h.rawMetrics) == 0 && len(h.metricSnapshots) == 0 && len(h.aggregatedMetrics) == 0
+}
+
+// bufferedMetrics returns the number of raw and snapshot metrics buffered.
+// Aggregated metrics are not included since their number is bounded by the
+// number of distinct names and attributes.  This function assumes the
+// Harvester is locked.
+func (h *Harvester) bufferedMetrics() int {
+	return len(h.rawMetrics) + countSnapshotMetrics(h.metricSnapshots)
+}
+
+// bufferSpace returns the number of items that can be added to a buffer
+// which contains the given number of items.
+func (h *Harvester) bufferSpace(buffered int) int {
+	if h.config.MaxBufferedPayloads <= 0 {
+		return math.MaxInt32
+	}
+	if space := h.config.MaxBufferedPayloads - buffered; space > 0 {
+		return space
+	}
+	return 0
+}
+
+// bufferDrop counts n items of the signal dropped because the buffer was
+// full and returns n.  This function assumes the Harvester is locked.
+func (h *Harvester) bufferDrop(signal Signal, n int) int {
+	h.bufferDropped[signal] += n
+	atomic.AddInt64(&h.bufferDrops, int64(n))
+	return n
+}
+
+// takeBufferDropped returns and resets the number of items of the signal
+// dropped because the buffer was full.  This function assumes the Harvester
+// is locked.
+func (h *Harvester) takeBufferDropped(signal Signal) int {
+	dropped := h.bufferDropped[signal]
+	h.bufferDropped[signal] = 0
+	return dropped
+}
+
+// logBufferDropped logs the number of items of the signal dropped because
+// the buffer was full.
+func (h *Harvester) logBufferDropped(signal Signal, dropped int) {
+	if dropped > 0 {
+		h.config.logError(map[string]interface{}{
+			"message":   "buffer full, data dropped",
+			"data-type": signal.String(),
+			"count":     dropped,
+		})
+	}
+}
+
+// afterRecord is called without the lock held after data of the signal is
+// recorded.  first is true if the data was added to an empty buffer and full
+// is the number of items dropped because the buffer was full.
+func (h *Harvester) afterRecord(signal Signal, first bool, full int) {
+	h.config.drop(signal, full, DropReasonBufferFull)
+	h.notifyFirstRecord(signal, first)
+}
+
+// Endpoints returns the URL to which the data of each signal is sent, after
+// applying the URL overrides.  Only the scheme and host of an override are
+// used, so this is useful to confirm the routing of data at startup.
+// Config.SpansMirrorURL is not included.
+func (h *Harvester) Endpoints() map[Signal]string {
+	if nil == h {
+		return nil
+	}
+	return map[Signal]string{
+		SignalSpans:   factoryURL(h.spanRequestFactory),
+		SignalMetrics: factoryURL(h.metricRequestFactory),
+		SignalEvents:  factoryURL(h.eventRequestFactory),
+		SignalLogs:    factoryURL(h.logRequestFactory),
+	}
+}
+
+// factoryURL returns the URL of the requests built by a factory created by
+// one of the New*RequestFactory functions.
+func factoryURL(f RequestFactory) string {
+	switch v := f.(type) {
+	case *hashRequestFactory:
+		return v.url().String()
+	case *eventRequestFactory:
+		return v.url().String()
+	}
+	return ""
+}
+
+// BufferDrops returns the total number of spans, metrics, events, and logs
+// dropped because their buffer was full.  See Config.MaxBufferedPayloads.
+func (h *Harvester) BufferDrops() int64 {
+	if nil == h {
+		return 0
+	}
+	return atomic.LoadInt64(&h.bufferDrops)
+}
+
+// notifyFirstRecord calls Config.OnFirstRecord if first is true.  It must be
+// called without the lock held so that the callback may use the Harvester.
+func (h *Harvester) notifyFirstRecord(signal Signal, first bool) {
+	if first && nil != h.config.OnFirstRecord {
+		h.config.OnFirstRecord(signal)
+	}
+}
+
 // findOrCreateMetric finds or creates the metric associated with the given
 // identity.  This function assumes the Harvester is locked.
 func (h *Harvester) findOrCreateMetric(identity metricIdentity) *metric {
@@ -641,6 +2135,13 @@ func (ag *MetricAggregator) Summary(name string, attributes map[string]interface
 	return &AggregatedSummary{metricHandle: newMetricHandle(ag.harvester, name, attributes)}
 }
 
+// RecordDuration records a duration observation to the aggregated Summary
+// metric with the given name and attributes.  It is shorthand for
+// h.MetricAggregator().Summary(name, attributes).RecordDuration(d).
+func (h *Harvester) RecordDuration(name string, attributes map[string]interface{}, d time.Duration) {
+	h.MetricAggregator().Summary(name, attributes).RecordDuration(d)
+}
+
 type cachedMapEntry struct {
 	key  string
 	data json.RawMessage
@@ -5,15 +5,22 @@ package telemetry
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -140,6 +147,9 @@ func TestSanitizeApiKeyForLogging(t *testing.T) {
 	assertEqual("foo", sanitizeAPIKeyForLogging("foo"))
 	assertEqual("foobarba", sanitizeAPIKeyForLogging("foobarbazqux"))
 	assertEqual("eu01xxfoobarba", sanitizeAPIKeyForLogging("eu01xxfoobarbazqux"))
+	// License keys are 40 characters and end in NRAL.
+	assertEqual("01234567", sanitizeAPIKeyForLogging("0123456789abcdef0123456789abcdef0123NRAL"))
+	assertEqual("eu01xx01234567", sanitizeAPIKeyForLogging("eu01xx0123456789abcdef0123456789abcdNRAL"))
 }
 
 func TestHarvesterRecordSpan(t *testing.T) {
@@ -229,14 +239,64 @@ func TestVetCommonAttributes(t *testing.T) {
 	}
 }
 
+func TestCommonAttributesKeepsValidSubset(t *testing.T) {
+	var savedErrors []map[string]interface{}
+	h, _ := NewHarvester(
+		configTesting,
+		configureLoggingErrorsToMap(&savedErrors),
+		ConfigCommonAttributes(map[string]interface{}{
+			"valid-string": "zap",
+			"valid-int":    123,
+			"bad-struct":   struct{}{},
+			"bad-slice":    []string{"a"},
+		}),
+	)
+	if len(savedErrors) != 1 {
+		t.Fatal(savedErrors)
+	}
+	if keys := savedErrors[0]["dropped-keys"]; !reflect.DeepEqual(keys, []string{"bad-slice", "bad-struct"}) {
+		t.Error("incorrect dropped keys", keys)
+	}
+
+	h.RecordMetric(Gauge{Name: "myGauge"})
+	reqs := h.swapOutMetrics(time.Now())
+	if len(reqs) != 1 {
+		t.Fatal(reqs)
+	}
+	bodyReader, _ := reqs[0].GetBody()
+	compressedBytes, _ := ioutil.ReadAll(bodyReader)
+	js, _ := internal.Uncompress(compressedBytes)
+	var batches []struct {
+		Common struct {
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"common"`
+	}
+	if err := json.Unmarshal(js, &batches); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{"valid-string": "zap", "valid-int": 123.0}
+	if attrs := batches[0].Common.Attributes; !reflect.DeepEqual(attrs, expect) {
+		t.Error("incorrect common attributes", attrs)
+	}
+}
+
+func TestCommonAttributesAllInvalid(t *testing.T) {
+	h, _ := NewHarvester(configTesting, ConfigCommonAttributes(map[string]interface{}{
+		"bad": struct{}{},
+	}))
+	if h.commonAttributes != nil {
+		t.Error("no common attributes should be sent", h.commonAttributes)
+	}
+}
+
 func TestHarvestCancelled(t *testing.T) {
-	var errs int
-	var posts int
+	errs := make(chan struct{}, 10)
+	var posts int32
 	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
 		// Test that the context with the deadline is added to the
 		// harvest request.
 		<-r.Context().Done()
-		posts++
+		atomic.AddInt32(&posts, 1)
 
 		// Set a retry after so that the backoff sleep is not zero to
 		// ensure that the Context().Done() select always succeeds.
@@ -248,7 +308,7 @@ func TestHarvestCancelled(t *testing.T) {
 	})
 	h, _ := NewHarvester(func(cfg *Config) {
 		cfg.ErrorLogger = func(e map[string]interface{}) {
-			errs++
+			errs <- struct{}{}
 		}
 		cfg.HarvestPeriod = 0
 		cfg.Client.Transport = rt
@@ -261,11 +321,97 @@ func TestHarvestCancelled(t *testing.T) {
 
 	h.HarvestNow(ctx)
 
-	if posts != 1 {
-		t.Error("incorrect number of tries tried", posts)
+	// HarvestNow returns once the context is done, so wait for the
+	// request goroutine to log its errors.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-errs:
+		case <-time.After(5 * time.Second):
+			t.Fatal("incorrect number of errors logged", i)
+		}
+	}
+	if n := atomic.LoadInt32(&posts); n != 1 {
+		t.Error("incorrect number of tries tried", n)
+	}
+	select {
+	case <-errs:
+		t.Error("too many errors logged")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestHarvestCallback(t *testing.T) {
+	var lock sync.Mutex
+	var results []HarvestResult
+	statuses := map[string][]int{
+		"/trace/v1":           {202},
+		"/v1/accounts/events": {413},
+		"/log/v1":             {500, 202},
+	}
+	h, _ := NewHarvester(configTesting, ConfigHarvestCallback(func(r HarvestResult) {
+		lock.Lock()
+		defer lock.Unlock()
+		results = append(results, r)
+	}), func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			code := statuses[req.URL.Path][0]
+			statuses[req.URL.Path] = statuses[req.URL.Path][1:]
+			return emptyResponse(code), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "1", TraceID: "id"})
+	h.RecordSpan(Span{ID: "2", TraceID: "id"})
+	h.RecordEvent(Event{EventType: "a"})
+	h.RecordEvent(Event{EventType: "b"})
+	h.RecordEvent(Event{EventType: "c"})
+	h.RecordLog(Log{Message: "log"})
+	h.HarvestNow(context.Background())
+
+	if len(results) != 3 {
+		t.Fatal(results)
+	}
+	bySignal := make(map[Signal]HarvestResult)
+	for _, r := range results {
+		if r.Bytes <= 0 || (nil != r.Err) != (r.StatusCode == 413) {
+			t.Error(r)
+		}
+		r.Bytes = 0
+		r.Err = nil
+		bySignal[r.Signal] = r
+	}
+	expect := map[Signal]HarvestResult{
+		SignalSpans:  {Signal: SignalSpans, Items: 2, StatusCode: 202},
+		SignalEvents: {Signal: SignalEvents, Items: 3, StatusCode: 413},
+		SignalLogs:   {Signal: SignalLogs, Items: 1, StatusCode: 202, Retries: 1},
+	}
+	if !reflect.DeepEqual(bySignal, expect) {
+		t.Error(bySignal)
+	}
+}
+
+func TestHarvestCallbackError(t *testing.T) {
+	var results []HarvestResult
+	h, _ := NewHarvester(configTesting, ConfigHarvestCallback(func(r HarvestResult) {
+		results = append(results, r)
+	}), func(cfg *Config) {
+		cfg.RetryBudgetRatio = 0.1
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("connection refused")
+		})
+	})
+	// Exhaust the retry budget so that the request is not retried.
+	for h.retryBudget.allowRetry() {
+	}
+	h.RecordSpan(Span{ID: "1", TraceID: "id"})
+	h.HarvestNow(context.Background())
+
+	if len(results) != 1 {
+		t.Fatal(results)
 	}
-	if errs != 2 {
-		t.Error("incorrect number of errors logged", errs)
+	if r := results[0]; r.Signal != SignalSpans || r.Items != 1 || r.StatusCode != 0 || nil == r.Err || r.Retries != 0 {
+		t.Error(r)
 	}
 }
 
@@ -407,6 +553,102 @@ func TestRecordMetric(t *testing.T) {
 	testHarvesterMetrics(t, h, expect)
 }
 
+func TestRecordGaugeSeries(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	var savedErrors []map[string]interface{}
+	h, _ := NewHarvester(configTesting, configureLoggingErrorsToMap(&savedErrors))
+	h.RecordGaugeSeries("myGauge", map[string]interface{}{"zip": "zap"}, []GaugePoint{
+		{Value: 1, Timestamp: start},
+		{Value: 2, Timestamp: start.Add(time.Second)},
+		{Value: math.NaN(), Timestamp: start.Add(2 * time.Second)},
+		{Value: 3, Timestamp: start.Add(3 * time.Second)},
+	})
+	if len(savedErrors) != 1 {
+		t.Error("invalid point should be logged", savedErrors)
+	}
+	expect := `[
+		{"name":"myGauge","type":"gauge","value":1,"timestamp":1417136460000,"attributes":{"zip":"zap"}},
+		{"name":"myGauge","type":"gauge","value":2,"timestamp":1417136461000,"attributes":{"zip":"zap"}},
+		{"name":"myGauge","type":"gauge","value":3,"timestamp":1417136463000,"attributes":{"zip":"zap"}}
+	]`
+	testHarvesterMetrics(t, h, expect)
+}
+
+func TestRecordGaugeSeriesNilHarvester(t *testing.T) {
+	var h *Harvester
+	h.RecordGaugeSeries("myGauge", nil, []GaugePoint{{Value: 1, Timestamp: time.Now()}})
+}
+
+func TestRecordMetricSnapshot(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting, ConfigCommonAttributes(map[string]interface{}{"zop": "zup"}))
+	h.RecordMetric(Gauge{Name: "other", Value: 1, Timestamp: start})
+	err := h.RecordMetricSnapshot([]Metric{
+		Count{Name: "requests", Value: 10},
+		Summary{Name: "latency", Count: 2, Sum: 3, Min: 1, Max: 2},
+		Gauge{Name: "queue", Value: 5},
+	}, start.Add(time.Minute), 10*time.Second)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	reqs := h.swapOutMetrics(start.Add(2 * time.Minute))
+	if len(reqs) != 1 {
+		t.Fatal(reqs)
+	}
+	bodyReader, _ := reqs[0].GetBody()
+	compressedBytes, _ := ioutil.ReadAll(bodyReader)
+	js, _ := internal.Uncompress(compressedBytes)
+	var batches []struct {
+		Common  json.RawMessage   `json:"common"`
+		Metrics []json.RawMessage `json:"metrics"`
+	}
+	if err := json.Unmarshal(js, &batches); nil != err {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 {
+		t.Fatal(string(js))
+	}
+	if n := len(batches[0].Metrics); n != 1 {
+		t.Error(n)
+	}
+	expect := compactJSONString(`{"timestamp":1417136520000,"interval.ms":10000,"attributes":{"zop":"zup"}}`)
+	if common := string(batches[1].Common); common != expect {
+		t.Error(common)
+	}
+	if n := len(batches[1].Metrics); n != 3 {
+		t.Error(n)
+	}
+}
+
+func TestRecordMetricSnapshotInvalid(t *testing.T) {
+	var savedErrors []map[string]interface{}
+	h, _ := NewHarvester(configTesting, configureLoggingErrorsToMap(&savedErrors))
+	err := h.RecordMetricSnapshot([]Metric{
+		Gauge{Name: "valid", Value: 1},
+		Gauge{Name: "invalid", Value: math.NaN()},
+	}, time.Time{}, 0)
+	if nil == err {
+		t.Error("expected error for invalid metric")
+	}
+	if len(savedErrors) != 1 {
+		t.Error(savedErrors)
+	}
+	if len(h.metricSnapshots) != 1 || len(h.metricSnapshots[0].metrics) != 1 {
+		t.Fatal(h.metricSnapshots)
+	}
+	if h.metricSnapshots[0].timestamp.IsZero() {
+		t.Error("zero snapshot time should be replaced with the current time")
+	}
+}
+
+func TestRecordMetricSnapshotNilHarvester(t *testing.T) {
+	var h *Harvester
+	if err := h.RecordMetricSnapshot([]Metric{Gauge{Name: "g", Value: 1}}, time.Now(), 0); nil != err {
+		t.Error(err)
+	}
+}
+
 func TestReturnCodes(t *testing.T) {
 	// tests which return codes should retry and which should not
 	testcases := []struct {
@@ -512,6 +754,144 @@ func Test429RetryAfterUsesConfig(t *testing.T) {
 	}
 }
 
+func TestPostDataMaxResponseBytes(t *testing.T) {
+	client := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 202,
+				Body:       ioutil.NopCloser(bytes.NewReader(make([]byte, 1000))),
+			}, nil
+		}),
+	}
+	req, _ := http.NewRequest("POST", defaultMetricURL, nil)
+	resp := postData(req, client, 100)
+	if resp.err != nil {
+		t.Fatal(resp.err)
+	}
+	if n := len(resp.body); n != 100 {
+		t.Error(n)
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestPostDataGzipResponse(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(`{"requestId":"id"}`))
+	zw.Close()
+
+	var acceptEncoding string
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			acceptEncoding = req.Header.Get("Accept-Encoding")
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+	h.HarvestNow(context.Background())
+	if acceptEncoding != "" {
+		t.Error(acceptEncoding)
+	}
+
+	body := &closeRecorder{Reader: bytes.NewReader(compressed.Bytes())}
+	client := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 202,
+				Header:     http.Header{"Content-Encoding": []string{"gzip"}},
+				Body:       body,
+			}, nil
+		}),
+	}
+	req, _ := http.NewRequest("POST", defaultMetricURL, nil)
+	resp := postData(req, client, 100)
+	if resp.err != nil {
+		t.Fatal(resp.err)
+	}
+	if string(resp.body) != `{"requestId":"id"}` {
+		t.Error(string(resp.body))
+	}
+	if !body.closed {
+		t.Error("response body not closed")
+	}
+}
+
+func TestPostDataAcceptEncoding(t *testing.T) {
+	var acceptEncoding string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(202)
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(`{"requestId":"id"}`))
+		zw.Close()
+	}))
+	defer srv.Close()
+
+	testcases := []struct {
+		transport      http.RoundTripper
+		acceptEncoding string
+	}{
+		{transport: nil, acceptEncoding: "gzip"},
+		{transport: &http.Transport{}, acceptEncoding: "gzip"},
+		// With compression disabled the Harvester respects the transport.
+		{transport: &http.Transport{DisableCompression: true}, acceptEncoding: ""},
+	}
+	for _, tc := range testcases {
+		acceptEncoding = ""
+		req, _ := http.NewRequest("POST", srv.URL, nil)
+		resp := postData(req, &http.Client{Transport: tc.transport}, 100)
+		if resp.err != nil {
+			t.Fatal(resp.err)
+		}
+		if acceptEncoding != tc.acceptEncoding {
+			t.Error(acceptEncoding, tc.acceptEncoding)
+		}
+		if tc.acceptEncoding != "" && string(resp.body) != `{"requestId":"id"}` {
+			t.Error(string(resp.body))
+		}
+	}
+}
+
+func TestPostDataInvalidGzipResponse(t *testing.T) {
+	client := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 202,
+				Header:     http.Header{"Content-Encoding": []string{"gzip"}},
+				Body:       ioutil.NopCloser(strings.NewReader("not gzip")),
+			}, nil
+		}),
+	}
+	req, _ := http.NewRequest("POST", defaultMetricURL, nil)
+	resp := postData(req, client, 100)
+	if resp.err != nil || len(resp.body) != 0 {
+		t.Error(resp.err, string(resp.body))
+	}
+}
+
+func TestConfigMaxResponseBytes(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	if n := h.config.maxResponseBytes(); n != defaultMaxResponseBytes {
+		t.Error(n)
+	}
+	h, _ = NewHarvester(configTesting, func(cfg *Config) {
+		cfg.MaxResponseBytes = 10
+	})
+	if n := h.config.maxResponseBytes(); n != 10 {
+		t.Error(n)
+	}
+}
+
 func TestResponseNeedsRetry(t *testing.T) {
 	testcases := []struct {
 		attempts      int
@@ -606,7 +986,7 @@ func TestResponseNeedsRetry(t *testing.T) {
 		},
 	}
 
-	h, _ := NewHarvester(configTesting)
+	h, _ := NewHarvester(configTesting, func(cfg *Config) { cfg.DisableJitter = true })
 	for _, test := range testcases {
 		resp := response{
 			statusCode: test.respCode,
@@ -622,6 +1002,104 @@ func TestResponseNeedsRetry(t *testing.T) {
 	}
 }
 
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, time.January, 2, 15, 4, 5, 0, time.UTC)
+	testcases := []struct {
+		retryAfter string
+		expectOK   bool
+		expect     time.Duration
+	}{
+		{retryAfter: "", expectOK: false},
+		{retryAfter: "hello", expectOK: false},
+		{retryAfter: "2", expectOK: true, expect: 2 * time.Second},
+		{retryAfter: "120", expectOK: true, expect: 2 * time.Minute},
+		{retryAfter: "Thu, 02 Jan 2020 15:04:35 GMT", expectOK: true, expect: 30 * time.Second},
+		{retryAfter: "Thursday, 02-Jan-20 15:05:05 GMT", expectOK: true, expect: time.Minute},
+		{retryAfter: "Thu, 02 Jan 2020 15:04:00 GMT", expectOK: true, expect: -5 * time.Second},
+	}
+	for _, test := range testcases {
+		d, ok := parseRetryAfter(test.retryAfter, now)
+		if ok != test.expectOK || d != test.expect {
+			t.Errorf("retryAfter=%q: expect=%v,%t actual=%v,%t",
+				test.retryAfter, test.expect, test.expectOK, d, ok)
+		}
+	}
+}
+
+func TestRetryAfterHTTPDate(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) { cfg.DisableJitter = true })
+
+	resp := response{
+		statusCode: 429,
+		retryAfter: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat),
+	}
+	retry, backoff := resp.needsRetry(&h.config, 1)
+	if !retry || backoff <= 58*time.Second || backoff > time.Minute {
+		t.Error(retry, backoff)
+	}
+
+	// Dates in the past fall back to the default backoff.
+	resp.retryAfter = time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)
+	retry, backoff = resp.needsRetry(&h.config, 1)
+	if !retry || backoff != time.Second {
+		t.Error(retry, backoff)
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	var max int64
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.randInt63n = func(n int64) int64 {
+			if n > max {
+				max = n
+			}
+			return 0
+		}
+	})
+	resp := response{statusCode: 500}
+	retry, backoff := resp.needsRetry(&h.config, 3)
+	if !retry || backoff != 2*time.Second {
+		t.Error(retry, backoff)
+	}
+	if max != int64(2*time.Second)+1 {
+		t.Error(max)
+	}
+
+	h.config.randInt63n = func(n int64) int64 { return n - 1 }
+	if retry, backoff := resp.needsRetry(&h.config, 3); !retry || backoff != 4*time.Second {
+		t.Error(retry, backoff)
+	}
+	// No retry is delayed before the first one.
+	if retry, backoff := resp.needsRetry(&h.config, 0); !retry || backoff != 0 {
+		t.Error(retry, backoff)
+	}
+
+	// A longer Retry-After header is not jittered.
+	h.config.randInt63n = func(n int64) int64 { return 0 }
+	resp = response{statusCode: 429, retryAfter: "3"}
+	if retry, backoff := resp.needsRetry(&h.config, 1); !retry || backoff != 3*time.Second {
+		t.Error(retry, backoff)
+	}
+
+	// Custom policies are not jittered.
+	h.config.RetryPolicy = DefaultRetryPolicy{}
+	resp = response{statusCode: 500}
+	if retry, backoff := resp.needsRetry(&h.config, 3); !retry || backoff != 4*time.Second {
+		t.Error(retry, backoff)
+	}
+}
+
+func TestRetryJitterRandom(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	resp := response{statusCode: 500}
+	for i := 0; i < 100; i++ {
+		retry, backoff := resp.needsRetry(&h.config, 4)
+		if !retry || backoff < 4*time.Second || backoff > 8*time.Second {
+			t.Fatal(retry, backoff)
+		}
+	}
+}
+
 func TestNoDataNoHarvest(t *testing.T) {
 	roundTripper := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
 		t.Error("harvest should not have been run")
@@ -655,7 +1133,9 @@ func TestNewRequestErrorNoPost(t *testing.T) {
 
 func TestRecordMetricNil(t *testing.T) {
 	var h *Harvester
-	h.RecordMetric(Count{})
+	if err := h.RecordMetric(Count{}); err != nil {
+		t.Error(err)
+	}
 }
 
 func TestRecordSpanZeroTimestamp(t *testing.T) {
@@ -701,6 +1181,87 @@ func TestHarvestAuditLog(t *testing.T) {
 	}
 }
 
+func TestHarvestAuditBodySink(t *testing.T) {
+	roundTripper := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return emptyResponse(200), nil
+	})
+
+	var audit map[string]interface{}
+	bodies := make(map[string][]byte)
+
+	h, _ := NewHarvester(func(cfg *Config) {
+		cfg.HarvestPeriod = 0
+		cfg.APIKey = "APIKey"
+		cfg.Client.Transport = roundTripper
+		cfg.AuditLogger = func(fields map[string]interface{}) {
+			audit = fields
+		}
+		cfg.AuditBodySink = func(id string, body []byte) {
+			bodies[id] = body
+		}
+	})
+	message := strings.Repeat("a", 10000)
+	h.RecordLog(Log{Message: message})
+	h.HarvestNow(context.Background())
+	if _, ok := audit["data"]; ok {
+		t.Error("body should not be logged inline", audit)
+	}
+	id, _ := audit["body-id"].(string)
+	body, ok := bodies[id]
+	if !ok || len(bodies) != 1 {
+		t.Fatal(audit, bodies)
+	}
+	var payload []struct {
+		Logs []struct {
+			Message string `json:"message"`
+		} `json:"logs"`
+	}
+	if err := json.Unmarshal(body, &payload); nil != err {
+		t.Fatal(err)
+	}
+	if len(payload) != 1 || len(payload[0].Logs) != 1 || payload[0].Logs[0].Message != message {
+		t.Error(string(body))
+	}
+}
+
+func TestHarvestAuditBodyCompressed(t *testing.T) {
+	roundTripper := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return emptyResponse(200), nil
+	})
+
+	var audit map[string]interface{}
+
+	h, _ := NewHarvester(func(cfg *Config) {
+		cfg.HarvestPeriod = 0
+		cfg.APIKey = "APIKey"
+		cfg.Client.Transport = roundTripper
+		cfg.AuditBodyCompressed = true
+		cfg.AuditLogger = func(fields map[string]interface{}) {
+			audit = fields
+		}
+	})
+	h.RecordMetric(Count{})
+	h.HarvestNow(context.Background())
+	if _, ok := audit["data"]; ok {
+		t.Error("body should not be logged uncompressed", audit)
+	}
+	if e := audit["content-encoding"]; e != "gzip" {
+		t.Error(e)
+	}
+	encoded, _ := audit["data-compressed"].(string)
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if nil != err {
+		t.Fatal(err)
+	}
+	body, err := internal.Uncompress(compressed)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"metrics":[{"name":"","type":"count","value":0}]`) {
+		t.Error(string(body))
+	}
+}
+
 func TestRequiredSpanFields(t *testing.T) {
 	h, _ := NewHarvester(configTesting)
 	if err := h.RecordSpan(Span{ID: "12345"}); err != errTraceIDUnset {
@@ -714,10 +1275,13 @@ func TestRequiredSpanFields(t *testing.T) {
 func TestRecordInvalidMetric(t *testing.T) {
 	var savedErrors []map[string]interface{}
 	h, _ := NewHarvester(configTesting, configureLoggingErrorsToMap(&savedErrors))
-	h.RecordMetric(Count{
+	err := h.RecordMetric(Count{
 		Name:  "bad-metric",
 		Value: math.NaN(),
 	})
+	if err == nil {
+		t.Error("expected an error recording a NaN metric")
+	}
 	if len(savedErrors) != 1 || !reflect.DeepEqual(savedErrors[0], map[string]interface{}{
 		"err":     errFloatNaN.Error(),
 		"message": "invalid count value",
@@ -815,3 +1379,1114 @@ func BenchmarkRetryBody1(b *testing.B) { benchmarkRetryBodyN(b, 1) }
 func BenchmarkRetryBody2(b *testing.B) { benchmarkRetryBodyN(b, 2) }
 func BenchmarkRetryBody4(b *testing.B) { benchmarkRetryBodyN(b, 4) }
 func BenchmarkRetryBody8(b *testing.B) { benchmarkRetryBodyN(b, 8) }
+
+func TestHarvestHeartbeat(t *testing.T) {
+	var heartbeats int
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.String() == defaultEventURL {
+			compressedBytes, _ := ioutil.ReadAll(req.Body)
+			js, _ := internal.Uncompress(compressedBytes)
+			var events []map[string]interface{}
+			if err := json.Unmarshal(js, &events); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range events {
+				if e["eventType"] != "MyHeartbeat" {
+					continue
+				}
+				heartbeats++
+				if e["version"] != version {
+					t.Error("incorrect heartbeat version", e["version"])
+				}
+				if _, ok := e["uptime.seconds"].(float64); !ok {
+					t.Error("missing heartbeat uptime", e)
+				}
+			}
+		}
+		return emptyResponse(202), nil
+	})
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Client.Transport = rt
+		cfg.HeartbeatEventType = "MyHeartbeat"
+	})
+
+	h.HarvestNow(context.Background())
+	if heartbeats != 1 {
+		t.Error("incorrect number of heartbeats", heartbeats)
+	}
+	h.HarvestNow(context.Background())
+	if heartbeats != 2 {
+		t.Error("incorrect number of heartbeats", heartbeats)
+	}
+}
+
+func TestHarvestNoHeartbeatByDefault(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	h.recordHeartbeat(time.Now())
+	if len(h.events) != 0 {
+		t.Error(h.events)
+	}
+}
+
+func TestHarvestDebugLogsSwappedOutCounts(t *testing.T) {
+	var swapped map[string]interface{}
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(202), nil
+		})
+		cfg.DebugLogger = func(fields map[string]interface{}) {
+			if fields["event"] == "harvest data swapped out" {
+				swapped = fields
+			}
+		}
+	})
+	h.RecordMetric(Count{})
+	h.RecordMetric(Gauge{})
+	h.MetricAggregator().Count("myCount", nil).Increment()
+	h.RecordSpan(Span{TraceID: "id", ID: "id"})
+	h.RecordEvent(Event{EventType: "myEvent"})
+	h.RecordEvent(Event{EventType: "myEvent"})
+	h.RecordLog(Log{Message: "one"})
+	h.RecordLog(Log{Message: "two"})
+	h.RecordLog(Log{Message: "three"})
+	h.HarvestNow(context.Background())
+
+	expect := map[string]interface{}{
+		"event":   "harvest data swapped out",
+		"metrics": 3,
+		"spans":   1,
+		"events":  2,
+		"logs":    3,
+	}
+	if !reflect.DeepEqual(expect, swapped) {
+		t.Error(swapped)
+	}
+}
+
+func TestMaxDataAgeDropsStaleData(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	var savedErrors []map[string]interface{}
+	h, _ := NewHarvester(configTesting, configureLoggingErrorsToMap(&savedErrors), func(cfg *Config) {
+		cfg.MaxDataAge = time.Hour
+	})
+
+	h.RecordMetric(Gauge{Name: "old", Timestamp: old})
+	h.RecordMetric(Gauge{Name: "new", Timestamp: now})
+	h.RecordMetric(Count{Name: "unset"})
+	h.RecordSpan(Span{TraceID: "id", ID: "old", Timestamp: old})
+	h.RecordSpan(Span{TraceID: "id", ID: "new", Timestamp: now})
+	h.RecordEvent(Event{EventType: "old", Timestamp: old})
+	h.RecordEvent(Event{EventType: "new", Timestamp: now})
+	h.RecordLog(Log{Message: "old", Timestamp: old})
+
+	metrics, _ := h.takeMetrics(now)
+	if len(metrics) != 2 {
+		t.Error("incorrect number of metrics kept", metrics)
+	}
+	if spans := h.takeSpans(); len(spans) != 1 || spans[0].ID != "new" {
+		t.Error("incorrect spans kept", spans)
+	}
+	if events := h.takeEvents(); len(events) != 1 || events[0].EventType != "new" {
+		t.Error("incorrect events kept", events)
+	}
+	if logs := h.takeLogs(); len(logs) != 0 {
+		t.Error("incorrect logs kept", logs)
+	}
+	if reqs := h.logRequests(nil); reqs != nil {
+		t.Error(reqs)
+	}
+
+	if len(savedErrors) != 4 {
+		t.Fatal(savedErrors)
+	}
+	for _, e := range savedErrors {
+		if e["count"] != 1 {
+			t.Error(e)
+		}
+	}
+}
+
+func TestMaxDataAgeUnset(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	h.RecordSpan(Span{TraceID: "id", ID: "id", Timestamp: time.Now().Add(-24 * time.Hour)})
+	if spans := h.takeSpans(); len(spans) != 1 {
+		t.Error(spans)
+	}
+}
+
+func TestClampFutureTimestamps(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	nearFuture := time.Now().Add(10 * time.Second)
+	var savedErrors []map[string]interface{}
+	h, _ := NewHarvester(configTesting, configureLoggingErrorsToMap(&savedErrors), func(cfg *Config) {
+		cfg.ClampFutureTimestamps = true
+		cfg.FutureTimestampSkew = time.Minute
+	})
+
+	h.RecordMetric(Gauge{Name: "future", Timestamp: future})
+	h.MetricAggregator().Gauge("aggregated", nil).valueNow(1, future)
+	h.RecordMetric(Gauge{Name: "nearFuture", Timestamp: nearFuture})
+	h.RecordSpan(Span{TraceID: "id", ID: "id", Timestamp: future})
+	h.RecordEvent(Event{EventType: "event", Timestamp: future})
+	h.RecordLog(Log{Message: "log", Timestamp: nearFuture})
+
+	before := time.Now()
+	metrics, _ := h.takeMetrics(time.Now())
+	for _, m := range metrics {
+		ts := m.timestamp()
+		if g, ok := m.(Gauge); ok && g.Name == "nearFuture" {
+			if !ts.Equal(nearFuture) {
+				t.Error("timestamp within skew should not be clamped", ts)
+			}
+			continue
+		}
+		if ts.After(time.Now()) || ts.Before(before) {
+			t.Error("metric timestamp not clamped", m)
+		}
+	}
+	if spans := h.takeSpans(); spans[0].Timestamp.After(time.Now()) {
+		t.Error("span timestamp not clamped", spans[0].Timestamp)
+	}
+	if events := h.takeEvents(); events[0].Timestamp.After(time.Now()) {
+		t.Error("event timestamp not clamped", events[0].Timestamp)
+	}
+	if logs := h.takeLogs(); !logs[0].Timestamp.Equal(nearFuture) {
+		t.Error("log timestamp within skew should not be clamped", logs[0].Timestamp)
+	}
+
+	if len(savedErrors) != 3 {
+		t.Fatal(savedErrors)
+	}
+	if savedErrors[0]["message"] != "clamping future timestamps to now" || savedErrors[0]["count"] != 2 {
+		t.Error(savedErrors[0])
+	}
+}
+
+func TestFutureTimestampsNotClampedByDefault(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	h, _ := NewHarvester(configTesting)
+	h.RecordSpan(Span{TraceID: "id", ID: "id", Timestamp: future})
+	if spans := h.takeSpans(); !spans[0].Timestamp.Equal(future) {
+		t.Error(spans[0].Timestamp)
+	}
+}
+
+func TestPayloadWarnBytes(t *testing.T) {
+	var savedErrors []map[string]interface{}
+	h, _ := NewHarvester(configTesting, configureLoggingErrorsToMap(&savedErrors), func(cfg *Config) {
+		cfg.PayloadWarnBytes = 1000
+		// Keep the large attribute value below from being truncated.
+		cfg.MaxAttributeValueLength = 0
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(202), nil
+		})
+	})
+	// Both requests exceed the threshold but are well under the split
+	// limit, and only one warning should be logged.
+	h.RecordEvent(Event{EventType: "large", Attributes: map[string]interface{}{
+		"payload": string(randomJSON(5000)),
+	}})
+	h.RecordLog(Log{Message: string(randomJSON(5000))})
+	h.RecordSpan(Span{ID: "id", TraceID: "id"})
+	h.HarvestNow(context.Background())
+
+	if len(savedErrors) != 1 {
+		t.Fatal(savedErrors)
+	}
+	if msg := savedErrors[0]["message"]; msg != "request payload exceeds warning threshold" {
+		t.Error(msg)
+	}
+	if n := savedErrors[0]["requests"]; n != 2 {
+		t.Error("incorrect number of large requests", n)
+	}
+	if size := savedErrors[0]["largest-bytes"].(int64); size <= 1000 || size >= maxCompressedSizeBytes {
+		t.Error("incorrect largest size", size)
+	}
+}
+
+func TestPayloadSizeObserver(t *testing.T) {
+	type sizes struct{ uncompressed, compressed int }
+	var lock sync.Mutex
+	sent := make(map[string]sizes)
+	observed := make(map[string]sizes)
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.PayloadSizeObserver = func(dataType string, uncompressed, compressed int) {
+			observed[dataType] = sizes{uncompressed: uncompressed, compressed: compressed}
+		}
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			compressed, _ := ioutil.ReadAll(req.Body)
+			uncompressed, _ := internal.Uncompress(compressed)
+			lock.Lock()
+			defer lock.Unlock()
+			sent[req.URL.String()] = sizes{uncompressed: len(uncompressed), compressed: len(compressed)}
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+	h.RecordLog(Log{Message: string(randomJSON(5000))})
+	h.HarvestNow(context.Background())
+
+	expect := map[string]sizes{
+		"spans": sent[defaultSpanURL],
+		"logs":  sent[defaultLogURL],
+	}
+	if !reflect.DeepEqual(observed, expect) {
+		t.Error(observed, expect)
+	}
+	if observed["logs"].uncompressed <= 5000 {
+		t.Error(observed["logs"])
+	}
+}
+
+func TestUncompressedSizeUnknown(t *testing.T) {
+	req, _ := http.NewRequest("POST", defaultSpanURL, strings.NewReader("[]"))
+	if n := uncompressedSize(req); n != -1 {
+		t.Error(n)
+	}
+}
+
+func TestPayloadWarnBytesUnset(t *testing.T) {
+	var savedErrors []map[string]interface{}
+	h, _ := NewHarvester(configTesting, configureLoggingErrorsToMap(&savedErrors), func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordLog(Log{Message: string(randomJSON(5000))})
+	h.HarvestNow(context.Background())
+	if len(savedErrors) != 0 {
+		t.Error(savedErrors)
+	}
+}
+
+func TestHarvestRoutineDisableJitter(t *testing.T) {
+	posts := make(chan time.Time, 10)
+	start := time.Now()
+	h, _ := NewHarvester(func(cfg *Config) {
+		cfg.APIKey = "api-key"
+		cfg.HarvestPeriod = 100 * time.Millisecond
+		cfg.DisableJitter = true
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			posts <- time.Now()
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "id"})
+
+	select {
+	case harvested := <-posts:
+		if d := harvested.Sub(start); d > time.Second {
+			t.Error("first harvest was delayed", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no harvest occurred")
+	}
+}
+
+func TestHarvestRoutineTypeHarvestPeriod(t *testing.T) {
+	posts := make(chan string, 10)
+	h, _ := NewHarvester(
+		ConfigAPIKey("api-key"),
+		ConfigHarvestPeriod(time.Hour),
+		ConfigLogsHarvestPeriod(10*time.Millisecond),
+		func(cfg *Config) {
+			cfg.DisableJitter = true
+			cfg.HeartbeatEventType = "Heartbeat"
+			cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				posts <- req.URL.Path
+				return emptyResponse(202), nil
+			})
+		})
+	defer h.Shutdown(context.Background())
+	h.RecordSpan(Span{ID: "id", TraceID: "id"})
+	h.RecordLog(Log{Message: "log"})
+
+	select {
+	case path := <-posts:
+		if path != "/log/v1" {
+			t.Error(path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("logs were not harvested")
+	}
+	h.harvests.Wait()
+
+	// Only logs are harvested, and the heartbeat is not recorded since
+	// events are not.
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.spans) != 1 || len(h.events) != 0 {
+		t.Error(h.spans, h.events)
+	}
+}
+
+func TestHarvestRoutineOnlyTypeHarvestPeriod(t *testing.T) {
+	// A type harvest period starts the harvest goroutine even if
+	// HarvestPeriod is zero.
+	h, _ := NewHarvester(configTesting, ConfigEventsHarvestPeriod(time.Hour))
+	if nil == h.routineDone {
+		t.Fatal("harvest goroutine not started")
+	}
+	if err := h.Shutdown(context.Background()); nil != err {
+		t.Error(err)
+	}
+}
+
+func TestHarvestTypeCorrelatesLogsAndSpans(t *testing.T) {
+	var bodies []string
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.CorrelateLogsAndSpans = true
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/log/v1" {
+				compressed, _ := ioutil.ReadAll(req.Body)
+				body, _ := internal.Uncompress(compressed)
+				bodies = append(bodies, string(body))
+			}
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "span-id", TraceID: "trace-id"})
+	h.RecordLog(Log{Message: "log", Attributes: map[string]interface{}{"trace.id": "trace-id"}})
+	h.harvestType(context.Background(), SignalLogs, SignalSpans)
+	if len(bodies) != 1 || !strings.Contains(bodies[0], `"span.id":"span-id"`) {
+		t.Error(bodies)
+	}
+}
+
+func TestHarvestNowContextCancelled(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		// The transport ignores the request's context, like a slow
+		// transport that does not notice cancellation.
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			close(started)
+			<-release
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "id"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	returned := make(chan struct{})
+	go func() {
+		h.HarvestNow(ctx)
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("HarvestNow did not return after the context was cancelled")
+	}
+}
+
+func TestHarvestSignal(t *testing.T) {
+	var lock sync.Mutex
+	var paths []string
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			paths = append(paths, req.URL.Path)
+			lock.Unlock()
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "id"})
+	h.RecordMetric(Gauge{Name: "gauge", Timestamp: time.Now()})
+	h.RecordEvent(Event{EventType: "event"})
+	h.RecordLog(Log{Message: "log"})
+
+	h.HarvestSignal(context.Background(), SignalLogs)
+	if !reflect.DeepEqual(paths, []string{"/log/v1"}) {
+		t.Error("only logs should be sent", paths)
+	}
+	if len(h.logs) != 0 || len(h.spans) != 1 || len(h.rawMetrics) != 1 || len(h.events) != 1 {
+		t.Error("other signals should remain buffered")
+	}
+
+	paths = nil
+	h.HarvestSignal(context.Background(), SignalMetrics)
+	h.HarvestSignal(context.Background(), SignalSpans)
+	h.HarvestSignal(context.Background(), SignalEvents)
+	if !reflect.DeepEqual(paths, []string{"/metric/v1", "/trace/v1", "/v1/accounts/events"}) {
+		t.Error(paths)
+	}
+}
+
+func TestHarvestSignalUnknown(t *testing.T) {
+	var savedErrors []map[string]interface{}
+	h, _ := NewHarvester(configTesting, configureLoggingErrorsToMap(&savedErrors))
+	h.RecordLog(Log{Message: "log"})
+	h.HarvestSignal(context.Background(), Signal(42))
+	if len(savedErrors) != 1 || len(h.logs) != 1 {
+		t.Error(savedErrors, h.logs)
+	}
+}
+
+func TestHarvestSignalNilHarvester(t *testing.T) {
+	var h *Harvester
+	h.HarvestSignal(context.Background(), SignalLogs)
+}
+
+func TestRequestInterceptor(t *testing.T) {
+	var lock sync.Mutex
+	var sent []string
+	var intercepted []string
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			sent = append(sent, req.URL.Host+" "+req.Header.Get("X-Test"))
+			return emptyResponse(202), nil
+		})
+		cfg.RequestInterceptor = func(reqs []*http.Request) []*http.Request {
+			var kept []*http.Request
+			for _, req := range reqs {
+				intercepted = append(intercepted, req.URL.Host)
+				if req.URL.Host == "insights-collector.newrelic.com" {
+					continue
+				}
+				req.Header.Set("X-Test", "annotated")
+				kept = append(kept, req)
+			}
+			return kept
+		}
+	})
+	h.RecordMetric(Gauge{Name: "gauge", Timestamp: time.Now()})
+	h.RecordSpan(Span{ID: "id", TraceID: "traceid", Timestamp: time.Now()})
+	h.RecordEvent(Event{EventType: "testEvent", Timestamp: time.Now()})
+	h.RecordLog(Log{Message: "message", Timestamp: time.Now()})
+	h.HarvestNow(context.Background())
+
+	expectIntercepted := []string{
+		"metric-api.newrelic.com",
+		"trace-api.newrelic.com",
+		"insights-collector.newrelic.com",
+		"log-api.newrelic.com",
+	}
+	if !reflect.DeepEqual(intercepted, expectIntercepted) {
+		t.Error(intercepted)
+	}
+	sort.Strings(sent)
+	expectSent := []string{
+		"log-api.newrelic.com annotated",
+		"metric-api.newrelic.com annotated",
+		"trace-api.newrelic.com annotated",
+	}
+	if !reflect.DeepEqual(sent, expectSent) {
+		t.Error(sent)
+	}
+}
+
+func TestHarvesterClone(t *testing.T) {
+	h, _ := NewHarvester(configTesting, ConfigCommonAttributes(map[string]interface{}{"service": "app"}))
+	db, err := h.Clone(func(cfg *Config) {
+		cfg.CommonAttributes["subsystem"] = "db"
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	cache, err := h.Clone(ConfigCommonAttributes(map[string]interface{}{"subsystem": "cache"}))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if db.config.Client != h.config.Client || cache.config.Client != h.config.Client {
+		t.Error("client should be shared")
+	}
+
+	now := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h.RecordEvent(Event{EventType: "base", Timestamp: now})
+	db.RecordEvent(Event{EventType: "db", Timestamp: now})
+
+	for _, tc := range []struct {
+		h          *Harvester
+		common     string
+		eventTypes []string
+	}{
+		{h: h, common: `{"service":"app"}`, eventTypes: []string{"base"}},
+		{h: db, common: `{"service":"app","subsystem":"db"}`, eventTypes: []string{"db"}},
+		{h: cache, common: `{"subsystem":"cache"}`},
+	} {
+		// The order of the common attributes is not fixed.
+		var common, expectCommon map[string]interface{}
+		json.Unmarshal(tc.h.commonAttributes.data, &common)
+		json.Unmarshal([]byte(tc.common), &expectCommon)
+		if !reflect.DeepEqual(common, expectCommon) {
+			t.Error(string(tc.h.commonAttributes.data), tc.common)
+		}
+		var eventTypes []string
+		for _, e := range tc.h.takeEvents() {
+			eventTypes = append(eventTypes, e.EventType)
+		}
+		if !reflect.DeepEqual(eventTypes, tc.eventTypes) {
+			t.Error(eventTypes, tc.eventTypes)
+		}
+	}
+}
+
+func TestNilHarvesterClone(t *testing.T) {
+	var h *Harvester
+	if clone, err := h.Clone(configTesting); nil != clone || err != errNilHarvester {
+		t.Error(clone, err)
+	}
+}
+
+func TestOnFirstRecord(t *testing.T) {
+	var signals []Signal
+	var h *Harvester
+	h, _ = NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(202), nil
+		})
+		cfg.OnFirstRecord = func(signal Signal) {
+			signals = append(signals, signal)
+			// The lock is not held, so the Harvester may be used.
+			h.RecordEvent(Event{EventType: "callback"})
+		}
+	})
+	expect := func(expect ...Signal) {
+		t.Helper()
+		if !reflect.DeepEqual(signals, expect) {
+			t.Errorf("expect=%v actual=%v", expect, signals)
+		}
+		signals = nil
+	}
+
+	h.RecordSpan(Span{ID: "1", TraceID: "trace"})
+	h.RecordSpan(Span{ID: "2", TraceID: "trace"})
+	h.MetricAggregator().Count("count", nil).Increment()
+	h.RecordMetric(Gauge{Name: "gauge", Timestamp: time.Now()})
+	h.RecordLog(Log{Message: "message"})
+	// The event recorded by the callback was the first event.
+	h.RecordEvent(Event{EventType: "event"})
+	expect(SignalSpans, SignalEvents, SignalMetrics, SignalLogs)
+
+	// Invalid data is not recorded.
+	h.HarvestSignal(context.Background(), SignalSpans)
+	h.RecordSpan(Span{ID: "3"})
+	expect()
+
+	h.RecordSpan(Span{ID: "3", TraceID: "trace"})
+	h.MetricAggregator().Summary("summary", nil).Record(1)
+	expect(SignalSpans)
+
+	h.HarvestNow(context.Background())
+	h.MetricAggregator().Gauge("gauge", nil).Value(1)
+	h.RecordSpan(Span{ID: "4", TraceID: "trace"})
+	expect(SignalMetrics, SignalEvents, SignalSpans)
+}
+
+func TestHarvesterShutdown(t *testing.T) {
+	var posts int32
+	h, _ := NewHarvester(func(cfg *Config) {
+		cfg.APIKey = "api-key"
+		cfg.HarvestPeriod = time.Hour
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&posts, 1)
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "id"})
+	h.RecordLog(Log{Message: "log"})
+
+	if err := h.Shutdown(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&posts); n != 2 {
+		t.Error("buffered data not sent", n)
+	}
+	select {
+	case <-h.routineDone:
+	default:
+		t.Error("harvest goroutine did not exit")
+	}
+
+	h.RecordSpan(Span{ID: "id", TraceID: "id"})
+	if err := h.Shutdown(context.Background()); nil != err {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt32(&posts); n != 2 {
+		t.Error("second Shutdown should not harvest", n)
+	}
+}
+
+func TestHarvesterShutdownContextExpired(t *testing.T) {
+	h, _ := NewHarvester(func(cfg *Config) {
+		cfg.APIKey = "api-key"
+		cfg.HarvestPeriod = 0
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "id"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+}
+
+func TestHarvesterShutdownNilHarvester(t *testing.T) {
+	var h *Harvester
+	if err := h.Shutdown(context.Background()); nil != err {
+		t.Error(err)
+	}
+}
+
+func TestHarvesterCoalesceRequests(t *testing.T) {
+	for _, tc := range []struct {
+		coalesce bool
+		requests int
+	}{
+		{coalesce: false, requests: 3},
+		{coalesce: true, requests: 2},
+	} {
+		received := make(chan struct{}, 10)
+		release := make(chan struct{})
+		var posts int32
+		h, _ := NewHarvester(configTesting, func(cfg *Config) {
+			cfg.CoalesceRequests = tc.coalesce
+			cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				received <- struct{}{}
+				if atomic.AddInt32(&posts, 1) == 1 {
+					<-release
+				}
+				return emptyResponse(202), nil
+			})
+		})
+
+		// The first harvest blocks until released so that the ticks
+		// after it occur while it is in progress.
+		schedule := harvestSchedule{signals: allSignals, coalesce: true}
+		h.RecordSpan(Span{ID: "1", TraceID: "id"})
+		h.harvestTick(schedule)
+		<-received
+		h.RecordSpan(Span{ID: "2", TraceID: "id"})
+		h.harvestTick(schedule)
+		if !tc.coalesce {
+			<-received
+		}
+		h.RecordSpan(Span{ID: "3", TraceID: "id"})
+		h.harvestTick(schedule)
+		if !tc.coalesce {
+			<-received
+		}
+		close(release)
+		h.harvests.Wait()
+		h.harvestTick(schedule)
+		h.harvests.Wait()
+
+		if n := atomic.LoadInt32(&posts); int(n) != tc.requests {
+			t.Errorf("coalesce=%v: expected %d requests, got %d", tc.coalesce, tc.requests, n)
+		}
+		if len(h.spans) != 0 {
+			t.Error("spans not sent", h.spans)
+		}
+	}
+}
+
+func TestHarvesterCoalesceRequestsMaxTicks(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.CoalesceRequests = true
+	})
+	atomic.AddInt32(&h.pendingHarvests, 1)
+	for i := 0; i < maxCoalescedTicks; i++ {
+		if !h.coalesceTick() {
+			t.Fatal("tick not coalesced", i)
+		}
+	}
+	if h.coalesceTick() {
+		t.Error("tick coalesced after max ticks")
+	}
+	if !h.coalesceTick() {
+		t.Error("coalesced ticks not reset")
+	}
+	for i := 0; i < maxCoalescedItems; i++ {
+		h.RecordEvent(Event{EventType: "event"})
+	}
+	if h.coalesceTick() {
+		t.Error("tick coalesced with max items buffered")
+	}
+}
+
+func TestTrackQueueLatency(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.TrackQueueLatency = true
+	})
+	attrs := map[string]interface{}{"zip": "zap"}
+	h.RecordSpan(Span{ID: "id", TraceID: "tid", Attributes: attrs})
+	h.RecordEvent(Event{EventType: "MyEvent"})
+	h.RecordEvent(Event{EventType: "MyEvent", AttributesJSON: json.RawMessage(`{"zip":"zap"}`)})
+	h.RecordLog(Log{Message: "hello", Attributes: attrs})
+	time.Sleep(20 * time.Millisecond)
+
+	spans := h.takeSpans()
+	events := h.takeEvents()
+	logs := h.takeLogs()
+	if len(spans) != 1 || len(events) != 2 || len(logs) != 1 {
+		t.Fatal(spans, events, logs)
+	}
+	// Adding Attributes would prevent AttributesJSON from being sent.
+	if nil != events[1].Attributes {
+		t.Error(events[1].Attributes)
+	}
+	for _, attributes := range []map[string]interface{}{spans[0].Attributes, events[0].Attributes, logs[0].Attributes} {
+		if wait, ok := attributes[queueWaitAttribute].(float64); !ok || wait < 20 {
+			t.Error(attributes)
+		}
+	}
+	if spans[0].Attributes["zip"] != "zap" {
+		t.Error(spans[0].Attributes)
+	}
+	if len(attrs) != 1 {
+		t.Error("caller's attributes modified", attrs)
+	}
+}
+
+func TestTrackQueueLatencyDisabled(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+	h.RecordLog(Log{Message: "hello"})
+	if spans := h.takeSpans(); nil != spans[0].Attributes {
+		t.Error(spans[0].Attributes)
+	}
+	if logs := h.takeLogs(); nil != logs[0].Attributes {
+		t.Error(logs[0].Attributes)
+	}
+}
+
+func TestMaxRequestsPerHarvest(t *testing.T) {
+	var lock sync.Mutex
+	var sent []string
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.MaxRequestsPerHarvest = 2
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			sent = append(sent, req.URL.String())
+			return emptyResponse(202), nil
+		})
+	})
+	record := func() {
+		h.RecordMetric(Gauge{Name: "gauge", Value: 1})
+		h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+		h.RecordEvent(Event{EventType: "MyEvent"})
+		h.RecordLog(Log{Message: "message"})
+	}
+	harvest := func(expect ...string) {
+		t.Helper()
+		lock.Lock()
+		sent = nil
+		lock.Unlock()
+		h.HarvestNow(context.Background())
+		sort.Strings(sent)
+		sort.Strings(expect)
+		if !reflect.DeepEqual(sent, expect) {
+			t.Error(sent, expect)
+		}
+	}
+
+	record()
+	harvest(defaultMetricURL, defaultSpanURL)
+	harvest(defaultEventURL, defaultLogURL)
+	harvest()
+
+	// Deferred requests are sent before the requests of later harvests.
+	record()
+	record()
+	harvest(defaultMetricURL, defaultSpanURL)
+	harvest(defaultEventURL, defaultLogURL)
+
+	// The harvest in Shutdown sends all deferred requests.
+	record()
+	harvest(defaultMetricURL, defaultSpanURL)
+	lock.Lock()
+	sent = nil
+	lock.Unlock()
+	h.Shutdown(context.Background())
+	sort.Strings(sent)
+	if expect := []string{defaultEventURL, defaultLogURL}; !reflect.DeepEqual(sent, expect) {
+		t.Error(sent, expect)
+	}
+}
+
+func TestMaxRequestsPerHarvestSignal(t *testing.T) {
+	var sent []string
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.MaxRequestsPerHarvest = 1
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent = append(sent, req.URL.String())
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+	h.RecordLog(Log{Message: "message"})
+	h.HarvestNow(context.Background())
+	// Only the deferred requests of the harvested signal are sent.
+	h.HarvestSignal(context.Background(), SignalEvents)
+	h.HarvestSignal(context.Background(), SignalLogs)
+	if expect := []string{defaultSpanURL, defaultLogURL}; !reflect.DeepEqual(sent, expect) {
+		t.Error(sent, expect)
+	}
+}
+
+func TestMaxRequestsPerHarvestDeferredLimit(t *testing.T) {
+	var lock sync.Mutex
+	var drops []dropRecord
+	var sent int
+	h, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops), func(cfg *Config) {
+		cfg.MaxRequestsPerHarvest = 1
+		cfg.MaxBufferedPayloads = 1
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			sent++
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+	h.RecordEvent(Event{EventType: "MyEvent"})
+	h.RecordLog(Log{Message: "message"})
+	h.HarvestNow(context.Background())
+
+	lock.Lock()
+	defer lock.Unlock()
+	if sent != 1 || len(h.deferredRequests) != 1 {
+		t.Error(sent, len(h.deferredRequests))
+	}
+	if len(drops) != 1 || drops[0].count != 1 || drops[0].reason != DropReasonBufferFull {
+		t.Error(drops)
+	}
+}
+
+func TestMaxDeferredRequests(t *testing.T) {
+	cfg := Config{MaxRequestsPerHarvest: 3}
+	if n := cfg.maxDeferredRequests(); n != 30 {
+		t.Error(n)
+	}
+	cfg.MaxBufferedPayloads = 5
+	if n := cfg.maxDeferredRequests(); n != 5 {
+		t.Error(n)
+	}
+}
+
+func TestRecordContext(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	ctx := context.Background()
+	if err := h.RecordSpanContext(ctx, Span{ID: "id", TraceID: "tid"}); nil != err {
+		t.Error(err)
+	}
+	if err := h.RecordMetricContext(ctx, Gauge{Name: "gauge", Value: 1}); nil != err {
+		t.Error(err)
+	}
+	if err := h.RecordEventContext(ctx, Event{EventType: "MyEvent"}); nil != err {
+		t.Error(err)
+	}
+	if err := h.RecordLogContext(ctx, Log{Message: "message"}); nil != err {
+		t.Error(err)
+	}
+	// Errors of the underlying Record methods are returned.
+	if err := h.RecordSpanContext(ctx, Span{ID: "id"}); err != errTraceIDUnset {
+		t.Error(err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := h.RecordSpanContext(cancelled, Span{ID: "id", TraceID: "tid"}); err != context.Canceled {
+		t.Error(err)
+	}
+	if err := h.RecordMetricContext(cancelled, Gauge{Name: "gauge", Value: 1}); err != context.Canceled {
+		t.Error(err)
+	}
+	if err := h.RecordEventContext(cancelled, Event{EventType: "MyEvent"}); err != context.Canceled {
+		t.Error(err)
+	}
+	if err := h.RecordLogContext(cancelled, Log{Message: "message"}); err != context.Canceled {
+		t.Error(err)
+	}
+
+	if n := len(h.takeSpans()); n != 1 {
+		t.Error(n)
+	}
+	if ms, _ := h.takeMetrics(time.Now()); len(ms) != 1 {
+		t.Error(ms)
+	}
+	if n := len(h.takeEvents()); n != 1 {
+		t.Error(n)
+	}
+	if n := len(h.takeLogs()); n != 1 {
+		t.Error(n)
+	}
+}
+
+func TestRecordContextNilHarvester(t *testing.T) {
+	var h *Harvester
+	if err := h.RecordSpanContext(context.Background(), Span{}); nil != err {
+		t.Error(err)
+	}
+	if err := h.RecordLogContext(context.Background(), Log{}); nil != err {
+		t.Error(err)
+	}
+}
+
+// redactKey returns a function which copies attributes without the key, for
+// use in filters.
+func redactKey(key string) func(map[string]interface{}) map[string]interface{} {
+	return func(attributes map[string]interface{}) map[string]interface{} {
+		redacted := make(map[string]interface{}, len(attributes))
+		for k, v := range attributes {
+			if k != key {
+				redacted[k] = v
+			}
+		}
+		return redacted
+	}
+}
+
+func TestFilters(t *testing.T) {
+	var lock sync.Mutex
+	var drops []dropRecord
+	redact := redactKey("user.email")
+	h, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops),
+		ConfigSpanFilter(func(s *Span) bool {
+			s.Attributes = redact(s.Attributes)
+			return s.ID != "drop"
+		}),
+		ConfigEventFilter(func(e *Event) bool {
+			e.Attributes = redact(e.Attributes)
+			return e.EventType != "drop"
+		}),
+		ConfigLogFilter(func(l *Log) bool {
+			l.Attributes = redact(l.Attributes)
+			return l.Message != "drop"
+		}),
+	)
+	attributes := map[string]interface{}{"user.email": "me@example.com", "zip": "12345"}
+	h.RecordSpan(Span{ID: "keep", TraceID: "trace", Attributes: attributes})
+	h.RecordSpan(Span{ID: "drop", TraceID: "trace", Attributes: attributes})
+	h.RecordEvent(Event{EventType: "keep", Attributes: attributes})
+	h.RecordEvent(Event{EventType: "drop", Attributes: attributes})
+	h.RecordEvent(Event{EventType: "drop", Attributes: attributes})
+	h.RecordLog(Log{Message: "keep", Attributes: attributes})
+	h.RecordLog(Log{Message: "drop", Attributes: attributes})
+
+	expect := map[string]interface{}{"zip": "12345"}
+	spans := h.takeSpans()
+	if len(spans) != 1 || spans[0].ID != "keep" || !reflect.DeepEqual(spans[0].Attributes, expect) {
+		t.Error(spans)
+	}
+	events := h.takeEvents()
+	if len(events) != 1 || events[0].EventType != "keep" || !reflect.DeepEqual(events[0].Attributes, expect) {
+		t.Error(events)
+	}
+	logs := h.takeLogs()
+	if len(logs) != 1 || logs[0].Message != "keep" || !reflect.DeepEqual(logs[0].Attributes, expect) {
+		t.Error(logs)
+	}
+	// The caller's attributes are unchanged.
+	if len(attributes) != 2 {
+		t.Error(attributes)
+	}
+	expectDrops := []dropRecord{
+		{"spans", 1, DropReasonFiltered},
+		{"events", 2, DropReasonFiltered},
+		{"logs", 1, DropReasonFiltered},
+	}
+	if !reflect.DeepEqual(drops, expectDrops) {
+		t.Errorf("\nexpect=%v\nactual=%v", expectDrops, drops)
+	}
+}
+
+func TestTypeCommonAttributes(t *testing.T) {
+	h, _ := NewHarvester(configTesting,
+		ConfigCommonAttributes(map[string]interface{}{"service": "app", "env": "prod"}),
+		func(cfg *Config) {
+			cfg.SpanCommonAttributes = map[string]interface{}{"env": "staging", "span.only": 1}
+			cfg.LogCommonAttributes = map[string]interface{}{"log.only": true, "invalid": []int{}}
+			cfg.EventCommonAttributes = map[string]interface{}{"event.only": "x", "env": "dev"}
+		})
+	clone, _ := h.Clone()
+
+	for _, hv := range []*Harvester{h, clone} {
+		for _, tc := range []struct {
+			entry  *cachedMapEntry
+			expect string
+		}{
+			// Metrics only get the global common attributes.
+			{entry: hv.commonAttributes, expect: `{"service":"app","env":"prod"}`},
+			// The span common attributes take precedence.
+			{entry: hv.spanCommonAttributes, expect: `{"service":"app","env":"staging","span.only":1}`},
+			{entry: hv.logCommonAttributes, expect: `{"service":"app","env":"prod","log.only":true}`},
+		} {
+			// The order of the common attributes is not fixed.
+			var common, expectCommon map[string]interface{}
+			json.Unmarshal(tc.entry.data, &common)
+			json.Unmarshal([]byte(tc.expect), &expectCommon)
+			if !reflect.DeepEqual(common, expectCommon) {
+				t.Error(string(tc.entry.data), tc.expect)
+			}
+		}
+
+		// Events get only the event common attributes, and their own
+		// attributes take precedence.
+		now := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+		hv.RecordEvent(Event{EventType: "a", Timestamp: now, Attributes: map[string]interface{}{"env": "test"}})
+		reqs := hv.swapOutEvents()
+		if len(reqs) != 1 {
+			t.Fatal(reqs)
+		}
+		bodyReader, _ := reqs[0].GetBody()
+		compressedBytes, _ := ioutil.ReadAll(bodyReader)
+		js, _ := internal.Uncompress(compressedBytes)
+		var events []map[string]interface{}
+		if err := json.Unmarshal(js, &events); nil != err {
+			t.Fatal(err, string(js))
+		}
+		expect := []map[string]interface{}{{
+			"eventType":  "a",
+			"timestamp":  float64(1417136460000),
+			"event.only": "x",
+			"env":        "test",
+		}}
+		if !reflect.DeepEqual(events, expect) {
+			t.Error(string(js))
+		}
+	}
+}
+
+func TestRequestInterceptorNilRequests(t *testing.T) {
+	var lock sync.Mutex
+	var attempts int
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.PayloadWarnBytes = 1
+		cfg.PayloadSizeObserver = func(string, int, int) {}
+		cfg.AuditLogger = func(map[string]interface{}) {}
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			attempts++
+			return emptyResponse(500), nil
+		})
+		cfg.RequestInterceptor = func(reqs []*http.Request) []*http.Request {
+			// A request built over a plain io.Reader has no GetBody.
+			req, _ := http.NewRequest("POST", "https://example.com", ioutil.NopCloser(strings.NewReader("body")))
+			return []*http.Request{nil, req, nil}
+		}
+	})
+	h.RecordEvent(Event{EventType: "testEvent", Timestamp: time.Now()})
+	h.HarvestNow(context.Background())
+
+	lock.Lock()
+	defer lock.Unlock()
+	if attempts != 1 {
+		t.Error("request without GetBody should not be retried", attempts)
+	}
+}
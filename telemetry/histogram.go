@@ -0,0 +1,150 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+var (
+	errHistogramBuckets    = errors.New("histogram must have one more count than boundaries")
+	errHistogramBoundaries = errors.New("histogram boundaries must be finite and increasing")
+	errHistogramCount      = errors.New("histogram counts must not be negative")
+)
+
+// Histogram is the metric type used for reporting values which have already
+// been counted into buckets, such as the histograms of Prometheus style
+// instrumentation.  The Metric API does not accept histograms, so a Histogram
+// is sent as a summary: its count is the total of the bucket counts, its min
+// is the lower boundary of the lowest non-empty bucket, and its max is the
+// upper boundary of the highest non-empty bucket.  Min and max are sent as
+// null if those buckets are unbounded.
+type Histogram struct {
+	// Name is the name of this metric.
+	Name string
+	// Attributes is a map of attributes for this metric.
+	Attributes map[string]interface{}
+	// AttributesJSON is a json.RawMessage of attributes for this metric. It
+	// will only be sent if Attributes is nil.
+	AttributesJSON json.RawMessage
+	// Boundaries are the increasing upper boundaries of the buckets, except
+	// the last bucket which has no upper boundary.
+	Boundaries []float64
+	// Counts are the number of values in each bucket for this time period.
+	// Counts[i] is the number of values greater than Boundaries[i-1] and
+	// less than or equal to Boundaries[i].  There must be one more count
+	// than there are boundaries.
+	Counts []float64
+	// Sum is the sum of all values for this time period.
+	Sum float64
+	// Timestamp is the start time of this metric's interval.   If Timestamp
+	// is unset then the Harvester's period start will be used.
+	Timestamp time.Time
+	// Interval is the length of time for this metric.  If Interval is unset
+	// then the time between Harvester harvests will be used.
+	Interval time.Duration
+	// Set to true to force the value of interval to be written to the payload
+	ForceIntervalValid bool
+}
+
+func (m Histogram) validate() map[string]interface{} {
+	invalid := func(err error) map[string]interface{} {
+		return map[string]interface{}{
+			"message": "invalid histogram field",
+			"name":    m.Name,
+			"err":     err.Error(),
+		}
+	}
+	if len(m.Counts) != len(m.Boundaries)+1 {
+		return invalid(errHistogramBuckets)
+	}
+	if err := isFloatValid(m.Sum); err != nil {
+		return invalid(err)
+	}
+	for _, c := range m.Counts {
+		if err := isFloatValid(c); err != nil {
+			return invalid(err)
+		}
+		if c < 0 {
+			return invalid(errHistogramCount)
+		}
+	}
+	for i, b := range m.Boundaries {
+		if nil != isFloatValid(b) || (i > 0 && b <= m.Boundaries[i-1]) {
+			return invalid(errHistogramBoundaries)
+		}
+	}
+	return nil
+}
+
+func (m Histogram) timestamp() time.Time { return m.Timestamp }
+
+func (m Histogram) withTimestamp(t time.Time) Metric {
+	m.Timestamp = t
+	return m
+}
+
+// summaryValues returns the count, min, and max of the summary the
+// histogram is sent as.  Min and max are NaN if they are unbounded or there
+// are no values.
+func (m Histogram) summaryValues() (count, min, max float64) {
+	min, max = math.NaN(), math.NaN()
+	lowest, highest := -1, -1
+	for i, c := range m.Counts {
+		count += c
+		if c > 0 {
+			if lowest < 0 {
+				lowest = i
+			}
+			highest = i
+		}
+	}
+	if lowest > 0 && lowest <= len(m.Boundaries) {
+		min = m.Boundaries[lowest-1]
+	}
+	if highest >= 0 && highest < len(m.Boundaries) {
+		max = m.Boundaries[highest]
+	}
+	return
+}
+
+func (m Histogram) writeJSON(buf *bytes.Buffer) {
+	w := internal.JSONFieldsWriter{Buf: buf}
+	buf.WriteByte('{')
+
+	w.StringField("name", m.Name)
+	w.StringField("type", "summary")
+
+	count, min, max := m.summaryValues()
+	w.AddKey("value")
+	buf.WriteByte('{')
+	vw := internal.JSONFieldsWriter{Buf: buf}
+	vw.FloatField("sum", m.Sum)
+	vw.FloatField("count", count)
+	if math.IsNaN(min) {
+		vw.RawField("min", json.RawMessage(`null`))
+	} else {
+		vw.FloatField("min", min)
+	}
+	if math.IsNaN(max) {
+		vw.RawField("max", json.RawMessage(`null`))
+	} else {
+		vw.FloatField("max", max)
+	}
+	buf.WriteByte('}')
+
+	writeTimestampInterval(&w, m.Timestamp, m.Interval, m.ForceIntervalValid)
+	if nil != m.Attributes {
+		w.WriterField("attributes", internal.Attributes(m.Attributes))
+	} else if nil != m.AttributesJSON {
+		w.RawField("attributes", m.AttributesJSON)
+	}
+	buf.WriteByte('}')
+}
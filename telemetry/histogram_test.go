@@ -0,0 +1,173 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistogramMetrics(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	metrics := []Metric{
+		Histogram{
+			Name:       "bounded",
+			Attributes: map[string]interface{}{"attribute": "string"},
+			Boundaries: []float64{1, 5, 10},
+			Counts:     []float64{0, 3, 2, 0},
+			Sum:        25,
+			Timestamp:  start,
+			Interval:   5 * time.Second,
+		},
+		Histogram{
+			Name:       "unbounded",
+			Boundaries: []float64{1, 5},
+			Counts:     []float64{1, 0, 2},
+			Sum:        100.5,
+		},
+		&Histogram{
+			Name:           "empty",
+			AttributesJSON: []byte(`{"zip":"zap"}`),
+			Counts:         []float64{0},
+		},
+	}
+	testGroupJSON(t, []Batch{{NewMetricGroup(metrics)}}, `[{"metrics":[
+		{
+			"name":"bounded",
+			"type":"summary",
+			"value":{"sum":25,"count":5,"min":1,"max":10},
+			"timestamp":1417136460000,
+			"interval.ms":5000,
+			"attributes":{"attribute":"string"}
+		},
+		{
+			"name":"unbounded",
+			"type":"summary",
+			"value":{"sum":100.5,"count":3,"min":null,"max":null}
+		},
+		{
+			"name":"empty",
+			"type":"summary",
+			"value":{"sum":0,"count":0,"min":null,"max":null},
+			"attributes":{"zip":"zap"}
+		}
+	]}]`)
+}
+
+func TestHistogramSplit(t *testing.T) {
+	group := NewMetricGroup([]Metric{
+		Histogram{Name: "h1", Counts: []float64{1}},
+		Histogram{Name: "h2", Counts: []float64{2}},
+	})
+	split := group.(splittablePayloadEntry).split(CountSplitStrategy{})
+	if len(split) != 2 {
+		t.Fatal("split into incorrect number of slices", len(split))
+	}
+	testGroupJSON(t, []Batch{{split[0]}}, `[{"metrics":[{"name":"h1","type":"summary","value":{"sum":0,"count":1,"min":null,"max":null}}]}]`)
+	testGroupJSON(t, []Batch{{split[1]}}, `[{"metrics":[{"name":"h2","type":"summary","value":{"sum":0,"count":2,"min":null,"max":null}}]}]`)
+}
+
+func TestHistogramHarvest(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting)
+	err := h.RecordMetric(Histogram{
+		Name:       "latency",
+		Boundaries: []float64{0.1, 0.5},
+		Counts:     []float64{4, 1, 0},
+		Sum:        0.6,
+		Timestamp:  start,
+		Interval:   time.Second,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	testHarvesterMetrics(t, h, `[{
+		"name":"latency",
+		"type":"summary",
+		"value":{"sum":0.6,"count":5,"min":null,"max":0.5},
+		"timestamp":1417136460000,
+		"interval.ms":1000
+	}]`)
+}
+
+func TestValidateHistogram(t *testing.T) {
+	expectErr := func(err error) map[string]interface{} {
+		return map[string]interface{}{
+			"message": "invalid histogram field",
+			"name":    "my-histogram",
+			"err":     err.Error(),
+		}
+	}
+	testcases := []struct {
+		m      Histogram
+		fields map[string]interface{}
+	}{
+		{
+			m:      Histogram{Name: "my-histogram", Boundaries: []float64{1, 2}, Counts: []float64{1, 2, 3}, Sum: 4},
+			fields: nil,
+		},
+		{
+			m:      Histogram{Name: "my-histogram", Counts: []float64{0}},
+			fields: nil,
+		},
+		{
+			m:      Histogram{Name: "my-histogram", Boundaries: []float64{1, 2}, Counts: []float64{1, 2}},
+			fields: expectErr(errHistogramBuckets),
+		},
+		{
+			m:      Histogram{Name: "my-histogram", Counts: []float64{1}, Sum: math.NaN()},
+			fields: expectErr(errFloatNaN),
+		},
+		{
+			m:      Histogram{Name: "my-histogram", Boundaries: []float64{1}, Counts: []float64{1, math.NaN()}},
+			fields: expectErr(errFloatNaN),
+		},
+		{
+			m:      Histogram{Name: "my-histogram", Boundaries: []float64{1}, Counts: []float64{math.Inf(1), 1}},
+			fields: expectErr(errFloatInfinity),
+		},
+		{
+			m:      Histogram{Name: "my-histogram", Boundaries: []float64{1}, Counts: []float64{-1, 1}},
+			fields: expectErr(errHistogramCount),
+		},
+		{
+			m:      Histogram{Name: "my-histogram", Boundaries: []float64{2, 1}, Counts: []float64{1, 1, 1}},
+			fields: expectErr(errHistogramBoundaries),
+		},
+		{
+			m:      Histogram{Name: "my-histogram", Boundaries: []float64{1, 1}, Counts: []float64{1, 1, 1}},
+			fields: expectErr(errHistogramBoundaries),
+		},
+		{
+			m:      Histogram{Name: "my-histogram", Boundaries: []float64{math.Inf(1)}, Counts: []float64{1, 1}},
+			fields: expectErr(errHistogramBoundaries),
+		},
+	}
+	for idx, tc := range testcases {
+		got := tc.m.validate()
+		if !reflect.DeepEqual(got, tc.fields) {
+			t.Error(idx, got, tc.fields)
+		}
+	}
+}
+
+func TestHistogramSnapshot(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	hist := Histogram{Name: "latency", Boundaries: []float64{1}, Counts: []float64{1, 2}, Sum: 3}
+	h.RecordMetric(&hist)
+	data, err := h.Snapshot()
+	if nil != err {
+		t.Fatal(err)
+	}
+	loaded, _ := NewHarvester(configTesting)
+	if err := loaded.LoadSnapshot(data); nil != err {
+		t.Fatal(err)
+	}
+	metrics, _ := loaded.takeMetrics(time.Now())
+	if len(metrics) != 1 || !reflect.DeepEqual(metrics[0], hist) {
+		t.Error(metrics)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import "sync"
+
+const (
+	// maxInternedStrings bounds the size of the intern table so that high
+	// cardinality values cannot cause it to grow without limit.  Strings
+	// seen once the table is full are not interned.
+	maxInternedStrings = 10 * 1000
+	// maxInternedStringLength is the length of the longest string that
+	// will be interned.  Longer strings are unlikely to be repeated.
+	maxInternedStringLength = 256
+)
+
+// stringInterner is a bounded table of strings used to share the backing
+// storage of identical attribute keys and values.  A nil *stringInterner
+// does not intern.
+type stringInterner struct {
+	lock    sync.Mutex
+	strings map[string]string
+}
+
+func newStringInterner() *stringInterner {
+	return &stringInterner{strings: make(map[string]string)}
+}
+
+// intern returns a string equal to s which shares its storage with earlier
+// strings equal to s when possible.  This method assumes the interner is
+// locked.
+func (in *stringInterner) intern(s string) string {
+	if len(s) > maxInternedStringLength {
+		return s
+	}
+	if interned, ok := in.strings[s]; ok {
+		return interned
+	}
+	if len(in.strings) < maxInternedStrings {
+		in.strings[s] = s
+	}
+	return s
+}
+
+// attributes returns a copy of the attributes with interned keys and string
+// values.  The attributes map itself is not modified since it is owned by
+// the caller.
+func (in *stringInterner) attributes(attributes map[string]interface{}) map[string]interface{} {
+	if nil == in || len(attributes) == 0 {
+		return attributes
+	}
+	in.lock.Lock()
+	defer in.lock.Unlock()
+
+	interned := make(map[string]interface{}, len(attributes))
+	for k, v := range attributes {
+		if s, ok := v.(string); ok {
+			v = in.intern(s)
+		}
+		interned[in.intern(k)] = v
+	}
+	return interned
+}
+
+// metric returns the metric with its attributes interned.
+func (in *stringInterner) metric(m Metric) Metric {
+	if nil == in {
+		return m
+	}
+	switch v := m.(type) {
+	case Count:
+		v.Attributes = in.attributes(v.Attributes)
+		return v
+	case Summary:
+		v.Attributes = in.attributes(v.Attributes)
+		return v
+	case Gauge:
+		v.Attributes = in.attributes(v.Attributes)
+		return v
+	case Histogram:
+		v.Attributes = in.attributes(v.Attributes)
+		return v
+	}
+	return m
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+func stringData(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
+
+// newString returns a copy of s that does not share its storage.
+func newString(s string) string {
+	return string([]byte(s))
+}
+
+func TestInternAttributes(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) { cfg.InternAttributes = true })
+	first := map[string]interface{}{newString("service.name"): newString("checkout"), "count": 1}
+	second := map[string]interface{}{newString("service.name"): newString("checkout"), "count": 2}
+	h.RecordEvent(Event{EventType: "event", Attributes: first})
+	h.RecordLog(Log{Message: "log", Attributes: second})
+
+	var keys []string
+	for _, attrs := range []map[string]interface{}{h.events[0].Attributes, h.logs[0].Attributes} {
+		for k := range attrs {
+			if k == "service.name" {
+				keys = append(keys, k)
+			}
+		}
+	}
+	if stringData(keys[0]) != stringData(keys[1]) {
+		t.Error("keys should share storage")
+	}
+	v1 := h.events[0].Attributes["service.name"].(string)
+	v2 := h.logs[0].Attributes["service.name"].(string)
+	if stringData(v1) != stringData(v2) {
+		t.Error("values should share storage")
+	}
+	if !reflect.DeepEqual(h.events[0].Attributes, first) || !reflect.DeepEqual(h.logs[0].Attributes, second) {
+		t.Error("attributes should be unchanged", h.events[0].Attributes, h.logs[0].Attributes)
+	}
+}
+
+func TestInternAttributesMetric(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) { cfg.InternAttributes = true })
+	h.RecordMetric(Gauge{Name: "g", Attributes: map[string]interface{}{"zip": newString("zap")}})
+	h.RecordMetric(Count{Name: "c", Attributes: map[string]interface{}{"zip": newString("zap")}})
+	g := h.rawMetrics[0].(Gauge).Attributes["zip"].(string)
+	c := h.rawMetrics[1].(Count).Attributes["zip"].(string)
+	if stringData(g) != stringData(c) {
+		t.Error("metric attribute values should share storage")
+	}
+}
+
+func TestStringInternerBounded(t *testing.T) {
+	in := newStringInterner()
+	for i := 0; i < 2*maxInternedStrings; i++ {
+		in.intern(strconv.Itoa(i))
+	}
+	if n := len(in.strings); n != maxInternedStrings {
+		t.Error("intern table exceeded bound", n)
+	}
+	long := string(make([]byte, maxInternedStringLength+1))
+	in = newStringInterner()
+	in.intern(long)
+	if len(in.strings) != 0 {
+		t.Error("long strings should not be interned")
+	}
+}
+
+func TestNilStringInterner(t *testing.T) {
+	var in *stringInterner
+	attrs := map[string]interface{}{"zip": "zap"}
+	if got := in.attributes(attrs); reflect.ValueOf(got).Pointer() != reflect.ValueOf(attrs).Pointer() {
+		t.Error("nil interner should return the same map")
+	}
+}
+
+func benchmarkRecordEventAttributes(b *testing.B, intern bool) {
+	const numEvents = 10 * 1000
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h, _ := NewHarvester(configTesting, func(cfg *Config) { cfg.InternAttributes = intern })
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		for j := 0; j < numEvents; j++ {
+			// Attributes decoded from a request or log line have
+			// freshly allocated keys and values.
+			h.RecordEvent(Event{EventType: "event", Attributes: map[string]interface{}{
+				newString("http.request.method"):  newString("GET"),
+				newString("http.response.status"): newString("200 OK"),
+				newString("service.name"):         newString("checkout-service"),
+				newString("deployment.region"):    newString("us-east-1"),
+			}})
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/numEvents, "retained-B/event")
+		runtime.KeepAlive(h)
+	}
+}
+
+// BenchmarkRecordEventAttributes compares the heap retained by buffered events
+// with and without InternAttributes.  Interning copies each attribute map when
+// it is recorded, so it allocates more per event, but the buffered events
+// retain less memory.
+func BenchmarkRecordEventAttributes(b *testing.B) {
+	b.Run("plain", func(b *testing.B) { benchmarkRecordEventAttributes(b, false) })
+	b.Run("interned", func(b *testing.B) { benchmarkRecordEventAttributes(b, true) })
+}
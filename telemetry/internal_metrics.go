@@ -0,0 +1,51 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"net/http"
+	"time"
+)
+
+// Names of the metrics recorded when Config.ReportInternalMetrics is set.
+const (
+	internalItemsSentMetric       = "newrelic.telemetry.sdk.items.sent"
+	internalItemsDroppedMetric    = "newrelic.telemetry.sdk.items.dropped"
+	internalRequestRetriesMetric  = "newrelic.telemetry.sdk.request.retries"
+	internalRequestDurationMetric = "newrelic.telemetry.sdk.request.duration"
+	internalHarvestDurationMetric = "newrelic.telemetry.sdk.harvest.duration"
+)
+
+// recordInternalDrop records dropped data to the internal metrics.  It is
+// called by Config.drop, so h.lock must not be held.
+func (h *Harvester) recordInternalDrop(signal Signal, count int, reason string) {
+	h.MetricAggregator().Count(internalItemsDroppedMetric, map[string]interface{}{
+		"signal": signal.String(),
+		"reason": reason,
+	}).Increase(float64(count))
+}
+
+// recordInternalRequest records the outcome of a request to the internal
+// metrics if Config.ReportInternalMetrics is set.
+func (h *Harvester) recordInternalRequest(req *http.Request, signal Signal, resp response, retries int, d time.Duration) {
+	if !h.config.ReportInternalMetrics {
+		return
+	}
+	attributes := map[string]interface{}{"signal": signal.String()}
+	agg := h.MetricAggregator()
+	if nil == resp.err && resp.statusCode >= 200 && resp.statusCode < 300 {
+		agg.Count(internalItemsSentMetric, attributes).Increase(float64(countRequestItems(req, h.config.CompressionDictionary)))
+	}
+	agg.Count(internalRequestRetriesMetric, attributes).Increase(float64(retries))
+	agg.Summary(internalRequestDurationMetric, attributes).RecordDuration(d)
+}
+
+// recordInternalHarvest records the duration of a harvest to the internal
+// metrics if Config.ReportInternalMetrics is set.
+func (h *Harvester) recordInternalHarvest(d time.Duration) {
+	if !h.config.ReportInternalMetrics {
+		return
+	}
+	h.MetricAggregator().Summary(internalHarvestDurationMetric, nil).RecordDuration(d)
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// internalMetrics returns the aggregated metrics of the harvester keyed by
+// name and attributes JSON.
+func internalMetrics(h *Harvester) map[string]Metric {
+	metrics, _ := h.takeMetrics(time.Now())
+	found := make(map[string]Metric, len(metrics))
+	for _, m := range metrics {
+		switch v := m.(type) {
+		case *Count:
+			found[v.Name+string(v.AttributesJSON)] = v
+		case *Summary:
+			found[v.Name+string(v.AttributesJSON)] = v
+		}
+	}
+	return found
+}
+
+func TestReportInternalMetrics(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.ReportInternalMetrics = true
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "1", TraceID: "tid"})
+	h.RecordSpan(Span{ID: "2", TraceID: "tid"})
+	h.RecordLog(Log{Message: "message"})
+	h.HarvestNow(context.Background())
+	h.RecordLog(Log{})
+
+	metrics := internalMetrics(h)
+	if c, ok := metrics[internalItemsSentMetric+`{"signal":"spans"}`].(*Count); !ok || c.Value != 2 {
+		t.Error(metrics)
+	}
+	if c, ok := metrics[internalItemsSentMetric+`{"signal":"logs"}`].(*Count); !ok || c.Value != 1 {
+		t.Error(metrics)
+	}
+	if c, ok := metrics[internalItemsDroppedMetric+`{"reason":"validation","signal":"logs"}`].(*Count); !ok || c.Value != 1 {
+		t.Error(metrics)
+	}
+	if c, ok := metrics[internalRequestRetriesMetric+`{"signal":"spans"}`].(*Count); !ok || c.Value != 0 {
+		t.Error(metrics)
+	}
+	if s, ok := metrics[internalRequestDurationMetric+`{"signal":"spans"}`].(*Summary); !ok || s.Count != 1 {
+		t.Error(metrics)
+	}
+	if s, ok := metrics[internalHarvestDurationMetric+`{}`].(*Summary); !ok || s.Count != 1 {
+		t.Error(metrics)
+	}
+}
+
+func TestReportInternalMetricsDisabled(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "1", TraceID: "tid"})
+	h.RecordLog(Log{})
+	h.HarvestNow(context.Background())
+	if metrics := internalMetrics(h); len(metrics) != 0 {
+		t.Error(metrics)
+	}
+}
+
+func TestReportInternalMetricsClone(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.ReportInternalMetrics = true
+	})
+	clone, _ := h.Clone()
+	clone.RecordLog(Log{})
+	if metrics := internalMetrics(h); len(metrics) != 0 {
+		t.Error("drop recorded to the original harvester", metrics)
+	}
+	if metrics := internalMetrics(clone); len(metrics) != 1 {
+		t.Error(metrics)
+	}
+
+	clone, _ = h.Clone(func(cfg *Config) { cfg.ReportInternalMetrics = false })
+	clone.RecordLog(Log{})
+	if metrics := internalMetrics(h); len(metrics) != 0 {
+		t.Error("drop recorded to the original harvester", metrics)
+	}
+}
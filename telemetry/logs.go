@@ -12,6 +12,60 @@ import (
 
 const logTypeName string = "logs"
 
+// Log types recognized by New Relic's built-in parsing rules.  Set
+// Log.LogType to one of these to have the message parsed into attributes.
+const (
+	LogTypeApache        = "apache"
+	LogTypeApacheError   = "apache_error"
+	LogTypeALB           = "alb"
+	LogTypeCassandra     = "cassandra"
+	LogTypeCloudFrontWeb = "cloudfront-web"
+	LogTypeELB           = "elb"
+	LogTypeHAProxyHTTP   = "haproxy_http"
+	LogTypeIISW3C        = "iis_w3c"
+	LogTypeLinuxCron     = "linux_cron"
+	LogTypeLinuxMessages = "linux_messages"
+	LogTypeMongoDB       = "mongodb"
+	LogTypeMonit         = "monit"
+	LogTypeMySQLError    = "mysql-error"
+	LogTypeNginx         = "nginx"
+	LogTypeNginxError    = "nginx-error"
+	LogTypePostgreSQL    = "postgresql"
+	LogTypeRabbitMQ      = "rabbitmq"
+	LogTypeRedis         = "redis"
+	LogTypeRoute53       = "route-53"
+	LogTypeSyslogRFC5424 = "syslog-rfc5424"
+)
+
+// logTypeAttribute is the attribute which selects the parsing rule.
+const logTypeAttribute = "logtype"
+
+// logLevelField is the field which Log.Severity is sent as.
+const logLevelField = "level"
+
+var knownLogTypes = map[string]bool{
+	LogTypeApache:        true,
+	LogTypeApacheError:   true,
+	LogTypeALB:           true,
+	LogTypeCassandra:     true,
+	LogTypeCloudFrontWeb: true,
+	LogTypeELB:           true,
+	LogTypeHAProxyHTTP:   true,
+	LogTypeIISW3C:        true,
+	LogTypeLinuxCron:     true,
+	LogTypeLinuxMessages: true,
+	LogTypeMongoDB:       true,
+	LogTypeMonit:         true,
+	LogTypeMySQLError:    true,
+	LogTypeNginx:         true,
+	LogTypeNginxError:    true,
+	LogTypePostgreSQL:    true,
+	LogTypeRabbitMQ:      true,
+	LogTypeRedis:         true,
+	LogTypeRoute53:       true,
+	LogTypeSyslogRFC5424: true,
+}
+
 // Log is a log.
 type Log struct {
 	// Required Fields:
@@ -22,7 +76,9 @@ type Log struct {
 	// Recommended Fields:
 	//
 	// Timestamp of the log message.  If Timestamp is not set, it
-	// will be assigned to time.Now() in Harvester.RecordLog.
+	// will be assigned to time.Now() in Harvester.RecordLog unless
+	// Config.DisableAutoTimestamp is set, in which case it is omitted
+	// and New Relic assigns the time the log is received.
 	Timestamp time.Time
 
 	// Additional Fields:
@@ -30,6 +86,19 @@ type Log struct {
 	// Attributes is a map of user specified tags on this log message.  The map
 	// values can be any of bool, number, or string.
 	Attributes map[string]interface{}
+	// LogType is sent as the "logtype" attribute, which selects the rule
+	// New Relic uses to parse the message, eg. LogTypeNginx.  It takes
+	// precedence over a "logtype" in Attributes.  Unknown log types are
+	// sent but logged once as they are unlikely to match a parsing rule.
+	LogType string
+	// Severity is the level of the log message, eg. "ERROR", which is sent
+	// as the "level" field used for filtering.  It takes precedence over a
+	// "level" in Attributes.
+	Severity string
+
+	// bufferedAt is when the log was recorded if Config.TrackQueueLatency
+	// is enabled.
+	bufferedAt time.Time
 }
 
 func (l *Log) writeJSON(buf *bytes.Buffer) {
@@ -37,18 +106,45 @@ func (l *Log) writeJSON(buf *bytes.Buffer) {
 	buf.WriteByte('{')
 
 	w.StringField("message", l.Message)
-	w.IntField("timestamp", l.Timestamp.UnixNano()/(1000*1000))
+	if !l.Timestamp.IsZero() {
+		w.IntField("timestamp", l.Timestamp.UnixNano()/(1000*1000))
+	}
+	if l.Severity != "" {
+		w.StringField(logLevelField, l.Severity)
+	}
 
 	w.AddKey("attributes")
 	buf.WriteByte('{')
 	ww := internal.JSONFieldsWriter{Buf: buf}
-
-	internal.AddAttributes(&ww, l.Attributes)
+	if l.LogType != "" {
+		ww.StringField(logTypeAttribute, l.LogType)
+	}
+	internal.AddAttributes(&ww, l.unshadowedAttributes())
 	buf.WriteByte('}')
 
 	buf.WriteByte('}')
 }
 
+// unshadowedAttributes returns the attributes without those that are set by
+// the LogType and Severity fields.
+func (l *Log) unshadowedAttributes() map[string]interface{} {
+	_, hasType := l.Attributes[logTypeAttribute]
+	hasType = hasType && l.LogType != ""
+	_, hasLevel := l.Attributes[logLevelField]
+	hasLevel = hasLevel && l.Severity != ""
+	if !hasType && !hasLevel {
+		return l.Attributes
+	}
+	attributes := make(map[string]interface{}, len(l.Attributes))
+	for k, v := range l.Attributes {
+		if (hasType && k == logTypeAttribute) || (hasLevel && k == logLevelField) {
+			continue
+		}
+		attributes[k] = v
+	}
+	return attributes
+}
+
 type logCommonBlock struct {
 	attributes MapEntry
 }
@@ -127,11 +223,15 @@ func (group *logGroup) WriteDataEntry(buf *bytes.Buffer) *bytes.Buffer {
 	return buf
 }
 
-func (group *logGroup) split() []splittablePayloadEntry {
+func (group *logGroup) split(strategy SplitStrategy) []splittablePayloadEntry {
 	if len(group.Logs) < 2 {
 		return nil
 	}
-	middle := len(group.Logs) / 2
+	middle := splitIndex(strategy, len(group.Logs), func(i int) int {
+		buf := &bytes.Buffer{}
+		group.Logs[i].writeJSON(buf)
+		return buf.Len()
+	})
 	return []splittablePayloadEntry{&logGroup{Logs: group.Logs[0:middle]}, &logGroup{Logs: group.Logs[middle:]}}
 }
 
@@ -51,35 +51,35 @@ func testLogGroupJSON(t testing.TB, batches []Batch, expect string) {
 func TestLogsPayloadSplit(t *testing.T) {
 	// test len 0
 	sp := NewLogGroup([]Log{})
-	split := sp.(splittablePayloadEntry).split()
+	split := sp.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if split != nil {
 		t.Error(split)
 	}
 
 	// test len 1
 	sp = NewLogGroup([]Log{{Message: "a"}})
-	split = sp.(splittablePayloadEntry).split()
+	split = sp.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if split != nil {
 		t.Error(split)
 	}
 
 	// test len 2
 	sp = NewLogGroup([]Log{{Message: "a"}, {Message: "b"}})
-	split = sp.(splittablePayloadEntry).split()
+	split = sp.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if len(split) != 2 {
 		t.Error("split into incorrect number of slices", len(split))
 	}
-	testLogGroupJSON(t, []Batch{{split[0]}}, `[{"logs":[{"message":"a","timestamp":-6795364578871,"attributes":{}}]}]`)
-	testLogGroupJSON(t, []Batch{{split[1]}}, `[{"logs":[{"message":"b","timestamp":-6795364578871,"attributes":{}}]}]`)
+	testLogGroupJSON(t, []Batch{{split[0]}}, `[{"logs":[{"message":"a","attributes":{}}]}]`)
+	testLogGroupJSON(t, []Batch{{split[1]}}, `[{"logs":[{"message":"b","attributes":{}}]}]`)
 
 	// test len 3
 	sp = NewLogGroup([]Log{{Message: "a"}, {Message: "b"}, {Message: "c"}})
-	split = sp.(splittablePayloadEntry).split()
+	split = sp.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if len(split) != 2 {
 		t.Error("split into incorrect number of slices", len(split))
 	}
-	testLogGroupJSON(t, []Batch{{split[0]}}, `[{"logs":[{"message":"a","timestamp":-6795364578871,"attributes":{}}]}]`)
-	testLogGroupJSON(t, []Batch{{split[1]}}, `[{"logs":[{"message":"b","timestamp":-6795364578871,"attributes":{}},{"message":"c","timestamp":-6795364578871,"attributes":{}}]}]`)
+	testLogGroupJSON(t, []Batch{{split[0]}}, `[{"logs":[{"message":"a","attributes":{}}]}]`)
+	testLogGroupJSON(t, []Batch{{split[1]}}, `[{"logs":[{"message":"b","attributes":{}},{"message":"c","attributes":{}}]}]`)
 }
 
 func TestLogsJSON(t *testing.T) {
@@ -94,7 +94,6 @@ func TestLogsJSON(t *testing.T) {
 	testLogGroupJSON(t, []Batch{{group}}, `[{"logs":[
 		{
 			"message":"",
-			"timestamp":-6795364578871,
 			"attributes": {
 			}
 		},
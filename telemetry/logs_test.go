@@ -5,7 +5,10 @@ package telemetry
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"reflect"
 	"testing"
 	"time"
 
@@ -85,6 +88,21 @@ func TestLog(t *testing.T) {
 	testHarvesterLogs(t, h, expect)
 }
 
+func TestLogMessagePrefix(t *testing.T) {
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.LogMessagePrefix = "[staging] "
+	})
+	h.RecordLog(Log{Message: "This is a log message.", Timestamp: tm})
+	h.RecordLog(Log{Message: "", Timestamp: tm})
+	expect := `[{"logs":[{
+		"message":"[staging] This is a log message.",
+		"timestamp":1417136460000,
+		"attributes":{}
+	}]}]`
+	testHarvesterLogs(t, h, expect)
+}
+
 func TestLogInvalidAttribute(t *testing.T) {
 	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
 	h, _ := NewHarvester(configTesting)
@@ -106,6 +124,134 @@ func TestLogInvalidAttribute(t *testing.T) {
 	testHarvesterLogs(t, h, expect)
 }
 
+func TestLogType(t *testing.T) {
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting)
+	h.RecordLog(Log{
+		Message:   "This is a log message.",
+		Timestamp: tm,
+		LogType:   LogTypeNginx,
+		Attributes: map[string]interface{}{
+			"logtype": "apache",
+			"zip":     "zap",
+		},
+	})
+	expect := `[{"logs":[{
+		"message":"This is a log message.",
+		"timestamp":1417136460000,
+		"attributes": {
+			"logtype":"nginx",
+			"zip":"zap"
+		}
+	}]}]`
+	testHarvesterLogs(t, h, expect)
+}
+
+func TestLogSeverity(t *testing.T) {
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	var logged []map[string]interface{}
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.ErrorLogger = func(fields map[string]interface{}) { logged = append(logged, fields) }
+	})
+	attributes := map[string]interface{}{"level": "debug", "zip": "zap"}
+	h.RecordLog(Log{Message: "one", Timestamp: tm, Severity: "ERROR", Attributes: attributes})
+	h.RecordLog(Log{Message: "two", Timestamp: tm, Severity: "WARN", Attributes: attributes})
+	h.RecordLog(Log{Message: "three", Timestamp: tm, Attributes: attributes})
+
+	if len(logged) != 1 || logged[0]["severity"] != "ERROR" {
+		t.Error(logged)
+	}
+	if len(attributes) != 2 {
+		t.Error("attributes should not be modified", attributes)
+	}
+	reqs := h.swapOutLogs()
+	if len(reqs) != 1 {
+		t.Fatal(reqs)
+	}
+	bodyReader, _ := reqs[0].GetBody()
+	compressedBytes, _ := ioutil.ReadAll(bodyReader)
+	js, _ := internal.Uncompress(compressedBytes)
+	// The order of the attributes is not fixed.
+	var actual, expect interface{}
+	json.Unmarshal(js, &actual)
+	json.Unmarshal([]byte(`[{"logs":[
+		{"message":"one","timestamp":1417136460000,"level":"ERROR","attributes":{"zip":"zap"}},
+		{"message":"two","timestamp":1417136460000,"level":"WARN","attributes":{"zip":"zap"}},
+		{"message":"three","timestamp":1417136460000,"attributes":{"level":"debug","zip":"zap"}}
+	]}]`), &expect)
+	if !reflect.DeepEqual(actual, expect) {
+		t.Error(string(js))
+	}
+}
+
+func TestLogTypeUnknown(t *testing.T) {
+	var logged []map[string]interface{}
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.ErrorLogger = func(fields map[string]interface{}) { logged = append(logged, fields) }
+	})
+	h.RecordLog(Log{Message: "one", LogType: LogTypeRedis})
+	h.RecordLog(Log{Message: "two", LogType: "my-custom-type"})
+	h.RecordLog(Log{Message: "three", LogType: "my-custom-type"})
+	if len(logged) != 1 {
+		t.Fatal(logged)
+	}
+	if lt := logged[0]["logtype"]; lt != "my-custom-type" {
+		t.Error(lt)
+	}
+	if n := len(h.swapOutLogs()); n != 1 {
+		t.Error(n)
+	}
+}
+
+func TestRecordLogf(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	before := time.Now()
+	if err := h.RecordLogf("WARN", "disk %s is %d%% full", "/dev/sda1", 95); nil != err {
+		t.Fatal(err)
+	}
+	if err := h.RecordLogf("", "no level"); nil != err {
+		t.Fatal(err)
+	}
+	if len(h.logs) != 2 {
+		t.Fatal(h.logs)
+	}
+	l := h.logs[0]
+	if l.Message != "disk /dev/sda1 is 95% full" {
+		t.Error(l.Message)
+	}
+	if l.Timestamp.Before(before) {
+		t.Error(l.Timestamp)
+	}
+	buf := &bytes.Buffer{}
+	l.writeJSON(buf)
+	expect := compactJSONString(fmt.Sprintf(`{
+		"message":"disk /dev/sda1 is 95%% full",
+		"timestamp":%d,
+		"level":"WARN",
+		"attributes":{}
+	}`, l.Timestamp.UnixNano()/int64(time.Millisecond)))
+	if js := buf.String(); js != expect {
+		t.Errorf("\nexpect=%s\nactual=%s\n", expect, js)
+	}
+	if h.logs[1].Severity != "" {
+		t.Error(h.logs[1].Severity)
+	}
+}
+
+func TestRecordLogfEmptyMessage(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	if err := h.RecordLogf("INFO", ""); err != errLogMessageUnset {
+		t.Error(err)
+	}
+}
+
+func TestRecordLogfNilHarvester(t *testing.T) {
+	var h *Harvester
+	if err := h.RecordLogf("INFO", "message %d", 1); nil != err {
+		t.Error(err)
+	}
+}
+
 func TestRecordLogNilHarvester(t *testing.T) {
 	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
 	var h *Harvester
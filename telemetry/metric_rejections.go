@@ -0,0 +1,179 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// RejectedMetric is a metric that the metrics endpoint permanently rejected.
+type RejectedMetric struct {
+	// JSON is the metric as it was sent, without common block values.
+	JSON json.RawMessage
+	// Reason is the reason for the rejection given by the endpoint, if any.
+	Reason string
+}
+
+// metricRejections is the body returned by metrics endpoints that support
+// partial batch acknowledgment.  Each index refers to the position of a
+// metric within the request, counting across all of its batches.
+//
+//	{"rejected":[{"index":3,"retryable":true,"reason":"..."}]}
+type metricRejections struct {
+	Rejected []struct {
+		Index     int    `json:"index"`
+		Retryable bool   `json:"retryable"`
+		Reason    string `json:"reason"`
+	} `json:"rejected"`
+}
+
+// maxMetricRequeueAge is the age after which a metric rejected with a
+// retryable error is dropped rather than re-queued again, so that a metric
+// which is always rejected is not resent forever.
+const maxMetricRequeueAge = 15 * time.Minute
+
+// requeuedMetric is a metric which was previously serialized, sent, and
+// rejected with a retryable error.
+type requeuedMetric struct {
+	js json.RawMessage
+	ts time.Time
+}
+
+func (m requeuedMetric) writeJSON(buf *bytes.Buffer)      { buf.Write(m.js) }
+func (m requeuedMetric) validate() map[string]interface{} { return nil }
+func (m requeuedMetric) timestamp() time.Time             { return m.ts }
+
+// withTimestamp returns the metric unmodified since its JSON has already been
+// serialized.  Timestamps were checked before the metric was first sent.
+func (m requeuedMetric) withTimestamp(time.Time) Metric { return m }
+
+// sentMetric is a metric parsed from a request body along with the common
+// block timestamp and interval of the batch it was sent in.
+type sentMetric struct {
+	js        json.RawMessage
+	timestamp json.Number
+	interval  json.Number
+}
+
+// parseSentMetrics returns the metrics in the request body in the order that
+// they were sent.
+func parseSentMetrics(req *http.Request, dict []byte) ([]sentMetric, error) {
+	body, err := readRequestBody(req, dict)
+	if nil != err {
+		return nil, err
+	}
+	var batches []struct {
+		Common struct {
+			Timestamp json.Number `json:"timestamp"`
+			Interval  json.Number `json:"interval.ms"`
+		} `json:"common"`
+		Metrics []json.RawMessage `json:"metrics"`
+	}
+	if err := json.Unmarshal(body, &batches); nil != err {
+		return nil, err
+	}
+	var sent []sentMetric
+	for _, b := range batches {
+		for _, js := range b.Metrics {
+			sent = append(sent, sentMetric{
+				js:        js,
+				timestamp: b.Common.Timestamp,
+				interval:  b.Common.Interval,
+			})
+		}
+	}
+	return sent, nil
+}
+
+// requeue returns the metric with the batch timestamp and interval applied
+// so that it keeps its original time window when sent in a later harvest.
+func (m sentMetric) requeue() (requeuedMetric, error) {
+	dec := json.NewDecoder(bytes.NewReader(m.js))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); nil != err {
+		return requeuedMetric{}, err
+	}
+	if _, ok := fields["timestamp"]; !ok && m.timestamp != "" {
+		fields["timestamp"] = m.timestamp
+	}
+	if _, ok := fields["interval.ms"]; !ok && m.interval != "" && fields["type"] != "gauge" {
+		fields["interval.ms"] = m.interval
+	}
+	var ts time.Time
+	if n, ok := fields["timestamp"].(json.Number); ok {
+		if ms, err := n.Int64(); nil == err {
+			ts = time.Unix(0, ms*int64(time.Millisecond))
+		}
+	}
+	js, err := json.Marshal(fields)
+	if nil != err {
+		return requeuedMetric{}, err
+	}
+	return requeuedMetric{js: js, ts: ts}, nil
+}
+
+// handleMetricRejections parses the body of a successful metrics response.
+// Metrics rejected with a retryable error are re-queued for the next harvest
+// unless they are older than maxMetricRequeueAge or have no timestamp, and
+// all others are passed to Config.MetricsRejectedCallback.
+func (h *Harvester) handleMetricRejections(req *http.Request, body []byte) {
+	var rejections metricRejections
+	if err := json.Unmarshal(body, &rejections); nil != err || len(rejections.Rejected) == 0 {
+		return
+	}
+	sent, err := parseSentMetrics(req, h.config.CompressionDictionary)
+	if nil != err {
+		h.config.logError(map[string]interface{}{
+			"err":     err.Error(),
+			"message": "unable to parse request for rejected metrics",
+		})
+		return
+	}
+
+	var requeue []Metric
+	var rejected []RejectedMetric
+	for _, r := range rejections.Rejected {
+		if r.Index < 0 || r.Index >= len(sent) {
+			continue
+		}
+		m := sent[r.Index]
+		if !r.Retryable {
+			rejected = append(rejected, RejectedMetric{JSON: m.js, Reason: r.Reason})
+			continue
+		}
+		rm, err := m.requeue()
+		if nil != err || rm.ts.IsZero() || time.Since(rm.ts) > maxMetricRequeueAge {
+			rejected = append(rejected, RejectedMetric{JSON: m.js, Reason: r.Reason})
+			continue
+		}
+		requeue = append(requeue, rm)
+	}
+
+	if len(requeue) > 0 {
+		var full int
+		h.lock.Lock()
+		if space := h.bufferSpace(h.bufferedMetrics()); space < len(requeue) {
+			full = h.bufferDrop(SignalMetrics, len(requeue)-space)
+			requeue = requeue[:space]
+		}
+		first := h.metricsEmpty() && len(requeue) > 0
+		h.rawMetrics = append(h.rawMetrics, requeue...)
+		h.addBufferedBytes(SignalMetrics, approximateMetricsSize(requeue))
+		h.lock.Unlock()
+		h.afterRecord(SignalMetrics, first, full)
+	}
+	h.config.logDebug(map[string]interface{}{
+		"event":    "metrics rejected",
+		"requeued": len(requeue),
+		"dropped":  len(rejected),
+	})
+	h.config.drop(SignalMetrics, len(rejected), DropReasonRejected)
+	if len(rejected) > 0 && nil != h.config.MetricsRejectedCallback {
+		h.config.MetricsRejectedCallback(rejected)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricPartialRejection(t *testing.T) {
+	// Metrics older than maxMetricRequeueAge are not re-queued.
+	start := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	ms := strconv.FormatInt(start.UnixNano()/int64(time.Millisecond), 10)
+	var posts int
+	var rejected []RejectedMetric
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			posts++
+			return &http.Response{
+				StatusCode: 202,
+				Body: ioutil.NopCloser(bytes.NewReader([]byte(`{"rejected":[
+					{"index":0,"retryable":true},
+					{"index":2,"retryable":false,"reason":"invalid name"},
+					{"index":17,"retryable":true}
+				]}`))),
+			}, nil
+		})
+		cfg.MetricsRejectedCallback = func(r []RejectedMetric) {
+			rejected = append(rejected, r...)
+		}
+	})
+	h.RecordMetric(Count{Name: "retryMe", Value: 1, Timestamp: start, Interval: time.Second})
+	h.RecordMetric(Count{Name: "accepted", Value: 2, Timestamp: start, Interval: time.Second})
+	h.RecordMetric(Gauge{Name: "dropMe", Value: 3, Timestamp: start})
+	h.HarvestNow(context.Background())
+
+	if posts != 1 {
+		t.Error("incorrect number of posts", posts)
+	}
+	if len(rejected) != 1 || rejected[0].Reason != "invalid name" ||
+		string(rejected[0].JSON) != `{"name":"dropMe","type":"gauge","value":3,"timestamp":`+ms+`}` {
+		t.Error(rejected)
+	}
+	if len(h.rawMetrics) != 1 {
+		t.Fatal(h.rawMetrics)
+	}
+	expect := `[
+		{"interval.ms":1000,"name":"retryMe","timestamp":` + ms + `,"type":"count","value":1}
+	]`
+	testHarvesterMetrics(t, h, expect)
+}
+
+func TestMetricRejectionRequeueBufferFull(t *testing.T) {
+	var lock sync.Mutex
+	var drops []dropRecord
+	var h *Harvester
+	h, _ = NewHarvester(configTesting, configureDropsToSlice(&lock, &drops), func(cfg *Config) {
+		cfg.MaxBufferedPayloads = 2
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// Only one of the rejected metrics fits in the buffer.
+			h.RecordMetric(Gauge{Name: "third", Value: 3, Timestamp: time.Now()})
+			return &http.Response{
+				StatusCode: 202,
+				Body: ioutil.NopCloser(bytes.NewReader([]byte(`{"rejected":[
+					{"index":0,"retryable":true},
+					{"index":1,"retryable":true}
+				]}`))),
+			}, nil
+		})
+	})
+	h.RecordMetric(Gauge{Name: "first", Value: 1, Timestamp: time.Now()})
+	h.RecordMetric(Gauge{Name: "second", Value: 2, Timestamp: time.Now()})
+	h.HarvestNow(context.Background())
+
+	if len(h.rawMetrics) != 2 {
+		t.Error(h.rawMetrics)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(drops) != 1 || drops[0] != (dropRecord{"metrics", 1, DropReasonBufferFull}) {
+		t.Error(drops)
+	}
+}
+
+func TestMetricRejectionAppliesCommonBlock(t *testing.T) {
+	common := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	h, _ := NewHarvester(configTesting)
+	h.RecordMetric(Count{Name: "noTimestamp", Value: 1})
+	h.RecordMetric(Gauge{Name: "gauge", Value: 2})
+	reqs := h.metricRequests(h.rawMetrics, nil, common, common.Add(5*time.Second))
+	h.rawMetrics = nil
+	if len(reqs) != 1 {
+		t.Fatal(reqs)
+	}
+
+	h.handleMetricRejections(reqs[0], []byte(`{"rejected":[{"index":0,"retryable":true},{"index":1,"retryable":true}]}`))
+	if len(h.rawMetrics) != 2 {
+		t.Fatal(h.rawMetrics)
+	}
+	for _, m := range h.rawMetrics {
+		if ts := m.timestamp(); !ts.Equal(common) {
+			t.Error("incorrect requeued timestamp", ts)
+		}
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(h.rawMetrics[0].(requeuedMetric).js, &fields)
+	if fields["interval.ms"] != 5000.0 {
+		t.Error(fields)
+	}
+	var gaugeFields map[string]interface{}
+	json.Unmarshal(h.rawMetrics[1].(requeuedMetric).js, &gaugeFields)
+	if _, ok := gaugeFields["interval.ms"]; ok {
+		t.Error("gauge should not have an interval", gaugeFields)
+	}
+}
+
+func TestMetricRejectionRequeueAge(t *testing.T) {
+	var rejected []RejectedMetric
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.MetricsRejectedCallback = func(r []RejectedMetric) {
+			rejected = append(rejected, r...)
+		}
+	})
+	now := time.Now()
+	h.RecordMetric(Gauge{Name: "recent", Value: 1, Timestamp: now})
+	h.RecordMetric(Gauge{Name: "old", Value: 2, Timestamp: now.Add(-2 * maxMetricRequeueAge)})
+	reqs := h.swapOutMetrics(now)
+	if len(reqs) != 1 {
+		t.Fatal(reqs)
+	}
+	h.handleMetricRejections(reqs[0], []byte(`{"rejected":[{"index":0,"retryable":true},{"index":1,"retryable":true}]}`))
+	if len(h.rawMetrics) != 1 || !h.rawMetrics[0].timestamp().Equal(now.Truncate(time.Millisecond)) {
+		t.Error(h.rawMetrics)
+	}
+	if len(rejected) != 1 || !bytes.Contains(rejected[0].JSON, []byte(`"old"`)) {
+		t.Error(rejected)
+	}
+}
+
+func TestMetricRejectionFailedResponse(t *testing.T) {
+	var posts int
+	var lock sync.Mutex
+	var drops []dropRecord
+	h, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops), func(cfg *Config) {
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			posts++
+			return &http.Response{
+				StatusCode: 400,
+				Body:       ioutil.NopCloser(bytes.NewReader([]byte(`{"rejected":[{"index":0,"retryable":true}]}`))),
+			}, nil
+		})
+	})
+	h.RecordMetric(Gauge{Name: "gauge", Value: 1, Timestamp: time.Now()})
+	h.HarvestNow(context.Background())
+
+	if posts != 1 || len(h.rawMetrics) != 0 {
+		t.Error(posts, h.rawMetrics)
+	}
+	if len(drops) != 1 || drops[0] != (dropRecord{"metrics", 1, DropReasonRejected}) {
+		t.Error(drops)
+	}
+}
+
+func TestMetricRejectionIgnoresOtherBodies(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	h.RecordMetric(Count{Name: "myCount"})
+	reqs := h.swapOutMetrics(time.Now())
+	for _, body := range []string{``, `{}`, `not json`, `{"requestId":"abc"}`} {
+		h.handleMetricRejections(reqs[0], []byte(body))
+	}
+	if len(h.rawMetrics) != 0 {
+		t.Error(h.rawMetrics)
+	}
+}
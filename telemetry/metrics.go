@@ -6,7 +6,10 @@ package telemetry
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"sort"
 	"time"
 
 	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
@@ -44,6 +47,16 @@ type Count struct {
 	Interval time.Duration
 	// Set to true to force the value of interval to be written to the payload
 	ForceIntervalValid bool
+	// ServerTimestamp, if true, omits the timestamp and interval from this
+	// metric, including those inherited from the common block, so that New
+	// Relic assigns them when the metric is received.  Timestamp, Interval,
+	// and ForceIntervalValid are then ignored.  This is only appropriate
+	// when the metric is sent soon after it is measured, since delays such
+	// as retries shift the window it is reported in.
+	ServerTimestamp bool
+	// nonMonotonic is set for counts of an AggregatedCount updated with Add,
+	// which may decrease.  It is only used by the OTLP/JSON format.
+	nonMonotonic bool
 }
 
 func (m Count) validate() map[string]interface{} {
@@ -57,10 +70,28 @@ func (m Count) validate() map[string]interface{} {
 	return nil
 }
 
-// Metric is implemented by Count, Gauge, and Summary.
+// usesServerTimestamp returns true if the metric is a Count or Summary with
+// ServerTimestamp set.
+func usesServerTimestamp(m Metric) bool {
+	switch v := m.(type) {
+	case Count:
+		return v.ServerTimestamp
+	case *Count:
+		return v.ServerTimestamp
+	case Summary:
+		return v.ServerTimestamp
+	case *Summary:
+		return v.ServerTimestamp
+	}
+	return false
+}
+
+// Metric is implemented by Count, Gauge, Summary, and Histogram.
 type Metric interface {
 	writeJSON(buf *bytes.Buffer)
 	validate() map[string]interface{}
+	timestamp() time.Time
+	withTimestamp(time.Time) Metric
 }
 
 func writeTimestampInterval(w *internal.JSONFieldsWriter, timestamp time.Time, interval time.Duration, forceIntervalValid bool) {
@@ -72,13 +103,27 @@ func writeTimestampInterval(w *internal.JSONFieldsWriter, timestamp time.Time, i
 	}
 }
 
+func (m Count) timestamp() time.Time {
+	if m.ServerTimestamp {
+		return time.Time{}
+	}
+	return m.Timestamp
+}
+
+func (m Count) withTimestamp(t time.Time) Metric {
+	m.Timestamp = t
+	return m
+}
+
 func (m Count) writeJSON(buf *bytes.Buffer) {
 	w := internal.JSONFieldsWriter{Buf: buf}
 	w.Buf.WriteByte('{')
 	w.StringField("name", m.Name)
 	w.StringField("type", "count")
 	w.FloatField("value", m.Value)
-	writeTimestampInterval(&w, m.Timestamp, m.Interval, m.ForceIntervalValid)
+	if !m.ServerTimestamp {
+		writeTimestampInterval(&w, m.Timestamp, m.Interval, m.ForceIntervalValid)
+	}
 	if nil != m.Attributes {
 		w.WriterField("attributes", internal.Attributes(m.Attributes))
 	} else if nil != m.AttributesJSON {
@@ -121,6 +166,77 @@ type Summary struct {
 	Interval time.Duration
 	// Set to true to force the value of interval to be written to the payload
 	ForceIntervalValid bool
+	// ServerTimestamp, if true, omits the timestamp and interval from this
+	// metric, including those inherited from the common block, so that New
+	// Relic assigns them when the metric is received.  Timestamp, Interval,
+	// and ForceIntervalValid are then ignored.  This is only appropriate
+	// when the metric is sent soon after it is measured, since delays such
+	// as retries shift the window it is reported in.
+	ServerTimestamp bool
+}
+
+// NewCountOnlySummary creates a Summary with the given count and sum for which
+// no min or max was observed.  Min and Max are set to NaN so that they are
+// sent as null.  This is useful for aggregated data whose extremes were not
+// tracked.
+func NewCountOnlySummary(name string, attributes map[string]interface{}, count, sum float64) Summary {
+	return Summary{
+		Name:       name,
+		Attributes: attributes,
+		Count:      count,
+		Sum:        sum,
+		Min:        math.NaN(),
+		Max:        math.NaN(),
+	}
+}
+
+const (
+	// percentileAttribute is the attribute of the gauges created by
+	// NewPercentileGauges containing the percentile.
+	percentileAttribute = "percentile"
+	// percentileNameSuffix is appended to the name of the gauges created
+	// by NewPercentileGauges.
+	percentileNameSuffix = ".percentiles"
+)
+
+var errPercentileRange = errors.New("percentile must be between 0 and 100")
+
+// NewPercentileGauges creates a Gauge for each of the precomputed percentiles
+// of a metric, eg. from a source which reports its p50, p90, and p99.  The
+// percentiles map each percentile, such as 99, to its value.  The Summary
+// metric cannot represent percentiles, so they are sent as Gauges named name
+// + ".percentiles" whose attributes are the given attributes plus a
+// "percentile" attribute, which dashboards can facet by.  The Gauges are
+// sorted by percentile.  An error is returned if a percentile is outside of
+// [0, 100].
+//
+//	gauges, err := telemetry.NewPercentileGauges("request.duration", attributes,
+//		map[float64]float64{50: p50, 99: p99}, time.Now())
+func NewPercentileGauges(name string, attributes map[string]interface{}, percentiles map[float64]float64, timestamp time.Time) ([]Gauge, error) {
+	keys := make([]float64, 0, len(percentiles))
+	for p := range percentiles {
+		if !(p >= 0 && p <= 100) {
+			return nil, fmt.Errorf("%w: %v", errPercentileRange, p)
+		}
+		keys = append(keys, p)
+	}
+	sort.Float64s(keys)
+
+	gauges := make([]Gauge, len(keys))
+	for i, p := range keys {
+		attrs := make(map[string]interface{}, len(attributes)+1)
+		for k, v := range attributes {
+			attrs[k] = v
+		}
+		attrs[percentileAttribute] = p
+		gauges[i] = Gauge{
+			Name:       name + percentileNameSuffix,
+			Attributes: attrs,
+			Value:      percentiles[p],
+			Timestamp:  timestamp,
+		}
+	}
+	return gauges, nil
 }
 
 func (m Summary) validate() map[string]interface{} {
@@ -153,6 +269,18 @@ func (m Summary) validate() map[string]interface{} {
 	return nil
 }
 
+func (m Summary) timestamp() time.Time {
+	if m.ServerTimestamp {
+		return time.Time{}
+	}
+	return m.Timestamp
+}
+
+func (m Summary) withTimestamp(t time.Time) Metric {
+	m.Timestamp = t
+	return m
+}
+
 func (m Summary) writeJSON(buf *bytes.Buffer) {
 	w := internal.JSONFieldsWriter{Buf: buf}
 	buf.WriteByte('{')
@@ -166,7 +294,7 @@ func (m Summary) writeJSON(buf *bytes.Buffer) {
 	vw.FloatField("sum", m.Sum)
 	vw.FloatField("count", m.Count)
 	if math.IsNaN(m.Min) {
-		w.RawField("min", json.RawMessage(`null`))
+		vw.RawField("min", json.RawMessage(`null`))
 	} else {
 		vw.FloatField("min", m.Min)
 	}
@@ -177,7 +305,9 @@ func (m Summary) writeJSON(buf *bytes.Buffer) {
 	}
 	buf.WriteByte('}')
 
-	writeTimestampInterval(&w, m.Timestamp, m.Interval, m.ForceIntervalValid)
+	if !m.ServerTimestamp {
+		writeTimestampInterval(&w, m.Timestamp, m.Interval, m.ForceIntervalValid)
+	}
 	if nil != m.Attributes {
 		w.WriterField("attributes", internal.Attributes(m.Attributes))
 	} else if nil != m.AttributesJSON {
@@ -212,6 +342,15 @@ type Gauge struct {
 	Timestamp time.Time
 }
 
+// GaugePoint is a single sample of a Gauge time series.  See
+// Harvester.RecordGaugeSeries.
+type GaugePoint struct {
+	// Value is the value of the gauge at Timestamp.
+	Value float64
+	// Timestamp is the time at which the value was gathered.
+	Timestamp time.Time
+}
+
 func (m Gauge) validate() map[string]interface{} {
 	if err := isFloatValid(m.Value); err != nil {
 		return map[string]interface{}{
@@ -223,6 +362,13 @@ func (m Gauge) validate() map[string]interface{} {
 	return nil
 }
 
+func (m Gauge) timestamp() time.Time { return m.Timestamp }
+
+func (m Gauge) withTimestamp(t time.Time) Metric {
+	m.Timestamp = t
+	return m
+}
+
 func (m Gauge) writeJSON(buf *bytes.Buffer) {
 	w := internal.JSONFieldsWriter{Buf: buf}
 	buf.WriteByte('{')
@@ -273,6 +419,79 @@ func (mcb *metricCommonBlock) WriteDataEntry(buf *bytes.Buffer) *bytes.Buffer {
 	return buf
 }
 
+// metricSnapshot holds metrics recorded with Harvester.RecordMetricSnapshot
+// which are sent in their own batch with the given timestamp and interval.
+type metricSnapshot struct {
+	metrics   []Metric
+	timestamp time.Time
+	interval  time.Duration
+}
+
+// withCommonTiming returns the metrics with the snapshot timestamp and
+// interval applied to those that do not set their own, as the common block
+// would apply them.
+func (ms metricSnapshot) withCommonTiming() []Metric {
+	metrics := make([]Metric, 0, len(ms.metrics))
+	for _, m := range ms.metrics {
+		switch v := m.(type) {
+		case *Count:
+			m = *v
+		case *Summary:
+			m = *v
+		case *Gauge:
+			m = *v
+		case *Histogram:
+			m = *v
+		}
+		switch v := m.(type) {
+		case Count:
+			if !v.ServerTimestamp {
+				if v.Timestamp.IsZero() {
+					v.Timestamp = ms.timestamp
+				}
+				if v.Interval == 0 {
+					v.Interval = ms.interval
+				}
+			}
+			m = v
+		case Summary:
+			if !v.ServerTimestamp {
+				if v.Timestamp.IsZero() {
+					v.Timestamp = ms.timestamp
+				}
+				if v.Interval == 0 {
+					v.Interval = ms.interval
+				}
+			}
+			m = v
+		case Gauge:
+			if v.Timestamp.IsZero() {
+				v.Timestamp = ms.timestamp
+			}
+			m = v
+		case Histogram:
+			if v.Timestamp.IsZero() {
+				v.Timestamp = ms.timestamp
+			}
+			if v.Interval == 0 {
+				v.Interval = ms.interval
+			}
+			m = v
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// countSnapshotMetrics returns the number of metrics in the snapshots.
+func countSnapshotMetrics(snapshots []metricSnapshot) int {
+	var n int
+	for _, ms := range snapshots {
+		n += len(ms.metrics)
+	}
+	return n
+}
+
 // MetricCommonBlockOption is a function that can be used to configure a metric common block
 type MetricCommonBlockOption func(block *metricCommonBlock) error
 
@@ -339,14 +558,19 @@ type metricGroup struct {
 	Metrics []Metric
 }
 
-// split will split the MetricGroup into 2 equal parts, returning a slice of MetricBatches.
-// If the number of metrics in the original is 0 or 1 then nil is returned.
-func (group *metricGroup) split() []splittablePayloadEntry {
+// split will split the MetricGroup into 2 parts at the point chosen by the
+// strategy, returning a slice of MetricBatches.  If the number of metrics in
+// the original is 0 or 1 then nil is returned.
+func (group *metricGroup) split(strategy SplitStrategy) []splittablePayloadEntry {
 	if len(group.Metrics) < 2 {
 		return nil
 	}
 
-	half := len(group.Metrics) / 2
+	half := splitIndex(strategy, len(group.Metrics), func(i int) int {
+		buf := &bytes.Buffer{}
+		group.Metrics[i].writeJSON(buf)
+		return buf.Len()
+	})
 	mb1 := *group
 	mb1.Metrics = group.Metrics[:half]
 	mb2 := *group
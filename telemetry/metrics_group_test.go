@@ -137,21 +137,21 @@ func testGroupJSON(t testing.TB, batches []Batch, expect string) {
 func TestSplit(t *testing.T) {
 	// test len 0
 	group := NewMetricGroup(nil)
-	split := group.(splittablePayloadEntry).split()
+	split := group.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if split != nil {
 		t.Error(split)
 	}
 
 	// test len 1
 	group = NewMetricGroup([]Metric{Count{}})
-	split = group.(splittablePayloadEntry).split()
+	split = group.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if split != nil {
 		t.Error(split)
 	}
 
 	// test len 2
 	group = NewMetricGroup([]Metric{Count{Name: "c1"}, Count{Name: "c2"}})
-	split = group.(splittablePayloadEntry).split()
+	split = group.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if len(split) != 2 {
 		t.Error("split into incorrect number of slices", len(split))
 	}
@@ -160,7 +160,7 @@ func TestSplit(t *testing.T) {
 
 	// test len 3
 	group = NewMetricGroup([]Metric{Count{Name: "c1"}, Count{Name: "c2"}, Count{Name: "c3"}})
-	split = group.(splittablePayloadEntry).split()
+	split = group.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if len(split) != 2 {
 		t.Error("split into incorrect number of slices", len(split))
 	}
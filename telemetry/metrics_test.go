@@ -5,6 +5,7 @@ package telemetry
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"math"
 	"reflect"
@@ -71,6 +72,132 @@ func TestMetricPayload(t *testing.T) {
 	}
 }
 
+func TestNewCountOnlySummary(t *testing.T) {
+	now := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	m := NewCountOnlySummary("count-only", map[string]interface{}{"zip": "zap"}, 4.0, 10.0)
+	if fields := m.validate(); nil != fields {
+		t.Fatal(fields)
+	}
+	m.Timestamp = now
+	buf := &bytes.Buffer{}
+	m.writeJSON(buf)
+	expect := compactJSONString(`{
+		"name":"count-only",
+		"type":"summary",
+		"value":{"sum":10,"count":4,"min":null,"max":null},
+		"timestamp":1417136460000,
+		"attributes":{"zip":"zap"}
+	}`)
+	if js := buf.String(); js != expect {
+		t.Errorf("\nexpect=%s\nactual=%s\n", expect, js)
+	}
+}
+
+func TestNewPercentileGauges(t *testing.T) {
+	now := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	gauges, err := NewPercentileGauges("duration", nil, map[float64]float64{99.9: 12, 50: 3, 0: 1}, now)
+	if nil != err {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte('[')
+	for i, g := range gauges {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		g.writeJSON(buf)
+	}
+	buf.WriteByte(']')
+	expect := compactJSONString(`[
+		{"name":"duration.percentiles","type":"gauge","value":1,"timestamp":1417136460000,"attributes":{"percentile":0}},
+		{"name":"duration.percentiles","type":"gauge","value":3,"timestamp":1417136460000,"attributes":{"percentile":50}},
+		{"name":"duration.percentiles","type":"gauge","value":12,"timestamp":1417136460000,"attributes":{"percentile":99.9}}
+	]`)
+	if js := buf.String(); js != expect {
+		t.Errorf("\nexpect=%s\nactual=%s\n", expect, js)
+	}
+}
+
+func TestNewPercentileGaugesAttributes(t *testing.T) {
+	attributes := map[string]interface{}{"zip": "zap"}
+	gauges, err := NewPercentileGauges("duration", attributes, map[float64]float64{50: 3}, time.Time{})
+	if nil != err {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{"zip": "zap", "percentile": 50.0}
+	if len(gauges) != 1 || !reflect.DeepEqual(gauges[0].Attributes, expect) {
+		t.Error(gauges)
+	}
+	// The caller's attributes must not be modified.
+	if len(attributes) != 1 {
+		t.Error(attributes)
+	}
+}
+
+func TestNewPercentileGaugesInvalid(t *testing.T) {
+	for _, p := range []float64{-1, 100.5, math.NaN(), math.Inf(1)} {
+		gauges, err := NewPercentileGauges("duration", nil, map[float64]float64{50: 3, p: 1}, time.Time{})
+		if nil != gauges || !errors.Is(err, errPercentileRange) {
+			t.Error(p, gauges, err)
+		}
+	}
+}
+
+func TestMetricPayloadServerTimestamp(t *testing.T) {
+	// Test that metrics using server timestamps are sent in a batch whose
+	// common block has no timestamp or interval.
+	now := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(ConfigCommonAttributes(map[string]interface{}{"zop": "zup"}), configTesting)
+	h.RecordMetric(Count{
+		Name:            "server-count",
+		Value:           1,
+		Timestamp:       now,
+		Interval:        time.Second,
+		ServerTimestamp: true,
+	})
+	h.RecordMetric(&Summary{
+		Name:            "server-summary",
+		Count:           1,
+		Sum:             2,
+		Min:             2,
+		Max:             2,
+		ServerTimestamp: true,
+	})
+	h.RecordMetric(Count{Name: "count", Value: 1})
+	h.lastHarvest = now
+	end := h.lastHarvest.Add(5 * time.Second)
+	reqs := h.swapOutMetrics(end)
+	if len(reqs) != 1 {
+		t.Fatal(reqs)
+	}
+	bodyReader, _ := reqs[0].GetBody()
+	compressedBytes, _ := ioutil.ReadAll(bodyReader)
+	js, _ := internal.Uncompress(compressedBytes)
+	actual := string(js)
+	expect := `[{
+		"common":{
+			"timestamp":1417136460000,
+			"interval.ms":5000,
+			"attributes":{"zop":"zup"}
+		},
+		"metrics":[
+			{"name":"count","type":"count","value":1}
+		]
+	},{
+		"common":{
+			"attributes":{"zop":"zup"}
+		},
+		"metrics":[
+			{"name":"server-count","type":"count","value":1},
+			{"name":"server-summary","type":"summary","value":{"sum":2,"count":1,"min":2,"max":2}}
+		]
+	}]`
+	compactExpect := compactJSONString(expect)
+	if compactExpect != actual {
+		t.Errorf("\nexpect=%s\nactual=%s\n", compactExpect, actual)
+	}
+}
+
 func TestVetAttributes(t *testing.T) {
 	testcases := []struct {
 		Input interface{}
@@ -0,0 +1,262 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+const (
+	otlpScopeName = "newrelic-telemetry-sdk-go"
+	// otlpTemporalityDelta is AGGREGATION_TEMPORALITY_DELTA.  Count metrics
+	// hold the change in value over their interval.
+	otlpTemporalityDelta = 1
+)
+
+// WithOTLPJSONMetrics creates a ClientOption to write metric request bodies in
+// the OTLP/JSON format used by OpenTelemetry collectors rather than the New
+// Relic format.  Each batch is written as a resourceMetrics entry whose
+// resource attributes are the batch's common attributes.  Only Count metrics,
+// which are written as delta sums, and Gauge metrics are supported.  Summary
+// metrics are omitted.  This option should only be used with a metric
+// RequestFactory, and is usually combined with WithEndpoint and WithPath.
+func WithOTLPJSONMetrics() ClientOption {
+	return func(o *requestFactory) {
+		o.bodyWriter = bufferOTLPMetricsBytes
+		o.contentType = defaultContentType
+	}
+}
+
+func bufferOTLPMetricsBytes(buf *bytes.Buffer, batches []Batch) {
+	buf.WriteString(`{"resourceMetrics":[`)
+	for i, batch := range batches {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeOTLPResourceMetrics(buf, batch)
+	}
+	buf.WriteString(`]}`)
+}
+
+func writeOTLPResourceMetrics(buf *bytes.Buffer, batch Batch) {
+	common := &metricCommonBlock{}
+	var metrics []Metric
+	for _, entry := range batch {
+		switch e := entry.(type) {
+		case *metricCommonBlock:
+			common = e
+		case *metricGroup:
+			metrics = append(metrics, e.Metrics...)
+		}
+	}
+
+	var resourceAttributes map[string]interface{}
+	if nil != common.attributes {
+		resourceAttributes = decodeOTLPAttributes(common.attributes.WriteDataEntry(&bytes.Buffer{}).Bytes())
+	}
+
+	buf.WriteString(`{"resource":{"attributes":`)
+	writeOTLPKeyValues(buf, resourceAttributes)
+	buf.WriteString(`},"scopeMetrics":[{"scope":`)
+	w := internal.JSONFieldsWriter{Buf: buf}
+	buf.WriteByte('{')
+	w.StringField("name", otlpScopeName)
+	w.StringField("version", version)
+	buf.WriteString(`},"metrics":[`)
+	needsComma := false
+	for _, m := range metrics {
+		start := buf.Len()
+		if needsComma {
+			buf.WriteByte(',')
+		}
+		if writeOTLPMetric(buf, m, common) {
+			needsComma = true
+		} else {
+			buf.Truncate(start)
+		}
+	}
+	buf.WriteString(`]}]}`)
+}
+
+// writeOTLPMetric writes the metric and returns true if it is of a
+// supported type.
+func writeOTLPMetric(buf *bytes.Buffer, m Metric, common *metricCommonBlock) bool {
+	switch v := m.(type) {
+	case *Count:
+		return writeOTLPMetric(buf, *v, common)
+	case *Gauge:
+		return writeOTLPMetric(buf, *v, common)
+	case Count:
+		startTime := v.Timestamp
+		if startTime.IsZero() {
+			startTime = common.timestamp
+		}
+		interval := v.Interval
+		if 0 == interval {
+			interval = common.interval
+		}
+		w := internal.JSONFieldsWriter{Buf: buf}
+		buf.WriteByte('{')
+		w.StringField("name", v.Name)
+		w.AddKey("sum")
+		buf.WriteString(`{"dataPoints":[`)
+		writeOTLPDataPoint(buf, otlpMetricAttributes(v.Attributes, v.AttributesJSON), startTime, startTime.Add(interval), v.Value)
+		buf.WriteString(`],"aggregationTemporality":`)
+		buf.WriteString(strconv.Itoa(otlpTemporalityDelta))
+		// Counts which may decrease are not monotonic, see
+		// AggregatedCount.Add.
+		monotonic := !v.nonMonotonic && v.Value >= 0
+		buf.WriteString(`,"isMonotonic":`)
+		buf.WriteString(strconv.FormatBool(monotonic))
+		buf.WriteString(`}}`)
+		return true
+	case Gauge:
+		timestamp := v.Timestamp
+		if timestamp.IsZero() {
+			timestamp = common.timestamp
+		}
+		w := internal.JSONFieldsWriter{Buf: buf}
+		buf.WriteByte('{')
+		w.StringField("name", v.Name)
+		w.AddKey("gauge")
+		buf.WriteString(`{"dataPoints":[`)
+		writeOTLPDataPoint(buf, otlpMetricAttributes(v.Attributes, v.AttributesJSON), time.Time{}, timestamp, v.Value)
+		buf.WriteString(`]}}`)
+		return true
+	}
+	return false
+}
+
+func writeOTLPDataPoint(buf *bytes.Buffer, attributes map[string]interface{}, startTime, timestamp time.Time, value float64) {
+	w := internal.JSONFieldsWriter{Buf: buf}
+	buf.WriteByte('{')
+	w.AddKey("attributes")
+	writeOTLPKeyValues(buf, attributes)
+	if !startTime.IsZero() {
+		w.StringField("startTimeUnixNano", strconv.FormatInt(startTime.UnixNano(), 10))
+	}
+	if !timestamp.IsZero() {
+		w.StringField("timeUnixNano", strconv.FormatInt(timestamp.UnixNano(), 10))
+	}
+	w.FloatField("asDouble", value)
+	buf.WriteByte('}')
+}
+
+func otlpMetricAttributes(attributes map[string]interface{}, attributesJSON json.RawMessage) map[string]interface{} {
+	if nil != attributes {
+		return attributes
+	}
+	return decodeOTLPAttributes(attributesJSON)
+}
+
+// decodeOTLPAttributes decodes a JSON object of attributes.  Numbers are
+// decoded as json.Number so that integers and doubles can be distinguished.
+func decodeOTLPAttributes(js []byte) map[string]interface{} {
+	if len(js) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	var attributes map[string]interface{}
+	if err := dec.Decode(&attributes); nil != err {
+		return nil
+	}
+	return attributes
+}
+
+// writeOTLPKeyValues writes the attributes as an array of OTLP KeyValue
+// objects sorted by key.  Attributes with unsupported values are omitted.
+func writeOTLPKeyValues(buf *bytes.Buffer, attributes map[string]interface{}) {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf.WriteByte('[')
+	needsComma := false
+	for _, k := range keys {
+		start := buf.Len()
+		if needsComma {
+			buf.WriteByte(',')
+		}
+		w := internal.JSONFieldsWriter{Buf: buf}
+		buf.WriteByte('{')
+		w.StringField("key", k)
+		w.AddKey("value")
+		if writeOTLPAnyValue(buf, attributes[k]) {
+			buf.WriteByte('}')
+			needsComma = true
+		} else {
+			buf.Truncate(start)
+		}
+	}
+	buf.WriteByte(']')
+}
+
+// writeOTLPAnyValue writes the value as an OTLP AnyValue object and returns
+// true if the value is of a supported type.  As required by OTLP/JSON, 64 bit
+// integers are written as strings.
+func writeOTLPAnyValue(buf *bytes.Buffer, val interface{}) bool {
+	if converted, ok := internal.ConvertAttribute(val); ok {
+		if _, custom := internal.ConvertAttribute(converted); custom {
+			return false
+		}
+		val = converted
+	}
+
+	start := buf.Len()
+	w := internal.JSONFieldsWriter{Buf: buf}
+	buf.WriteByte('{')
+	switch v := val.(type) {
+	case string:
+		w.StringField("stringValue", v)
+	case bool:
+		w.BoolField("boolValue", v)
+	case int:
+		w.StringField("intValue", strconv.FormatInt(int64(v), 10))
+	case int8:
+		w.StringField("intValue", strconv.FormatInt(int64(v), 10))
+	case int16:
+		w.StringField("intValue", strconv.FormatInt(int64(v), 10))
+	case int32:
+		w.StringField("intValue", strconv.FormatInt(int64(v), 10))
+	case int64:
+		w.StringField("intValue", strconv.FormatInt(v, 10))
+	case uint:
+		w.StringField("intValue", strconv.FormatUint(uint64(v), 10))
+	case uint8:
+		w.StringField("intValue", strconv.FormatUint(uint64(v), 10))
+	case uint16:
+		w.StringField("intValue", strconv.FormatUint(uint64(v), 10))
+	case uint32:
+		w.StringField("intValue", strconv.FormatUint(uint64(v), 10))
+	case uint64:
+		w.StringField("intValue", strconv.FormatUint(uint64(v), 10))
+	case float32:
+		w.FloatField("doubleValue", float64(v))
+	case float64:
+		w.FloatField("doubleValue", v)
+	case json.Number:
+		if i, err := v.Int64(); nil == err {
+			w.StringField("intValue", strconv.FormatInt(i, 10))
+		} else if f, err := v.Float64(); nil == err {
+			w.FloatField("doubleValue", f)
+		} else {
+			buf.Truncate(start)
+			return false
+		}
+	default:
+		buf.Truncate(start)
+		return false
+	}
+	buf.WriteByte('}')
+	return true
+}
@@ -0,0 +1,240 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+// The otlp types mirror the OTLP/JSON encoding of the metrics protobuf
+// messages and are used to decode request bodies.
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpMetric struct {
+	Name  string `json:"name"`
+	Gauge *struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	} `json:"gauge"`
+	Sum *struct {
+		DataPoints             []otlpDataPoint `json:"dataPoints"`
+		AggregationTemporality int             `json:"aggregationTemporality"`
+		IsMonotonic            bool            `json:"isMonotonic"`
+	} `json:"sum"`
+}
+
+type otlpMetricsData struct {
+	ResourceMetrics []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []struct {
+			Scope struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"scope"`
+			Metrics []otlpMetric `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+func decodeOTLPRequest(t *testing.T, batches []Batch) otlpMetricsData {
+	t.Helper()
+	factory, _ := NewMetricRequestFactory(WithNoDefaultKey(), WithOTLPJSONMetrics())
+	req, err := factory.BuildRequest(context.Background(), batches)
+	if nil != err {
+		t.Fatal(err)
+	}
+	compressed, _ := ioutil.ReadAll(req.Body)
+	js, err := internal.Uncompress(compressed)
+	if nil != err {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	var data otlpMetricsData
+	if err := dec.Decode(&data); nil != err {
+		t.Fatal("invalid OTLP/JSON", err, string(js))
+	}
+	return data
+}
+
+func strPtr(s string) *string     { return &s }
+func boolPtr(b bool) *bool        { return &b }
+func floatPtr(f float64) *float64 { return &f }
+
+func TestOTLPJSONMetrics(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	common, _ := NewMetricCommonBlock(
+		WithMetricTimestamp(start),
+		WithMetricInterval(5*time.Second),
+		WithMetricAttributes(map[string]interface{}{"service": "checkout"}),
+	)
+	group := NewMetricGroup([]Metric{
+		Count{
+			Name:       "requests",
+			Attributes: map[string]interface{}{"zip": "zap", "ok": true, "code": 200, "ratio": 0.5},
+			Value:      3,
+		},
+		Gauge{
+			Name:           "temperature",
+			AttributesJSON: json.RawMessage(`{"room":"kitchen","floor":2}`),
+			Value:          21.5,
+			Timestamp:      start.Add(time.Second),
+		},
+		Summary{Name: "unsupported", Count: 1, Sum: 1, Min: 1, Max: 1},
+	})
+
+	data := decodeOTLPRequest(t, []Batch{{common, group}})
+	if len(data.ResourceMetrics) != 1 || len(data.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatal(data)
+	}
+	rm := data.ResourceMetrics[0]
+	expectResource := []otlpKeyValue{{Key: "service", Value: otlpAnyValue{StringValue: strPtr("checkout")}}}
+	if !reflect.DeepEqual(rm.Resource.Attributes, expectResource) {
+		t.Error("incorrect resource attributes", rm.Resource.Attributes)
+	}
+	sm := rm.ScopeMetrics[0]
+	if sm.Scope.Name != otlpScopeName || sm.Scope.Version != version {
+		t.Error("incorrect scope", sm.Scope)
+	}
+	if len(sm.Metrics) != 2 {
+		t.Fatal("summary metrics should be omitted", sm.Metrics)
+	}
+
+	count := sm.Metrics[0]
+	if count.Name != "requests" || nil == count.Sum || nil != count.Gauge {
+		t.Fatal(count)
+	}
+	if count.Sum.AggregationTemporality != otlpTemporalityDelta || !count.Sum.IsMonotonic {
+		t.Error("incorrect sum fields", count.Sum)
+	}
+	expectCount := []otlpDataPoint{{
+		Attributes: []otlpKeyValue{
+			{Key: "code", Value: otlpAnyValue{IntValue: strPtr("200")}},
+			{Key: "ok", Value: otlpAnyValue{BoolValue: boolPtr(true)}},
+			{Key: "ratio", Value: otlpAnyValue{DoubleValue: floatPtr(0.5)}},
+			{Key: "zip", Value: otlpAnyValue{StringValue: strPtr("zap")}},
+		},
+		StartTimeUnixNano: "1417136460000000000",
+		TimeUnixNano:      "1417136465000000000",
+		AsDouble:          3,
+	}}
+	if !reflect.DeepEqual(count.Sum.DataPoints, expectCount) {
+		t.Errorf("incorrect count data points: %+v", count.Sum.DataPoints)
+	}
+
+	gauge := sm.Metrics[1]
+	if gauge.Name != "temperature" || nil == gauge.Gauge || nil != gauge.Sum {
+		t.Fatal(gauge)
+	}
+	expectGauge := []otlpDataPoint{{
+		Attributes: []otlpKeyValue{
+			{Key: "floor", Value: otlpAnyValue{IntValue: strPtr("2")}},
+			{Key: "room", Value: otlpAnyValue{StringValue: strPtr("kitchen")}},
+		},
+		TimeUnixNano: "1417136461000000000",
+		AsDouble:     21.5,
+	}}
+	if !reflect.DeepEqual(gauge.Gauge.DataPoints, expectGauge) {
+		t.Errorf("incorrect gauge data points: %+v", gauge.Gauge.DataPoints)
+	}
+}
+
+func TestOTLPJSONMetricsUnsignedAttributes(t *testing.T) {
+	group := NewMetricGroup([]Metric{Gauge{
+		Name:       "gauge",
+		Attributes: map[string]interface{}{"max": uint64(math.MaxUint64), "small": uint8(7)},
+		Value:      1,
+	}})
+	data := decodeOTLPRequest(t, []Batch{{group}})
+	gauge := data.ResourceMetrics[0].ScopeMetrics[0].Metrics[0]
+	expect := []otlpKeyValue{
+		{Key: "max", Value: otlpAnyValue{IntValue: strPtr("18446744073709551615")}},
+		{Key: "small", Value: otlpAnyValue{IntValue: strPtr("7")}},
+	}
+	if attrs := gauge.Gauge.DataPoints[0].Attributes; !reflect.DeepEqual(attrs, expect) {
+		t.Errorf("incorrect attributes: %+v", attrs)
+	}
+}
+
+func TestOTLPJSONMetricsMultipleBatches(t *testing.T) {
+	g1 := NewMetricGroup([]Metric{Gauge{Name: "a", Value: 1}})
+	g2 := NewMetricGroup([]Metric{Summary{Name: "b"}})
+	data := decodeOTLPRequest(t, []Batch{{g1}, {g2}})
+	if len(data.ResourceMetrics) != 2 {
+		t.Fatal(data)
+	}
+	if m := data.ResourceMetrics[0].ScopeMetrics[0].Metrics; len(m) != 1 || m[0].Name != "a" {
+		t.Error(m)
+	}
+	if m := data.ResourceMetrics[1].ScopeMetrics[0].Metrics; len(m) != 0 {
+		t.Error(m)
+	}
+}
+
+func TestOTLPJSONMetricsPerRequestOption(t *testing.T) {
+	factory, _ := NewMetricRequestFactory(WithNoDefaultKey())
+	batches := []Batch{{NewMetricGroup([]Metric{Gauge{Name: "a", Value: 1}})}}
+	req, _ := factory.BuildRequest(context.Background(), batches, WithOTLPJSONMetrics())
+	compressed, _ := ioutil.ReadAll(req.Body)
+	js, _ := internal.Uncompress(compressed)
+	var data otlpMetricsData
+	if err := json.Unmarshal(js, &data); nil != err || len(data.ResourceMetrics) != 1 {
+		t.Error(err, string(js))
+	}
+}
+
+func TestOTLPJSONMetricsNonMonotonicCounts(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	h.MetricAggregator().Count("increased", nil).Increase(2)
+	h.MetricAggregator().Count("added", nil).Add(1)
+	upDown := h.MetricAggregator().Count("upDown", nil)
+	upDown.Add(-1)
+	metrics, _ := h.takeMetrics(time.Now())
+	metrics = append(metrics, Count{Name: "negative", Value: -1})
+
+	data := decodeOTLPRequest(t, []Batch{{NewMetricGroup(metrics)}})
+	monotonic := make(map[string]bool)
+	for _, m := range data.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		monotonic[m.Name] = m.Sum.IsMonotonic
+	}
+	expect := map[string]bool{"increased": true, "added": false, "upDown": false, "negative": false}
+	if !reflect.DeepEqual(monotonic, expect) {
+		t.Error(monotonic)
+	}
+
+	// The count stays non-monotonic in later harvests.
+	upDown.Add(2)
+	metrics, _ = h.takeMetrics(time.Now())
+	data = decodeOTLPRequest(t, []Batch{{NewMetricGroup(metrics)}})
+	if m := data.ResourceMetrics[0].ScopeMetrics[0].Metrics; len(m) != 1 || m[0].Sum.IsMonotonic {
+		t.Error(m)
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	errRegistryName        = errors.New("metric name must not be empty")
+	errRegistryLabelName   = errors.New("label names must be unique and not empty")
+	errRegistryLabelValues = errors.New("number of label values does not match the label names")
+)
+
+// MetricRegistry provides a Prometheus style API for recording metrics.
+// Metrics are registered once with the names of their labels, and are then
+// recorded with the values of those labels.  The registry holds the current
+// value of each counter and gauge for each combination of label values, and
+// records that state to the Harvester before every harvest of metrics, so
+// the series are sent with every harvest even if they are not updated.
+//
+//	registry := telemetry.NewMetricRegistry(h)
+//	requests, err := registry.RegisterCounter("http.requests", "method", "code")
+//	...
+//	requests.Inc("GET", "200")
+type MetricRegistry struct {
+	harvester *Harvester
+
+	lock     sync.Mutex
+	names    map[string]bool
+	counters []*RegisteredCounter
+	gauges   []*RegisteredGauge
+}
+
+// NewMetricRegistry creates a MetricRegistry which records metrics to the
+// Harvester.
+func NewMetricRegistry(h *Harvester) *MetricRegistry {
+	r := &MetricRegistry{
+		harvester: h,
+		names:     make(map[string]bool),
+	}
+	if nil != h {
+		h.addMetricCollector(r.collect)
+	}
+	return r
+}
+
+// collect records the state of the registered counters and gauges to the
+// Harvester.  It is called before each harvest of metrics.
+func (r *MetricRegistry) collect() {
+	r.lock.Lock()
+	counters := r.counters
+	gauges := r.gauges
+	r.lock.Unlock()
+
+	for _, c := range counters {
+		c.collect()
+	}
+	for _, g := range gauges {
+		g.collect()
+	}
+}
+
+// register validates and reserves the name of a metric.
+func (r *MetricRegistry) register(name string, labelNames []string) (*registeredMetric, error) {
+	if name == "" {
+		return nil, errRegistryName
+	}
+	seen := make(map[string]bool, len(labelNames))
+	for _, l := range labelNames {
+		if l == "" || seen[l] {
+			return nil, errRegistryLabelName
+		}
+		seen[l] = true
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.names[name] {
+		return nil, fmt.Errorf("metric %q is already registered", name)
+	}
+	r.names[name] = true
+	return &registeredMetric{
+		harvester:  r.harvester,
+		name:       name,
+		labelNames: append([]string(nil), labelNames...),
+		series:     make(map[string]*registrySeries),
+	}, nil
+}
+
+// RegisterCounter registers a counter with the given label names.  An error
+// is returned if the name is empty or already registered, or if the label
+// names are empty or repeated.
+func (r *MetricRegistry) RegisterCounter(name string, labelNames ...string) (*RegisteredCounter, error) {
+	m, err := r.register(name, labelNames)
+	if nil != err {
+		return nil, err
+	}
+	c := &RegisteredCounter{registeredMetric: m}
+	r.lock.Lock()
+	r.counters = append(r.counters, c)
+	r.lock.Unlock()
+	return c, nil
+}
+
+// RegisterGauge registers a gauge with the given label names.  An error is
+// returned if the name is empty or already registered, or if the label names
+// are empty or repeated.
+func (r *MetricRegistry) RegisterGauge(name string, labelNames ...string) (*RegisteredGauge, error) {
+	m, err := r.register(name, labelNames)
+	if nil != err {
+		return nil, err
+	}
+	g := &RegisteredGauge{registeredMetric: m}
+	r.lock.Lock()
+	r.gauges = append(r.gauges, g)
+	r.lock.Unlock()
+	return g, nil
+}
+
+// RegisterHistogram registers a histogram with the given label names.  An
+// error is returned if the name is empty or already registered, or if the
+// label names are empty or repeated.
+func (r *MetricRegistry) RegisterHistogram(name string, labelNames ...string) (*RegisteredHistogram, error) {
+	m, err := r.register(name, labelNames)
+	if nil != err {
+		return nil, err
+	}
+	return &RegisteredHistogram{registeredMetric: m}, nil
+}
+
+// registrySeries is the state of a registered counter or gauge for one
+// combination of label values.
+type registrySeries struct {
+	attributes map[string]interface{}
+	value      float64
+	// reported is the counter value recorded in the previous harvest.
+	reported float64
+}
+
+// registeredMetric is the name, label names, and series of a registered
+// metric.
+type registeredMetric struct {
+	harvester  *Harvester
+	name       string
+	labelNames []string
+
+	lock   sync.Mutex
+	series map[string]*registrySeries
+}
+
+// attributes returns the attributes for the label values.  false is returned,
+// and the value is dropped, if the number of label values is incorrect.
+func (m *registeredMetric) attributes(labelValues []string) (map[string]interface{}, bool) {
+	if nil == m.harvester {
+		return nil, false
+	}
+	if len(labelValues) != len(m.labelNames) {
+		m.harvester.config.logError(map[string]interface{}{
+			"message": "invalid registered metric label values",
+			"name":    m.name,
+			"err":     errRegistryLabelValues.Error(),
+		})
+		m.harvester.config.drop(SignalMetrics, 1, DropReasonValidation)
+		return nil, false
+	}
+	attributes := make(map[string]interface{}, len(labelValues))
+	for i, v := range labelValues {
+		attributes[m.labelNames[i]] = v
+	}
+	return attributes, true
+}
+
+// update applies fn to the series with the given label values, creating it
+// if necessary.
+func (m *registeredMetric) update(labelValues []string, fn func(*registrySeries)) {
+	attributes, ok := m.attributes(labelValues)
+	if !ok {
+		return
+	}
+	key := strings.Join(labelValues, "\x00")
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &registrySeries{attributes: attributes}
+		m.series[key] = s
+	}
+	fn(s)
+}
+
+// RegisteredCounter is a counter registered with a MetricRegistry.  The
+// registry holds its cumulative value, and the increase since the previous
+// harvest is recorded as an AggregatedCount before each harvest, including
+// increases of zero.
+type RegisteredCounter struct{ *registeredMetric }
+
+// Add increases the counter with the given label values by val, which must
+// be non-negative.
+func (c *RegisteredCounter) Add(val float64, labelValues ...string) {
+	if nil == c || val < 0 {
+		return
+	}
+	c.update(labelValues, func(s *registrySeries) { s.value += val })
+}
+
+// Inc increases the counter with the given label values by one.
+func (c *RegisteredCounter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *RegisteredCounter) collect() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, s := range c.series {
+		c.harvester.MetricAggregator().Count(c.name, s.attributes).Increase(s.value - s.reported)
+		s.reported = s.value
+	}
+}
+
+// RegisteredGauge is a gauge registered with a MetricRegistry.  The registry
+// holds the last value set, which is recorded as an AggregatedGauge before
+// each harvest.
+type RegisteredGauge struct{ *registeredMetric }
+
+// Set sets the value of the gauge with the given label values.
+func (g *RegisteredGauge) Set(val float64, labelValues ...string) {
+	if nil == g {
+		return
+	}
+	g.update(labelValues, func(s *registrySeries) { s.value = val })
+}
+
+func (g *RegisteredGauge) collect() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, s := range g.series {
+		g.harvester.MetricAggregator().Gauge(g.name, s.attributes).Value(s.value)
+	}
+}
+
+// RegisteredHistogram is a histogram registered with a MetricRegistry.  The
+// Metric API does not accept histograms, so observations are recorded as an
+// AggregatedSummary whose count, sum, min, and max are exact rather than
+// counted into buckets.  Only the observations made since the previous
+// harvest are sent.
+type RegisteredHistogram struct{ *registeredMetric }
+
+// Observe adds an observation to the histogram with the given label values.
+func (hg *RegisteredHistogram) Observe(val float64, labelValues ...string) {
+	if nil == hg {
+		return
+	}
+	if attributes, ok := hg.attributes(labelValues); ok {
+		hg.harvester.MetricAggregator().Summary(hg.name, attributes).Record(val)
+	}
+}
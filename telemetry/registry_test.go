@@ -0,0 +1,148 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegistryCounter(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	registry := NewMetricRegistry(h)
+	requests, err := registry.RegisterCounter("http.requests", "method", "code")
+	if nil != err {
+		t.Fatal(err)
+	}
+	requests.Inc("GET", "200")
+	requests.Add(2, "GET", "200")
+	requests.Inc("POST", "500")
+	expect := `[
+		{"name":"http.requests","type":"count","value":1,"attributes":{"code":"500","method":"POST"}},
+		{"name":"http.requests","type":"count","value":3,"attributes":{"code":"200","method":"GET"}}
+	]`
+	testHarvesterMetrics(t, h, expect)
+}
+
+func TestRegistryHistogram(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	registry := NewMetricRegistry(h)
+	latency, err := registry.RegisterHistogram("http.latency", "route")
+	if nil != err {
+		t.Fatal(err)
+	}
+	latency.Observe(5, "/users")
+	latency.Observe(15, "/users")
+	latency.Observe(10, "/users")
+	expect := `[
+		{"name":"http.latency","type":"summary","value":{"sum":30,"count":3,"min":5,"max":15},"attributes":{"route":"/users"}}
+	]`
+	testHarvesterMetrics(t, h, expect)
+}
+
+func TestRegistryGauge(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	registry := NewMetricRegistry(h)
+	temperature, err := registry.RegisterGauge("temperature")
+	if nil != err {
+		t.Fatal(err)
+	}
+	temperature.Set(20)
+	temperature.Set(22)
+	metrics, _ := h.takeMetrics(time.Now())
+	if len(metrics) != 1 {
+		t.Fatal(metrics)
+	}
+	if g, ok := metrics[0].(*Gauge); !ok || g.Name != "temperature" || g.Value != 22 {
+		t.Error(metrics[0])
+	}
+}
+
+func TestRegistryStateSentEveryHarvest(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	registry := NewMetricRegistry(h)
+	requests, _ := registry.RegisterCounter("http.requests")
+	temperature, _ := registry.RegisterGauge("temperature")
+	requests.Add(3)
+	temperature.Set(20)
+	h.takeMetrics(time.Now())
+
+	// The gauge keeps its value and the counter reports no increase.
+	values := make(map[string]float64)
+	metrics, _ := h.takeMetrics(time.Now())
+	for _, m := range metrics {
+		switch v := m.(type) {
+		case *Count:
+			values[v.Name] = v.Value
+		case *Gauge:
+			values[v.Name] = v.Value
+		}
+	}
+	if len(values) != 2 || values["http.requests"] != 0 || values["temperature"] != 20 {
+		t.Error(values)
+	}
+
+	requests.Inc()
+	metrics, _ = h.takeMetrics(time.Now())
+	for _, m := range metrics {
+		if c, ok := m.(*Count); ok && c.Value != 1 {
+			t.Error(c)
+		}
+	}
+}
+
+func TestRegistryInvalidRegistration(t *testing.T) {
+	registry := NewMetricRegistry(nil)
+	if _, err := registry.RegisterCounter("requests"); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := registry.RegisterGauge("requests"); nil == err {
+		t.Error("duplicate name registered")
+	}
+	if _, err := registry.RegisterCounter(""); err != errRegistryName {
+		t.Error(err)
+	}
+	if _, err := registry.RegisterHistogram("latency", "route", "route"); err != errRegistryLabelName {
+		t.Error(err)
+	}
+	if _, err := registry.RegisterHistogram("latency", ""); err != errRegistryLabelName {
+		t.Error(err)
+	}
+}
+
+func TestRegistryLabelValuesMismatch(t *testing.T) {
+	var logged int
+	var dropped int
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.ErrorLogger = func(map[string]interface{}) { logged++ }
+		cfg.OnDrop = func(signal string, count int, reason string) {
+			if signal == "metrics" && reason == DropReasonValidation {
+				dropped += count
+			}
+		}
+	})
+	registry := NewMetricRegistry(h)
+	requests, _ := registry.RegisterCounter("http.requests", "method", "code")
+	requests.Inc("GET")
+	requests.Inc("GET", "200", "extra")
+	if logged != 2 || dropped != 2 {
+		t.Error(logged, dropped)
+	}
+	if reqs := h.swapOutMetrics(time.Now()); nil != reqs {
+		t.Error(reqs)
+	}
+}
+
+func TestRegistryNilMetrics(t *testing.T) {
+	var c *RegisteredCounter
+	c.Inc()
+	var g *RegisteredGauge
+	g.Set(1)
+	var hg *RegisteredHistogram
+	hg.Observe(1)
+
+	registry := NewMetricRegistry(nil)
+	counter, _ := registry.RegisterCounter("requests")
+	counter.Inc()
+}
@@ -15,7 +15,72 @@ const (
 
 type splittablePayloadEntry interface {
 	MapEntry
-	split() []splittablePayloadEntry
+	split(strategy SplitStrategy) []splittablePayloadEntry
+}
+
+// SplitStrategy decides where a group of telemetry data is divided when the
+// request containing it is too large to send.
+type SplitStrategy interface {
+	// SplitIndex returns the index at which a group of n items should be
+	// divided.  size returns the serialized size in bytes of the item at
+	// index i.  Values outside of [1, n-1] are clamped to that range.
+	SplitIndex(n int, size func(i int) int) int
+}
+
+// CountSplitStrategy divides groups into two halves with an equal number of
+// items.  This is the default strategy.
+type CountSplitStrategy struct{}
+
+// SplitIndex implements SplitStrategy.
+func (CountSplitStrategy) SplitIndex(n int, size func(i int) int) int {
+	return n / 2
+}
+
+// ByteSizeSplitStrategy divides groups into two halves of roughly equal
+// serialized size.  This is useful when item sizes vary widely, for example
+// when a few spans have very large attributes.
+type ByteSizeSplitStrategy struct{}
+
+// SplitIndex implements SplitStrategy.
+func (ByteSizeSplitStrategy) SplitIndex(n int, size func(i int) int) int {
+	sizes := make([]int, n)
+	total := 0
+	for i := range sizes {
+		sizes[i] = size(i)
+		total += sizes[i]
+	}
+	best := 1
+	bestDiff := total
+	prefix := 0
+	for i := 1; i < n; i++ {
+		prefix += sizes[i-1]
+		diff := total - 2*prefix
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < bestDiff {
+			best = i
+			bestDiff = diff
+		}
+	}
+	return best
+}
+
+// splitIndex returns the index at which a group of n items should be split
+// using the given strategy.  The result is always within [1, n-1] so that both
+// halves are non-empty.
+func splitIndex(strategy SplitStrategy, n int, size func(i int) int) int {
+	if nil == strategy {
+		strategy = CountSplitStrategy{}
+	}
+	idx := strategy.SplitIndex(n, size)
+	if idx < 1 {
+		idx = 1
+	}
+	if idx > n-1 {
+		idx = n - 1
+	}
+	return idx
 }
 
 var (
@@ -28,21 +93,43 @@ func requestNeedsSplit(r *http.Request) bool {
 
 // buildSplitRequests converts a []Batch into a collection of appropiately sized requests
 func buildSplitRequests(batches []Batch, factory RequestFactory) ([]*http.Request, error) {
-	return newRequestsInternal(batches, factory, requestNeedsSplit)
+	return buildSplitRequestsWithStrategy(batches, factory, CountSplitStrategy{})
+}
+
+// buildSplitRequestsWithStrategy is like buildSplitRequests but uses the given
+// strategy to decide where oversized groups are split.
+func buildSplitRequestsWithStrategy(batches []Batch, factory RequestFactory, strategy SplitStrategy) ([]*http.Request, error) {
+	return newRequestsInternal(batches, factory, requestNeedsSplit, strategy)
+}
+
+// buildSplitRequestsSkippingOversized is like buildSplitRequestsWithStrategy
+// but rather than failing if a payload cannot be split small enough, the
+// requests that are still too large are returned separately so that only
+// their data is dropped.
+func buildSplitRequestsSkippingOversized(batches []Batch, factory RequestFactory, strategy SplitStrategy) (reqs, oversized []*http.Request, err error) {
+	return splitRequests(batches, factory, requestNeedsSplit, strategy, true)
 }
 
-func newRequestsInternal(batches []Batch, factory RequestFactory, needsSplit func(*http.Request) bool) ([]*http.Request, error) {
+func newRequestsInternal(batches []Batch, factory RequestFactory, needsSplit func(*http.Request) bool, strategy SplitStrategy) ([]*http.Request, error) {
+	reqs, _, err := splitRequests(batches, factory, needsSplit, strategy, false)
+	return reqs, err
+}
+
+// splitRequests builds requests for the batches, recursively splitting them
+// while needsSplit returns true.  If a request needs splitting but cannot be
+// split then errUnableToSplit is returned, unless skipOversized is true in
+// which case the request is returned in oversized.
+func splitRequests(batches []Batch, factory RequestFactory, needsSplit func(*http.Request) bool, strategy SplitStrategy, skipOversized bool) (reqs, oversized []*http.Request, err error) {
 	// Context will be defined in the harvester when the request is actually submitted to the client
 	r, err := factory.BuildRequest(context.TODO(), batches)
 	if nil != err {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if !needsSplit(r) {
-		return []*http.Request{r}, nil
+		return []*http.Request{r}, nil, nil
 	}
 
-	var reqs []*http.Request
 	var splitBatches1 []Batch
 	var splitBatches2 []Batch
 	payloadWasSplit := false
@@ -58,7 +145,7 @@ func newRequestsInternal(batches []Batch, factory RequestFactory, needsSplit fun
 		for _, e := range batches[0] {
 			splittable, isPayloadSplittable := e.(splittablePayloadEntry)
 			if isPayloadSplittable {
-				splitEntry := splittable.split()
+				splitEntry := splittable.split(strategy)
 				if splitEntry != nil {
 					payload1Entries = append(payload1Entries, splitEntry[0].(MapEntry))
 					payload2Entries = append(payload2Entries, splitEntry[1].(MapEntry))
@@ -75,15 +162,19 @@ func newRequestsInternal(batches []Batch, factory RequestFactory, needsSplit fun
 	}
 
 	if !payloadWasSplit {
-		return nil, errUnableToSplit
+		if skipOversized {
+			return nil, []*http.Request{r}, nil
+		}
+		return nil, nil, errUnableToSplit
 	}
 
 	for _, b := range [][]Batch{splitBatches1, splitBatches2} {
-		rs, err := newRequestsInternal(b, factory, needsSplit)
+		rs, os, err := splitRequests(b, factory, needsSplit, strategy, skipOversized)
 		if nil != err {
-			return nil, err
+			return nil, nil, err
 		}
 		reqs = append(reqs, rs...)
+		oversized = append(oversized, os...)
 	}
-	return reqs, nil
+	return reqs, oversized, nil
 }
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 
 	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
@@ -16,8 +17,10 @@ import (
 
 const defaultUserAgent = "NewRelic-Go-TelemetrySDK/" + version
 const defaultScheme = "https"
+const defaultContentType = "application/json"
 const apiKeyHeader = "Api-Key"
 const licenseKeyHeader = "X-License-Key"
+const requestIDHeader = "X-Request-Id"
 
 // MapEntry represents a piece of the telemetry data that is included in a single
 // request that should be sent to New Relic. Example MapEntry types include SpanGroup
@@ -46,6 +49,21 @@ type RequestFactory interface {
 	BuildRequest(context.Context, []Batch, ...ClientOption) (*http.Request, error)
 }
 
+// RawRequestFactory is implemented by the RequestFactory values returned by
+// the New*RequestFactory functions.  It is separate from RequestFactory so
+// that existing implementations of that interface are not broken.  Use a type
+// assertion to access it:
+//
+//	raw, ok := factory.(RawRequestFactory)
+type RawRequestFactory interface {
+	// BuildRawRequest converts an already serialized, uncompressed request
+	// body into an http.Request.  The body is compressed and the headers
+	// are set as for BuildRequest, but the body is sent as is.  It must be
+	// in the format expected by the factory's endpoint.  This is useful for
+	// replaying captured payloads and for testing.
+	BuildRawRequest(context.Context, []byte, ...ClientOption) (*http.Request, error)
+}
+
 type requestFactory struct {
 	apiKeyHeader        string
 	apiKey              string
@@ -56,6 +74,28 @@ type requestFactory struct {
 	userAgent           string
 	zippers             *sync.Pool
 	uncompressedBuffers *sync.Pool
+	compressor          Compressor
+	// bodyWriter replaces the default writer of request bodies if set.
+	bodyWriter writer
+	// contentType replaces the default Content-Type header if set.  Options
+	// which set bodyWriter should also set the content type they write.
+	contentType string
+	// acceptEncoding is the Accept-Encoding header if set.
+	acceptEncoding string
+	// requestID returns the X-Request-Id header of each request if set.
+	requestID func() string
+}
+
+// Compressor compresses request bodies.  Implement this interface to replace
+// the default pooled gzip compression, for example with a custom-tuned or
+// shared compressor.
+type Compressor interface {
+	// Writer returns a writer that compresses data written to it into w.
+	// Close is called once the entire request body has been written.
+	Writer(w io.Writer) io.WriteCloser
+	// Encoding returns the value of the Content-Encoding header for
+	// compressed request bodies, eg. "gzip".
+	Encoding() string
 }
 
 type gzipPoolEntry struct {
@@ -79,6 +119,9 @@ func configure(f *requestFactory, options []ClientOption) error {
 	if f.apiKey == "" && !f.noDefaultKey {
 		return errors.New("api key option must be specified! (one of WithLicenseKey, WithInsertKey, or WithNoDefaultKey)")
 	}
+	if !strings.HasPrefix(f.path, "/") {
+		return errors.New("path must begin with \"/\"")
+	}
 	return nil
 
 }
@@ -94,25 +137,9 @@ func (f *eventRequestFactory) BuildRequest(ctx context.Context, batches []Batch,
 type writer func(buf *bytes.Buffer, batches []Batch)
 
 func (f *requestFactory) buildRequest(ctx context.Context, batches []Batch, bufferRequestBytes writer, options []ClientOption) (*http.Request, error) {
-	configuredFactory := f
-	if len(options) > 0 {
-		configuredFactory = &requestFactory{
-			apiKeyHeader:        f.apiKeyHeader,
-			apiKey:              f.apiKey,
-			noDefaultKey:        f.noDefaultKey,
-			scheme:              f.scheme,
-			endpoint:            f.endpoint,
-			path:                f.path,
-			userAgent:           f.userAgent,
-			zippers:             f.zippers,
-			uncompressedBuffers: f.uncompressedBuffers,
-		}
-
-		err := configure(configuredFactory, options)
-
-		if err != nil {
-			return &http.Request{}, errors.New("unable to configure this request based on options passed in")
-		}
+	configuredFactory, err := f.configured(options)
+	if err != nil {
+		return &http.Request{}, err
 	}
 
 	// Grab a buffer from the cached buffers and reset it
@@ -120,26 +147,60 @@ func (f *requestFactory) buildRequest(ctx context.Context, batches []Batch, buff
 	defer configuredFactory.uncompressedBuffers.Put(decompressedBuffer)
 	decompressedBuffer.Reset()
 
-	// Grab a gzip structure (and buffer) from the cache and reset it
-	poolEntry := configuredFactory.zippers.Get().(*gzipPoolEntry)
-	defer configuredFactory.zippers.Put(poolEntry)
-	poolEntry.compressedBuffer.Reset()
-	poolEntry.zipper.Reset(poolEntry.compressedBuffer)
-
 	// Generate the payload
+	if nil != configuredFactory.bodyWriter {
+		bufferRequestBytes = configuredFactory.bodyWriter
+	}
 	bufferRequestBytes(decompressedBuffer, batches)
 
-	// Compress the payload
-	err := internal.CompressWithWriter(decompressedBuffer.Bytes(), poolEntry.zipper)
+	return configuredFactory.buildBodyRequest(ctx, decompressedBuffer.Bytes())
+}
+
+// BuildRawRequest converts an already serialized, uncompressed request body
+// into an http.Request.
+func (f *requestFactory) BuildRawRequest(ctx context.Context, body []byte, options ...ClientOption) (*http.Request, error) {
+	configuredFactory, err := f.configured(options)
 	if err != nil {
 		return &http.Request{}, err
 	}
+	return configuredFactory.buildBodyRequest(ctx, body)
+}
 
-	// The following buffers are no longer used after this point:
-	// * decompressedBuffer
-	// * poolEntry.compressedBuffer
-	requestBytes := make([]byte, len(poolEntry.compressedBuffer.Bytes()))
-	copy(requestBytes, poolEntry.compressedBuffer.Bytes())
+// configured returns the factory with the options applied.  The factory
+// itself is returned if there are no options.
+func (f *requestFactory) configured(options []ClientOption) (*requestFactory, error) {
+	if len(options) == 0 {
+		return f, nil
+	}
+	configuredFactory := &requestFactory{
+		apiKeyHeader:        f.apiKeyHeader,
+		apiKey:              f.apiKey,
+		noDefaultKey:        f.noDefaultKey,
+		scheme:              f.scheme,
+		endpoint:            f.endpoint,
+		path:                f.path,
+		userAgent:           f.userAgent,
+		zippers:             f.zippers,
+		uncompressedBuffers: f.uncompressedBuffers,
+		compressor:          f.compressor,
+		bodyWriter:          f.bodyWriter,
+		contentType:         f.contentType,
+		acceptEncoding:      f.acceptEncoding,
+		requestID:           f.requestID,
+	}
+	if err := configure(configuredFactory, options); err != nil {
+		return nil, errors.New("unable to configure this request based on options passed in")
+	}
+	return configuredFactory, nil
+}
+
+// buildBodyRequest compresses the serialized payload and builds the request.
+func (f *requestFactory) buildBodyRequest(ctx context.Context, payload []byte) (*http.Request, error) {
+	// Compress the payload
+	requestBytes, err := f.compress(payload)
+	if err != nil {
+		return &http.Request{}, err
+	}
 
 	getBody := func() (io.ReadCloser, error) {
 		return ioutil.NopCloser(bytes.NewBuffer(requestBytes)), nil
@@ -147,16 +208,12 @@ func (f *requestFactory) buildRequest(ctx context.Context, batches []Batch, buff
 
 	var contentLength = int64(len(requestBytes))
 	body, _ := getBody()
-	endpoint := configuredFactory.endpoint
-	headers := configuredFactory.getHeaders()
+	endpoint := f.endpoint
+	headers := f.getHeaders()
 
 	request := &http.Request{
-		Method: "POST",
-		URL: &url.URL{
-			Scheme: configuredFactory.scheme,
-			Host:   configuredFactory.endpoint,
-			Path:   configuredFactory.path,
-		},
+		Method:        "POST",
+		URL:           f.url(),
 		Header:        headers,
 		Body:          body,
 		GetBody:       getBody,
@@ -167,13 +224,78 @@ func (f *requestFactory) buildRequest(ctx context.Context, batches []Batch, buff
 	return request.WithContext(ctx), nil
 }
 
+// compress compresses the payload using the configured Compressor, or the
+// pooled gzip writers if none is set.  The returned slice is not shared with
+// any pooled buffer.
+func (f *requestFactory) compress(payload []byte) ([]byte, error) {
+	if nil != f.compressor {
+		var buf bytes.Buffer
+		w := f.compressor.Writer(&buf)
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	// Grab a gzip structure (and buffer) from the cache and reset it
+	poolEntry := f.zippers.Get().(*gzipPoolEntry)
+	defer f.zippers.Put(poolEntry)
+	poolEntry.compressedBuffer.Reset()
+	poolEntry.zipper.Reset(poolEntry.compressedBuffer)
+
+	err := internal.CompressWithWriter(payload, poolEntry.zipper)
+	if err != nil {
+		return nil, err
+	}
+
+	// poolEntry.compressedBuffer is no longer used after this point.
+	requestBytes := make([]byte, len(poolEntry.compressedBuffer.Bytes()))
+	copy(requestBytes, poolEntry.compressedBuffer.Bytes())
+	return requestBytes, nil
+}
+
+func (f *requestFactory) contentEncoding() string {
+	if nil != f.compressor {
+		return f.compressor.Encoding()
+	}
+	return "gzip"
+}
+
+// url returns the URL of the generated requests.
+func (f *requestFactory) url() *url.URL {
+	return &url.URL{
+		Scheme: f.scheme,
+		Host:   f.endpoint,
+		Path:   f.path,
+	}
+}
+
+func (f *requestFactory) getContentType() string {
+	if f.contentType != "" {
+		return f.contentType
+	}
+	return defaultContentType
+}
+
 func (f *requestFactory) getHeaders() http.Header {
-	return http.Header{
-		"Content-Type":     []string{"application/json"},
-		"Content-Encoding": []string{"gzip"},
+	headers := http.Header{
+		"Content-Type":     []string{f.getContentType()},
+		"Content-Encoding": []string{f.contentEncoding()},
 		f.apiKeyHeader:     []string{f.apiKey},
 		"User-Agent":       []string{f.userAgent},
 	}
+	if f.acceptEncoding != "" {
+		headers["Accept-Encoding"] = []string{f.acceptEncoding}
+	}
+	if nil != f.requestID {
+		if id := f.requestID(); id != "" {
+			headers[requestIDHeader] = []string{id}
+		}
+	}
+	return headers
 }
 
 func bufferRequestBytes(buf *bytes.Buffer, batches []Batch) {
@@ -221,7 +343,7 @@ func NewSpanRequestFactory(options ...ClientOption) (RequestFactory, error) {
 		userAgent:           defaultUserAgent,
 		scheme:              defaultScheme,
 		zippers:             newGzipPool(gzip.DefaultCompression),
-		uncompressedBuffers: newUncompressedBufferPool(),
+		uncompressedBuffers: newUncompressedBufferPool(0),
 	}
 	err := configure(f, options)
 	if err != nil {
@@ -240,7 +362,7 @@ func NewMetricRequestFactory(options ...ClientOption) (RequestFactory, error) {
 		userAgent:           defaultUserAgent,
 		scheme:              defaultScheme,
 		zippers:             newGzipPool(gzip.DefaultCompression),
-		uncompressedBuffers: newUncompressedBufferPool(),
+		uncompressedBuffers: newUncompressedBufferPool(0),
 	}
 	err := configure(f, options)
 	if err != nil {
@@ -259,7 +381,7 @@ func NewEventRequestFactory(options ...ClientOption) (RequestFactory, error) {
 		userAgent:           defaultUserAgent,
 		scheme:              defaultScheme,
 		zippers:             newGzipPool(gzip.DefaultCompression),
-		uncompressedBuffers: newUncompressedBufferPool(),
+		uncompressedBuffers: newUncompressedBufferPool(0),
 	}
 	err := configure(f, options)
 	if err != nil {
@@ -278,7 +400,7 @@ func NewLogRequestFactory(options ...ClientOption) (RequestFactory, error) {
 		userAgent:           defaultUserAgent,
 		scheme:              defaultScheme,
 		zippers:             newGzipPool(gzip.DefaultCompression),
-		uncompressedBuffers: newUncompressedBufferPool(),
+		uncompressedBuffers: newUncompressedBufferPool(0),
 	}
 	err := configure(f, options)
 	if err != nil {
@@ -288,6 +410,27 @@ func NewLogRequestFactory(options ...ClientOption) (RequestFactory, error) {
 	return &hashRequestFactory{requestFactory: f}, nil
 }
 
+var (
+	gzipPoolsLock sync.Mutex
+	gzipPools     = make(map[int]*sync.Pool)
+)
+
+// sharedGzipPool returns a pool of gzip writers for the level which is shared
+// by all factories.  This allows a level to be given as an option to each
+// BuildRequest call without creating a new pool, and new writers, for every
+// request.
+func sharedGzipPool(gzipLevel int) *sync.Pool {
+	gzipPoolsLock.Lock()
+	defer gzipPoolsLock.Unlock()
+
+	pool, ok := gzipPools[gzipLevel]
+	if !ok {
+		pool = newGzipPool(gzipLevel)
+		gzipPools[gzipLevel] = pool
+	}
+	return pool
+}
+
 func newGzipPool(gzipLevel int) *sync.Pool {
 	return &sync.Pool{New: func() interface{} {
 		var buffer bytes.Buffer
@@ -296,9 +439,15 @@ func newGzipPool(gzipLevel int) *sync.Pool {
 	}}
 }
 
-func newUncompressedBufferPool() *sync.Pool {
+// newUncompressedBufferPool returns a pool of buffers which are created with
+// the initial capacity.  Each factory has its own pool, so that the capacities
+// used by an application do not accumulate pools which are never freed.
+func newUncompressedBufferPool(capacity int) *sync.Pool {
 	return &sync.Pool{New: func() interface{} {
 		var buffer bytes.Buffer
+		if capacity > 0 {
+			buffer.Grow(capacity)
+		}
 		return &buffer
 	}}
 }
@@ -334,6 +483,14 @@ func WithEndpoint(endpoint string) ClientOption {
 	}
 }
 
+// WithPath creates a ClientOption to specify the path to use for the generated
+// requests, eg. "/v1/logs".  The path must begin with "/".
+func WithPath(path string) ClientOption {
+	return func(o *requestFactory) {
+		o.path = path
+	}
+}
+
 // WithUserAgent creates a ClientOption to specify additional user agent information for the generated requests.
 func WithUserAgent(userAgent string) ClientOption {
 	return func(o *requestFactory) {
@@ -352,12 +509,64 @@ func WithInsecure() ClientOption {
 func WithGzipCompressionLevel(level int) ClientOption {
 	return func(o *requestFactory) {
 		// If the gzip compression level is invalid, the gzip pool is not overridden
-		if _, err := gzip.NewWriterLevel(nil, level); err != nil {
-			o.zippers = newGzipPool(level)
+		if _, err := gzip.NewWriterLevel(nil, level); err == nil {
+			o.zippers = sharedGzipPool(level)
+		}
+	}
+}
+
+// WithBufferInitialCapacity creates a ClientOption to specify the initial
+// capacity in bytes of the buffers into which uncompressed payloads are
+// written.  Setting it to the size of a typical payload avoids growing the
+// buffers while large batches are written.  By default buffers start empty.
+// Capacities which are not positive are ignored.
+func WithBufferInitialCapacity(capacity int) ClientOption {
+	return func(o *requestFactory) {
+		if capacity > 0 {
+			o.uncompressedBuffers = newUncompressedBufferPool(capacity)
 		}
 	}
 }
 
+// WithCompressor creates a ClientOption to specify the Compressor used to
+// compress request bodies.  This replaces the default gzip compression,
+// including any level set with WithGzipCompressionLevel.
+func WithCompressor(c Compressor) ClientOption {
+	return func(o *requestFactory) {
+		o.compressor = c
+	}
+}
+
+// WithContentType creates a ClientOption to specify the Content-Type header of
+// the generated requests.  Use this with a custom body format, eg. to send
+// "application/x-protobuf".  The default is "application/json".
+func WithContentType(contentType string) ClientOption {
+	return func(o *requestFactory) {
+		o.contentType = contentType
+	}
+}
+
+// WithAcceptEncoding creates a ClientOption to specify the Accept-Encoding
+// header of the generated requests, eg. "gzip" to allow compressed responses.
+// Note that http.Transport only decompresses responses transparently when the
+// request has no Accept-Encoding header, so the response body must be
+// decompressed by the caller when this option is used.
+func WithAcceptEncoding(encoding string) ClientOption {
+	return func(o *requestFactory) {
+		o.acceptEncoding = encoding
+	}
+}
+
+// WithRequestIDFunc creates a ClientOption to specify a function which returns
+// the X-Request-Id header of each generated request, eg. to correlate requests
+// with an existing tracing system.  The header is omitted if the function
+// returns an empty string.  The function must be safe for concurrent use.
+func WithRequestIDFunc(fn func() string) ClientOption {
+	return func(o *requestFactory) {
+		o.requestID = fn
+	}
+}
+
 // withScheme is meant to be used with the harvester because the harvester requires specifying
 // an absolute uri which includes the scheme.
 func withScheme(scheme string) ClientOption {
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"io"
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
@@ -53,6 +55,10 @@ func TestClientOptions(t *testing.T) {
 		{name: "WithInsecure", option: WithInsecure()},
 		{name: "WithGzipCompressionLevel-bad", option: WithGzipCompressionLevel(9000)},
 		{name: "WithGzipCompressionLevel-good", option: WithGzipCompressionLevel(gzip.BestCompression)},
+		{name: "WithCompressor", option: WithCompressor(identityCompressor{})},
+		{name: "WithPath", option: WithPath("/v1/traces")},
+		{name: "WithAcceptEncoding", option: WithAcceptEncoding("gzip")},
+		{name: "WithRequestIDFunc", option: WithRequestIDFunc(func() string { return "id" })},
 	}
 
 	for _, test := range tests {
@@ -113,3 +119,281 @@ func TestSpanFactoryRequest(t *testing.T) {
 		t.Error("Content-Encoding header must be gzip")
 	}
 }
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// identityCompressor is a Compressor that does not modify its input.
+type identityCompressor struct{}
+
+func (identityCompressor) Writer(w io.Writer) io.WriteCloser { return nopWriteCloser{w} }
+func (identityCompressor) Encoding() string                  { return "identity" }
+
+func TestFactoryWithCompressor(t *testing.T) {
+	f, _ := NewSpanRequestFactory(WithInsertKey("key!"), WithCompressor(identityCompressor{}))
+	request, err := f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc := request.Header.Get("Content-Encoding"); enc != "identity" {
+		t.Error("incorrect Content-Encoding header", enc)
+	}
+	body, _ := ioutil.ReadAll(request.Body)
+	if string(body) != `[{"spans":[]}]` {
+		t.Error("incorrect body", string(body))
+	}
+	if request.ContentLength != int64(len(body)) {
+		t.Error("incorrect content length", request.ContentLength)
+	}
+}
+
+func TestBuildRequestWithCompressorOption(t *testing.T) {
+	f, _ := NewSpanRequestFactory(WithInsertKey("key!"))
+	request, _ := f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}}, WithCompressor(identityCompressor{}))
+	if enc := request.Header.Get("Content-Encoding"); enc != "identity" {
+		t.Error("incorrect Content-Encoding header", enc)
+	}
+
+	// The factory itself should be unaffected by the per-request option.
+	request, _ = f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}})
+	if enc := request.Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Error("incorrect Content-Encoding header", enc)
+	}
+}
+
+func TestFactoryWithContentType(t *testing.T) {
+	f, _ := NewMetricRequestFactory(WithInsertKey("key!"), WithContentType("application/x-protobuf"))
+	request, _ := f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}})
+	if ct := request.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Error("incorrect Content-Type header", ct)
+	}
+
+	// The content type declared by a body format option replaces the
+	// configured one.
+	request, _ = f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}}, WithOTLPJSONMetrics())
+	if ct := request.Header.Get("Content-Type"); ct != "application/json" {
+		t.Error("incorrect Content-Type header", ct)
+	}
+
+	// The factory itself should be unaffected by the per-request option.
+	request, _ = f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}})
+	if ct := request.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Error("incorrect Content-Type header", ct)
+	}
+}
+
+func TestBuildRawRequest(t *testing.T) {
+	f, _ := NewLogRequestFactory(WithNoDefaultKey())
+	raw, ok := f.(RawRequestFactory)
+	if !ok {
+		t.Fatal("factory does not implement RawRequestFactory")
+	}
+	js := `[{"common":{"attributes":{"zip":"zap"}},"logs":[{"message":"captured","timestamp":1417136460000}]}]`
+	request, err := raw.BuildRawRequest(context.Background(), []byte(js), WithInsertKey("key!"), WithUserAgent("replay"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := request.URL.String(); u != "https://log-api.newrelic.com/log/v1" {
+		t.Error("incorrect URL", u)
+	}
+	if key := request.Header.Get("Api-Key"); key != "key!" {
+		t.Error("incorrect key", key)
+	}
+	if ua := request.Header.Get("User-Agent"); !strings.HasSuffix(ua, " replay") {
+		t.Error("incorrect user agent", ua)
+	}
+	if enc := request.Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Error("incorrect Content-Encoding header", enc)
+	}
+	compressed, _ := ioutil.ReadAll(request.Body)
+	if request.ContentLength != int64(len(compressed)) {
+		t.Error("incorrect content length", request.ContentLength)
+	}
+	body, err := internal.Uncompress(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != js {
+		t.Error("incorrect body", string(body))
+	}
+}
+
+func TestBuildRawRequestInvalidOption(t *testing.T) {
+	f, _ := NewSpanRequestFactory(WithNoDefaultKey())
+	if _, err := f.(RawRequestFactory).BuildRawRequest(context.Background(), []byte(`[]`), WithPath("no-slash")); err == nil {
+		t.Error("expected an error for an invalid option")
+	}
+}
+
+type repetitivePayloadEntry struct{}
+
+func (m *repetitivePayloadEntry) DataTypeKey() string {
+	return "spans"
+}
+
+func (m *repetitivePayloadEntry) WriteDataEntry(buf *bytes.Buffer) *bytes.Buffer {
+	buf.WriteByte('[')
+	for i := 0; i < 100; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`{"id":"12345","trace.id":"67890","attributes":{"name":"span"}}`)
+	}
+	buf.WriteByte(']')
+	return buf
+}
+
+func TestBuildRequestWithGzipCompressionLevelOption(t *testing.T) {
+	f, _ := NewSpanRequestFactory(WithInsertKey("key!"))
+	batches := []Batch{{&repetitivePayloadEntry{}}}
+	request, _ := f.BuildRequest(context.Background(), batches)
+	defaultSize := request.ContentLength
+
+	request, _ = f.BuildRequest(context.Background(), batches, WithGzipCompressionLevel(gzip.NoCompression))
+	if request.ContentLength <= defaultSize {
+		t.Error("compression level option ignored", request.ContentLength, defaultSize)
+	}
+	body, _ := ioutil.ReadAll(request.Body)
+	if _, err := internal.Uncompress(body); nil != err {
+		t.Error(err)
+	}
+
+	// The factory itself should be unaffected by the per-request option.
+	request, _ = f.BuildRequest(context.Background(), batches)
+	if request.ContentLength != defaultSize {
+		t.Error("factory compression level changed", request.ContentLength, defaultSize)
+	}
+}
+
+func TestFactoriesWithDifferentGzipCompressionLevels(t *testing.T) {
+	contentLength := func(level int) int64 {
+		f, _ := NewSpanRequestFactory(WithInsertKey("key!"), WithGzipCompressionLevel(level))
+		request, _ := f.BuildRequest(context.Background(), []Batch{{&repetitivePayloadEntry{}}})
+		return request.ContentLength
+	}
+	if none, best := contentLength(gzip.NoCompression), contentLength(gzip.BestCompression); none <= best {
+		t.Error("compression level ignored", none, best)
+	}
+	if invalid, def := contentLength(9000), contentLength(gzip.DefaultCompression); invalid != def {
+		t.Error("invalid compression level not ignored", invalid, def)
+	}
+}
+
+func TestFactoryWithPath(t *testing.T) {
+	f, err := NewLogRequestFactory(WithInsertKey("key!"), WithEndpoint("localhost:4318"), WithPath("/v1/logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, _ := f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}})
+	if u := request.URL.String(); u != "https://localhost:4318/v1/logs" {
+		t.Error("incorrect URL", u)
+	}
+}
+
+func TestFactoryWithInvalidPath(t *testing.T) {
+	f, err := NewSpanRequestFactory(WithInsertKey("key!"), WithPath("v1/traces"))
+	if f != nil {
+		t.Error("Factory was created with an invalid path")
+	}
+	if err == nil {
+		t.Error("Expected an error, but one was not generated.")
+	}
+
+	f, _ = NewSpanRequestFactory(WithInsertKey("key!"))
+	if _, err := f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}}, WithPath("")); err == nil {
+		t.Error("Expected an error, but one was not generated.")
+	}
+}
+
+func TestBuildRequestWithBufferInitialCapacity(t *testing.T) {
+	f, _ := NewLogRequestFactory(WithInsertKey("key!"), WithBufferInitialCapacity(64*1024))
+	batches := testLogBatch(10)
+	request, err := f.BuildRequest(context.Background(), batches)
+	if nil != err {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(request.Body)
+	uncompressed, _ := internal.Uncompress(body)
+	expect := &bytes.Buffer{}
+	bufferRequestBytes(expect, batches)
+	// Attribute order is random, so only the length is compared.
+	if len(uncompressed) != expect.Len() {
+		t.Error(string(uncompressed))
+	}
+
+	buf := newUncompressedBufferPool(64 * 1024).Get().(*bytes.Buffer)
+	if buf.Cap() < 64*1024 {
+		t.Error(buf.Cap())
+	}
+	// Each factory has its own pool.
+	f1, _ := NewLogRequestFactory(WithInsertKey("key!"), WithBufferInitialCapacity(1024))
+	f2, _ := NewLogRequestFactory(WithInsertKey("key!"), WithBufferInitialCapacity(1024))
+	if f1.(*hashRequestFactory).uncompressedBuffers == f2.(*hashRequestFactory).uncompressedBuffers {
+		t.Error("factories share a buffer pool")
+	}
+	// Capacities which are not positive keep the default pool.
+	g, _ := NewLogRequestFactory(WithInsertKey("key!"), WithBufferInitialCapacity(-1))
+	if buf := g.(*hashRequestFactory).uncompressedBuffers.Get().(*bytes.Buffer); buf.Cap() != 0 {
+		t.Error(buf.Cap())
+	}
+}
+
+// benchmarkUncompressedBuffer writes a large batch into a buffer taken from a
+// new pool, as happens when a pool's buffers have been garbage collected.
+func benchmarkUncompressedBuffer(b *testing.B, capacity int) {
+	batches := testLogBatch(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buf := newUncompressedBufferPool(capacity).Get().(*bytes.Buffer)
+		bufferRequestBytes(buf, batches)
+	}
+}
+
+// These benchmarks compare the allocations of writing a large batch with and
+// without a buffer initial capacity large enough for the payload.
+func BenchmarkUncompressedBufferDefault(b *testing.B) { benchmarkUncompressedBuffer(b, 0) }
+func BenchmarkUncompressedBufferCapacity(b *testing.B) {
+	benchmarkUncompressedBuffer(b, 256*1024)
+}
+
+func TestFactoryWithAcceptEncoding(t *testing.T) {
+	f, _ := NewSpanRequestFactory(WithInsertKey("key!"))
+	request, _ := f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}})
+	if _, ok := request.Header["Accept-Encoding"]; ok {
+		t.Error("Accept-Encoding set by default", request.Header)
+	}
+
+	request, _ = f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}}, WithAcceptEncoding("gzip"))
+	if enc := request.Header.Get("Accept-Encoding"); enc != "gzip" {
+		t.Error(enc)
+	}
+}
+
+func TestFactoryWithRequestIDFunc(t *testing.T) {
+	f, _ := NewSpanRequestFactory(WithInsertKey("key!"))
+	request, _ := f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}})
+	if _, ok := request.Header[requestIDHeader]; ok {
+		t.Error("X-Request-Id set by default", request.Header)
+	}
+
+	request, _ = f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}}, WithRequestIDFunc(func() string { return "" }))
+	if _, ok := request.Header[requestIDHeader]; ok {
+		t.Error("empty X-Request-Id set", request.Header)
+	}
+
+	request, _ = f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}}, WithRequestIDFunc(func() string { return "trace-123" }))
+	if id := request.Header.Get(requestIDHeader); id != "trace-123" {
+		t.Error(id)
+	}
+}
+
+func TestFactoryRequestIDFuncWithPerCallOption(t *testing.T) {
+	f, _ := NewSpanRequestFactory(WithInsertKey("key!"), WithRequestIDFunc(func() string { return "trace-123" }))
+	request, _ := f.BuildRequest(context.Background(), []Batch{{&MockPayloadEntry{}}}, WithUserAgent("x"))
+	if id := request.Header.Get(requestIDHeader); id != "trace-123" {
+		t.Error(id)
+	}
+}
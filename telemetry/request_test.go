@@ -40,7 +40,7 @@ func (p *testSplittablePayloadEntry) WriteDataEntry(buf *bytes.Buffer) *bytes.Bu
 	return buf
 }
 
-func (p *testSplittablePayloadEntry) split() []splittablePayloadEntry {
+func (p *testSplittablePayloadEntry) split(strategy SplitStrategy) []splittablePayloadEntry {
 	if nil == p.splitPayloads {
 		return nil
 	}
@@ -57,7 +57,7 @@ func TestNewRequestNoSplitNeeded(t *testing.T) {
 	entries := []MapEntry{&testPayload}
 	reqs, err := newRequestsInternal([]Batch{entries}, testFactory(), func(r *http.Request) bool {
 		return false
-	})
+	}, CountSplitStrategy{})
 	if err != nil {
 		t.Error(err)
 	}
@@ -83,7 +83,7 @@ func TestNewRequestSplitNeeded(t *testing.T) {
 		}
 
 		return shouldSplit
-	})
+	}, CountSplitStrategy{})
 	if err != nil {
 		t.Error(err)
 	}
@@ -112,7 +112,7 @@ func TestNewRequestSplittingMultiplePayloadsNeeded(t *testing.T) {
 		}
 
 		return shouldSplit
-	})
+	}, CountSplitStrategy{})
 	if err != nil {
 		t.Error(err)
 	}
@@ -153,7 +153,7 @@ func TestNewRequestCantSplitPayload(t *testing.T) {
 		}
 
 		return shouldSplit
-	})
+	}, CountSplitStrategy{})
 
 	if err != errUnableToSplit {
 		t.Error(err)
@@ -186,7 +186,7 @@ func TestNewRequestCantSplitPayloadsEnough(t *testing.T) {
 		}
 
 		return isOriginalPayload || isPayloadThatCantBeSplitAgain
-	})
+	}, CountSplitStrategy{})
 
 	if err != errUnableToSplit {
 		t.Error(err)
@@ -274,3 +274,76 @@ func testFactory() RequestFactory {
 	factory, _ := NewMetricRequestFactory(WithNoDefaultKey())
 	return factory
 }
+
+func TestSplitIndexStrategies(t *testing.T) {
+	sizes := []int{1000, 10, 10, 10, 10, 10, 10, 10, 10, 10}
+	size := func(i int) int { return sizes[i] }
+
+	if idx := splitIndex(CountSplitStrategy{}, len(sizes), size); idx != 5 {
+		t.Error("incorrect count split index", idx)
+	}
+	if idx := splitIndex(ByteSizeSplitStrategy{}, len(sizes), size); idx != 1 {
+		t.Error("incorrect byte size split index", idx)
+	}
+	if idx := splitIndex(nil, len(sizes), size); idx != 5 {
+		t.Error("nil strategy should split by count", idx)
+	}
+}
+
+type outOfRangeSplitStrategy struct{ idx int }
+
+func (s outOfRangeSplitStrategy) SplitIndex(n int, size func(i int) int) int {
+	return s.idx
+}
+
+func TestSplitIndexClamped(t *testing.T) {
+	size := func(i int) int { return 1 }
+	if idx := splitIndex(outOfRangeSplitStrategy{idx: -3}, 4, size); idx != 1 {
+		t.Error(idx)
+	}
+	if idx := splitIndex(outOfRangeSplitStrategy{idx: 10}, 4, size); idx != 3 {
+		t.Error(idx)
+	}
+}
+
+func TestByteSizeSplitSkewedSpans(t *testing.T) {
+	spans := []Span{{ID: "big", Attributes: map[string]interface{}{
+		"payload": string(randomJSON(10000)),
+	}}}
+	for i := 0; i < 9; i++ {
+		spans = append(spans, Span{ID: "small"})
+	}
+	groupSize := func(e splittablePayloadEntry) int {
+		return e.WriteDataEntry(&bytes.Buffer{}).Len()
+	}
+
+	countSplit := NewSpanGroup(spans).(splittablePayloadEntry).split(CountSplitStrategy{})
+	byteSplit := NewSpanGroup(spans).(splittablePayloadEntry).split(ByteSizeSplitStrategy{})
+	if len(countSplit) != 2 || len(byteSplit) != 2 {
+		t.Fatal(len(countSplit), len(byteSplit))
+	}
+
+	countDiff := groupSize(countSplit[0]) - groupSize(countSplit[1])
+	byteDiff := groupSize(byteSplit[0]) - groupSize(byteSplit[1])
+	if byteDiff >= countDiff {
+		t.Errorf("byte size split not more balanced: byteDiff=%d countDiff=%d", byteDiff, countDiff)
+	}
+	if n := len(byteSplit[0].(*spanGroup).Spans); n != 1 {
+		t.Error("large span should be split from the rest", n)
+	}
+}
+
+func TestBuildSplitRequestsSkippingOversized(t *testing.T) {
+	group1 := []MapEntry{&testUnsplittablePayloadEntry{rawData: randomJSON(maxCompressedSizeBytes * 4)}}
+	group2 := []MapEntry{&testUnsplittablePayloadEntry{rawData: randomJSON(10)}}
+	reqs, oversized, err := buildSplitRequestsSkippingOversized([]Batch{group1, group2}, testFactory(), CountSplitStrategy{})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if len(reqs) != 1 || len(oversized) != 1 {
+		t.Fatal(len(reqs), len(oversized))
+	}
+	if reqs[0].ContentLength >= maxCompressedSizeBytes || oversized[0].ContentLength < maxCompressedSizeBytes {
+		t.Error(reqs[0].ContentLength, oversized[0].ContentLength)
+	}
+}
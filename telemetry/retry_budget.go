@@ -0,0 +1,102 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// retryBudgetMaxTokens is the number of tokens a retryBudget starts with and
+// the most it can hold.  Retries are allowed while more than half remain.
+const retryBudgetMaxTokens = 10
+
+// retryBudget limits retries to a fraction of successful requests using the
+// token accounting of gRPC retry throttling.  A nil retryBudget allows all
+// retries.
+type retryBudget struct {
+	lock   sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{
+		ratio:  ratio,
+		tokens: retryBudgetMaxTokens,
+	}
+}
+
+// success replenishes the budget after a successful request.
+func (b *retryBudget) success() {
+	if nil == b {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens += b.ratio
+	if b.tokens > retryBudgetMaxTokens {
+		b.tokens = retryBudgetMaxTokens
+	}
+}
+
+// allowRetry spends a token for a failed attempt and returns true if the
+// attempt may be retried.
+func (b *retryBudget) allowRetry() bool {
+	if nil == b {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens--
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+	return b.tokens > retryBudgetMaxTokens/2
+}
+
+// countRequestItems returns the number of spans, metrics, events, or logs in
+// the request body.  dict is the dictionary of deflate encoded bodies.  Zero
+// is returned if the body cannot be decoded.
+func countRequestItems(req *http.Request, dict []byte) int {
+	body, err := readRequestBody(req, dict)
+	if nil != err {
+		return 0
+	}
+	var entries []map[string]json.RawMessage
+	if err := json.Unmarshal(body, &entries); nil != err {
+		return 0
+	}
+	var count int
+	for _, entry := range entries {
+		group, isGroup := groupItems(entry)
+		if !isGroup {
+			// Events are sent as a flat array.
+			count++
+			continue
+		}
+		count += group
+	}
+	return count
+}
+
+// groupItems returns the number of items in a batch object of a metrics,
+// spans, or logs request body and false if the entry is not such a batch.
+func groupItems(entry map[string]json.RawMessage) (int, bool) {
+	for _, key := range []string{"metrics", "spans", "logs"} {
+		js, ok := entry[key]
+		if !ok {
+			continue
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(js, &items); nil != err {
+			continue
+		}
+		return len(items), true
+	}
+	return 0, false
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(0.5)
+	// The budget starts full and allows retries until half the tokens
+	// are spent.
+	for i := 0; i < 4; i++ {
+		if !b.allowRetry() {
+			t.Fatal("retry should be allowed", i, b.tokens)
+		}
+	}
+	if b.allowRetry() {
+		t.Fatal("retry should not be allowed", b.tokens)
+	}
+	// Two successes earn back one token, which is then spent by the next
+	// failure.
+	b.success()
+	b.success()
+	if b.allowRetry() {
+		t.Fatal("retry should not be allowed", b.tokens)
+	}
+	for i := 0; i < 100; i++ {
+		b.success()
+	}
+	if b.tokens != retryBudgetMaxTokens {
+		t.Fatal("tokens should be capped", b.tokens)
+	}
+	if !b.allowRetry() {
+		t.Fatal("retry should be allowed", b.tokens)
+	}
+}
+
+func TestRetryBudgetNil(t *testing.T) {
+	var b *retryBudget
+	b.success()
+	if !b.allowRetry() {
+		t.Error("nil budget should allow retries")
+	}
+}
+
+func TestRetryBudgetThrottlesRetries(t *testing.T) {
+	// Disable backoff delay.
+	oBOSS := backoffSequenceSeconds
+	backoffSequenceSeconds = make([]int, len(oBOSS))
+	defer func() { backoffSequenceSeconds = oBOSS }()
+
+	var lock sync.Mutex
+	var drops []dropRecord
+	var attempts int
+	var status = 503
+	h, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops), func(cfg *Config) {
+		cfg.RetryBudgetRatio = 0.5
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			attempts++
+			return emptyResponse(status), nil
+		})
+	})
+
+	// The sustained failure would retry forever without the budget.
+	h.RecordSpan(Span{ID: "1", TraceID: "trace", Timestamp: time.Now()})
+	h.HarvestNow(context.Background())
+	if attempts != 5 {
+		t.Error("initial budget should allow four retries", attempts)
+	}
+
+	// Once exhausted, failed requests are not retried.
+	attempts = 0
+	h.RecordSpan(Span{ID: "2", TraceID: "trace", Timestamp: time.Now()})
+	h.RecordSpan(Span{ID: "3", TraceID: "trace", Timestamp: time.Now()})
+	h.HarvestNow(context.Background())
+	if attempts != 1 {
+		t.Error("exhausted budget should not allow retries", attempts)
+	}
+
+	expect := []dropRecord{
+		{"spans", 1, DropReasonRetryBudget},
+		{"spans", 2, DropReasonRetryBudget},
+	}
+	if !reflect.DeepEqual(drops, expect) {
+		t.Errorf("\nexpect=%v\nactual=%v", expect, drops)
+	}
+
+	// Successful requests replenish the budget enough for one retry.
+	status = 202
+	for i := 0; i < 6; i++ {
+		h.RecordEvent(Event{EventType: "testEvent", Timestamp: time.Now()})
+		h.HarvestNow(context.Background())
+	}
+	status = 503
+	attempts = 0
+	h.RecordEvent(Event{EventType: "testEvent", Timestamp: time.Now()})
+	h.HarvestNow(context.Background())
+	if attempts != 2 {
+		t.Error("replenished budget should allow a retry", attempts)
+	}
+}
+
+func TestCountRequestItems(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.CommonAttributes = map[string]interface{}{"zip": "zap"}
+	})
+	h.RecordMetric(Gauge{Name: "gauge", Timestamp: time.Now()})
+	h.RecordMetric(Count{Name: "count", Timestamp: time.Now()})
+	h.RecordEvent(Event{EventType: "testEvent", Timestamp: time.Now()})
+	h.RecordEvent(Event{EventType: "testEvent", Timestamp: time.Now()})
+	h.RecordEvent(Event{EventType: "testEvent", Timestamp: time.Now()})
+	h.RecordLog(Log{Message: "message", Timestamp: time.Now()})
+
+	testcases := []struct {
+		reqs   []*http.Request
+		expect int
+	}{
+		{reqs: h.swapOutMetrics(time.Now()), expect: 2},
+		{reqs: h.swapOutEvents(), expect: 3},
+		{reqs: h.swapOutLogs(), expect: 1},
+	}
+	for idx, tc := range testcases {
+		if len(tc.reqs) != 1 {
+			t.Fatal(idx, tc.reqs)
+		}
+		if count := countRequestItems(tc.reqs[0], nil); count != tc.expect {
+			t.Error(idx, count, tc.expect)
+		}
+	}
+}
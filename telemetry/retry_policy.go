@@ -0,0 +1,69 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides whether the Harvester retries a failed request and how
+// long it waits before doing so.  Set Config.RetryPolicy to replace the
+// default policy, eg. with exponential backoff with jitter, a cap on the
+// number of retries, or a circuit breaker.  NextBackoff may be called from
+// multiple goroutines.
+type RetryPolicy interface {
+	// NextBackoff is called after each failed attempt of a request.
+	// statusCode is the response's status code, or zero if no response
+	// was received.  attempt is the number of retries already made,
+	// starting at zero.  retryAfter is the response's Retry-After header,
+	// if any.  It returns whether the request should be retried and how
+	// long to wait first.  Requests with a 2xx response are never retried
+	// and are not passed to NextBackoff.
+	NextBackoff(statusCode, attempt int, retryAfter string) (retry bool, delay time.Duration)
+}
+
+// DefaultRetryPolicy is the retry schedule of the Harvester without jitter.
+// Requests are retried after 0, 1, 2, 4, 8, and then every 16 seconds until
+// the harvest context is done, except for successful responses and
+// responses with a status code of 400, 403, 404, 405, 411, or 413.  A longer
+// Retry-After header of a 429 response, in seconds or as an HTTP-date, is
+// honored.  Custom policies can delegate to it, eg. to only limit the number
+// of retries.  It is used if Config.RetryPolicy is unset and
+// Config.DisableJitter is set.
+type DefaultRetryPolicy struct{}
+
+// NextBackoff implements RetryPolicy.
+func (DefaultRetryPolicy) NextBackoff(statusCode, attempt int, retryAfter string) (bool, time.Duration) {
+	return response{statusCode: statusCode, retryAfter: retryAfter}.defaultRetry(attempt)
+}
+
+// JitteredRetryPolicy is the RetryPolicy used if Config.RetryPolicy is unset.
+// It retries like DefaultRetryPolicy, but randomizes each delay to between
+// half of it and all of it ("equal jitter"), so that harvesters which failed
+// at once do not retry in lockstep.  A longer Retry-After header is still
+// honored exactly.
+type JitteredRetryPolicy struct {
+	// Int63n, if set, replaces the random source, eg. for deterministic
+	// tests.  It must return a number in [0, n) and is called with n > 0.
+	// It may be called from multiple goroutines.
+	Int63n func(n int64) int64
+}
+
+// NextBackoff implements RetryPolicy.
+func (p JitteredRetryPolicy) NextBackoff(statusCode, attempt int, retryAfter string) (bool, time.Duration) {
+	return response{statusCode: statusCode, retryAfter: retryAfter}.retryBackoff(p.jitter(defaultBackoff(attempt)))
+}
+
+func (p JitteredRetryPolicy) jitter(backoff time.Duration) time.Duration {
+	if backoff <= 1 {
+		return backoff
+	}
+	int63n := p.Int63n
+	if nil == int63n {
+		int63n = rand.New(rand.NewSource(time.Now().UnixNano())).Int63n
+	}
+	half := backoff / 2
+	return half + time.Duration(int63n(int64(backoff-half)+1))
+}
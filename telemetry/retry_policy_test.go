@@ -0,0 +1,168 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cappedRetryPolicy retries immediately at most max times.
+type cappedRetryPolicy struct {
+	max   int
+	calls int32
+}
+
+func (p *cappedRetryPolicy) NextBackoff(statusCode, attempt int, retryAfter string) (bool, time.Duration) {
+	atomic.AddInt32(&p.calls, 1)
+	if attempt >= p.max {
+		return false, 0
+	}
+	retry, _ := DefaultRetryPolicy{}.NextBackoff(statusCode, attempt, retryAfter)
+	return retry, 0
+}
+
+func TestRetryPolicyCapsAttempts(t *testing.T) {
+	var attempts int32
+	policy := &cappedRetryPolicy{max: 3}
+	var result HarvestResult
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.RetryPolicy = policy
+		cfg.HarvestCallback = func(r HarvestResult) { result = r }
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&attempts, 1)
+			return emptyResponse(500), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+
+	done := make(chan struct{})
+	go func() {
+		h.HarvestNow(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("harvest did not complete")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 4 {
+		t.Error(n)
+	}
+	if n := atomic.LoadInt32(&policy.calls); n != 4 {
+		t.Error(n)
+	}
+	if result.Retries != 3 || result.StatusCode != 500 {
+		t.Error(result)
+	}
+}
+
+// alwaysRetryPolicy retries every request immediately at most max times,
+// without checking the status code.
+type alwaysRetryPolicy struct {
+	max   int
+	calls int32
+}
+
+func (p *alwaysRetryPolicy) NextBackoff(statusCode, attempt int, retryAfter string) (bool, time.Duration) {
+	atomic.AddInt32(&p.calls, 1)
+	return attempt < p.max, 0
+}
+
+func TestRetryPolicySuccessNotRetried(t *testing.T) {
+	var attempts int32
+	policy := &alwaysRetryPolicy{max: 3}
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.RetryPolicy = policy
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&attempts, 1)
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "tid"})
+	h.HarvestNow(context.Background())
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Error(n)
+	}
+	if n := atomic.LoadInt32(&policy.calls); n != 0 {
+		t.Error(n)
+	}
+}
+
+func TestDefaultRetryPolicy(t *testing.T) {
+	testcases := []struct {
+		statusCode int
+		attempt    int
+		retryAfter string
+		retry      bool
+		delay      time.Duration
+	}{
+		{statusCode: 202, retry: false},
+		{statusCode: 413, retry: false},
+		{statusCode: 500, attempt: 0, retry: true, delay: 0},
+		{statusCode: 500, attempt: 3, retry: true, delay: 4 * time.Second},
+		{statusCode: 500, attempt: 100, retry: true, delay: 16 * time.Second},
+		{statusCode: 0, attempt: 1, retry: true, delay: time.Second},
+		{statusCode: 429, attempt: 1, retryAfter: "30", retry: true, delay: 30 * time.Second},
+	}
+	for _, tc := range testcases {
+		retry, delay := DefaultRetryPolicy{}.NextBackoff(tc.statusCode, tc.attempt, tc.retryAfter)
+		if retry != tc.retry || delay != tc.delay {
+			t.Error(tc, retry, delay)
+		}
+	}
+}
+
+func TestJitteredRetryPolicy(t *testing.T) {
+	var max int64
+	policy := JitteredRetryPolicy{Int63n: func(n int64) int64 {
+		if n > max {
+			max = n
+		}
+		return n - 1
+	}}
+	if retry, delay := policy.NextBackoff(500, 3, ""); !retry || delay != 4*time.Second {
+		t.Error(retry, delay)
+	}
+	if max != int64(2*time.Second)+1 {
+		t.Error(max)
+	}
+	policy.Int63n = func(n int64) int64 { return 0 }
+	if retry, delay := policy.NextBackoff(500, 3, ""); !retry || delay != 2*time.Second {
+		t.Error(retry, delay)
+	}
+	// A longer Retry-After header is not jittered.
+	if retry, delay := policy.NextBackoff(429, 1, "3"); !retry || delay != 3*time.Second {
+		t.Error(retry, delay)
+	}
+	if retry, _ := policy.NextBackoff(413, 1, ""); retry {
+		t.Error(retry)
+	}
+
+	// Without Int63n a random source is used.
+	for i := 0; i < 100; i++ {
+		retry, delay := JitteredRetryPolicy{}.NextBackoff(500, 4, "")
+		if !retry || delay < 4*time.Second || delay > 8*time.Second {
+			t.Fatal(retry, delay)
+		}
+	}
+}
+
+func TestConfigRetryPolicy(t *testing.T) {
+	cfg := Config{}
+	if _, ok := cfg.retryPolicy().(JitteredRetryPolicy); !ok {
+		t.Error(cfg.retryPolicy())
+	}
+	cfg.DisableJitter = true
+	if _, ok := cfg.retryPolicy().(DefaultRetryPolicy); !ok {
+		t.Error(cfg.retryPolicy())
+	}
+	cfg.RetryPolicy = &cappedRetryPolicy{max: 1}
+	if _, ok := cfg.retryPolicy().(*cappedRetryPolicy); !ok {
+		t.Error(cfg.retryPolicy())
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// RetryTransport is an http.RoundTripper that retries requests which fail
+// with a retryable status code, using the same rules as the Harvester.  Use
+// it with an http.Client to send requests built by a RequestFactory without
+// writing a retry loop.
+type RetryTransport struct {
+	// DebugLogger receives structured debug log messages each time a
+	// request is retried.  It must be set before the transport is used.
+	DebugLogger func(map[string]interface{})
+
+	base    http.RoundTripper
+	backoff []time.Duration
+	retries int64
+}
+
+// NewRetryTransport creates a RetryTransport that sends requests using base.
+// A request is retried at most len(backoff) times, waiting backoff[i] before
+// retry i unless the server requests a longer wait with a Retry-After header.
+// If base is nil, http.DefaultTransport is used.  If backoff is nil, the
+// Harvester's backoff sequence is used.
+func NewRetryTransport(base http.RoundTripper, backoff []time.Duration) *RetryTransport {
+	if nil == base {
+		base = http.DefaultTransport
+	}
+	if nil == backoff {
+		for _, secs := range backoffSequenceSeconds {
+			backoff = append(backoff, time.Duration(secs)*time.Second)
+		}
+	}
+	return &RetryTransport{
+		base:    base,
+		backoff: backoff,
+	}
+}
+
+// Retries returns the total number of retries made by this transport.
+func (t *RetryTransport) Retries() int64 {
+	return atomic.LoadInt64(&t.retries)
+}
+
+// RoundTrip implements http.RoundTripper.  Requests with a body can only be
+// retried if GetBody is set, as it is for requests built by a RequestFactory.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	canRetry := nil == req.Body || nil != req.GetBody
+	for attempts := 0; ; attempts++ {
+		resp, err := t.base.RoundTrip(req)
+		if !canRetry || attempts >= len(t.backoff) {
+			return resp, err
+		}
+
+		r := response{err: err}
+		if nil != resp {
+			r.statusCode = resp.StatusCode
+			r.retryAfter = resp.Header.Get("Retry-After")
+		}
+		retry, backoff := r.retryBackoff(t.backoff[attempts])
+		if !retry {
+			return resp, err
+		}
+
+		tmr := time.NewTimer(backoff)
+		select {
+		case <-tmr.C:
+		case <-req.Context().Done():
+			tmr.Stop()
+			return resp, err
+		}
+
+		if nil != resp {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		next := req.Clone(req.Context())
+		if nil != req.GetBody {
+			if next.Body, err = req.GetBody(); nil != err {
+				return nil, err
+			}
+		}
+		req = next
+
+		atomic.AddInt64(&t.retries, 1)
+		if nil != t.DebugLogger {
+			t.DebugLogger(map[string]interface{}{
+				"event":   "request retry",
+				"url":     req.URL.String(),
+				"attempt": attempts + 1,
+				"status":  r.statusCode,
+				"backoff": backoff.String(),
+			})
+		}
+	}
+}
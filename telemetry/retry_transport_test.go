@@ -0,0 +1,136 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransportRetries(t *testing.T) {
+	testcases := []struct {
+		codes         []int
+		backoff       []time.Duration
+		expectPosts   int
+		expectRetries int64
+		expectStatus  int
+	}{
+		{codes: []int{200}, backoff: make([]time.Duration, 3), expectPosts: 1, expectRetries: 0, expectStatus: 200},
+		{codes: []int{500, 202}, backoff: make([]time.Duration, 3), expectPosts: 2, expectRetries: 1, expectStatus: 202},
+		{codes: []int{429, 503, 200}, backoff: make([]time.Duration, 3), expectPosts: 3, expectRetries: 2, expectStatus: 200},
+		{codes: []int{413, 200}, backoff: make([]time.Duration, 3), expectPosts: 1, expectRetries: 0, expectStatus: 413},
+		{codes: []int{500, 500, 500}, backoff: make([]time.Duration, 2), expectPosts: 3, expectRetries: 2, expectStatus: 500},
+	}
+
+	for _, test := range testcases {
+		var posts int
+		base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			body, _ := ioutil.ReadAll(req.Body)
+			if string(body) != "hello" {
+				t.Errorf("incorrect body on attempt %d: %q", posts, body)
+			}
+			code := test.codes[posts]
+			posts++
+			return emptyResponse(code), nil
+		})
+
+		transport := NewRetryTransport(base, test.backoff)
+		req, _ := http.NewRequest("POST", "https://metric-api.newrelic.com/metric/v1", strings.NewReader("hello"))
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.expectStatus {
+			t.Error("incorrect status", resp.StatusCode, test.codes)
+		}
+		if posts != test.expectPosts {
+			t.Error("incorrect number of posts", posts, test.codes)
+		}
+		if r := transport.Retries(); r != test.expectRetries {
+			t.Error("incorrect number of retries", r, test.codes)
+		}
+	}
+}
+
+func TestRetryTransportHonorsRetryAfter(t *testing.T) {
+	var posts int
+	var start time.Time
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		posts++
+		if posts > 1 {
+			if since := time.Since(start); since < time.Second {
+				t.Errorf("Retry-After not honored, since=%v", since)
+			}
+			return emptyResponse(200), nil
+		}
+		start = time.Now()
+		resp := emptyResponse(429)
+		resp.Header = http.Header{"Retry-After": []string{"1"}}
+		return resp, nil
+	})
+
+	var logged []map[string]interface{}
+	transport := NewRetryTransport(base, []time.Duration{0})
+	transport.DebugLogger = func(fields map[string]interface{}) {
+		logged = append(logged, fields)
+	}
+	req, _ := http.NewRequest("GET", "https://metric-api.newrelic.com/metric/v1", nil)
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 2 {
+		t.Error("incorrect number of posts", posts)
+	}
+	if len(logged) != 1 || logged[0]["status"] != 429 {
+		t.Error(logged)
+	}
+}
+
+func TestRetryTransportContextCancelled(t *testing.T) {
+	var posts int
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		posts++
+		return emptyResponse(500), nil
+	})
+	transport := NewRetryTransport(base, []time.Duration{time.Minute})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest("GET", "https://metric-api.newrelic.com/metric/v1", nil)
+	resp, err := transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 500 || posts != 1 {
+		t.Error(resp.StatusCode, posts)
+	}
+}
+
+func TestRetryTransportWithHarvesterRequests(t *testing.T) {
+	var posts int
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		posts++
+		if posts == 1 {
+			return emptyResponse(503), nil
+		}
+		return emptyResponse(202), nil
+	})
+	h, _ := NewHarvester(configTesting)
+	h.RecordMetric(Count{})
+	reqs := h.swapOutMetrics(time.Now())
+	if len(reqs) != 1 {
+		t.Fatal(reqs)
+	}
+	client := &http.Client{Transport: NewRetryTransport(base, []time.Duration{0})}
+	resp, err := client.Do(reqs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 202 || posts != 2 {
+		t.Error(resp.StatusCode, posts)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// snapshotVersion is the version of the snapshot format written by
+// Harvester.Snapshot.  It must be incremented whenever the format changes in
+// a way that older versions of LoadSnapshot cannot read.
+const snapshotVersion = 1
+
+var (
+	errSnapshotVersion = errors.New("unsupported snapshot version")
+)
+
+// snapshot is the portable representation of the data buffered by a
+// Harvester.
+type snapshot struct {
+	Version int              `json:"version"`
+	Spans   []Span           `json:"spans,omitempty"`
+	Metrics []snapshotMetric `json:"metrics,omitempty"`
+	Events  []Event          `json:"events,omitempty"`
+	Logs    []Log            `json:"logs,omitempty"`
+}
+
+// snapshotMetric holds exactly one metric along with its type so that it can
+// be restored to the correct Metric implementation.
+type snapshotMetric struct {
+	Type      string           `json:"type"`
+	Count     *Count           `json:"count,omitempty"`
+	Summary   *snapshotSummary `json:"summary,omitempty"`
+	Gauge     *Gauge           `json:"gauge,omitempty"`
+	Histogram *Histogram       `json:"histogram,omitempty"`
+	// JSON and Timestamp are used for metrics which have already been
+	// serialized, such as those re-queued after a retryable rejection.
+	JSON      json.RawMessage `json:"json,omitempty"`
+	Timestamp time.Time       `json:"timestamp,omitempty"`
+}
+
+// snapshotSummary is a Summary whose Min and Max are written as null when
+// they are NaN, as for NewCountOnlySummary, since JSON has no NaN.
+type snapshotSummary struct {
+	Summary
+	Min *float64
+	Max *float64
+}
+
+func newSnapshotSummary(s Summary) *snapshotSummary {
+	ss := &snapshotSummary{Summary: s}
+	if !math.IsNaN(s.Min) {
+		ss.Min = &s.Min
+	}
+	if !math.IsNaN(s.Max) {
+		ss.Max = &s.Max
+	}
+	return ss
+}
+
+func (ss *snapshotSummary) summary() Summary {
+	s := ss.Summary
+	s.Min, s.Max = math.NaN(), math.NaN()
+	if nil != ss.Min {
+		s.Min = *ss.Min
+	}
+	if nil != ss.Max {
+		s.Max = *ss.Max
+	}
+	return s
+}
+
+func newSnapshotMetric(m Metric) (snapshotMetric, bool) {
+	switch v := m.(type) {
+	case Count:
+		return snapshotMetric{Type: "count", Count: &v}, true
+	case *Count:
+		c := *v
+		return snapshotMetric{Type: "count", Count: &c}, true
+	case Summary:
+		return snapshotMetric{Type: "summary", Summary: newSnapshotSummary(v)}, true
+	case *Summary:
+		return snapshotMetric{Type: "summary", Summary: newSnapshotSummary(*v)}, true
+	case Gauge:
+		return snapshotMetric{Type: "gauge", Gauge: &v}, true
+	case *Gauge:
+		g := *v
+		return snapshotMetric{Type: "gauge", Gauge: &g}, true
+	case Histogram:
+		return snapshotMetric{Type: "histogram", Histogram: &v}, true
+	case *Histogram:
+		hm := *v
+		return snapshotMetric{Type: "histogram", Histogram: &hm}, true
+	case requeuedMetric:
+		return snapshotMetric{Type: "raw", JSON: v.js, Timestamp: v.ts}, true
+	}
+	return snapshotMetric{}, false
+}
+
+func (sm snapshotMetric) metric() (Metric, error) {
+	switch {
+	case sm.Type == "count" && nil != sm.Count:
+		sm.Count.AttributesJSON = nullRawMessage(sm.Count.AttributesJSON)
+		return *sm.Count, nil
+	case sm.Type == "summary" && nil != sm.Summary:
+		s := sm.Summary.summary()
+		s.AttributesJSON = nullRawMessage(s.AttributesJSON)
+		return s, nil
+	case sm.Type == "gauge" && nil != sm.Gauge:
+		sm.Gauge.AttributesJSON = nullRawMessage(sm.Gauge.AttributesJSON)
+		return *sm.Gauge, nil
+	case sm.Type == "histogram" && nil != sm.Histogram:
+		sm.Histogram.AttributesJSON = nullRawMessage(sm.Histogram.AttributesJSON)
+		return *sm.Histogram, nil
+	case sm.Type == "raw" && nil != sm.JSON:
+		return requeuedMetric{js: sm.JSON, ts: sm.Timestamp}, nil
+	}
+	return nil, fmt.Errorf("invalid snapshot metric of type %q", sm.Type)
+}
+
+// nullRawMessage converts the JSON null written for an unset AttributesJSON
+// field back into a nil json.RawMessage.
+func nullRawMessage(js json.RawMessage) json.RawMessage {
+	if string(js) == "null" {
+		return nil
+	}
+	return js
+}
+
+// Snapshot returns all of the spans, metrics, events, and logs currently
+// buffered in the Harvester, serialized into a portable JSON format.  The
+// buffers are not modified.  Aggregated metrics are included as the Count,
+// Summary, and Gauge metrics they would be harvested as, and metrics recorded
+// with RecordMetricSnapshot have its timestamp and interval applied.  The
+// result can be passed to LoadSnapshot to buffer the data again, possibly in
+// another Harvester or process.  Requests deferred due to
+// Config.MaxRequestsPerHarvest have already been built and are not included.
+func (h *Harvester) Snapshot() ([]byte, error) {
+	if nil == h {
+		return nil, nil
+	}
+	s := snapshot{Version: snapshotVersion}
+
+	h.lock.Lock()
+	s.Spans = append([]Span(nil), h.spans...)
+	s.Events = append([]Event(nil), h.events...)
+	s.Logs = append([]Log(nil), h.logs...)
+	metrics := append([]Metric(nil), h.rawMetrics...)
+	for _, ms := range h.metricSnapshots {
+		metrics = append(metrics, ms.withCommonTiming()...)
+	}
+	for _, m := range h.aggregatedMetrics {
+		if nil != m.c {
+			metrics = append(metrics, *m.c)
+		}
+		if nil != m.s {
+			metrics = append(metrics, *m.s)
+		}
+		if nil != m.g {
+			metrics = append(metrics, *m.g)
+		}
+	}
+	h.lock.Unlock()
+
+	for _, m := range metrics {
+		sm, ok := newSnapshotMetric(m)
+		if !ok {
+			return nil, fmt.Errorf("unable to snapshot metric of type %T", m)
+		}
+		s.Metrics = append(s.Metrics, sm)
+	}
+	return json.Marshal(s)
+}
+
+// LoadSnapshot buffers the data contained in a snapshot created by Snapshot.
+// The data is added to any data already buffered and will be sent in the next
+// harvest.  No data is buffered if the snapshot cannot be read.  Data which
+// does not fit in the buffers, see Config.MaxBufferedPayloads, is dropped.
+func (h *Harvester) LoadSnapshot(data []byte) error {
+	if nil == h {
+		return nil
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); nil != err {
+		return err
+	}
+	if s.Version != snapshotVersion {
+		return errSnapshotVersion
+	}
+	metrics := make([]Metric, 0, len(s.Metrics))
+	for _, sm := range s.Metrics {
+		m, err := sm.metric()
+		if nil != err {
+			return err
+		}
+		metrics = append(metrics, m)
+	}
+	for i := range s.Events {
+		s.Events[i].AttributesJSON = nullRawMessage(s.Events[i].AttributesJSON)
+	}
+	for i := range s.Spans {
+		for j := range s.Spans[i].Events {
+			s.Spans[i].Events[j].AttributesJSON = nullRawMessage(s.Spans[i].Events[j].AttributesJSON)
+		}
+	}
+
+	full := make(map[Signal]int)
+	h.lock.Lock()
+	if space := h.bufferSpace(len(h.spans)); space < len(s.Spans) {
+		full[SignalSpans] = h.bufferDrop(SignalSpans, len(s.Spans)-space)
+		s.Spans = s.Spans[:space]
+	}
+	if space := h.bufferSpace(h.bufferedMetrics()); space < len(metrics) {
+		full[SignalMetrics] = h.bufferDrop(SignalMetrics, len(metrics)-space)
+		metrics = metrics[:space]
+	}
+	if space := h.bufferSpace(len(h.events)); space < len(s.Events) {
+		full[SignalEvents] = h.bufferDrop(SignalEvents, len(s.Events)-space)
+		s.Events = s.Events[:space]
+	}
+	if space := h.bufferSpace(len(h.logs)); space < len(s.Logs) {
+		full[SignalLogs] = h.bufferDrop(SignalLogs, len(s.Logs)-space)
+		s.Logs = s.Logs[:space]
+	}
+	first := map[Signal]bool{
+		SignalSpans:   len(h.spans) == 0 && len(s.Spans) > 0,
+		SignalMetrics: h.metricsEmpty() && len(metrics) > 0,
+		SignalEvents:  len(h.events) == 0 && len(s.Events) > 0,
+		SignalLogs:    len(h.logs) == 0 && len(s.Logs) > 0,
+	}
+	h.spans = append(h.spans, s.Spans...)
+	h.rawMetrics = append(h.rawMetrics, metrics...)
+	h.events = append(h.events, s.Events...)
+	h.logs = append(h.logs, s.Logs...)
+	for i := range s.Spans {
+		h.addBufferedBytes(SignalSpans, s.Spans[i].approximateSize())
+	}
+	h.addBufferedBytes(SignalMetrics, approximateMetricsSize(metrics))
+	for i := range s.Events {
+		h.addBufferedBytes(SignalEvents, s.Events[i].approximateSize())
+	}
+	for i := range s.Logs {
+		h.addBufferedBytes(SignalLogs, s.Logs[i].approximateSize())
+	}
+	h.lock.Unlock()
+	for _, signal := range allSignals {
+		h.afterRecord(signal, first[signal], full[signal])
+	}
+
+	h.config.logDebug(map[string]interface{}{
+		"event":   "snapshot loaded",
+		"spans":   len(s.Spans),
+		"metrics": len(metrics),
+		"events":  len(s.Events),
+		"logs":    len(s.Logs),
+	})
+	return nil
+}
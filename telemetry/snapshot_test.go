@@ -0,0 +1,213 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting)
+	h.RecordSpan(Span{
+		ID:         "span-id",
+		TraceID:    "trace-id",
+		Timestamp:  start,
+		Duration:   2 * time.Second,
+		Attributes: map[string]interface{}{"zip": "zap"},
+	})
+	h.RecordMetric(Count{Name: "count", Value: 3, Timestamp: start, Interval: 5 * time.Second})
+	h.RecordMetric(Summary{Name: "summary", Count: 2, Sum: 3, Min: 1, Max: 2, Timestamp: start, Interval: 5 * time.Second})
+	h.RecordMetric(Gauge{Name: "gauge", Value: 1.5, Timestamp: start, AttributesJSON: json.RawMessage(`{"zip":"zap"}`)})
+	h.MetricAggregator().Count("aggregated", nil).Increment()
+	h.rawMetrics = append(h.rawMetrics, requeuedMetric{js: json.RawMessage(`{"name":"requeued","type":"gauge","value":1}`), ts: start})
+	h.RecordEvent(Event{EventType: "event", Timestamp: start})
+	h.RecordLog(Log{Message: "log", Timestamp: start})
+
+	data, err := h.Snapshot()
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// Taking a snapshot must not modify the buffers.
+	if len(h.spans) != 1 || len(h.rawMetrics) != 4 || len(h.aggregatedMetrics) != 1 ||
+		len(h.events) != 1 || len(h.logs) != 1 {
+		t.Error("snapshot modified harvester buffers")
+	}
+
+	loaded, _ := NewHarvester(configTesting)
+	if err := loaded.LoadSnapshot(data); nil != err {
+		t.Fatal(err)
+	}
+
+	expectSpans := compactJSONString(`[{"spans":[{
+		"id":"span-id",
+		"trace.id":"trace-id",
+		"timestamp":1417136460000,
+		"attributes":{"duration.ms":2000,"zip":"zap"}
+	}]}]`)
+	if js := spanRequestBody(t, loaded.swapOutSpans()); js != expectSpans {
+		t.Error(js)
+	}
+
+	metrics, _ := loaded.takeMetrics(time.Now())
+	if len(metrics) != 5 {
+		t.Fatal(len(metrics))
+	}
+	if m, ok := metrics[0].(Count); !ok || m.Value != 3 || !m.Timestamp.Equal(start) || m.Interval != 5*time.Second {
+		t.Error(metrics[0])
+	}
+	if m, ok := metrics[1].(Summary); !ok || m.Count != 2 || m.Sum != 3 || m.Min != 1 || m.Max != 2 {
+		t.Error(metrics[1])
+	}
+	if m, ok := metrics[2].(Gauge); !ok || m.Value != 1.5 || string(m.AttributesJSON) != `{"zip":"zap"}` {
+		t.Error(metrics[2])
+	}
+	if m, ok := metrics[3].(requeuedMetric); !ok || !m.ts.Equal(start) || string(m.js) != `{"name":"requeued","type":"gauge","value":1}` {
+		t.Error(metrics[3])
+	}
+	if m, ok := metrics[4].(Count); !ok || m.Name != "aggregated" || m.Value != 1 {
+		t.Error(metrics[4])
+	}
+
+	if len(loaded.events) != 1 || loaded.events[0].EventType != "event" || loaded.events[0].AttributesJSON != nil {
+		t.Error(loaded.events)
+	}
+	if len(loaded.logs) != 1 || loaded.logs[0].Message != "log" || !loaded.logs[0].Timestamp.Equal(start) {
+		t.Error(loaded.logs)
+	}
+}
+
+func TestSnapshotCountOnlySummary(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	h.RecordMetric(NewCountOnlySummary("summary", nil, 2, 3))
+	h.RecordMetric(Summary{Name: "bounded", Count: 1, Sum: 1, Min: 0, Max: 1})
+	data, err := h.Snapshot()
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	loaded, _ := NewHarvester(configTesting)
+	if err := loaded.LoadSnapshot(data); nil != err {
+		t.Fatal(err)
+	}
+	metrics, _ := loaded.takeMetrics(time.Now())
+	if len(metrics) != 2 {
+		t.Fatal(len(metrics))
+	}
+	if m, ok := metrics[0].(Summary); !ok || m.Count != 2 || m.Sum != 3 || !math.IsNaN(m.Min) || !math.IsNaN(m.Max) {
+		t.Error(metrics[0])
+	}
+	if m, ok := metrics[1].(Summary); !ok || m.Min != 0 || m.Max != 1 {
+		t.Error(metrics[1])
+	}
+}
+
+func TestLoadSnapshotBufferFull(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	for i := 0; i < 3; i++ {
+		h.RecordEvent(Event{EventType: "event", Timestamp: time.Now()})
+		h.RecordMetric(Gauge{Name: "gauge", Value: 1, Timestamp: time.Now()})
+	}
+	data, err := h.Snapshot()
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	var lock sync.Mutex
+	var drops []dropRecord
+	loaded, _ := NewHarvester(configTesting, configureDropsToSlice(&lock, &drops), func(cfg *Config) {
+		cfg.MaxBufferedPayloads = 2
+	})
+	loaded.RecordMetric(Gauge{Name: "gauge", Value: 1, Timestamp: time.Now()})
+	if err := loaded.LoadSnapshot(data); nil != err {
+		t.Fatal(err)
+	}
+	if len(loaded.events) != 2 || len(loaded.rawMetrics) != 2 {
+		t.Error(len(loaded.events), len(loaded.rawMetrics))
+	}
+	expect := []dropRecord{
+		{"metrics", 2, DropReasonBufferFull},
+		{"events", 1, DropReasonBufferFull},
+	}
+	if !reflect.DeepEqual(drops, expect) {
+		t.Error(drops)
+	}
+}
+
+func spanRequestBody(t *testing.T, reqs []*http.Request) string {
+	if len(reqs) != 1 {
+		t.Fatal(reqs)
+	}
+	bodyReader, _ := reqs[0].GetBody()
+	compressedBytes, _ := ioutil.ReadAll(bodyReader)
+	js, err := internal.Uncompress(compressedBytes)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return string(js)
+}
+
+func TestSnapshotMetricSnapshot(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting)
+	h.RecordMetricSnapshot([]Metric{
+		&Count{Name: "count", Value: 1},
+		Gauge{Name: "gauge", Value: 2, Timestamp: start.Add(time.Second)},
+	}, start, 5*time.Second)
+
+	data, err := h.Snapshot()
+	if nil != err {
+		t.Fatal(err)
+	}
+	loaded, _ := NewHarvester(configTesting)
+	if err := loaded.LoadSnapshot(data); nil != err {
+		t.Fatal(err)
+	}
+	metrics, _ := loaded.takeMetrics(time.Now())
+	if len(metrics) != 2 {
+		t.Fatal(len(metrics))
+	}
+	if m, ok := metrics[0].(Count); !ok || !m.Timestamp.Equal(start) || m.Interval != 5*time.Second {
+		t.Error(metrics[0])
+	}
+	if m, ok := metrics[1].(Gauge); !ok || !m.Timestamp.Equal(start.Add(time.Second)) {
+		t.Error(metrics[1])
+	}
+}
+
+func TestLoadSnapshotErrors(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	if err := h.LoadSnapshot([]byte(`{`)); nil == err {
+		t.Error("expected error for invalid JSON")
+	}
+	if err := h.LoadSnapshot([]byte(`{"version":2}`)); err != errSnapshotVersion {
+		t.Error(err)
+	}
+	if err := h.LoadSnapshot([]byte(`{"version":1,"events":[{"EventType":"e"}],"metrics":[{"type":"unknown"}]}`)); nil == err {
+		t.Error("expected error for invalid metric")
+	}
+	if len(h.events) != 0 {
+		t.Error("no data should be buffered when the snapshot is invalid", h.events)
+	}
+}
+
+func TestSnapshotNilHarvester(t *testing.T) {
+	var h *Harvester
+	if data, err := h.Snapshot(); nil != data || nil != err {
+		t.Error(data, err)
+	}
+	if err := h.LoadSnapshot([]byte(`{}`)); nil != err {
+		t.Error(err)
+	}
+}
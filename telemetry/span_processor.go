@@ -0,0 +1,187 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SpanProcessor receives completed spans before they are recorded in a
+// Harvester.  SpanProcessors can be chained to build a pipeline which drops,
+// samples, or enriches spans, similar to the OpenTelemetry SpanProcessor.
+type SpanProcessor interface {
+	// OnEnd is called with each completed span.  It may be called from
+	// multiple goroutines.
+	OnEnd(Span)
+	// Shutdown flushes any buffered spans and releases the processor's
+	// resources.  Spans passed to OnEnd after Shutdown are dropped.
+	Shutdown(context.Context) error
+}
+
+// defaultSpanBatchSize is the batch size used by NewBatchSpanProcessor if the
+// size given is not positive.
+const defaultSpanBatchSize = 512
+
+// defaultSpanFlushDelay is the delay between the scheduled flushes of a
+// BatchSpanProcessor if BatchSpanProcessorFlushDelay is not used.
+const defaultSpanFlushDelay = 5 * time.Second
+
+// BatchSpanProcessor is a SpanProcessor which buffers spans and records them
+// in a Harvester in batches.  This reduces contention on the Harvester when
+// many goroutines complete spans at once.
+type BatchSpanProcessor struct {
+	harvester *Harvester
+	size      int
+	delay     time.Duration
+	done      chan struct{}
+
+	lock     sync.Mutex
+	spans    []Span
+	started  bool
+	shutdown bool
+}
+
+// BatchSpanProcessorOption is a function that can be used to configure a
+// BatchSpanProcessor.
+type BatchSpanProcessorOption func(*BatchSpanProcessor)
+
+// BatchSpanProcessorFlushDelay sets the delay between the scheduled flushes of a
+// BatchSpanProcessor, which record the buffered spans even if the batch is
+// not full.  If d is not positive the default of 5 seconds is used.
+func BatchSpanProcessorFlushDelay(d time.Duration) BatchSpanProcessorOption {
+	return func(p *BatchSpanProcessor) {
+		p.delay = d
+	}
+}
+
+// NewBatchSpanProcessor creates a BatchSpanProcessor which records spans in
+// the Harvester once size spans have been buffered, and every 5 seconds
+// otherwise so that spans are not held back when few are completed.  If size
+// is not positive a batch size of 512 is used.  The goroutine of the
+// scheduled flushes is started by the first call to OnEnd and runs until
+// Shutdown is called, so Shutdown must be called once the processor is no
+// longer used.
+func NewBatchSpanProcessor(h *Harvester, size int, options ...BatchSpanProcessorOption) *BatchSpanProcessor {
+	if size <= 0 {
+		size = defaultSpanBatchSize
+	}
+	p := &BatchSpanProcessor{
+		harvester: h,
+		size:      size,
+		done:      make(chan struct{}),
+	}
+	for _, option := range options {
+		option(p)
+	}
+	if p.delay <= 0 {
+		p.delay = defaultSpanFlushDelay
+	}
+	return p
+}
+
+// flushRoutine flushes the buffered spans every p.delay until Shutdown.
+func (p *BatchSpanProcessor) flushRoutine() {
+	ticker := time.NewTicker(p.delay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Flush()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// OnEnd buffers the span, recording the buffered spans in the Harvester once
+// the batch is full.
+func (p *BatchSpanProcessor) OnEnd(s Span) {
+	p.lock.Lock()
+	if p.shutdown {
+		p.lock.Unlock()
+		return
+	}
+	if !p.started {
+		p.started = true
+		go p.flushRoutine()
+	}
+	p.spans = append(p.spans, s)
+	var batch []Span
+	if len(p.spans) >= p.size {
+		batch = p.spans
+		p.spans = nil
+	}
+	p.lock.Unlock()
+
+	p.record(batch)
+}
+
+// Flush records all buffered spans in the Harvester.
+func (p *BatchSpanProcessor) Flush() {
+	p.lock.Lock()
+	batch := p.spans
+	p.spans = nil
+	p.lock.Unlock()
+
+	p.record(batch)
+}
+
+// Shutdown stops the scheduled flushes and records all buffered spans in the
+// Harvester.  Spans passed to OnEnd after Shutdown are dropped.  The Harvester
+// is not harvested or stopped.
+func (p *BatchSpanProcessor) Shutdown(ctx context.Context) error {
+	p.lock.Lock()
+	if !p.shutdown {
+		close(p.done)
+	}
+	p.shutdown = true
+	batch := p.spans
+	p.spans = nil
+	p.lock.Unlock()
+
+	p.record(batch)
+	return ctx.Err()
+}
+
+func (p *BatchSpanProcessor) record(batch []Span) {
+	for _, s := range batch {
+		if err := p.harvester.RecordSpan(s); nil != err {
+			p.harvester.config.logError(map[string]interface{}{
+				"err":     err.Error(),
+				"message": "unable to record span",
+			})
+		}
+	}
+}
+
+// FilteringSpanProcessor is a SpanProcessor which passes on only the spans
+// that its keep func returns true for.
+type FilteringSpanProcessor struct {
+	next SpanProcessor
+	keep func(*Span) bool
+}
+
+// NewFilteringSpanProcessor creates a FilteringSpanProcessor which passes the
+// spans for which keep returns true to next.  keep may modify the span, eg.
+// to add attributes, before it is passed on.
+func NewFilteringSpanProcessor(next SpanProcessor, keep func(*Span) bool) *FilteringSpanProcessor {
+	return &FilteringSpanProcessor{
+		next: next,
+		keep: keep,
+	}
+}
+
+// OnEnd passes the span on to the next SpanProcessor if it is kept.
+func (p *FilteringSpanProcessor) OnEnd(s Span) {
+	if p.keep(&s) {
+		p.next.OnEnd(s)
+	}
+}
+
+// Shutdown shuts down the next SpanProcessor.
+func (p *FilteringSpanProcessor) Shutdown(ctx context.Context) error {
+	return p.next.Shutdown(ctx)
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSpanProcessorChain(t *testing.T) {
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting)
+	batch := NewBatchSpanProcessor(h, 2)
+	p := NewFilteringSpanProcessor(batch, func(s *Span) bool {
+		if s.Name == "/health" {
+			return false
+		}
+		s.Attributes = map[string]interface{}{"env": "test"}
+		return true
+	})
+
+	p.OnEnd(Span{ID: "1", TraceID: "tid", Name: "/health", Timestamp: tm})
+	p.OnEnd(Span{ID: "2", TraceID: "tid", Name: "/users", Timestamp: tm})
+	if n := len(h.spans); n != 0 {
+		t.Fatal("span recorded before batch was full", n)
+	}
+	p.OnEnd(Span{ID: "3", TraceID: "tid", Name: "/orders", Timestamp: tm})
+	p.OnEnd(Span{ID: "4", TraceID: "tid", Name: "/health", Timestamp: tm})
+	if n := len(h.spans); n != 2 {
+		t.Fatal(n)
+	}
+	p.OnEnd(Span{ID: "5", TraceID: "tid", Name: "/items", Timestamp: tm})
+	if err := p.Shutdown(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	p.OnEnd(Span{ID: "6", TraceID: "tid", Name: "/late", Timestamp: tm})
+
+	expect := `[{"spans":[
+		{"id":"2","trace.id":"tid","timestamp":1417136460000,"attributes":{"name":"/users","env":"test"}},
+		{"id":"3","trace.id":"tid","timestamp":1417136460000,"attributes":{"name":"/orders","env":"test"}},
+		{"id":"5","trace.id":"tid","timestamp":1417136460000,"attributes":{"name":"/items","env":"test"}}
+	]}]`
+	testHarvesterSpans(t, h, expect)
+}
+
+func TestBatchSpanProcessorFlush(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	p := NewBatchSpanProcessor(h, 0)
+	if p.size != defaultSpanBatchSize {
+		t.Error(p.size)
+	}
+	if p.delay != defaultSpanFlushDelay {
+		t.Error(p.delay)
+	}
+	p.OnEnd(Span{ID: "1", TraceID: "tid"})
+	p.Flush()
+	if n := len(h.spans); n != 1 {
+		t.Error(n)
+	}
+}
+
+func TestBatchSpanProcessorInvalidSpan(t *testing.T) {
+	var logged []map[string]interface{}
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.ErrorLogger = func(fields map[string]interface{}) { logged = append(logged, fields) }
+	})
+	p := NewBatchSpanProcessor(h, 1)
+	p.OnEnd(Span{ID: "1"})
+	if len(logged) != 1 || logged[0]["err"] != errTraceIDUnset.Error() {
+		t.Error(logged)
+	}
+}
+
+func TestBatchSpanProcessorNilHarvester(t *testing.T) {
+	p := NewBatchSpanProcessor(nil, 1)
+	p.OnEnd(Span{ID: "1", TraceID: "tid"})
+	if err := p.Shutdown(context.Background()); nil != err {
+		t.Error(err)
+	}
+}
+
+func TestBatchSpanProcessorScheduledFlush(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	p := NewBatchSpanProcessor(h, 0, BatchSpanProcessorFlushDelay(10*time.Millisecond))
+	defer p.Shutdown(context.Background())
+
+	p.OnEnd(Span{ID: "1", TraceID: "tid"})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.takeSpans()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("span not flushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBatchSpanProcessorShutdownStopsFlushes(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	p := NewBatchSpanProcessor(h, 0, BatchSpanProcessorFlushDelay(10*time.Millisecond))
+	if p.delay != 10*time.Millisecond {
+		t.Error(p.delay)
+	}
+	p.OnEnd(Span{ID: "1", TraceID: "tid"})
+	p.Shutdown(context.Background())
+	p.Shutdown(context.Background())
+	select {
+	case <-p.done:
+	default:
+		t.Error("flush routine not stopped")
+	}
+}
+
+func TestBatchSpanProcessorStartsFlushesLazily(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	p := NewBatchSpanProcessor(h, 0)
+	if p.started {
+		t.Error("flush routine started before OnEnd")
+	}
+	p.OnEnd(Span{ID: "1", TraceID: "tid"})
+	p.OnEnd(Span{ID: "2", TraceID: "tid"})
+	if !p.started {
+		t.Error("flush routine not started")
+	}
+	p.Shutdown(context.Background())
+	// Spans passed after Shutdown do not restart the flushes.
+	p.OnEnd(Span{ID: "3", TraceID: "tid"})
+	if n := len(h.takeSpans()); n != 2 {
+		t.Error(n)
+	}
+}
@@ -5,6 +5,8 @@ package telemetry
 
 import (
 	"bytes"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
@@ -12,6 +14,93 @@ import (
 
 const spanTypeName string = "spans"
 
+// SpanDurationUnit controls the unit used to report Span.Duration.
+type SpanDurationUnit int
+
+const (
+	// SpanDurationMilliseconds reports durations in the "duration.ms"
+	// attribute.  This is the default.
+	SpanDurationMilliseconds SpanDurationUnit = iota
+	// SpanDurationMicroseconds reports durations in the "duration.us"
+	// attribute.
+	SpanDurationMicroseconds
+	// SpanDurationNanoseconds reports durations in the "duration.ns"
+	// attribute.
+	SpanDurationNanoseconds
+)
+
+// writeDuration writes the duration field using the unit's attribute name.
+func (u SpanDurationUnit) writeDuration(w *internal.JSONFieldsWriter, d time.Duration) {
+	switch u {
+	case SpanDurationMicroseconds:
+		w.FloatField("duration.us", float64(d.Nanoseconds())/1000.0)
+	case SpanDurationNanoseconds:
+		w.IntField("duration.ns", d.Nanoseconds())
+	default:
+		w.FloatField("duration.ms", d.Seconds()*1000.0)
+	}
+}
+
+// Span kinds which may be used as Span.Kind.
+const (
+	// SpanKindServer is used for spans which handle a synchronous request
+	// from a remote client.
+	SpanKindServer = "server"
+	// SpanKindClient is used for spans which make a synchronous request to
+	// a remote server.
+	SpanKindClient = "client"
+	// SpanKindProducer is used for spans which send an asynchronous
+	// message, eg. to a queue.
+	SpanKindProducer = "producer"
+	// SpanKindConsumer is used for spans which process an asynchronous
+	// message.
+	SpanKindConsumer = "consumer"
+	// SpanKindInternal is used for spans which represent an internal
+	// operation with no remote parent or child.
+	SpanKindInternal = "internal"
+)
+
+// Span status codes which may be used as Span.StatusCode.
+const (
+	// SpanStatusOK indicates that the operation completed successfully.
+	SpanStatusOK = "OK"
+	// SpanStatusError indicates that the operation contains an error.
+	SpanStatusError = "ERROR"
+)
+
+const (
+	spanKindAttribute          = "span.kind"
+	spanStatusCodeAttribute    = "otel.status_code"
+	spanStatusMessageAttribute = "otel.status_description"
+)
+
+// normalizeSpanKind returns the accepted span kind for kind, which may be in
+// any case and may use the OpenTelemetry "SPAN_KIND_" prefix.  false is
+// returned if the kind is unknown.
+func normalizeSpanKind(kind string) (string, bool) {
+	k := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(kind)), "span_kind_")
+	switch k {
+	case SpanKindServer, SpanKindClient, SpanKindProducer, SpanKindConsumer, SpanKindInternal:
+		return k, true
+	}
+	return "", false
+}
+
+// normalizeSpanStatusCode returns the accepted status code for code, which may
+// be in any case and may use the OpenTelemetry "STATUS_CODE_" prefix.  The
+// "UNSET" status is normalized to the empty string.  false is returned if the
+// status code is unknown.
+func normalizeSpanStatusCode(code string) (string, bool) {
+	c := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(code)), "STATUS_CODE_")
+	switch c {
+	case SpanStatusOK, SpanStatusError:
+		return c, true
+	case "UNSET":
+		return "", true
+	}
+	return "", false
+}
+
 // Span is a distributed tracing span.
 type Span struct {
 	// Required Fields:
@@ -22,7 +111,9 @@ type Span struct {
 	// trace.
 	TraceID string
 	// Timestamp is when this span started.  If Timestamp is not set, it
-	// will be assigned to time.Now() in Harvester.RecordSpan.
+	// will be assigned to time.Now() in Harvester.RecordSpan unless
+	// Config.DisableAutoTimestamp is set, in which case it is omitted
+	// and New Relic assigns the time the span is received.
 	Timestamp time.Time
 
 	// Recommended Fields:
@@ -37,6 +128,18 @@ type Span struct {
 	Duration time.Duration
 	// ServiceName is the name of the service that created this span.
 	ServiceName string
+	// Kind is the kind of this span, one of the SpanKind constants.  It is
+	// sent as the "span.kind" attribute.  Harvester.RecordSpan normalizes
+	// the case and OpenTelemetry "SPAN_KIND_" prefix of the kind, and
+	// omits unknown kinds.
+	Kind string
+	// StatusCode is the status of this span, SpanStatusOK or
+	// SpanStatusError.  It is sent as the "otel.status_code" attribute.
+	// Like Kind, it is normalized by Harvester.RecordSpan.
+	StatusCode string
+	// StatusMessage describes the status of this span, eg. the error
+	// message.  It is sent as the "otel.status_description" attribute.
+	StatusMessage string
 
 	// Additional Fields:
 	//
@@ -46,15 +149,53 @@ type Span struct {
 	// Events is a slice of events that occurred during the execution of a span.
 	// This feature is a work in progress.
 	Events []Event
+	// Links is a slice of links to spans in other traces, such as the
+	// upstream traces of a batch or fan-in operation.  Use AddLink to add
+	// links.
+	Links []SpanLink
+
+	// bufferedAt is when the span was recorded if
+	// Config.TrackQueueLatency is enabled.
+	bufferedAt time.Time
 }
 
-func (s *Span) writeJSON(buf *bytes.Buffer) {
+// SpanLink is a link from a span to a span in another trace.
+type SpanLink struct {
+	// TraceID is the trace id of the linked span.
+	TraceID string
+	// SpanID is the id of the linked span.
+	SpanID string
+	// Attributes is a map of user specified tags on this link.  The map
+	// values can be any of bool, number, or string.
+	Attributes map[string]interface{}
+}
+
+var errSpanLinkIDUnset = errors.New("span link trace id and span id must be set")
+
+// AddLink adds a link to the span with the given trace id, span id, and
+// attributes.  An error is returned and no link is added if either id is
+// empty.
+func (s *Span) AddLink(traceID, spanID string, attributes map[string]interface{}) error {
+	if traceID == "" || spanID == "" {
+		return errSpanLinkIDUnset
+	}
+	s.Links = append(s.Links, SpanLink{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		Attributes: attributes,
+	})
+	return nil
+}
+
+func (s *Span) writeJSON(buf *bytes.Buffer, unit SpanDurationUnit) {
 	w := internal.JSONFieldsWriter{Buf: buf}
 	buf.WriteByte('{')
 
 	w.StringField("id", s.ID)
 	w.StringField("trace.id", s.TraceID)
-	w.IntField("timestamp", s.Timestamp.UnixNano()/(1000*1000))
+	if !s.Timestamp.IsZero() {
+		w.IntField("timestamp", s.Timestamp.UnixNano()/(1000*1000))
+	}
 
 	w.AddKey("attributes")
 	buf.WriteByte('{')
@@ -67,13 +208,22 @@ func (s *Span) writeJSON(buf *bytes.Buffer) {
 		ww.StringField("parent.id", s.ParentID)
 	}
 	if s.Duration != 0 {
-		ww.FloatField("duration.ms", s.Duration.Seconds()*1000.0)
+		unit.writeDuration(&ww, s.Duration)
 	}
 	if s.ServiceName != "" {
 		ww.StringField("service.name", s.ServiceName)
 	}
+	if s.Kind != "" {
+		ww.StringField(spanKindAttribute, s.Kind)
+	}
+	if s.StatusCode != "" {
+		ww.StringField(spanStatusCodeAttribute, s.StatusCode)
+	}
+	if s.StatusMessage != "" {
+		ww.StringField(spanStatusMessageAttribute, s.StatusMessage)
+	}
 
-	internal.AddAttributes(&ww, s.Attributes)
+	internal.AddAttributes(&ww, s.unshadowedAttributes())
 	buf.WriteByte('}')
 
 	if len(s.Events) > 0 {
@@ -97,9 +247,55 @@ func (s *Span) writeJSON(buf *bytes.Buffer) {
 		buf.WriteByte(']')
 	}
 
+	if len(s.Links) > 0 {
+		w.AddKey("links")
+		buf.WriteByte('[')
+		for i, l := range s.Links {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('{')
+			lw := internal.JSONFieldsWriter{Buf: buf}
+			lw.StringField("trace.id", l.TraceID)
+			lw.StringField("id", l.SpanID)
+			lw.AddKey("attributes")
+			buf.WriteByte('{')
+			lw.NoComma()
+			internal.AddAttributes(&lw, l.Attributes)
+			buf.WriteByte('}')
+			buf.WriteByte('}')
+		}
+		buf.WriteByte(']')
+	}
+
 	buf.WriteByte('}')
 }
 
+// unshadowedAttributes returns the attributes without those that are set by
+// the Kind, StatusCode, and StatusMessage fields.
+func (s *Span) unshadowedAttributes() map[string]interface{} {
+	shadowed := func(key, field string) bool {
+		_, ok := s.Attributes[key]
+		return ok && field != ""
+	}
+	hasKind := shadowed(spanKindAttribute, s.Kind)
+	hasCode := shadowed(spanStatusCodeAttribute, s.StatusCode)
+	hasMessage := shadowed(spanStatusMessageAttribute, s.StatusMessage)
+	if !hasKind && !hasCode && !hasMessage {
+		return s.Attributes
+	}
+	attributes := make(map[string]interface{}, len(s.Attributes))
+	for k, v := range s.Attributes {
+		if (hasKind && k == spanKindAttribute) ||
+			(hasCode && k == spanStatusCodeAttribute) ||
+			(hasMessage && k == spanStatusMessageAttribute) {
+			continue
+		}
+		attributes[k] = v
+	}
+	return attributes
+}
+
 // spanCommonBlock represents the shared elements of a SpanGroup.
 type spanCommonBlock struct {
 	attributes MapEntry
@@ -159,6 +355,8 @@ func WithSpanAttributes(commonAttributes map[string]interface{}) SpanCommonBlock
 // SpanGroup represents a grouping of spans in a payload to New Relic.
 type spanGroup struct {
 	Spans []Span
+	// durationUnit is the unit used to report each span's duration.
+	durationUnit SpanDurationUnit
 }
 
 // DataTypeKey returns the type of data contained in this MapEntry.
@@ -173,18 +371,25 @@ func (group *spanGroup) WriteDataEntry(buf *bytes.Buffer) *bytes.Buffer {
 		if idx > 0 {
 			buf.WriteByte(',')
 		}
-		s.writeJSON(buf)
+		s.writeJSON(buf, group.durationUnit)
 	}
 	buf.WriteByte(']')
 	return buf
 }
 
-func (group *spanGroup) split() []splittablePayloadEntry {
+func (group *spanGroup) split(strategy SplitStrategy) []splittablePayloadEntry {
 	if len(group.Spans) < 2 {
 		return nil
 	}
-	middle := len(group.Spans) / 2
-	return []splittablePayloadEntry{&spanGroup{Spans: group.Spans[0:middle]}, &spanGroup{Spans: group.Spans[middle:]}}
+	middle := splitIndex(strategy, len(group.Spans), func(i int) int {
+		buf := &bytes.Buffer{}
+		group.Spans[i].writeJSON(buf, group.durationUnit)
+		return buf.Len()
+	})
+	return []splittablePayloadEntry{
+		&spanGroup{Spans: group.Spans[0:middle], durationUnit: group.durationUnit},
+		&spanGroup{Spans: group.Spans[middle:], durationUnit: group.durationUnit},
+	}
 }
 
 // NewSpanGroup creates a new MapEntry representing a group of spans in a batch.
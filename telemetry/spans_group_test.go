@@ -50,35 +50,35 @@ func testSpanGroupJSON(t testing.TB, batches []Batch, expect string) {
 func TestSpansPayloadSplit(t *testing.T) {
 	// test len 0
 	sp := NewSpanGroup([]Span{})
-	split := sp.(splittablePayloadEntry).split()
+	split := sp.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if split != nil {
 		t.Error(split)
 	}
 
 	// test len 1
 	sp = NewSpanGroup([]Span{{Name: "a"}})
-	split = sp.(splittablePayloadEntry).split()
+	split = sp.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if split != nil {
 		t.Error(split)
 	}
 
 	// test len 2
 	sp = NewSpanGroup([]Span{{Name: "a"}, {Name: "b"}})
-	split = sp.(splittablePayloadEntry).split()
+	split = sp.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if len(split) != 2 {
 		t.Error("split into incorrect number of slices", len(split))
 	}
-	testSpanGroupJSON(t, []Batch{{split[0]}}, `[{"spans":[{"id":"","trace.id":"","timestamp":-6795364578871,"attributes":{"name":"a"}}]}]`)
-	testSpanGroupJSON(t, []Batch{{split[1]}}, `[{"spans":[{"id":"","trace.id":"","timestamp":-6795364578871,"attributes":{"name":"b"}}]}]`)
+	testSpanGroupJSON(t, []Batch{{split[0]}}, `[{"spans":[{"id":"","trace.id":"","attributes":{"name":"a"}}]}]`)
+	testSpanGroupJSON(t, []Batch{{split[1]}}, `[{"spans":[{"id":"","trace.id":"","attributes":{"name":"b"}}]}]`)
 
 	// test len 3
 	sp = NewSpanGroup([]Span{{Name: "a"}, {Name: "b"}, {Name: "c"}})
-	split = sp.(splittablePayloadEntry).split()
+	split = sp.(splittablePayloadEntry).split(CountSplitStrategy{})
 	if len(split) != 2 {
 		t.Error("split into incorrect number of slices", len(split))
 	}
-	testSpanGroupJSON(t, []Batch{{split[0]}}, `[{"spans":[{"id":"","trace.id":"","timestamp":-6795364578871,"attributes":{"name":"a"}}]}]`)
-	testSpanGroupJSON(t, []Batch{{split[1]}}, `[{"spans":[{"id":"","trace.id":"","timestamp":-6795364578871,"attributes":{"name":"b"}},{"id":"","trace.id":"","timestamp":-6795364578871,"attributes":{"name":"c"}}]}]`)
+	testSpanGroupJSON(t, []Batch{{split[0]}}, `[{"spans":[{"id":"","trace.id":"","attributes":{"name":"a"}}]}]`)
+	testSpanGroupJSON(t, []Batch{{split[1]}}, `[{"spans":[{"id":"","trace.id":"","attributes":{"name":"b"}},{"id":"","trace.id":"","attributes":{"name":"c"}}]}]`)
 }
 
 func TestSpansJSON(t *testing.T) {
@@ -99,7 +99,6 @@ func TestSpansJSON(t *testing.T) {
 		{
 			"id":"",
 			"trace.id":"",
-			"timestamp":-6795364578871,
 			"attributes": {
 			}
 		},
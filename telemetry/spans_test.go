@@ -5,7 +5,10 @@ package telemetry
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
+	"net/http"
+	"sync"
 	"testing"
 	"time"
 
@@ -97,6 +100,103 @@ func TestSpan(t *testing.T) {
 	testHarvesterSpans(t, h, expect)
 }
 
+func TestSpanKindAndStatus(t *testing.T) {
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting)
+	h.RecordSpan(Span{
+		ID:            "myid",
+		TraceID:       "mytraceid",
+		Timestamp:     tm,
+		Kind:          SpanKindServer,
+		StatusCode:    SpanStatusError,
+		StatusMessage: "connection reset",
+		Attributes: map[string]interface{}{
+			// These are shadowed by the fields.
+			"span.kind":        "client",
+			"otel.status_code": "OK",
+			"zip":              "zap",
+		},
+	})
+	expect := `[{"spans":[{
+		"id":"myid",
+		"trace.id":"mytraceid",
+		"timestamp":1417136460000,
+		"attributes": {
+			"span.kind":"server",
+			"otel.status_code":"ERROR",
+			"otel.status_description":"connection reset",
+			"zip":"zap"
+		}
+	}]}]`
+	testHarvesterSpans(t, h, expect)
+}
+
+func TestSpanKindAndStatusNormalized(t *testing.T) {
+	testcases := []struct {
+		kind, code     string
+		expectKind     string
+		expectCode     string
+		expectLogCount int
+	}{
+		{kind: "SERVER", code: "ok", expectKind: SpanKindServer, expectCode: SpanStatusOK},
+		{kind: "SPAN_KIND_PRODUCER", code: "STATUS_CODE_ERROR", expectKind: SpanKindProducer, expectCode: SpanStatusError},
+		{kind: " consumer ", code: "unset", expectKind: SpanKindConsumer, expectCode: ""},
+		{kind: "sideways", code: "maybe", expectKind: "", expectCode: "", expectLogCount: 2},
+	}
+	for _, test := range testcases {
+		var logged []map[string]interface{}
+		h, _ := NewHarvester(configTesting, func(cfg *Config) {
+			cfg.ErrorLogger = func(fields map[string]interface{}) {
+				logged = append(logged, fields)
+			}
+		})
+		span := Span{ID: "id", TraceID: "tid", Kind: test.kind, StatusCode: test.code}
+		// Unknown values are only logged once.
+		h.RecordSpan(span)
+		h.RecordSpan(span)
+		if len(logged) != test.expectLogCount {
+			t.Error(test.kind, test.code, logged)
+		}
+		h.lock.Lock()
+		for _, s := range h.spans {
+			if s.Kind != test.expectKind || s.StatusCode != test.expectCode {
+				t.Error(test.kind, test.code, s.Kind, s.StatusCode)
+			}
+		}
+		h.lock.Unlock()
+	}
+}
+
+func TestSpanDurationUnit(t *testing.T) {
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	testcases := []struct {
+		unit     SpanDurationUnit
+		expected string
+	}{
+		{unit: SpanDurationMilliseconds, expected: `"duration.ms":1.5`},
+		{unit: SpanDurationMicroseconds, expected: `"duration.us":1500`},
+		{unit: SpanDurationNanoseconds, expected: `"duration.ns":1500000`},
+	}
+	for _, test := range testcases {
+		h, _ := NewHarvester(configTesting, func(cfg *Config) {
+			cfg.SpanDurationUnit = test.unit
+		})
+		h.RecordSpan(Span{
+			ID:        "myid",
+			TraceID:   "mytraceid",
+			Timestamp: tm,
+			Duration:  1500 * time.Microsecond,
+		})
+		expect := `[{"spans":[{
+			"id":"myid",
+			"trace.id":"mytraceid",
+			"timestamp":1417136460000,
+			"attributes": {` + test.expected + `}
+		}]}]`
+		testHarvesterSpans(t, h, expect)
+	}
+}
+
 func TestSpanInvalidAttribute(t *testing.T) {
 	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
 	h, _ := NewHarvester(configTesting)
@@ -227,6 +327,43 @@ func TestSpanWithEvents(t *testing.T) {
 	testHarvesterSpans(t, h, expect)
 }
 
+func TestSpanWithLinks(t *testing.T) {
+	tm := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	h, _ := NewHarvester(configTesting)
+	span := Span{ID: "myid", TraceID: "mytraceid", Timestamp: tm}
+	for i, id := range []string{"a", "b", "c"} {
+		if err := span.AddLink("trace-"+id, "span-"+id, map[string]interface{}{"batch.index": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.RecordSpan(span)
+	expect := `[{"spans":[{
+		"id":"myid",
+		"trace.id":"mytraceid",
+		"timestamp":1417136460000,
+		"attributes": {},
+		"links": [
+			{"trace.id":"trace-a","id":"span-a","attributes":{"batch.index":0}},
+			{"trace.id":"trace-b","id":"span-b","attributes":{"batch.index":1}},
+			{"trace.id":"trace-c","id":"span-c","attributes":{"batch.index":2}}
+		]
+	}]}]`
+	testHarvesterSpans(t, h, expect)
+}
+
+func TestSpanAddLinkInvalid(t *testing.T) {
+	var span Span
+	if err := span.AddLink("", "span", nil); err != errSpanLinkIDUnset {
+		t.Error(err)
+	}
+	if err := span.AddLink("trace", "", nil); err != errSpanLinkIDUnset {
+		t.Error(err)
+	}
+	if len(span.Links) != 0 {
+		t.Error("invalid links should not be added", span.Links)
+	}
+}
+
 func BenchmarkSpanCommonBlock(b *testing.B) {
 	buf := &bytes.Buffer{}
 
@@ -241,3 +378,70 @@ func BenchmarkSpanCommonBlock(b *testing.B) {
 		block.WriteDataEntry(buf)
 	}
 }
+
+func TestSpansMirrorURL(t *testing.T) {
+	var lock sync.Mutex
+	bodies := make(map[string]string)
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.SpansMirrorURL = "https://trace-observer.example.com/trace/v1"
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			compressed, _ := ioutil.ReadAll(req.Body)
+			body, _ := internal.Uncompress(compressed)
+			lock.Lock()
+			defer lock.Unlock()
+			bodies[req.URL.Host] = string(body)
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{
+		ID:        "id",
+		TraceID:   "traceid",
+		Timestamp: time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC),
+	})
+	h.HarvestNow(context.Background())
+
+	expect := `[{"spans":[{"id":"id","trace.id":"traceid","timestamp":1417136460000,"attributes":{}}]}]`
+	for _, host := range []string{"trace-api.newrelic.com", "trace-observer.example.com"} {
+		if body := bodies[host]; body != expect {
+			t.Errorf("%s\nexpect=%s\nactual=%s", host, expect, body)
+		}
+	}
+	if len(bodies) != 2 {
+		t.Error(bodies)
+	}
+}
+
+func TestSpansMirrorNotCounted(t *testing.T) {
+	var lock sync.Mutex
+	var results []HarvestResult
+	var drops []dropRecord
+	buf := &bytes.Buffer{}
+	h, _ := NewHarvester(configTesting, ConfigFallbackWriter(buf), configureDropsToSlice(&lock, &drops), func(cfg *Config) {
+		cfg.SpansMirrorURL = "https://trace-observer.example.com/trace/v1"
+		cfg.HarvestCallback = func(r HarvestResult) {
+			lock.Lock()
+			defer lock.Unlock()
+			results = append(results, r)
+		}
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Host == "trace-observer.example.com" {
+				return emptyResponse(413), nil
+			}
+			return emptyResponse(202), nil
+		})
+	})
+	h.RecordSpan(Span{ID: "id", TraceID: "traceid", Timestamp: time.Now()})
+	h.HarvestNow(context.Background())
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(results) != 1 || results[0].Signal != SignalSpans || results[0].Items != 1 {
+		t.Error(results)
+	}
+	if len(drops) != 0 {
+		t.Error(drops)
+	}
+	if buf.Len() != 0 {
+		t.Error(buf.String())
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import "time"
+
+// timestampFilter applies the Config's timestamp rules to buffered data at
+// harvest time: data older than MaxDataAge is dropped and, if
+// ClampFutureTimestamps is set, timestamps too far in the future are clamped
+// to the current time.
+type timestampFilter struct {
+	now     time.Time
+	cutoff  time.Time
+	limit   time.Time
+	dropped int
+	clamped int
+}
+
+func (cfg *Config) newTimestampFilter(now time.Time) *timestampFilter {
+	f := &timestampFilter{
+		now:    now,
+		cutoff: cfg.staleCutoff(now),
+	}
+	if cfg.ClampFutureTimestamps {
+		f.limit = now.Add(cfg.FutureTimestampSkew)
+	}
+	return f
+}
+
+// enabled returns true if the filter may drop or modify any data.
+func (f *timestampFilter) enabled() bool {
+	return !f.cutoff.IsZero() || !f.limit.IsZero()
+}
+
+// apply returns the timestamp that should be used and whether the item should
+// be kept.  Zero timestamps are never modified or dropped.
+func (f *timestampFilter) apply(ts time.Time) (time.Time, bool) {
+	if ts.IsZero() {
+		return ts, true
+	}
+	if !f.cutoff.IsZero() && ts.Before(f.cutoff) {
+		f.dropped++
+		return ts, false
+	}
+	if !f.limit.IsZero() && ts.After(f.limit) {
+		f.clamped++
+		return f.now, true
+	}
+	return ts, true
+}
+
+// log reports the number of items of the given signal that were dropped
+// or clamped.
+func (f *timestampFilter) log(cfg *Config, signal Signal) {
+	if f.dropped > 0 {
+		cfg.logError(map[string]interface{}{
+			"message":   "dropping data older than max data age",
+			"data-type": signal.String(),
+			"count":     f.dropped,
+		})
+		cfg.drop(signal, f.dropped, DropReasonTooOld)
+	}
+	if f.clamped > 0 {
+		cfg.logError(map[string]interface{}{
+			"message":   "clamping future timestamps to now",
+			"data-type": signal.String(),
+			"count":     f.clamped,
+		})
+	}
+	f.dropped = 0
+	f.clamped = 0
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+const (
+	// defaultMaxAttributeValueLength is the maximum length in bytes of the
+	// string attribute values accepted by New Relic's ingest APIs.
+	defaultMaxAttributeValueLength = 4095
+	// truncatedSuffix is appended to truncated attribute values.
+	truncatedSuffix = "..."
+)
+
+// truncateString returns s shortened to at most max bytes and ending with
+// truncatedSuffix.  Multi-byte characters are not split.
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	if max <= len(truncatedSuffix) {
+		return truncatedSuffix[:max]
+	}
+	cut := max - len(truncatedSuffix)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + truncatedSuffix
+}
+
+// truncateAttributes returns the attributes with the string values longer
+// than Config.MaxAttributeValueLength truncated.  The attributes map itself is
+// not modified since it is owned by the caller, so it is only copied if values
+// are truncated.
+func (h *Harvester) truncateAttributes(attributes map[string]interface{}) map[string]interface{} {
+	if nil == h || h.config.MaxAttributeValueLength <= 0 {
+		return attributes
+	}
+	max := h.config.MaxAttributeValueLength
+	var truncated map[string]interface{}
+	for k, v := range attributes {
+		s, ok := v.(string)
+		if !ok || len(s) <= max {
+			continue
+		}
+		if nil == truncated {
+			truncated = make(map[string]interface{}, len(attributes))
+			for k, v := range attributes {
+				truncated[k] = v
+			}
+		}
+		truncated[k] = truncateString(s, max)
+		atomic.AddInt64(&h.truncatedValues, 1)
+	}
+	if nil == truncated {
+		return attributes
+	}
+	return truncated
+}
+
+// truncateMetric returns the metric with its attributes truncated.  Pointer
+// metrics are copied since they are owned by the caller.
+func (h *Harvester) truncateMetric(m Metric) Metric {
+	switch v := m.(type) {
+	case *Count:
+		if nil != v {
+			c := h.truncateMetric(*v).(Count)
+			return &c
+		}
+	case *Summary:
+		if nil != v {
+			s := h.truncateMetric(*v).(Summary)
+			return &s
+		}
+	case *Gauge:
+		if nil != v {
+			g := h.truncateMetric(*v).(Gauge)
+			return &g
+		}
+	case *Histogram:
+		if nil != v {
+			hist := h.truncateMetric(*v).(Histogram)
+			return &hist
+		}
+	case Count:
+		v.Attributes = h.truncateAttributes(v.Attributes)
+		return v
+	case Summary:
+		v.Attributes = h.truncateAttributes(v.Attributes)
+		return v
+	case Gauge:
+		v.Attributes = h.truncateAttributes(v.Attributes)
+		return v
+	case Histogram:
+		v.Attributes = h.truncateAttributes(v.Attributes)
+		return v
+	}
+	return m
+}
+
+// truncateSpan truncates the attributes of the span and of its events and
+// links.  The events and links are copied since they are owned by the caller.
+func (h *Harvester) truncateSpan(s *Span) {
+	if h.config.MaxAttributeValueLength <= 0 {
+		return
+	}
+	s.Attributes = h.truncateAttributes(s.Attributes)
+	if len(s.Events) > 0 {
+		events := make([]Event, len(s.Events))
+		for i, e := range s.Events {
+			e.Attributes = h.truncateAttributes(e.Attributes)
+			events[i] = e
+		}
+		s.Events = events
+	}
+	if len(s.Links) > 0 {
+		links := make([]SpanLink, len(s.Links))
+		for i, l := range s.Links {
+			l.Attributes = h.truncateAttributes(l.Attributes)
+			links[i] = l
+		}
+		s.Links = links
+	}
+}
+
+// logTruncatedValues logs the number of attribute values truncated since the
+// previous harvest.
+func (h *Harvester) logTruncatedValues() {
+	if n := atomic.SwapInt64(&h.truncatedValues, 0); n > 0 {
+		h.config.logError(map[string]interface{}{
+			"message":    "attribute values truncated",
+			"count":      n,
+			"max-length": h.config.MaxAttributeValueLength,
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTruncateString(t *testing.T) {
+	testcases := []struct {
+		input  string
+		max    int
+		expect string
+	}{
+		{input: "short", max: 10, expect: "short"},
+		{input: "exactly10!", max: 10, expect: "exactly10!"},
+		{input: "this is too long", max: 10, expect: "this is..."},
+		// The 3 byte character is not split.
+		{input: "abcdé€xyz", max: 10, expect: "abcdé..."},
+		{input: "too long", max: 2, expect: ".."},
+	}
+	for _, test := range testcases {
+		if out := truncateString(test.input, test.max); out != test.expect {
+			t.Errorf("truncateString(%q, %d) = %q, want %q", test.input, test.max, out, test.expect)
+		}
+	}
+}
+
+func TestTruncateSpanAttribute(t *testing.T) {
+	h, _ := NewHarvester(configTesting)
+	huge := strings.Repeat("a", 100*1000)
+	attributes := map[string]interface{}{"huge": huge}
+	h.RecordSpan(Span{
+		ID:         "id",
+		TraceID:    "tid",
+		Attributes: attributes,
+		Events:     []Event{{EventType: "event", Attributes: attributes}},
+		Links:      []SpanLink{{TraceID: "other", SpanID: "span", Attributes: attributes}},
+	})
+	if attributes["huge"] != huge {
+		t.Error("attributes should not be modified")
+	}
+
+	spans := h.takeSpans()
+	if len(spans) != 1 {
+		t.Fatal(spans)
+	}
+	for _, value := range []interface{}{
+		spans[0].Attributes["huge"],
+		spans[0].Events[0].Attributes["huge"],
+		spans[0].Links[0].Attributes["huge"],
+	} {
+		s, _ := value.(string)
+		if len(s) != defaultMaxAttributeValueLength || !strings.HasSuffix(s, truncatedSuffix) {
+			t.Error(len(s))
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	spans[0].writeJSON(buf, SpanDurationMilliseconds)
+	if n := buf.Len(); n > 4*defaultMaxAttributeValueLength {
+		t.Error("serialized span too large", n)
+	}
+}
+
+func TestTruncateAttributesAllTypes(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.MaxAttributeValueLength = 10
+	})
+	attributes := map[string]interface{}{"value": "this is too long", "number": 1}
+	h.RecordEvent(Event{EventType: "event", Attributes: attributes})
+	h.RecordLog(Log{Message: "message", Attributes: attributes})
+	h.RecordMetric(Gauge{Name: "gauge", Attributes: attributes, Timestamp: time.Now()})
+	h.MetricAggregator().Count("count", attributes).Increment()
+
+	events := h.takeEvents()
+	logs := h.takeLogs()
+	metrics, _ := h.takeMetrics(time.Now())
+	if len(events) != 1 || len(logs) != 1 || len(metrics) != 2 {
+		t.Fatal(events, logs, metrics)
+	}
+	for _, attrs := range []map[string]interface{}{events[0].Attributes, logs[0].Attributes} {
+		if attrs["value"] != "this is..." || attrs["number"] != 1 {
+			t.Error(attrs)
+		}
+	}
+	for _, m := range metrics {
+		var js string
+		switch v := m.(type) {
+		case Gauge:
+			js, _ = v.Attributes["value"].(string)
+		case *Count:
+			js = string(v.AttributesJSON)
+		}
+		if !strings.Contains(js, "this is...") {
+			t.Error(m)
+		}
+	}
+}
+
+func TestTruncateAttributesLoggedOncePerHarvest(t *testing.T) {
+	var logged []map[string]interface{}
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.MaxAttributeValueLength = 10
+		cfg.ErrorLogger = func(fields map[string]interface{}) { logged = append(logged, fields) }
+		cfg.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return emptyResponse(202), nil
+		})
+	})
+	attributes := map[string]interface{}{"value": "this is too long"}
+	h.RecordEvent(Event{EventType: "event", Attributes: attributes})
+	h.RecordEvent(Event{EventType: "event", Attributes: attributes})
+	h.HarvestNow(context.Background())
+	h.HarvestNow(context.Background())
+
+	if len(logged) != 1 {
+		t.Fatal(logged)
+	}
+	if logged[0]["message"] != "attribute values truncated" || logged[0]["count"] != int64(2) {
+		t.Error(logged[0])
+	}
+}
+
+func TestTruncateAttributesDisabled(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.MaxAttributeValueLength = 0
+	})
+	huge := strings.Repeat("a", 100*1000)
+	h.RecordEvent(Event{EventType: "event", Attributes: map[string]interface{}{"huge": huge}})
+	if events := h.takeEvents(); events[0].Attributes["huge"] != huge {
+		t.Error("attribute truncated")
+	}
+}
+
+func TestTruncatePointerMetrics(t *testing.T) {
+	h, _ := NewHarvester(configTesting, func(cfg *Config) {
+		cfg.MaxAttributeValueLength = 10
+	})
+	attributes := map[string]interface{}{"value": "this is too long"}
+	metrics := []Metric{
+		&Count{Name: "count", Attributes: attributes},
+		&Summary{Name: "summary", Attributes: attributes},
+		&Gauge{Name: "gauge", Attributes: attributes},
+		&Histogram{Name: "histogram", Attributes: attributes},
+	}
+	for _, m := range metrics {
+		var attrs map[string]interface{}
+		switch v := h.truncateMetric(m).(type) {
+		case *Count:
+			attrs = v.Attributes
+		case *Summary:
+			attrs = v.Attributes
+		case *Gauge:
+			attrs = v.Attributes
+		case *Histogram:
+			attrs = v.Attributes
+		default:
+			t.Fatal(v)
+		}
+		if attrs["value"] != "this is..." {
+			t.Error(m, attrs)
+		}
+	}
+	// The caller's metrics are not modified.
+	if metrics[0].(*Count).Attributes["value"] != "this is too long" {
+		t.Error(metrics[0])
+	}
+	var nilCount *Count
+	if m := h.truncateMetric(nilCount); m != Metric(nilCount) {
+		t.Error(m)
+	}
+}